@@ -66,12 +66,13 @@ func BuildMinirootFSCmd(ctx context.Context, opts ...build.Option) error {
 	if err != nil {
 		return fmt.Errorf("failed to create working directory: %w", err)
 	}
-	defer os.RemoveAll(wd)
 
 	bc, err := build.New(wd, opts...)
 	if err != nil {
+		os.RemoveAll(wd)
 		return err
 	}
+	defer bc.Close()
 
 	if err := bc.Refresh(); err != nil {
 		return err
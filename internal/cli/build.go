@@ -41,6 +41,27 @@ func buildCmd() *cobra.Command {
 	var sbomFormats []string
 	var extraKeys []string
 	var extraRepos []string
+	var failOnInsecurePaths bool
+	var reportPath string
+	var installedDBPath string
+	var provenancePath string
+	var strictAnnotations bool
+	var strictKeyring bool
+	var strictBaseImage bool
+	var strictEntrypoint bool
+	var maxImageSize int64
+	var compressionLevel string
+	var compression string
+	var sbomPredicates bool
+	var sbomGzip bool
+	var sbomSigningKey string
+	var rawBuildArgs []string
+	var profile string
+	var overrideRunAs string
+	var requireSBOM bool
+	var cacheDir string
+	var offline bool
+	var outputFormat string
 
 	cmd := &cobra.Command{
 		Use:   "build",
@@ -52,6 +73,9 @@ command, e.g.
 
   # docker load < output.tar
 
+With --output-format oci-layout, the output path is instead written as an
+OCI image layout directory.
+
 Along the image, apko will generate CycloneDX and SPDX SBOMs (software 
 bill of materials) describing the image contents.
 `,
@@ -67,21 +91,47 @@ bill of materials) describing the image contents.
 
 			if !writeSBOM {
 				sbomFormats = []string{}
+			} else {
+				sbomFormats = defaultSBOMFormats(sbomFormats)
+			}
+			buildArgs, err := parseBuildArgs(rawBuildArgs)
+			if err != nil {
+				return fmt.Errorf("parsing build args from command line: %w", err)
 			}
 			return BuildCmd(cmd.Context(), args[1], args[2],
 				build.WithConfig(args[0]),
 				build.WithProot(useProot),
 				build.WithDockerMediatypes(useDockerMediaTypes),
 				build.WithBuildDate(buildDate),
-				build.WithAssertions(build.RequireGroupFile(true), build.RequirePasswdFile(true)),
+				build.WithAssertions(assertions(failOnInsecurePaths)...),
 				build.WithSBOM(sbomPath),
 				build.WithSBOMFormats(sbomFormats),
+				build.WithSBOMPredicates(sbomPredicates),
+				build.WithSBOMGzip(sbomGzip),
+				build.WithSBOMSigningKey(sbomSigningKey),
+				build.WithRequireSBOM(requireSBOM),
+				build.WithBuildReport(reportPath),
+				build.WithInstalledDB(installedDBPath),
+				build.WithProvenance(provenancePath),
 				build.WithExtraKeys(extraKeys),
 				build.WithTags(args[1]),
 				build.WithExtraRepos(extraRepos),
 				build.WithArch(types.ParseArchitecture(ba)),
 				build.WithDebugLogging(debugEnabled),
 				build.WithVCS(withVCS),
+				build.WithStrictAnnotations(strictAnnotations),
+				build.WithStrictKeyring(strictKeyring),
+				build.WithStrictBaseImage(strictBaseImage),
+				build.WithStrictEntrypoint(strictEntrypoint),
+				build.WithMaxImageSize(maxImageSize),
+				build.WithCompressionLevel(compressionLevel),
+				build.WithCompression(compression),
+				build.WithOutputFormat(outputFormat),
+				build.WithBuildArgs(buildArgs),
+				build.WithProfile(profile),
+				build.WithOverrideRunAs(overrideRunAs),
+				build.WithCacheDir(cacheDir),
+				build.WithOffline(offline),
 			)
 		},
 	}
@@ -95,23 +145,64 @@ bill of materials) describing the image contents.
 	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "generate SBOMs in dir (defaults to image directory)")
 	cmd.Flags().StringSliceVar(&buildArch, "build-arch", []string{runtime.GOARCH}, "architecture to build for -- default is Go runtime architecture")
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
-	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", sbom.DefaultOptions.Formats, "SBOM formats to output")
+	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", nil, fmt.Sprintf("SBOM formats to output, overrides APKO_SBOM_FORMATS (default %v)", sbom.DefaultOptions.Formats))
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
+	cmd.Flags().BoolVar(&failOnInsecurePaths, "fail-on-insecure-paths", false, "fail when world-writable paths or setuid/setgid binaries are found in the image")
+	cmd.Flags().BoolVar(&strictAnnotations, "strict-annotations", false, "fail when annotations conflict with reserved OCI keys or values derived by apko")
+	cmd.Flags().BoolVar(&strictKeyring, "strict-keyring", false, "fail when repositories are configured without a keyring or a keyring without repositories")
+	cmd.Flags().BoolVar(&strictBaseImage, "strict-base-image", false, "fail when the base image is not referenced by digest")
+	cmd.Flags().BoolVar(&strictEntrypoint, "strict-entrypoint", false, "fail when the image has no entrypoint command, shell fragment or cmd")
+	cmd.Flags().Int64Var(&maxImageSize, "max-image-size", 0, "maximum uncompressed size of the image in bytes (0 for no limit)")
+	cmd.Flags().StringVar(&compression, "compression", "gzip", "compression of the image layer, gzip or zstd (zstd requires OCI media types)")
+	cmd.Flags().StringVar(&compressionLevel, "compression-level", "", "gzip level of the image layer, 0-9 or none (defaults to parallel compression at the default level)")
+	cmd.Flags().BoolVar(&sbomPredicates, "sbom-predicates", false, "write an in-toto statement next to each SBOM")
+	cmd.Flags().BoolVar(&sbomGzip, "sbom-gzip", false, "also write a gzip compressed copy of each SBOM")
+	cmd.Flags().StringVar(&sbomSigningKey, "sbom-signing-key", "", "path of the private key to sign each SBOM with, writing <sbom>.sig (COSIGN_PASSWORD decrypts encrypted keys)")
+	cmd.Flags().StringArrayVar(&rawBuildArgs, "build-arg", []string{}, "build arg which environment and templated annotation values may reference (KEY=VALUE), may be repeated")
+	cmd.Flags().StringVar(&profile, "profile", "", "profile of the configuration to build, overriding its entrypoint and command")
+	cmd.Flags().StringVar(&overrideRunAs, "override-run-as", "", "user[:group] to run the image as instead of the configured run-as user, e.g. root for debug builds")
+	cmd.Flags().BoolVar(&requireSBOM, "require-sbom", false, "fail instead of skipping SBOM generation when no SBOM formats are configured")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "local apk cache to read packages from, overriding contents.cache-dir")
+	cmd.Flags().BoolVar(&offline, "offline", false, "forbid apk network access, failing when a needed package is not in the cache")
+	cmd.Flags().StringVar(&outputFormat, "output-format", build.OutputFormatTarGZ, fmt.Sprintf("format of the output image, %q or %q (an OCI image layout directory)", build.OutputFormatTarGZ, build.OutputFormatOCILayout))
+	cmd.Flags().StringVar(&reportPath, "report-path", "", "path to write a JSON summary of the build, relative to the SBOM output directory")
+	cmd.Flags().StringVar(&installedDBPath, "installed-db-path", "", "path to write a copy of the installed package database of the image")
+	cmd.Flags().StringVar(&provenancePath, "provenance-path", "", "path to write the SLSA provenance of the image")
 
 	return cmd
 }
 
+// assertions returns the checks run on the image filesystem once it is
+// built. The image is only scanned for insecure paths when requested.
+func assertions(failOnInsecurePaths bool) []build.Assertion {
+	checks := []build.Assertion{build.RequireGroupFile(true), build.RequirePasswdFile(true)}
+	if failOnInsecurePaths {
+		checks = append(checks, build.RequireNoInsecurePaths(false))
+	}
+	return checks
+}
+
+// defaultSBOMFormats returns the default SBOM formats when neither the
+// flag nor the APKO_SBOM_FORMATS env variable sets them.
+func defaultSBOMFormats(formats []string) []string {
+	if _, ok := os.LookupEnv("APKO_SBOM_FORMATS"); formats == nil && !ok {
+		return sbom.DefaultOptions.Formats
+	}
+	return formats
+}
+
 func BuildCmd(ctx context.Context, imageRef, outputTarGZ string, opts ...build.Option) error {
 	wd, err := os.MkdirTemp("", "apko-*")
 	if err != nil {
 		return fmt.Errorf("failed to create working directory: %w", err)
 	}
-	defer os.RemoveAll(wd)
 
 	bc, err := build.New(wd, opts...)
 	if err != nil {
+		os.RemoveAll(wd)
 		return err
 	}
+	defer bc.Close()
 
 	if err := bc.Refresh(); err != nil {
 		return err
@@ -136,7 +227,24 @@ func BuildCmd(ctx context.Context, imageRef, outputTarGZ string, opts ...build.O
 		return fmt.Errorf("failed to build layer image: %w", err)
 	}
 
-	defer os.Remove(layerTarGZ)
+	// the layer is kept when it was written to a requested path
+	if bc.Options.TarballOutputPath == "" {
+		defer os.Remove(layerTarGZ)
+	}
+
+	if bc.Options.OutputFormat == build.OutputFormatOCILayout {
+		if err := oci.BuildImageLayoutFromLayer(
+			imageRef, layerTarGZ, outputTarGZ, bc.ImageConfiguration, bc.Logger(), bc.Options); err != nil {
+			return fmt.Errorf("failed to build OCI image layout: %w", err)
+		}
+		bc.Options.LayoutPath = outputTarGZ
+
+		if err := bc.GenerateSBOM(); err != nil {
+			return fmt.Errorf("generating SBOMs: %w", err)
+		}
+
+		return nil
+	}
 
 	if err := bc.GenerateSBOM(); err != nil {
 		return fmt.Errorf("generating SBOMs: %w", err)
@@ -24,7 +24,6 @@ import (
 
 	"github.com/google/go-containerregistry/pkg/name"
 	coci "github.com/sigstore/cosign/pkg/oci"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 
@@ -48,6 +47,23 @@ func publish() *cobra.Command {
 	var debugEnabled bool
 	var withVCS bool
 	var writeSBOM bool
+	var failOnInsecurePaths bool
+	var strictAnnotations bool
+	var strictKeyring bool
+	var strictBaseImage bool
+	var strictEntrypoint bool
+	var maxImageSize int64
+	var compressionLevel string
+	var compression string
+	var sbomPredicates bool
+	var sbomGzip bool
+	var sbomSigningKey string
+	var rawBuildArgs []string
+	var profile string
+	var overrideRunAs string
+	var requireSBOM bool
+	var cacheDir string
+	var offline bool
 
 	cmd := &cobra.Command{
 		Use:   "publish",
@@ -61,26 +77,48 @@ in a keychain.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if !writeSBOM {
 				sbomFormats = []string{}
+			} else {
+				sbomFormats = defaultSBOMFormats(sbomFormats)
 			}
 			archs := types.ParseArchitectures(archstrs)
 			annotations, err := parseAnnotations(rawAnnotations)
 			if err != nil {
 				return fmt.Errorf("parsing annotations from command line: %w", err)
 			}
+			buildArgs, err := parseBuildArgs(rawBuildArgs)
+			if err != nil {
+				return fmt.Errorf("parsing build args from command line: %w", err)
+			}
 			if err := PublishCmd(cmd.Context(), imageRefs, archs,
 				build.WithConfig(args[0]),
 				build.WithProot(useProot),
 				build.WithDockerMediatypes(useDockerMediaTypes),
 				build.WithTags(args[1:]...),
 				build.WithBuildDate(buildDate),
-				build.WithAssertions(build.RequireGroupFile(true), build.RequirePasswdFile(true)),
+				build.WithAssertions(assertions(failOnInsecurePaths)...),
 				build.WithSBOM(sbomPath),
 				build.WithSBOMFormats(sbomFormats),
+				build.WithSBOMPredicates(sbomPredicates),
+				build.WithSBOMGzip(sbomGzip),
+				build.WithSBOMSigningKey(sbomSigningKey),
+				build.WithRequireSBOM(requireSBOM),
 				build.WithExtraKeys(extraKeys),
 				build.WithExtraRepos(extraRepos),
 				build.WithDebugLogging(debugEnabled),
 				build.WithVCS(withVCS),
+				build.WithStrictAnnotations(strictAnnotations),
+				build.WithStrictKeyring(strictKeyring),
+				build.WithStrictBaseImage(strictBaseImage),
+				build.WithStrictEntrypoint(strictEntrypoint),
+				build.WithMaxImageSize(maxImageSize),
+				build.WithCompressionLevel(compressionLevel),
+				build.WithCompression(compression),
 				build.WithAnnotations(annotations),
+				build.WithBuildArgs(buildArgs),
+				build.WithProfile(profile),
+				build.WithOverrideRunAs(overrideRunAs),
+				build.WithCacheDir(cacheDir),
+				build.WithOffline(offline),
 			); err != nil {
 				return err
 			}
@@ -98,9 +136,26 @@ in a keychain.`,
 	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "path to write the SBOMs")
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config.")
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
-	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", sbom.DefaultOptions.Formats, "SBOM formats to output")
+	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", nil, fmt.Sprintf("SBOM formats to output, overrides APKO_SBOM_FORMATS (default %v)", sbom.DefaultOptions.Formats))
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVar(&rawAnnotations, "annotations", []string{}, "OCI annotations to add. Separate with colon (key:value)")
+	cmd.Flags().BoolVar(&failOnInsecurePaths, "fail-on-insecure-paths", false, "fail when world-writable paths or setuid/setgid binaries are found in the image")
+	cmd.Flags().BoolVar(&strictAnnotations, "strict-annotations", false, "fail when annotations conflict with reserved OCI keys or values derived by apko")
+	cmd.Flags().BoolVar(&strictKeyring, "strict-keyring", false, "fail when repositories are configured without a keyring or a keyring without repositories")
+	cmd.Flags().BoolVar(&strictBaseImage, "strict-base-image", false, "fail when the base image is not referenced by digest")
+	cmd.Flags().BoolVar(&strictEntrypoint, "strict-entrypoint", false, "fail when the image has no entrypoint command, shell fragment or cmd")
+	cmd.Flags().Int64Var(&maxImageSize, "max-image-size", 0, "maximum uncompressed size of the image in bytes (0 for no limit)")
+	cmd.Flags().StringVar(&compression, "compression", "gzip", "compression of the image layer, gzip or zstd (zstd requires OCI media types)")
+	cmd.Flags().StringVar(&compressionLevel, "compression-level", "", "gzip level of the image layer, 0-9 or none (defaults to parallel compression at the default level)")
+	cmd.Flags().BoolVar(&sbomPredicates, "sbom-predicates", false, "write an in-toto statement next to each SBOM")
+	cmd.Flags().BoolVar(&sbomGzip, "sbom-gzip", false, "also write a gzip compressed copy of each SBOM")
+	cmd.Flags().StringVar(&sbomSigningKey, "sbom-signing-key", "", "path of the private key to sign each SBOM with, writing <sbom>.sig (COSIGN_PASSWORD decrypts encrypted keys)")
+	cmd.Flags().StringArrayVar(&rawBuildArgs, "build-arg", []string{}, "build arg which environment and templated annotation values may reference (KEY=VALUE), may be repeated")
+	cmd.Flags().StringVar(&profile, "profile", "", "profile of the configuration to build, overriding its entrypoint and command")
+	cmd.Flags().StringVar(&overrideRunAs, "override-run-as", "", "user[:group] to run the image as instead of the configured run-as user, e.g. root for debug builds")
+	cmd.Flags().BoolVar(&requireSBOM, "require-sbom", false, "fail instead of skipping SBOM generation when no SBOM formats are configured")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "local apk cache to read packages from, overriding contents.cache-dir")
+	cmd.Flags().BoolVar(&offline, "offline", false, "forbid apk network access, failing when a needed package is not in the cache")
 
 	return cmd
 }
@@ -110,12 +165,13 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 	if err != nil {
 		return fmt.Errorf("failed to create working directory: %w", err)
 	}
-	defer os.RemoveAll(wd)
 
 	bc, err := build.New(wd, opts...)
 	if err != nil {
+		os.RemoveAll(wd)
 		return err
 	}
+	defer bc.Close()
 
 	if len(bc.Options.SBOMFormats) > 0 {
 		bc.Options.WantSBOM = true
@@ -129,6 +185,9 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 	if len(bc.ImageConfiguration.Archs) == 0 {
 		bc.ImageConfiguration.Archs = archs
 	}
+	if bc.Options.TarballOutputPath != "" && len(bc.ImageConfiguration.Archs) > 1 {
+		return fmt.Errorf("a tarball output path can only be used when publishing a single architecture")
+	}
 	bc.Logger().Infof(
 		"Publishing images for %d architectures: %+v",
 		len(bc.ImageConfiguration.Archs),
@@ -138,12 +197,14 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 	// The build context options is sometimes copied in the next functions. Ensure
 	// we have the directory defined and created by invoking the function early.
 	bc.Options.TempDir()
-	defer os.RemoveAll(bc.Options.TempDir())
 
 	bc.Logger().Printf("building tags %v", bc.Options.Tags)
 
 	var errg errgroup.Group
 	workDir := bc.Options.WorkDir
+	// The SBOMs are generated from the working directory of each arch, so
+	// restore the root one for Close to remove them all.
+	defer func() { bc.Options.WorkDir = workDir }()
 	imgs := map[types.Architecture]coci.SignedImage{}
 
 	// This is a hack to skip the SBOM generation during
@@ -209,11 +270,11 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 	}
 
 	if wantSBOM {
-		logrus.Info("Generating arch image SBOMs")
+		bc.Logger().Info("Generating arch image SBOMs")
 		for arch, img := range imgs {
 			bc.Options.WantSBOM = true
 			bc.Options.Arch = arch
-			bc.Options.TarballPath = filepath.Join(bc.Options.TempDir(), bc.Options.TarballFileName())
+			bc.Options.TarballPath = bc.Options.LayerTarballPath()
 			bc.Options.WorkDir = filepath.Join(workDir, arch.ToAPK())
 
 			if err := bc.GenerateImageSBOM(arch, img); err != nil {
@@ -282,12 +343,12 @@ func publishIndex(bc *build.Context, imgs map[types.Architecture]coci.SignedImag
 	indexDigest name.Digest, idx coci.SignedImageIndex, err error,
 ) {
 	if bc.Options.UseDockerMediaTypes {
-		indexDigest, idx, err = oci.PublishDockerIndex(imgs, logrus.NewEntry(bc.Options.Log), bc.Options.Tags...)
+		indexDigest, idx, err = oci.PublishDockerIndex(imgs, bc.Logger(), bc.Options.Tags...)
 		if err != nil {
 			return name.Digest{}, nil, fmt.Errorf("failed to build Docker index: %w", err)
 		}
 	} else {
-		indexDigest, idx, err = oci.PublishIndex(imgs, logrus.NewEntry(bc.Options.Log), bc.Options.Tags...)
+		indexDigest, idx, err = oci.PublishIndex(imgs, bc.Logger(), bc.Options.Tags...)
 		if err != nil {
 			return name.Digest{}, nil, fmt.Errorf("failed to build OCI index: %w", err)
 		}
@@ -316,3 +377,20 @@ func parseAnnotations(rawAnnotations []string) (map[string]string, error) {
 	}
 	return annotations, nil
 }
+
+// parseBuildArgs parses the KEY=VALUE build args given on the command
+// line. Values may be empty, or contain '='.
+func parseBuildArgs(rawBuildArgs []string) (map[string]string, error) {
+	buildArgs := map[string]string{}
+	for _, s := range rawBuildArgs {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("unable to parse build arg: %s", s)
+		}
+		if _, ok := buildArgs[k]; ok {
+			return nil, fmt.Errorf("build arg %s defined more than once", k)
+		}
+		buildArgs[k] = v
+	}
+	return buildArgs, nil
+}
@@ -18,6 +18,7 @@ package apk
 
 import (
 	"fmt"
+	"os"
 	"runtime"
 
 	"golang.org/x/sync/errgroup"
@@ -75,8 +76,71 @@ func (a *APK) buildExecutor() error {
 
 // Builds the image in Context.WorkDir according to the image configuration
 func (a *APK) Initialize(ic *types.ImageConfiguration) error {
+	if err := checkOffline(&a.Options, ic); err != nil {
+		return err
+	}
+
+	if err := a.checkHostAPKToolsVersion(ic); err != nil {
+		return err
+	}
+
+	// the pinned package checksums are verified against the package files
+	// apk installed, which it only keeps in a cache
+	if len(ic.Contents.PackageChecksums) > 0 && a.Options.CacheDir == "" && ic.Contents.CacheDir == "" {
+		dir, err := os.MkdirTemp("", "apko-apk-cache-*")
+		if err != nil {
+			return fmt.Errorf("creating apk cache directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+
+		a.Options.CacheDir = dir
+		defer func() { a.Options.CacheDir = "" }()
+	}
+
+	if err := a.initWorld(ic); err != nil {
+		return err
+	}
+
+	// install the packages which must be present first, if any
+	if err := a.installPhases(ic); err != nil {
+		return err
+	}
+
+	// sync reality with desired apk world
+	if err := a.impl.FixateWorld(&a.Options, ic, a.executor); err != nil {
+		return fmt.Errorf("failed to fixate apk world: %w", err)
+	}
+
+	// install the local packages, which are in no repository
+	if err := a.impl.InstallLocalPackages(&a.Options, ic, a.executor); err != nil {
+		return fmt.Errorf("failed to install local packages: %w", err)
+	}
+
+	// check the installed packages against the pinned checksums
+	if err := a.impl.VerifyPackageChecksums(&a.Options, ic); err != nil {
+		return fmt.Errorf("failed to verify package checksums: %w", err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		if err := a.impl.NormalizeScriptsTar(&a.Options); err != nil {
+			return fmt.Errorf("failed to normalize scripts.tar: %w", err)
+		}
+		return nil
+	})
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// initWorld initializes the apk database, keyring, repositories and world
+// in the working directory.
+func (a *APK) initWorld(ic *types.ImageConfiguration) error {
 	// initialize apk
-	if err := a.impl.InitDB(&a.Options, *a.executor); err != nil {
+	if err := a.impl.InitDB(&a.Options, ic, *a.executor); err != nil {
 		return fmt.Errorf("failed to initialize apk database: %w", err)
 	}
 
@@ -103,24 +167,97 @@ func (a *APK) Initialize(ic *types.ImageConfiguration) error {
 		return nil
 	})
 
-	if err := eg.Wait(); err != nil {
-		return err
+	return eg.Wait()
+}
+
+// checkHostAPKToolsVersion records the version of the apk-tools of the
+// build host, which runs every apk operation of the build, failing when it
+// is not the version pinned by the image configuration. apko does not
+// install the pinned version itself. The host is only queried once, when
+// no version is recorded yet.
+func (a *APK) checkHostAPKToolsVersion(ic *types.ImageConfiguration) error {
+	version := a.Options.APKToolsVersion
+	if version == "" {
+		v, err := a.impl.APKToolsVersion(&a.Options, a.executor)
+		if err != nil {
+			if ic.Contents.APKToolsVersion != "" {
+				return fmt.Errorf("failed to determine the apk-tools version, %s is required: %w", ic.Contents.APKToolsVersion, err)
+			}
+			// the version is only recorded when it is not pinned
+			a.Options.Logger().Warnf("unable to determine the apk-tools version: %v", err)
+			return nil
+		}
+
+		a.Options.Logger().Infof("using apk-tools %s", v)
+		version = v
 	}
 
-	// sync reality with desired apk world
-	if err := a.impl.FixateWorld(&a.Options, a.executor); err != nil {
-		return fmt.Errorf("failed to fixate apk world: %w", err)
+	if ic.Contents.APKToolsVersion != "" && version != ic.Contents.APKToolsVersion {
+		return fmt.Errorf("apk-tools %s is required, but %s is installed", ic.Contents.APKToolsVersion, version)
 	}
 
-	eg.Go(func() error {
-		if err := a.impl.NormalizeScriptsTar(&a.Options); err != nil {
-			return fmt.Errorf("failed to normalize scripts.tar: %w", err)
-		}
+	a.Options.APKToolsVersion = version
+	return nil
+}
+
+// Resolve returns the packages, at the versions apk resolved, which would
+// be installed from the image configuration, without downloading or
+// installing any of them. The working directory is initialized with the
+// apk database, keyring, repositories and world.
+func (a *APK) Resolve(ic *types.ImageConfiguration) ([]PlannedPackage, error) {
+	if err := checkOffline(&a.Options, ic); err != nil {
+		return nil, err
+	}
+
+	if err := a.checkHostAPKToolsVersion(ic); err != nil {
+		return nil, err
+	}
+
+	if err := a.initWorld(ic); err != nil {
+		return nil, err
+	}
+
+	pkgs, err := a.impl.ResolveWorld(&a.Options, ic, a.executor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve apk world: %w", err)
+	}
+
+	return pkgs, nil
+}
+
+// installPhases installs the packages listed in the install order one
+// phase at a time, so that each of them (and its dependencies) is present
+// before the next one is installed. The full world is restored afterwards.
+func (a *APK) installPhases(ic *types.ImageConfiguration) error {
+	if len(ic.Contents.InstallOrder) == 0 {
 		return nil
-	})
+	}
 
-	if err := eg.Wait(); err != nil {
-		return err
+	for i, pkg := range ic.Contents.InstallOrder {
+		a.Options.Logger().Infof("installing phase %d: %s", i+1, pkg)
+
+		pkgs, err := ic.PhasePackages(a.Options.Arch, ic.Contents.InstallOrder[:i+1])
+		if err != nil {
+			return fmt.Errorf("failed to resolve packages for phase %d: %w", i+1, err)
+		}
+
+		// The phase packages are already resolved for the architecture,
+		// so they no longer reference any package group.
+		phaseIc := *ic
+		phaseIc.Contents.Packages = pkgs
+		phaseIc.Contents.PackageGroups = nil
+
+		if err := a.impl.InitWorld(&a.Options, &phaseIc); err != nil {
+			return fmt.Errorf("failed to initialize apk world for phase %d: %w", i+1, err)
+		}
+
+		if err := a.impl.FixateWorld(&a.Options, &phaseIc, a.executor); err != nil {
+			return fmt.Errorf("failed to fixate apk world for phase %d: %w", i+1, err)
+		}
+	}
+
+	if err := a.impl.InitWorld(&a.Options, ic); err != nil {
+		return fmt.Errorf("failed to initialize apk world: %w", err)
 	}
 
 	return nil
@@ -16,6 +16,9 @@ package apk
 
 import (
 	"archive/tar"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -23,6 +26,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"go.lsp.dev/uri"
@@ -36,11 +41,15 @@ import (
 //counterfeiter:generate . apkImplementation
 
 type apkImplementation interface {
-	InitDB(*options.Options, exec.Executor) error
+	InitDB(*options.Options, *types.ImageConfiguration, exec.Executor) error
 	LoadSystemKeyring(*options.Options, ...string) ([]string, error)
 	InitKeyring(*options.Options, *types.ImageConfiguration) error
 	InitWorld(*options.Options, *types.ImageConfiguration) error
-	FixateWorld(*options.Options, *exec.Executor) error
+	FixateWorld(*options.Options, *types.ImageConfiguration, *exec.Executor) error
+	VerifyPackageChecksums(*options.Options, *types.ImageConfiguration) error
+	ResolveWorld(*options.Options, *types.ImageConfiguration, *exec.Executor) ([]PlannedPackage, error)
+	APKToolsVersion(*options.Options, *exec.Executor) (string, error)
+	InstallLocalPackages(*options.Options, *types.ImageConfiguration, *exec.Executor) error
 	NormalizeScriptsTar(*options.Options) error
 	InitRepositories(*options.Options, *types.ImageConfiguration) error
 }
@@ -50,11 +59,13 @@ type apkDefaultImplementation struct{}
 // Initialize the APK database for a given build context.  It is assumed that
 // the build context itself is properly set up, and that `bc.Options.WorkDir` is set
 // to the path of a working directory.
-func (di *apkDefaultImplementation) InitDB(o *options.Options, e exec.Executor) error {
+func (di *apkDefaultImplementation) InitDB(o *options.Options, ic *types.ImageConfiguration, e exec.Executor) error {
 	o.Logger().Infof("initializing apk database")
-	return e.Execute(
-		"apk", "add", "--initdb", "--arch", o.Arch.ToAPK(), "--root", o.WorkDir,
-	)
+
+	args := []string{"add", "--initdb", "--arch", o.Arch.ToAPK(), "--root", o.WorkDir}
+	args = append(args, apkArgs(o, ic, false)...)
+
+	return e.Execute("apk", args...)
 }
 
 // LoadSystemKeyring returns the keys found in the system keyring
@@ -106,9 +117,35 @@ func (di *apkDefaultImplementation) InitKeyring(o *options.Options, ic *types.Im
 		return fmt.Errorf("failed to make keys dir: %w", err)
 	}
 
+	keysDir := filepath.Join(o.WorkDir, DefaultKeyRingPath)
+
+	// In append mode, keys already present in the working directory (e.g.
+	// from a base image) are kept, and keys with the same fingerprint as
+	// one of them are skipped.
+	fingerprints := map[string]string{}
+	if ic.Contents.AppendKeyring {
+		existing, err := os.ReadDir(keysDir)
+		if err != nil {
+			return fmt.Errorf("reading existing keys: %w", err)
+		}
+
+		for _, f := range existing {
+			if f.IsDir() {
+				continue
+			}
+
+			data, err := os.ReadFile(filepath.Join(keysDir, f.Name()))
+			if err != nil {
+				return fmt.Errorf("failed to read existing apk key: %w", err)
+			}
+			fingerprints[keyFingerprint(data)] = f.Name()
+		}
+	}
+
 	keyFiles := ic.Contents.Keyring
 
-	if len(keyFiles) == 0 {
+	// The existing keys replace the system keyring in append mode.
+	if len(keyFiles) == 0 && len(fingerprints) == 0 {
 		keyFiles, err = di.LoadSystemKeyring(o)
 		if err != nil {
 			return fmt.Errorf("opening system keyring: %w", err)
@@ -120,71 +157,125 @@ func (di *apkDefaultImplementation) InitKeyring(o *options.Options, ic *types.Im
 		keyFiles = append(keyFiles, o.ExtraKeyFiles...)
 	}
 
+	keyData := make([][]byte, len(keyFiles))
+
 	var eg errgroup.Group
 
-	for _, element := range keyFiles {
-		element := element
+	for i, element := range keyFiles {
+		i, element := i, element
 		eg.Go(func() error {
-			o.Logger().Debugf("installing key %v", element)
-
-			// Normalize the element as a URI, so that local paths
-			// are translated into file:// URLs, allowing them to be parsed
-			// into a url.URL{}.
-			var asURI uri.URI
-			if strings.HasPrefix(element, "https://") {
-				asURI, _ = uri.Parse(element)
-			} else {
-				asURI = uri.New(element)
-			}
-			asURL, err := url.Parse(string(asURI))
+			o.Logger().Debugf("fetching key %v", element)
+
+			data, err := fetchKey(element, o.Offline)
 			if err != nil {
-				return fmt.Errorf("failed to parse key as URI: %w", err)
+				return err
 			}
 
-			var data []byte
-			switch asURL.Scheme {
-			case "file":
-				data, err = os.ReadFile(element)
-				if err != nil {
-					return fmt.Errorf("failed to read apk key: %w", err)
-				}
-			case "https":
-				resp, err := http.Get(asURL.String())
-				if err != nil {
-					return fmt.Errorf("failed to fetch apk key: %w", err)
-				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode < 200 || resp.StatusCode > 299 {
-					return errors.New("failed to fetch apk key: http response indicated error")
-				}
-
-				data, err = io.ReadAll(resp.Body)
-				if err != nil {
-					return fmt.Errorf("failed to read apk key response: %w", err)
-				}
-			default:
-				return fmt.Errorf("scheme %s not supported", asURL.Scheme)
+			keyData[i], err = normalizeKey(element, data)
+			return err
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	for i, element := range keyFiles {
+		name := filepath.Base(element)
+		dest := filepath.Join(keysDir, name)
+
+		if ic.Contents.AppendKeyring {
+			fp := keyFingerprint(keyData[i])
+			if existing, ok := fingerprints[fp]; ok {
+				o.Logger().Debugf("key %v is already installed as %s, skipping", element, existing)
+				continue
 			}
 
-			// #nosec G306 -- apk keyring must be publicly readable
-			if err := os.WriteFile(filepath.Join(o.WorkDir, "etc", "apk", "keys", filepath.Base(element)), data,
-				0o644); err != nil {
-				return fmt.Errorf("failed to write apk key: %w", err)
+			// apk matches keys to signatures by name, so keys can't be renamed.
+			if _, err := os.Stat(dest); err == nil {
+				return fmt.Errorf("a different apk key named %s is already installed", name)
 			}
+			fingerprints[fp] = name
+		}
 
-			return nil
-		})
+		o.Logger().Debugf("installing key %v", element)
+
+		// #nosec G306 -- apk keyring must be publicly readable
+		if err := os.WriteFile(dest, keyData[i], 0o644); err != nil {
+			return fmt.Errorf("failed to write apk key: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// fetchKey reads an apk key from a local path or an https URL, which
+// offline builds cannot fetch.
+func fetchKey(element string, offline bool) ([]byte, error) {
+	// Normalize the element as a URI, so that local paths
+	// are translated into file:// URLs, allowing them to be parsed
+	// into a url.URL{}.
+	var asURI uri.URI
+	if strings.HasPrefix(element, "https://") {
+		asURI, _ = uri.Parse(element)
+	} else {
+		asURI = uri.New(element)
+	}
+	asURL, err := url.Parse(string(asURI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key as URI: %w", err)
+	}
+
+	switch asURL.Scheme {
+	case "file":
+		data, err := os.ReadFile(element)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read apk key: %w", err)
+		}
+		return data, nil
+	case "https":
+		if offline {
+			return nil, fmt.Errorf("key %s cannot be fetched in an offline build", element)
+		}
+		resp, err := http.Get(asURL.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch apk key: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, errors.New("failed to fetch apk key: http response indicated error")
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read apk key response: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("scheme %s not supported", asURL.Scheme)
 	}
+}
 
-	return eg.Wait()
+// keyFingerprint returns the SHA-256 fingerprint of the public key in
+// PEM encoded key data, or of the raw data if it can't be decoded.
+func keyFingerprint(data []byte) string {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
 }
 
 // Generates a specified /etc/apk/world file in the build context.
 func (di *apkDefaultImplementation) InitWorld(o *options.Options, ic *types.ImageConfiguration) error {
 	o.Logger().Infof("initializing apk world")
 
-	data := strings.Join(ic.Contents.Packages, "\n")
+	pkgs, err := ic.ResolvedPackages(o.Arch)
+	if err != nil {
+		return fmt.Errorf("resolving packages: %w", err)
+	}
+
+	data := strings.Join(pkgs, "\n")
 
 	// #nosec G306 -- apk world must be publicly readable
 	if err := os.WriteFile(filepath.Join(o.WorkDir, "etc", "apk", "world"),
@@ -195,18 +286,277 @@ func (di *apkDefaultImplementation) InitWorld(o *options.Options, ic *types.Imag
 	return nil
 }
 
+// cacheArgs returns the apk flags selecting its package cache. apk uses
+// no cache unless a cache directory is set, which it then reads packages
+// and indexes from, storing what it fetches there. Offline builds never
+// use the network, so the indexes are not refreshed even with update.
+func cacheArgs(o *options.Options, ic *types.ImageConfiguration, update bool) []string {
+	args := []string{}
+
+	if dir := cacheDir(o, ic); dir == "" {
+		args = append(args, "--no-cache")
+	} else {
+		args = append(args, "--cache-dir", dir)
+	}
+
+	if o.Offline {
+		return append(args, "--no-network")
+	}
+
+	if update {
+		args = append(args, "--update-cache")
+	}
+
+	return args
+}
+
+// cacheDir returns the apk cache directory of the options, which takes
+// precedence over the one of the image configuration, if any.
+func cacheDir(o *options.Options, ic *types.ImageConfiguration) string {
+	if o.CacheDir != "" {
+		return o.CacheDir
+	}
+	return ic.Contents.CacheDir
+}
+
+// checkOffline fails an offline build with remote repositories but no apk
+// cache to read their indexes and packages from.
+func checkOffline(o *options.Options, ic *types.ImageConfiguration) error {
+	if !o.Offline || cacheDir(o, ic) != "" {
+		return nil
+	}
+
+	repos, err := ic.ResolvedRepositories(o.Arch)
+	if err != nil {
+		return err
+	}
+	for _, repo := range append(repos, o.ExtraRepos...) {
+		if !types.IsLocalRepository(repo) {
+			return fmt.Errorf("repository %s is remote, but no apk cache is configured for the offline build", repo)
+		}
+	}
+
+	return nil
+}
+
+// trustArgs returns the apk flags disabling the verification of package
+// and repository signatures, when the image configuration allows it.
+// They are passed to every apk operation reading the repositories.
+func trustArgs(ic *types.ImageConfiguration) []string {
+	if ic.Contents.AllowUntrusted {
+		return []string{"--allow-untrusted"}
+	}
+	return []string{}
+}
+
+// apkArgs returns the flags passed to every apk operation: the cache and
+// trust flags, followed by the apk options of the image configuration.
+func apkArgs(o *options.Options, ic *types.ImageConfiguration, update bool) []string {
+	args := cacheArgs(o, ic, update)
+	args = append(args, trustArgs(ic)...)
+	return append(args, ic.Contents.APKOptions...)
+}
+
 // Force apk's resolver to re-resolve the requested dependencies in /etc/apk/world.
-func (di *apkDefaultImplementation) FixateWorld(o *options.Options, e *exec.Executor) error {
+func (di *apkDefaultImplementation) FixateWorld(o *options.Options, ic *types.ImageConfiguration, e *exec.Executor) error {
 	o.Logger().Infof("synchronizing with desired apk world")
 
 	args := []string{
-		"fix", "--root", o.WorkDir, "--no-scripts", "--no-cache",
-		"--update-cache", "--arch", o.Arch.ToAPK(),
+		"fix", "--root", o.WorkDir, "--no-scripts",
+		"--arch", o.Arch.ToAPK(),
 	}
+	if ic.Contents.AllowUntrusted {
+		o.Logger().Warnf("INSECURE: installing packages without verifying their signatures")
+	}
+	args = append(args, apkArgs(o, ic, true)...)
 
 	return e.Execute("apk", args...)
 }
 
+// InstallLocalPackages installs the local .apk files of the image
+// configuration, if any, along with their dependencies.
+func (di *apkDefaultImplementation) InstallLocalPackages(o *options.Options, ic *types.ImageConfiguration, e *exec.Executor) error {
+	if len(ic.Contents.LocalPackages) == 0 {
+		return nil
+	}
+
+	o.Logger().Infof("installing %d local packages", len(ic.Contents.LocalPackages))
+
+	args := []string{
+		"add", "--root", o.WorkDir, "--no-scripts",
+		"--arch", o.Arch.ToAPK(),
+	}
+	args = append(args, apkArgs(o, ic, false)...)
+
+	return e.Execute("apk", append(args, ic.Contents.LocalPackages...)...)
+}
+
+// PlannedPackage is a package which apk would install, at the version
+// it resolved.
+type PlannedPackage struct {
+	Name    string
+	Version string
+}
+
+// The lines of the output of a simulated apk run listing the packages it
+// would install or upgrade, e.g. "(1/5) Installing musl (1.2.3-r0)".
+var plannedPackageRegexp = regexp.MustCompile(`^\(\d+/\d+\) (?:Installing|Upgrading) (\S+) \((?:\S+ -> )?(\S+)\)`)
+
+// parsePlannedPackages returns the packages listed in the output of a
+// simulated apk run.
+func parsePlannedPackages(output []byte) []PlannedPackage {
+	pkgs := []PlannedPackage{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if m := plannedPackageRegexp.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			pkgs = append(pkgs, PlannedPackage{Name: m[1], Version: m[2]})
+		}
+	}
+	return pkgs
+}
+
+// ResolveWorld resolves the dependencies in /etc/apk/world against the
+// repositories, returning the packages apk would install without
+// downloading or installing them.
+func (di *apkDefaultImplementation) ResolveWorld(o *options.Options, ic *types.ImageConfiguration, e *exec.Executor) ([]PlannedPackage, error) {
+	o.Logger().Infof("resolving apk world")
+
+	args := []string{
+		"fix", "--root", o.WorkDir, "--simulate", "--no-scripts",
+		"--arch", o.Arch.ToAPK(),
+	}
+	args = append(args, apkArgs(o, ic, true)...)
+
+	out, err := e.ExecuteOutput("apk", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePlannedPackages(out), nil
+}
+
+// The output of apk --version, e.g.
+// "apk-tools 2.12.9, compiled for x86_64.".
+var apkToolsVersionRegexp = regexp.MustCompile(`^apk-tools (\S+?),? `)
+
+// parseAPKToolsVersion returns the version in the output of apk --version.
+func parseAPKToolsVersion(output []byte) (string, error) {
+	m := apkToolsVersionRegexp.FindStringSubmatch(strings.TrimSpace(string(output)) + " ")
+	if m == nil {
+		return "", fmt.Errorf("unexpected apk --version output %q", strings.TrimSpace(string(output)))
+	}
+	return m[1], nil
+}
+
+// APKToolsVersion returns the version of the apk-tools running the build.
+func (di *apkDefaultImplementation) APKToolsVersion(o *options.Options, e *exec.Executor) (string, error) {
+	out, err := e.ExecuteOutput("apk", "--version")
+	if err != nil {
+		return "", err
+	}
+
+	return parseAPKToolsVersion(out)
+}
+
+// cachedPackageName returns the name of the file apk caches the package
+// as, e.g. "busybox-1.35.0-r17.1a2b3c4d.apk", where the suffix is the hex
+// encoding of the first bytes of its checksum.
+func cachedPackageName(pkg InstalledPackage) (string, error) {
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pkg.Checksum, "Q1"))
+	if err != nil || !strings.HasPrefix(pkg.Checksum, "Q1") || len(sum) < 4 {
+		return "", fmt.Errorf("malformed checksum %q of installed package %s", pkg.Checksum, pkg.Name)
+	}
+	return fmt.Sprintf("%s-%s.%x.apk", pkg.Name, pkg.Version, sum[:4]), nil
+}
+
+// installedPackageFile returns the path of the package file apk installed
+// the package from: the file cached by apk or, for packages of local
+// repositories, which apk does not cache, the file of the repository.
+func installedPackageFile(o *options.Options, ic *types.ImageConfiguration, pkg InstalledPackage) (string, error) {
+	cached, err := cachedPackageName(pkg)
+	if err != nil {
+		return "", err
+	}
+
+	candidates := []string{}
+	if dir := cacheDir(o, ic); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, cached))
+	}
+
+	repos, err := ic.ResolvedRepositories(o.Arch)
+	if err != nil {
+		return "", err
+	}
+	for _, repo := range repos {
+		fields := strings.Fields(repo)
+		dir := strings.TrimPrefix(fields[len(fields)-1], "file://")
+		if strings.HasPrefix(dir, "/") || strings.HasPrefix(dir, ".") {
+			candidates = append(candidates, filepath.Join(dir, o.Arch.ToAPK(), fmt.Sprintf("%s-%s.apk", pkg.Name, pkg.Version)))
+		}
+	}
+
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("package file of %s-%s was not found in the apk cache or the local repositories", pkg.Name, pkg.Version)
+}
+
+// VerifyPackageChecksums checks that the pinned packages are installed at
+// the pinned versions, and that the SHA-256 checksums of the package files
+// they were installed from match the pinned ones.
+func (di *apkDefaultImplementation) VerifyPackageChecksums(o *options.Options, ic *types.ImageConfiguration) error {
+	if len(ic.Contents.PackageChecksums) == 0 {
+		return nil
+	}
+
+	o.Logger().Infof("verifying %d pinned package checksums", len(ic.Contents.PackageChecksums))
+
+	pkgs, err := InstalledPackages(o.WorkDir)
+	if err != nil {
+		return err
+	}
+	installed := make(map[string]InstalledPackage, len(pkgs))
+	for _, pkg := range pkgs {
+		installed[pkg.Name] = pkg
+	}
+
+	pinned := make([]string, 0, len(ic.Contents.PackageChecksums))
+	for pkg := range ic.Contents.PackageChecksums {
+		pinned = append(pinned, pkg)
+	}
+	sort.Strings(pinned)
+
+	for _, pin := range pinned {
+		name, version, _ := strings.Cut(pin, "=")
+		pkg, ok := installed[name]
+		if !ok {
+			return fmt.Errorf("pinned package %s is not installed", name)
+		}
+		if pkg.Version != version {
+			return fmt.Errorf("pinned package %s is installed at version %s", pin, pkg.Version)
+		}
+
+		path, err := installedPackageFile(o, ic, pkg)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading package file of %s: %w", pin, err)
+		}
+
+		sum := fmt.Sprintf("%x", sha256.Sum256(data))
+		if want := ic.Contents.PackageChecksums[pin]; !strings.EqualFold(sum, want) {
+			return fmt.Errorf("checksum mismatch for package %s: expected %s, got %s", pin, want, sum)
+		}
+	}
+
+	return nil
+}
+
 func (di *apkDefaultImplementation) NormalizeScriptsTar(o *options.Options) error {
 	scriptsTar := filepath.Join(o.WorkDir, "lib", "apk", "db", "scripts.tar")
 
@@ -265,7 +615,12 @@ func (di *apkDefaultImplementation) NormalizeScriptsTar(o *options.Options) erro
 func (di *apkDefaultImplementation) InitRepositories(o *options.Options, ic *types.ImageConfiguration) error {
 	o.Logger().Infof("initializing apk repositories")
 
-	data := strings.Join(ic.Contents.Repositories, "\n")
+	repos, err := ic.ResolvedRepositories(o.Arch)
+	if err != nil {
+		return err
+	}
+
+	data := strings.Join(repos, "\n")
 
 	if len(o.ExtraRepos) > 0 {
 		// TODO(kaniini): not sure if the extra newline is actually needed
@@ -16,6 +16,7 @@ package apk_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -23,6 +24,8 @@ import (
 	"chainguard.dev/apko/pkg/apk"
 	"chainguard.dev/apko/pkg/apk/apkfakes"
 	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/exec"
+	"chainguard.dev/apko/pkg/options"
 )
 
 func TestInitialize(t *testing.T) {
@@ -67,6 +70,20 @@ func TestInitialize(t *testing.T) {
 			msg:         "FixateWorld should fail",
 			shouldError: true,
 		},
+		{ // InstallLocalPackages fails
+			prepare: func(fai *apkfakes.FakeApkImplementation) {
+				fai.InstallLocalPackagesReturns(fakeErr)
+			},
+			msg:         "InstallLocalPackages should fail",
+			shouldError: true,
+		},
+		{ // VerifyPackageChecksums fails
+			prepare: func(fai *apkfakes.FakeApkImplementation) {
+				fai.VerifyPackageChecksumsReturns(fakeErr)
+			},
+			msg:         "VerifyPackageChecksums should fail",
+			shouldError: true,
+		},
 		{ // NormalizeScriptsTar fails
 			prepare: func(fai *apkfakes.FakeApkImplementation) {
 				fai.NormalizeScriptsTarReturns(fakeErr)
@@ -88,3 +105,92 @@ func TestInitialize(t *testing.T) {
 		}
 	}
 }
+
+func TestInitializeInstallOrder(t *testing.T) {
+	mock := &apkfakes.FakeApkImplementation{}
+
+	sut := apk.New()
+	sut.SetImplementation(mock)
+	sut.Options.Arch = types.ParseArchitecture("amd64")
+
+	ic := &types.ImageConfiguration{}
+	ic.Contents.Packages = []string{"nginx", "ca-certificates=20220614-r0", "busybox>1.35[arch=amd64]", "busybox-arm[arch=arm64]"}
+	ic.Contents.InstallOrder = []string{"busybox", "ca-certificates"}
+	require.NoError(t, sut.Initialize(ic))
+
+	// One world per phase, plus the initial and the restored full world.
+	require.Equal(t, 4, mock.InitWorldCallCount())
+	require.Equal(t, 3, mock.FixateWorldCallCount())
+
+	// The phases keep the version constraints of the resolved packages.
+	_, phaseIc, _ := mock.FixateWorldArgsForCall(0)
+	require.Equal(t, []string{"busybox>1.35"}, phaseIc.Contents.Packages)
+
+	_, phaseIc, _ = mock.FixateWorldArgsForCall(1)
+	require.Equal(t, []string{"ca-certificates=20220614-r0", "busybox>1.35"}, phaseIc.Contents.Packages)
+
+	_, finalIc := mock.InitWorldArgsForCall(3)
+	require.Equal(t, ic.Contents.Packages, finalIc.Contents.Packages)
+}
+
+func TestInitializePackageChecksumsCache(t *testing.T) {
+	mock := &apkfakes.FakeApkImplementation{}
+
+	sut := apk.New()
+	sut.SetImplementation(mock)
+
+	// Pinned packages are installed through a cache, so that the package
+	// files they were installed from can be verified.
+	var cacheDir string
+	mock.FixateWorldStub = func(o *options.Options, _ *types.ImageConfiguration, _ *exec.Executor) error {
+		cacheDir = o.CacheDir
+		return nil
+	}
+	mock.VerifyPackageChecksumsStub = func(o *options.Options, _ *types.ImageConfiguration) error {
+		require.Equal(t, cacheDir, o.CacheDir)
+		require.DirExists(t, o.CacheDir)
+		return nil
+	}
+
+	ic := &types.ImageConfiguration{}
+	ic.Contents.PackageChecksums = map[string]string{"nginx=1.22.0-r1": strings.Repeat("0", 64)}
+	require.NoError(t, sut.Initialize(ic))
+	require.NotEmpty(t, cacheDir)
+	require.NoDirExists(t, cacheDir)
+	require.Empty(t, sut.Options.CacheDir)
+
+	// The configured cache is used otherwise.
+	mock.VerifyPackageChecksumsStub = nil
+	ic.Contents.CacheDir = "/var/cache/apk"
+	require.NoError(t, sut.Initialize(ic))
+	require.Empty(t, cacheDir)
+}
+
+func TestInitializeAPKToolsVersion(t *testing.T) {
+	mock := &apkfakes.FakeApkImplementation{}
+	mock.APKToolsVersionReturns("2.12.9", nil)
+
+	sut := apk.New()
+	sut.SetImplementation(mock)
+
+	// The version is recorded when it is not pinned, and the host is only
+	// checked once.
+	ic := &types.ImageConfiguration{}
+	require.NoError(t, sut.Initialize(ic))
+	require.Equal(t, "2.12.9", sut.Options.APKToolsVersion)
+	require.NoError(t, sut.Initialize(ic))
+	require.Equal(t, 1, mock.APKToolsVersionCallCount())
+
+	// The recorded version is checked against the pinned one.
+	ic.Contents.APKToolsVersion = "2.12.9"
+	require.NoError(t, sut.Initialize(ic))
+
+	ic.Contents.APKToolsVersion = "2.14.0"
+	require.ErrorContains(t, sut.Initialize(ic), "apk-tools 2.14.0 is required, but 2.12.9 is installed")
+	require.Equal(t, 1, mock.APKToolsVersionCallCount())
+
+	// A pinned version fails the build when it cannot be determined.
+	sut.Options.APKToolsVersion = ""
+	mock.APKToolsVersionReturns("", fmt.Errorf("synthetic error"))
+	require.ErrorContains(t, sut.Initialize(ic), "failed to determine the apk-tools version")
+}
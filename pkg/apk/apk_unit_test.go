@@ -15,15 +15,31 @@
 package apk
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 
 	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/exec"
+	"chainguard.dev/apko/pkg/exec/execfakes"
 	"chainguard.dev/apko/pkg/options"
 )
 
@@ -76,13 +92,7 @@ func TestInitKeyring(t *testing.T) {
 	}
 
 	// Create an image configuration
-	ic := &types.ImageConfiguration{
-		Contents: struct {
-			Repositories []string
-			Keyring      []string
-			Packages     []string
-		}{},
-	}
+	ic := &types.ImageConfiguration{}
 
 	keyPath := filepath.Join(dir, "alpine-devel@lists.alpinelinux.org-5e69ca50.rsa.pub")
 	writeTestKey(t, keyPath)
@@ -108,19 +118,82 @@ func TestInitKeyring(t *testing.T) {
 
 func writeTestKey(t *testing.T, path string) {
 	demoKey := `-----BEGIN PUBLIC KEY-----
-	MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAwXEJ8uVwJPODshTkf2BH
-	pH5fVVDppOa974+IQJsZDmGd3Ny0dcd+WwYUhNFUW3bAfc3/egaMWCaprfaHn+oS
-	4ddbOFgbX8JCHdru/QMAAU0aEWSMybfJGA569c38fNUF/puX6XK/y0lD2SS3YQ/a
-	oJ5jb5eNrQGR1HHMAd0G9WC4JeZ6WkVTkrcOw55F00aUPGEjejreXBerhTyFdabo
-	dSfc1TILWIYD742Lkm82UBOPsOSdSfOdsMOOkSXxhdCJuCQQ70DHkw7Epy9r+X33
-	ybI4r1cARcV75OviyhD8CFhAlapLKaYnRFqFxlA515e6h8i8ih/v3MSEW17cCK0b
-	QwIDAQAB
-	-----END PUBLIC KEY-----
+MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAwXEJ8uVwJPODshTkf2BH
+pH5fVVDppOa974+IQJsZDmGd3Ny0dcd+WwYUhNFUW3bAfc3/egaMWCaprfaHn+oS
+4ddbOFgbX8JCHdru/QMAAU0aEWSMybfJGA569c38fNUF/puX6XK/y0lD2SS3YQ/a
+oJ5jb5eNrQGR1HHMAd0G9WC4JeZ6WkVTkrcOw55F00aUPGEjejreXBerhTyFdabo
+dSfc1TILWIYD742Lkm82UBOPsOSdSfOdsMOOkSXxhdCJuCQQ70DHkw7Epy9r+X33
+ybI4r1cARcV75OviyhD8CFhAlapLKaYnRFqFxlA515e6h8i8ih/v3MSEW17cCK0b
+QwIDAQAB
+-----END PUBLIC KEY-----
 `
 	// Put a valid key in the directory
 	require.NoError(t, os.WriteFile(path, []byte(demoKey), os.FileMode(0o644)))
 }
 
+// writeRSAKey writes a new RSA public key, PEM encoded, to path.
+func writeRSAKey(t *testing.T, path string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	data, err := encodeRSAPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+}
+
+func TestNormalizeKey(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "test.rsa.pub")
+	writeTestKey(t, keyPath)
+	pemKey, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+
+	// PEM RSA keys are kept as is
+	data, err := normalizeKey("test.rsa.pub", pemKey)
+	require.NoError(t, err)
+	require.Equal(t, pemKey, data)
+
+	block, _ := pem.Decode(pemKey)
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+	rsaPub := pub.(*rsa.PublicKey)
+
+	// PKCS #1 keys are converted
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(rsaPub)})
+	data, err = normalizeKey("test.rsa.pub", pkcs1)
+	require.NoError(t, err)
+	require.Equal(t, pemKey, data)
+
+	// and so are armored PGP keys
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", &packet.Config{RSABits: 2048})
+	require.NoError(t, err)
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+
+	data, err = normalizeKey("pgp.rsa.pub", armored.Bytes())
+	require.NoError(t, err)
+	want, err := encodeRSAPublicKey(entity.PrimaryKey.PublicKey.(*rsa.PublicKey))
+	require.NoError(t, err)
+	require.Equal(t, want, data)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	require.NoError(t, err)
+
+	for key, msg := range map[string]string{
+		"not a key": "is not PEM encoded",
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})):       "is a non-RSA key",
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})):      `is a PEM "CERTIFICATE" block`,
+		"-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n-----END PGP PUBLIC KEY BLOCK-----": "holds 0 keys",
+	} {
+		_, err := normalizeKey("bad.rsa.pub", []byte(key))
+		require.ErrorContains(t, err, msg)
+	}
+}
+
 func TestLoadSystemKeyring(t *testing.T) {
 	di := apkDefaultImplementation{}
 
@@ -149,3 +222,221 @@ func TestLoadSystemKeyring(t *testing.T) {
 	_, err = di.LoadSystemKeyring(o)
 	require.NoError(t, err, "testing loading system keyring")
 }
+
+func TestInitKeyringAppend(t *testing.T) {
+	dir := t.TempDir()
+	di := apkDefaultImplementation{}
+	o := &options.Options{
+		Log:     &logrus.Logger{},
+		WorkDir: dir,
+	}
+	keysDir := filepath.Join(dir, DefaultKeyRingPath)
+	require.NoError(t, os.MkdirAll(keysDir, 0o755))
+
+	// A key installed by the base image.
+	writeTestKey(t, filepath.Join(keysDir, "base.rsa.pub"))
+
+	src := t.TempDir()
+	sameKey := filepath.Join(src, "same.rsa.pub")
+	writeTestKey(t, sameKey)
+	otherKey := filepath.Join(src, "other.rsa.pub")
+	writeRSAKey(t, otherKey)
+
+	ic := &types.ImageConfiguration{}
+	ic.Contents.AppendKeyring = true
+	ic.Contents.Keyring = []string{sameKey, otherKey}
+	require.NoError(t, di.InitKeyring(o, ic))
+
+	keys, err := os.ReadDir(keysDir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, k := range keys {
+		names = append(names, k.Name())
+	}
+	require.Equal(t, []string{"base.rsa.pub", "other.rsa.pub"}, names)
+
+	// A different key with the name of an installed one is an error.
+	writeRSAKey(t, otherKey)
+	require.Error(t, di.InitKeyring(o, ic))
+
+	// The existing keys are used instead of the system keyring.
+	ic.Contents.Keyring = nil
+	require.NoError(t, di.InitKeyring(o, ic))
+}
+
+func TestVerifyPackageChecksums(t *testing.T) {
+	dir := t.TempDir()
+	cache := t.TempDir()
+	di := apkDefaultImplementation{}
+	o := &options.Options{
+		Log:      &logrus.Logger{},
+		WorkDir:  dir,
+		CacheDir: cache,
+		Arch:     types.ParseArchitecture("x86_64"),
+	}
+
+	dbDir := filepath.Join(dir, "lib", "apk", "db")
+	require.NoError(t, os.MkdirAll(dbDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dbDir, "installed"), []byte(
+		"C:Q1abc=\nP:busybox\nV:1.35.0-r17\n\nC:Q1AQIDBAUGBwgJCgsMDQ4PEBESExQ=\nP:nginx\nV:1.22.0-r1",
+	), 0o644))
+
+	ic := &types.ImageConfiguration{}
+	ic.Contents.PackageChecksums = map[string]string{"nginx=1.23.0-r0": strings.Repeat("0", 64)}
+	require.ErrorContains(t, di.VerifyPackageChecksums(o, ic), "installed at version 1.22.0-r1")
+
+	ic.Contents.PackageChecksums = map[string]string{"curl=7.84.0-r0": strings.Repeat("0", 64)}
+	require.ErrorContains(t, di.VerifyPackageChecksums(o, ic), "not installed")
+
+	// The package file apk cached while installing the package is checked.
+	ic.Contents.PackageChecksums = map[string]string{"nginx=1.22.0-r1": strings.Repeat("0", 64)}
+	require.ErrorContains(t, di.VerifyPackageChecksums(o, ic), "not found in the apk cache")
+
+	data := []byte("nginx package")
+	require.NoError(t, os.WriteFile(filepath.Join(cache, "nginx-1.22.0-r1.01020304.apk"), data, 0o644))
+	require.ErrorContains(t, di.VerifyPackageChecksums(o, ic), "checksum mismatch")
+
+	ic.Contents.PackageChecksums = map[string]string{"nginx=1.22.0-r1": fmt.Sprintf("%X", sha256.Sum256(data))}
+	require.NoError(t, di.VerifyPackageChecksums(o, ic))
+
+	// Packages of local repositories are not cached by apk.
+	o.CacheDir = ""
+	repo := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, o.Arch.ToAPK()), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, o.Arch.ToAPK(), "nginx-1.22.0-r1.apk"), data, 0o644))
+	ic.Contents.Repositories = []string{"@local " + repo}
+	require.NoError(t, di.VerifyPackageChecksums(o, ic))
+}
+
+func TestParsePlannedPackages(t *testing.T) {
+	out := []byte(`fetch https://dl-cdn.alpinelinux.org/alpine/edge/main/x86_64/APKINDEX.tar.gz
+(1/3) Installing musl (1.2.3-r0)
+(2/3) Upgrading busybox (1.35.0-r17 -> 1.35.0-r18)
+  (3/3) Installing alpine-baselayout-data (3.2.0-r23)
+OK: 7 MiB in 3 packages
+`)
+	require.Equal(t, []PlannedPackage{
+		{Name: "musl", Version: "1.2.3-r0"},
+		{Name: "busybox", Version: "1.35.0-r18"},
+		{Name: "alpine-baselayout-data", Version: "3.2.0-r23"},
+	}, parsePlannedPackages(out))
+
+	require.Empty(t, parsePlannedPackages([]byte("OK: 0 MiB in 0 packages\n")))
+}
+
+func TestParseAPKToolsVersion(t *testing.T) {
+	for out, want := range map[string]string{
+		"apk-tools 2.12.9, compiled for x86_64.\n": "2.12.9",
+		"apk-tools 2.14.0_rc1\n":                   "2.14.0_rc1",
+	} {
+		version, err := parseAPKToolsVersion([]byte(out))
+		require.NoError(t, err)
+		require.Equal(t, want, version)
+	}
+
+	_, err := parseAPKToolsVersion([]byte("apk: unrecognized option\n"))
+	require.Error(t, err)
+}
+
+func TestFixateWorldAPKOptions(t *testing.T) {
+	di := apkDefaultImplementation{}
+	o := &options.Options{
+		Log:     &logrus.Logger{},
+		WorkDir: t.TempDir(),
+		Arch:    types.ParseArchitecture("amd64"),
+	}
+
+	e, err := exec.New(o.WorkDir, o.Logger())
+	require.NoError(t, err)
+	fake := &execfakes.FakeExecutorImplementation{}
+	e.SetImplementation(fake)
+
+	ic := &types.ImageConfiguration{}
+	ic.Contents.APKOptions = []string{"--force-overwrite", "--no-network"}
+	require.NoError(t, di.FixateWorld(o, ic, e))
+	require.Equal(t, 1, fake.RunCallCount())
+	cmd, _, _ := fake.RunArgsForCall(0)
+	require.Equal(t, []string{"--force-overwrite", "--no-network"}, cmd.Args[len(cmd.Args)-2:])
+}
+
+func TestCacheArgs(t *testing.T) {
+	ic := &types.ImageConfiguration{}
+	require.Equal(t, []string{"--no-cache", "--update-cache"}, cacheArgs(&options.Options{}, ic, true))
+	require.Equal(t, []string{"--no-cache"}, cacheArgs(&options.Options{}, ic, false))
+
+	ic.Contents.CacheDir = "/var/cache/apk"
+	require.Equal(t, []string{"--cache-dir", "/var/cache/apk", "--update-cache"}, cacheArgs(&options.Options{}, ic, true))
+	require.Equal(t, []string{"--cache-dir", "/tmp/cache"}, cacheArgs(&options.Options{CacheDir: "/tmp/cache"}, ic, false))
+	require.Equal(t, []string{"--cache-dir", "/var/cache/apk", "--no-network"}, cacheArgs(&options.Options{Offline: true}, ic, true))
+}
+
+func TestOffline(t *testing.T) {
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = &http.Transport{
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			t.Errorf("unexpected request to %s", r.URL)
+			return nil, fmt.Errorf("requests are rejected")
+		},
+	}
+	t.Cleanup(func() { http.DefaultClient.Transport = transport })
+
+	di := apkDefaultImplementation{}
+	o := &options.Options{Log: &logrus.Logger{}, WorkDir: t.TempDir(), Offline: true}
+	ic := &types.ImageConfiguration{}
+
+	// Remote keys are not fetched.
+	ic.Contents.Keyring = []string{"https://alpinelinux.org/keys/alpine-devel@lists.alpinelinux.org-4a6a0840.rsa.pub"}
+	require.ErrorContains(t, di.InitKeyring(o, ic), "cannot be fetched in an offline build")
+
+	// Remote repositories are only served from the apk cache.
+	ic.Contents.Repositories = []string{"https://dl-cdn.alpinelinux.org/alpine/edge/main", "@local " + t.TempDir()}
+	require.ErrorContains(t, checkOffline(o, ic), "no apk cache is configured")
+
+	o.CacheDir = t.TempDir()
+	require.NoError(t, checkOffline(o, ic))
+
+	o.CacheDir = ""
+	ic.Contents.Repositories = []string{"@local " + t.TempDir()}
+	require.NoError(t, checkOffline(o, ic))
+}
+
+func TestAPKArgs(t *testing.T) {
+	di := apkDefaultImplementation{}
+	o := &options.Options{
+		Log:      &logrus.Logger{},
+		WorkDir:  t.TempDir(),
+		Arch:     types.ParseArchitecture("amd64"),
+		CacheDir: "/tmp/cache",
+	}
+
+	e, err := exec.New(o.WorkDir, o.Logger())
+	require.NoError(t, err)
+	fake := &execfakes.FakeExecutorImplementation{}
+	e.SetImplementation(fake)
+
+	ic := &types.ImageConfiguration{}
+	ic.Contents.AllowUntrusted = true
+	ic.Contents.APKOptions = []string{"--force-overwrite"}
+	ic.Contents.LocalPackages = []string{"/tmp/app.apk"}
+
+	require.NoError(t, di.InitDB(o, ic, *e))
+	require.NoError(t, di.FixateWorld(o, ic, e))
+	require.NoError(t, di.InstallLocalPackages(o, ic, e))
+	_, err = di.ResolveWorld(o, ic, e)
+	require.NoError(t, err)
+
+	// every apk operation gets the cache, trust and apk options
+	check := func(args []string) {
+		require.Contains(t, args, "--allow-untrusted", args)
+		require.Contains(t, args, "--force-overwrite", args)
+		require.Contains(t, strings.Join(args, " "), "--cache-dir /tmp/cache", args)
+	}
+	require.Equal(t, 3, fake.RunCallCount())
+	for i := 0; i < fake.RunCallCount(); i++ {
+		cmd, _, _ := fake.RunArgsForCall(i)
+		check(cmd.Args)
+	}
+	require.Equal(t, 1, fake.OutputCallCount())
+	cmd, _, _ := fake.OutputArgsForCall(0)
+	check(cmd.Args)
+}
@@ -1,20 +1,50 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
 // Code generated by counterfeiter. DO NOT EDIT.
 package apkfakes
 
 import (
 	"sync"
 
+	"chainguard.dev/apko/pkg/apk"
 	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/exec"
 	"chainguard.dev/apko/pkg/options"
 )
 
 type FakeApkImplementation struct {
-	FixateWorldStub        func(*options.Options, *exec.Executor) error
+	APKToolsVersionStub        func(*options.Options, *exec.Executor) (string, error)
+	aPKToolsVersionMutex       sync.RWMutex
+	aPKToolsVersionArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *exec.Executor
+	}
+	aPKToolsVersionReturns struct {
+		result1 string
+		result2 error
+	}
+	aPKToolsVersionReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	FixateWorldStub        func(*options.Options, *types.ImageConfiguration, *exec.Executor) error
 	fixateWorldMutex       sync.RWMutex
 	fixateWorldArgsForCall []struct {
 		arg1 *options.Options
-		arg2 *exec.Executor
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
 	}
 	fixateWorldReturns struct {
 		result1 error
@@ -22,11 +52,12 @@ type FakeApkImplementation struct {
 	fixateWorldReturnsOnCall map[int]struct {
 		result1 error
 	}
-	InitDBStub        func(*options.Options, exec.Executor) error
+	InitDBStub        func(*options.Options, *types.ImageConfiguration, exec.Executor) error
 	initDBMutex       sync.RWMutex
 	initDBArgsForCall []struct {
 		arg1 *options.Options
-		arg2 exec.Executor
+		arg2 *types.ImageConfiguration
+		arg3 exec.Executor
 	}
 	initDBReturns struct {
 		result1 error
@@ -70,6 +101,19 @@ type FakeApkImplementation struct {
 	initWorldReturnsOnCall map[int]struct {
 		result1 error
 	}
+	InstallLocalPackagesStub        func(*options.Options, *types.ImageConfiguration, *exec.Executor) error
+	installLocalPackagesMutex       sync.RWMutex
+	installLocalPackagesArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
+	}
+	installLocalPackagesReturns struct {
+		result1 error
+	}
+	installLocalPackagesReturnsOnCall map[int]struct {
+		result1 error
+	}
 	LoadSystemKeyringStub        func(*options.Options, ...string) ([]string, error)
 	loadSystemKeyringMutex       sync.RWMutex
 	loadSystemKeyringArgsForCall []struct {
@@ -95,23 +139,116 @@ type FakeApkImplementation struct {
 	normalizeScriptsTarReturnsOnCall map[int]struct {
 		result1 error
 	}
+	ResolveWorldStub        func(*options.Options, *types.ImageConfiguration, *exec.Executor) ([]apk.PlannedPackage, error)
+	resolveWorldMutex       sync.RWMutex
+	resolveWorldArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
+	}
+	resolveWorldReturns struct {
+		result1 []apk.PlannedPackage
+		result2 error
+	}
+	resolveWorldReturnsOnCall map[int]struct {
+		result1 []apk.PlannedPackage
+		result2 error
+	}
+	VerifyPackageChecksumsStub        func(*options.Options, *types.ImageConfiguration) error
+	verifyPackageChecksumsMutex       sync.RWMutex
+	verifyPackageChecksumsArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}
+	verifyPackageChecksumsReturns struct {
+		result1 error
+	}
+	verifyPackageChecksumsReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeApkImplementation) FixateWorld(arg1 *options.Options, arg2 *exec.Executor) error {
+func (fake *FakeApkImplementation) APKToolsVersion(arg1 *options.Options, arg2 *exec.Executor) (string, error) {
+	fake.aPKToolsVersionMutex.Lock()
+	ret, specificReturn := fake.aPKToolsVersionReturnsOnCall[len(fake.aPKToolsVersionArgsForCall)]
+	fake.aPKToolsVersionArgsForCall = append(fake.aPKToolsVersionArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *exec.Executor
+	}{arg1, arg2})
+	stub := fake.APKToolsVersionStub
+	fakeReturns := fake.aPKToolsVersionReturns
+	fake.recordInvocation("APKToolsVersion", []interface{}{arg1, arg2})
+	fake.aPKToolsVersionMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeApkImplementation) APKToolsVersionCallCount() int {
+	fake.aPKToolsVersionMutex.RLock()
+	defer fake.aPKToolsVersionMutex.RUnlock()
+	return len(fake.aPKToolsVersionArgsForCall)
+}
+
+func (fake *FakeApkImplementation) APKToolsVersionCalls(stub func(*options.Options, *exec.Executor) (string, error)) {
+	fake.aPKToolsVersionMutex.Lock()
+	defer fake.aPKToolsVersionMutex.Unlock()
+	fake.APKToolsVersionStub = stub
+}
+
+func (fake *FakeApkImplementation) APKToolsVersionArgsForCall(i int) (*options.Options, *exec.Executor) {
+	fake.aPKToolsVersionMutex.RLock()
+	defer fake.aPKToolsVersionMutex.RUnlock()
+	argsForCall := fake.aPKToolsVersionArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeApkImplementation) APKToolsVersionReturns(result1 string, result2 error) {
+	fake.aPKToolsVersionMutex.Lock()
+	defer fake.aPKToolsVersionMutex.Unlock()
+	fake.APKToolsVersionStub = nil
+	fake.aPKToolsVersionReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeApkImplementation) APKToolsVersionReturnsOnCall(i int, result1 string, result2 error) {
+	fake.aPKToolsVersionMutex.Lock()
+	defer fake.aPKToolsVersionMutex.Unlock()
+	fake.APKToolsVersionStub = nil
+	if fake.aPKToolsVersionReturnsOnCall == nil {
+		fake.aPKToolsVersionReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.aPKToolsVersionReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeApkImplementation) FixateWorld(arg1 *options.Options, arg2 *types.ImageConfiguration, arg3 *exec.Executor) error {
 	fake.fixateWorldMutex.Lock()
 	ret, specificReturn := fake.fixateWorldReturnsOnCall[len(fake.fixateWorldArgsForCall)]
 	fake.fixateWorldArgsForCall = append(fake.fixateWorldArgsForCall, struct {
 		arg1 *options.Options
-		arg2 *exec.Executor
-	}{arg1, arg2})
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
+	}{arg1, arg2, arg3})
 	stub := fake.FixateWorldStub
 	fakeReturns := fake.fixateWorldReturns
-	fake.recordInvocation("FixateWorld", []interface{}{arg1, arg2})
+	fake.recordInvocation("FixateWorld", []interface{}{arg1, arg2, arg3})
 	fake.fixateWorldMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2)
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1
@@ -125,17 +262,17 @@ func (fake *FakeApkImplementation) FixateWorldCallCount() int {
 	return len(fake.fixateWorldArgsForCall)
 }
 
-func (fake *FakeApkImplementation) FixateWorldCalls(stub func(*options.Options, *exec.Executor) error) {
+func (fake *FakeApkImplementation) FixateWorldCalls(stub func(*options.Options, *types.ImageConfiguration, *exec.Executor) error) {
 	fake.fixateWorldMutex.Lock()
 	defer fake.fixateWorldMutex.Unlock()
 	fake.FixateWorldStub = stub
 }
 
-func (fake *FakeApkImplementation) FixateWorldArgsForCall(i int) (*options.Options, *exec.Executor) {
+func (fake *FakeApkImplementation) FixateWorldArgsForCall(i int) (*options.Options, *types.ImageConfiguration, *exec.Executor) {
 	fake.fixateWorldMutex.RLock()
 	defer fake.fixateWorldMutex.RUnlock()
 	argsForCall := fake.fixateWorldArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *FakeApkImplementation) FixateWorldReturns(result1 error) {
@@ -161,19 +298,20 @@ func (fake *FakeApkImplementation) FixateWorldReturnsOnCall(i int, result1 error
 	}{result1}
 }
 
-func (fake *FakeApkImplementation) InitDB(arg1 *options.Options, arg2 exec.Executor) error {
+func (fake *FakeApkImplementation) InitDB(arg1 *options.Options, arg2 *types.ImageConfiguration, arg3 exec.Executor) error {
 	fake.initDBMutex.Lock()
 	ret, specificReturn := fake.initDBReturnsOnCall[len(fake.initDBArgsForCall)]
 	fake.initDBArgsForCall = append(fake.initDBArgsForCall, struct {
 		arg1 *options.Options
-		arg2 exec.Executor
-	}{arg1, arg2})
+		arg2 *types.ImageConfiguration
+		arg3 exec.Executor
+	}{arg1, arg2, arg3})
 	stub := fake.InitDBStub
 	fakeReturns := fake.initDBReturns
-	fake.recordInvocation("InitDB", []interface{}{arg1, arg2})
+	fake.recordInvocation("InitDB", []interface{}{arg1, arg2, arg3})
 	fake.initDBMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2)
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1
@@ -187,17 +325,17 @@ func (fake *FakeApkImplementation) InitDBCallCount() int {
 	return len(fake.initDBArgsForCall)
 }
 
-func (fake *FakeApkImplementation) InitDBCalls(stub func(*options.Options, exec.Executor) error) {
+func (fake *FakeApkImplementation) InitDBCalls(stub func(*options.Options, *types.ImageConfiguration, exec.Executor) error) {
 	fake.initDBMutex.Lock()
 	defer fake.initDBMutex.Unlock()
 	fake.InitDBStub = stub
 }
 
-func (fake *FakeApkImplementation) InitDBArgsForCall(i int) (*options.Options, exec.Executor) {
+func (fake *FakeApkImplementation) InitDBArgsForCall(i int) (*options.Options, *types.ImageConfiguration, exec.Executor) {
 	fake.initDBMutex.RLock()
 	defer fake.initDBMutex.RUnlock()
 	argsForCall := fake.initDBArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *FakeApkImplementation) InitDBReturns(result1 error) {
@@ -409,16 +547,84 @@ func (fake *FakeApkImplementation) InitWorldReturnsOnCall(i int, result1 error)
 	}{result1}
 }
 
+func (fake *FakeApkImplementation) InstallLocalPackages(arg1 *options.Options, arg2 *types.ImageConfiguration, arg3 *exec.Executor) error {
+	fake.installLocalPackagesMutex.Lock()
+	ret, specificReturn := fake.installLocalPackagesReturnsOnCall[len(fake.installLocalPackagesArgsForCall)]
+	fake.installLocalPackagesArgsForCall = append(fake.installLocalPackagesArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
+	}{arg1, arg2, arg3})
+	stub := fake.InstallLocalPackagesStub
+	fakeReturns := fake.installLocalPackagesReturns
+	fake.recordInvocation("InstallLocalPackages", []interface{}{arg1, arg2, arg3})
+	fake.installLocalPackagesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeApkImplementation) InstallLocalPackagesCallCount() int {
+	fake.installLocalPackagesMutex.RLock()
+	defer fake.installLocalPackagesMutex.RUnlock()
+	return len(fake.installLocalPackagesArgsForCall)
+}
+
+func (fake *FakeApkImplementation) InstallLocalPackagesCalls(stub func(*options.Options, *types.ImageConfiguration, *exec.Executor) error) {
+	fake.installLocalPackagesMutex.Lock()
+	defer fake.installLocalPackagesMutex.Unlock()
+	fake.InstallLocalPackagesStub = stub
+}
+
+func (fake *FakeApkImplementation) InstallLocalPackagesArgsForCall(i int) (*options.Options, *types.ImageConfiguration, *exec.Executor) {
+	fake.installLocalPackagesMutex.RLock()
+	defer fake.installLocalPackagesMutex.RUnlock()
+	argsForCall := fake.installLocalPackagesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeApkImplementation) InstallLocalPackagesReturns(result1 error) {
+	fake.installLocalPackagesMutex.Lock()
+	defer fake.installLocalPackagesMutex.Unlock()
+	fake.InstallLocalPackagesStub = nil
+	fake.installLocalPackagesReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeApkImplementation) InstallLocalPackagesReturnsOnCall(i int, result1 error) {
+	fake.installLocalPackagesMutex.Lock()
+	defer fake.installLocalPackagesMutex.Unlock()
+	fake.InstallLocalPackagesStub = nil
+	if fake.installLocalPackagesReturnsOnCall == nil {
+		fake.installLocalPackagesReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.installLocalPackagesReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeApkImplementation) LoadSystemKeyring(arg1 *options.Options, arg2 ...string) ([]string, error) {
+	var arg2Copy []string
+	if arg2 != nil {
+		arg2Copy = make([]string, len(arg2))
+		copy(arg2Copy, arg2)
+	}
 	fake.loadSystemKeyringMutex.Lock()
 	ret, specificReturn := fake.loadSystemKeyringReturnsOnCall[len(fake.loadSystemKeyringArgsForCall)]
 	fake.loadSystemKeyringArgsForCall = append(fake.loadSystemKeyringArgsForCall, struct {
 		arg1 *options.Options
 		arg2 []string
-	}{arg1, arg2})
+	}{arg1, arg2Copy})
 	stub := fake.LoadSystemKeyringStub
 	fakeReturns := fake.loadSystemKeyringReturns
-	fake.recordInvocation("LoadSystemKeyring", []interface{}{arg1, arg2})
+	fake.recordInvocation("LoadSystemKeyring", []interface{}{arg1, arg2Copy})
 	fake.loadSystemKeyringMutex.Unlock()
 	if stub != nil {
 		return stub(arg1, arg2...)
@@ -535,9 +741,139 @@ func (fake *FakeApkImplementation) NormalizeScriptsTarReturnsOnCall(i int, resul
 	}{result1}
 }
 
+func (fake *FakeApkImplementation) ResolveWorld(arg1 *options.Options, arg2 *types.ImageConfiguration, arg3 *exec.Executor) ([]apk.PlannedPackage, error) {
+	fake.resolveWorldMutex.Lock()
+	ret, specificReturn := fake.resolveWorldReturnsOnCall[len(fake.resolveWorldArgsForCall)]
+	fake.resolveWorldArgsForCall = append(fake.resolveWorldArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
+	}{arg1, arg2, arg3})
+	stub := fake.ResolveWorldStub
+	fakeReturns := fake.resolveWorldReturns
+	fake.recordInvocation("ResolveWorld", []interface{}{arg1, arg2, arg3})
+	fake.resolveWorldMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeApkImplementation) ResolveWorldCallCount() int {
+	fake.resolveWorldMutex.RLock()
+	defer fake.resolveWorldMutex.RUnlock()
+	return len(fake.resolveWorldArgsForCall)
+}
+
+func (fake *FakeApkImplementation) ResolveWorldCalls(stub func(*options.Options, *types.ImageConfiguration, *exec.Executor) ([]apk.PlannedPackage, error)) {
+	fake.resolveWorldMutex.Lock()
+	defer fake.resolveWorldMutex.Unlock()
+	fake.ResolveWorldStub = stub
+}
+
+func (fake *FakeApkImplementation) ResolveWorldArgsForCall(i int) (*options.Options, *types.ImageConfiguration, *exec.Executor) {
+	fake.resolveWorldMutex.RLock()
+	defer fake.resolveWorldMutex.RUnlock()
+	argsForCall := fake.resolveWorldArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeApkImplementation) ResolveWorldReturns(result1 []apk.PlannedPackage, result2 error) {
+	fake.resolveWorldMutex.Lock()
+	defer fake.resolveWorldMutex.Unlock()
+	fake.ResolveWorldStub = nil
+	fake.resolveWorldReturns = struct {
+		result1 []apk.PlannedPackage
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeApkImplementation) ResolveWorldReturnsOnCall(i int, result1 []apk.PlannedPackage, result2 error) {
+	fake.resolveWorldMutex.Lock()
+	defer fake.resolveWorldMutex.Unlock()
+	fake.ResolveWorldStub = nil
+	if fake.resolveWorldReturnsOnCall == nil {
+		fake.resolveWorldReturnsOnCall = make(map[int]struct {
+			result1 []apk.PlannedPackage
+			result2 error
+		})
+	}
+	fake.resolveWorldReturnsOnCall[i] = struct {
+		result1 []apk.PlannedPackage
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeApkImplementation) VerifyPackageChecksums(arg1 *options.Options, arg2 *types.ImageConfiguration) error {
+	fake.verifyPackageChecksumsMutex.Lock()
+	ret, specificReturn := fake.verifyPackageChecksumsReturnsOnCall[len(fake.verifyPackageChecksumsArgsForCall)]
+	fake.verifyPackageChecksumsArgsForCall = append(fake.verifyPackageChecksumsArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}{arg1, arg2})
+	stub := fake.VerifyPackageChecksumsStub
+	fakeReturns := fake.verifyPackageChecksumsReturns
+	fake.recordInvocation("VerifyPackageChecksums", []interface{}{arg1, arg2})
+	fake.verifyPackageChecksumsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeApkImplementation) VerifyPackageChecksumsCallCount() int {
+	fake.verifyPackageChecksumsMutex.RLock()
+	defer fake.verifyPackageChecksumsMutex.RUnlock()
+	return len(fake.verifyPackageChecksumsArgsForCall)
+}
+
+func (fake *FakeApkImplementation) VerifyPackageChecksumsCalls(stub func(*options.Options, *types.ImageConfiguration) error) {
+	fake.verifyPackageChecksumsMutex.Lock()
+	defer fake.verifyPackageChecksumsMutex.Unlock()
+	fake.VerifyPackageChecksumsStub = stub
+}
+
+func (fake *FakeApkImplementation) VerifyPackageChecksumsArgsForCall(i int) (*options.Options, *types.ImageConfiguration) {
+	fake.verifyPackageChecksumsMutex.RLock()
+	defer fake.verifyPackageChecksumsMutex.RUnlock()
+	argsForCall := fake.verifyPackageChecksumsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeApkImplementation) VerifyPackageChecksumsReturns(result1 error) {
+	fake.verifyPackageChecksumsMutex.Lock()
+	defer fake.verifyPackageChecksumsMutex.Unlock()
+	fake.VerifyPackageChecksumsStub = nil
+	fake.verifyPackageChecksumsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeApkImplementation) VerifyPackageChecksumsReturnsOnCall(i int, result1 error) {
+	fake.verifyPackageChecksumsMutex.Lock()
+	defer fake.verifyPackageChecksumsMutex.Unlock()
+	fake.VerifyPackageChecksumsStub = nil
+	if fake.verifyPackageChecksumsReturnsOnCall == nil {
+		fake.verifyPackageChecksumsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.verifyPackageChecksumsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeApkImplementation) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
+	fake.aPKToolsVersionMutex.RLock()
+	defer fake.aPKToolsVersionMutex.RUnlock()
 	fake.fixateWorldMutex.RLock()
 	defer fake.fixateWorldMutex.RUnlock()
 	fake.initDBMutex.RLock()
@@ -548,10 +884,16 @@ func (fake *FakeApkImplementation) Invocations() map[string][][]interface{} {
 	defer fake.initRepositoriesMutex.RUnlock()
 	fake.initWorldMutex.RLock()
 	defer fake.initWorldMutex.RUnlock()
+	fake.installLocalPackagesMutex.RLock()
+	defer fake.installLocalPackagesMutex.RUnlock()
 	fake.loadSystemKeyringMutex.RLock()
 	defer fake.loadSystemKeyringMutex.RUnlock()
 	fake.normalizeScriptsTarMutex.RLock()
 	defer fake.normalizeScriptsTarMutex.RUnlock()
+	fake.resolveWorldMutex.RLock()
+	defer fake.resolveWorldMutex.RUnlock()
+	fake.verifyPackageChecksumsMutex.RLock()
+	defer fake.verifyPackageChecksumsMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -0,0 +1,84 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// InstalledPackage is a package of the installed database of an image.
+type InstalledPackage struct {
+	Name    string
+	Version string
+	Origin  string
+	// Checksum is the Q1 prefixed checksum of the package.
+	Checksum string
+	// Files are the absolute paths of the files the package installed.
+	Files []string
+}
+
+// InstalledPackages parses the installed database of the image in root,
+// returning its packages in the order of the database. The error wraps
+// os.ErrNotExist when no packages are installed.
+func InstalledPackages(root string) ([]InstalledPackage, error) {
+	f, err := os.Open(filepath.Join(root, "lib", "apk", "db", "installed"))
+	if err != nil {
+		return nil, fmt.Errorf("opening installed database: %w", err)
+	}
+	defer f.Close()
+
+	pkgs := []InstalledPackage{}
+	pkg, dir := InstalledPackage{}, ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if pkg.Name != "" {
+				pkgs = append(pkgs, pkg)
+			}
+			pkg, dir = InstalledPackage{}, ""
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, ":")
+		switch key {
+		case "P":
+			pkg.Name = value
+		case "V":
+			pkg.Version = value
+		case "o":
+			pkg.Origin = value
+		case "C":
+			pkg.Checksum = value
+		case "F":
+			dir = value
+		case "R":
+			pkg.Files = append(pkg.Files, path.Join("/", dir, value))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading installed database: %w", err)
+	}
+	if pkg.Name != "" {
+		pkgs = append(pkgs, pkg)
+	}
+
+	return pkgs, nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstalledPackages(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := InstalledPackages(dir)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	dbDir := filepath.Join(dir, "lib", "apk", "db")
+	require.NoError(t, os.MkdirAll(dbDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dbDir, "installed"), []byte(
+		"C:Q1abc=\nP:busybox\nV:1.35.0-r17\no:busybox\nF:bin\nR:busybox\nR:sh\nF:etc\nR:motd\n\n"+
+			"C:Q1AQIDBAUGBwgJCgsMDQ4PEBESExQ=\nP:nginx\nV:1.22.0-r1\no:nginx\nF:usr/sbin\nR:nginx",
+	), 0o644))
+
+	pkgs, err := InstalledPackages(dir)
+	require.NoError(t, err)
+	require.Equal(t, []InstalledPackage{{
+		Name:     "busybox",
+		Version:  "1.35.0-r17",
+		Origin:   "busybox",
+		Checksum: "Q1abc=",
+		Files:    []string{"/bin/busybox", "/bin/sh", "/etc/motd"},
+	}, {
+		Name:     "nginx",
+		Version:  "1.22.0-r1",
+		Origin:   "nginx",
+		Checksum: "Q1AQIDBAUGBwgJCgsMDQ4PEBESExQ=",
+		Files:    []string{"/usr/sbin/nginx"},
+	}}, pkgs)
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// normalizeKey returns the key in data in the format apk reads: a PEM
+// encoded RSA public key. Keys already in that format, or in the PKCS #1
+// format of "RSA PUBLIC KEY" blocks, are accepted as well as armored PGP
+// public keys with an RSA primary key, which are converted. Any other key
+// is an error, apk being unable to check signatures with it.
+func normalizeKey(name string, data []byte) ([]byte, error) {
+	unsupported := func(format string) error {
+		return fmt.Errorf("apk key %s is %s, which is not supported: keys must be PEM encoded RSA public keys or armored PGP public keys", name, format)
+	}
+
+	if bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("reading PGP apk key %s: %w", name, err)
+		}
+		if len(entities) != 1 {
+			return nil, fmt.Errorf("PGP apk key %s holds %d keys, instead of one", name, len(entities))
+		}
+
+		pub, ok := entities[0].PrimaryKey.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, unsupported("a non-RSA PGP key")
+		}
+		return encodeRSAPublicKey(pub)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, unsupported("not PEM encoded")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing apk key %s: %w", name, err)
+		}
+		if _, ok := pub.(*rsa.PublicKey); !ok {
+			return nil, unsupported("a non-RSA key")
+		}
+		return data, nil
+	case "RSA PUBLIC KEY":
+		pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing apk key %s: %w", name, err)
+		}
+		return encodeRSAPublicKey(pub)
+	default:
+		return nil, unsupported(fmt.Sprintf("a PEM %q block", block.Type))
+	}
+}
+
+// encodeRSAPublicKey returns the PEM encoding of the key, as apk reads it.
+func encodeRSAPublicKey(pub *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("encoding apk key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// LocalPackageName returns the name of the package in the .apk file at
+// path, read from its .PKGINFO.
+func LocalPackageName(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// the signature, control and data segments of the package are
+	// concatenated gzip streams of tar entries, which are read as one
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		if hdr.Name != ".PKGINFO" {
+			continue
+		}
+
+		scanner := bufio.NewScanner(tr)
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), " = ")
+			if ok && key == "pkgname" {
+				return value, nil
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return "", fmt.Errorf("reading .PKGINFO of %s: %w", path, err)
+		}
+		return "", fmt.Errorf("%s has no package name in its .PKGINFO", path)
+	}
+
+	return "", fmt.Errorf("%s has no .PKGINFO", path)
+}
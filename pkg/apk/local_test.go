@@ -0,0 +1,61 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeSegment appends a gzip compressed tar segment, without the end of
+// archive marker, holding the given files, as in .apk files.
+func writeSegment(t *testing.T, f *os.File, files map[string]string) {
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	for name, data := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}))
+		_, err := tw.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Flush())
+	require.NoError(t, gzw.Close())
+}
+
+func TestLocalPackageName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello-1.0-r0.apk")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	writeSegment(t, f, map[string]string{".SIGN.RSA.key.rsa.pub": "signature"})
+	writeSegment(t, f, map[string]string{".PKGINFO": "# Generated by abuild\npkgname = hello\npkgver = 1.0-r0\n"})
+	require.NoError(t, f.Close())
+
+	name, err := LocalPackageName(path)
+	require.NoError(t, err)
+	require.Equal(t, "hello", name)
+
+	other := filepath.Join(t.TempDir(), "other.apk")
+	f, err = os.Create(other)
+	require.NoError(t, err)
+	writeSegment(t, f, map[string]string{"usr/bin/hello": ""})
+	require.NoError(t, f.Close())
+
+	_, err = LocalPackageName(other)
+	require.ErrorContains(t, err, "has no .PKGINFO")
+}
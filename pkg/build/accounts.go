@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"golang.org/x/sync/errgroup"
 
@@ -64,12 +66,59 @@ func (di *defaultBuildImplementation) appendUser(
 	return append(users, ue)
 }
 
+// addGroupMembers adds users to the members of their supplementary
+// groups, skipping those which are already members.
+func addGroupMembers(groups []passwd.GroupEntry, members map[string][]string) error {
+	found := map[string]struct{}{}
+
+	for i := range groups {
+		users, ok := members[groups[i].GroupName]
+		if !ok {
+			continue
+		}
+		found[groups[i].GroupName] = struct{}{}
+
+		// The members may be shared with the image configuration.
+		groups[i].Members = append([]string{}, groups[i].Members...)
+
+		for _, u := range users {
+			present := false
+			for _, m := range groups[i].Members {
+				if m == u {
+					present = true
+					break
+				}
+			}
+
+			if !present {
+				groups[i].Members = append(groups[i].Members, u)
+			}
+		}
+	}
+
+	for g, users := range members {
+		if _, ok := found[g]; !ok {
+			return fmt.Errorf("group %s of users %v does not exist in the image", g, users)
+		}
+	}
+
+	return nil
+}
+
 func (di *defaultBuildImplementation) MutateAccounts(
 	o *options.Options, ic *types.ImageConfiguration,
 ) error {
 	var eg errgroup.Group
 
-	if len(ic.Accounts.Groups) != 0 {
+	// Supplementary group memberships configured on the user side.
+	members := map[string][]string{}
+	for _, u := range ic.Accounts.Users {
+		for _, g := range u.Groups {
+			members[g] = append(members[g], u.UserName)
+		}
+	}
+
+	if len(ic.Accounts.Groups) != 0 || len(members) != 0 {
 		// Mutate the /etc/groups file
 		eg.Go(func() error {
 			path := filepath.Join(o.WorkDir, "etc", "group")
@@ -83,6 +132,10 @@ func (di *defaultBuildImplementation) MutateAccounts(
 				gf.Entries = di.appendGroup(o, gf.Entries, g)
 			}
 
+			if err := addGroupMembers(gf.Entries, members); err != nil {
+				return err
+			}
+
 			if err := gf.WriteFile(path); err != nil {
 				return err
 			}
@@ -160,5 +213,71 @@ func (di *defaultBuildImplementation) MutateAccounts(
 		return err
 	}
 
+	if ic.Accounts.NumericRunAs && ic.Accounts.RunAs != "" {
+		runAs, err := resolveNumericRunAs(o, ic.Accounts.RunAs)
+		if err != nil {
+			return fmt.Errorf("resolving run-as user %q: %w", ic.Accounts.RunAs, err)
+		}
+		ic.Accounts.RunAs = runAs
+	}
+
+	return nil
+}
+
+// checkRunAsOverride checks that the run-as override of the build, if
+// any, resolves to a user, and group, of the image.
+func (bc *Context) checkRunAsOverride() error {
+	if bc.OverrideRunAs == "" {
+		return nil
+	}
+
+	if _, err := resolveNumericRunAs(&bc.Options, bc.OverrideRunAs); err != nil {
+		return fmt.Errorf("resolving run-as override %q: %w", bc.OverrideRunAs, err)
+	}
+
 	return nil
 }
+
+// resolveNumericRunAs resolves a run-as value of the form user[:group],
+// where user and group are either names or IDs, into the numeric uid:gid
+// form. If no group is given, the primary group of the user is used.
+func resolveNumericRunAs(o *options.Options, runAs string) (string, error) {
+	uf, err := passwd.ReadOrCreateUserFile(filepath.Join(o.WorkDir, "etc", "passwd"))
+	if err != nil {
+		return "", err
+	}
+
+	userPart, groupPart, hasGroup := strings.Cut(runAs, ":")
+
+	var user *passwd.UserEntry
+	for i, ue := range uf.Entries {
+		if ue.UserName == userPart || strconv.FormatUint(uint64(ue.UID), 10) == userPart {
+			user = &uf.Entries[i]
+			break
+		}
+	}
+	if user == nil {
+		return "", fmt.Errorf("user %q not found in /etc/passwd", userPart)
+	}
+
+	if !hasGroup {
+		return fmt.Sprintf("%d:%d", user.UID, user.GID), nil
+	}
+
+	if gid, err := strconv.ParseUint(groupPart, 10, 32); err == nil {
+		return fmt.Sprintf("%d:%d", user.UID, gid), nil
+	}
+
+	gf, err := passwd.ReadOrCreateGroupFile(filepath.Join(o.WorkDir, "etc", "group"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, ge := range gf.Entries {
+		if ge.GroupName == groupPart {
+			return fmt.Sprintf("%d:%d", user.UID, ge.GID), nil
+		}
+	}
+
+	return "", fmt.Errorf("group %q not found in /etc/group", groupPart)
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestCheckRunAsOverride(t *testing.T) {
+	wd := t.TempDir()
+	bc := &Context{Options: options.Options{WorkDir: wd}}
+	require.NoError(t, bc.checkRunAsOverride())
+
+	require.NoError(t, os.MkdirAll(filepath.Join(wd, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(wd, "etc", "passwd"), []byte("root:x:0:0:root:/root:/bin/sh\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(wd, "etc", "group"), []byte("root:x:0:root\n"), 0o644))
+
+	for _, runAs := range []string{"root", "0", "root:root", "0:0"} {
+		bc.OverrideRunAs = runAs
+		require.NoError(t, bc.checkRunAsOverride(), runAs)
+	}
+
+	bc.OverrideRunAs = "debug"
+	require.EqualError(t, bc.checkRunAsOverride(), `resolving run-as override "debug": user "debug" not found in /etc/passwd`)
+
+	bc.OverrideRunAs = "root:wheel"
+	require.EqualError(t, bc.checkRunAsOverride(), `resolving run-as override "root:wheel": group "wheel" not found in /etc/group`)
+}
+
+func TestMutateAccountsNumericRunAs(t *testing.T) {
+	wd := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(wd, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(wd, "etc", "passwd"), []byte("nonroot:x:65532:65532::/dev/null:/sbin/nologin\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(wd, "etc", "group"), []byte("nonroot:x:65532:\nwww-data:x:82:\n"), 0o644))
+
+	di := &defaultBuildImplementation{}
+	o := &options.Options{WorkDir: wd}
+
+	for runAs, want := range map[string]string{
+		"nonroot":          "65532:65532",
+		"65532":            "65532:65532",
+		"nonroot:www-data": "65532:82",
+		"nonroot:1000":     "65532:1000",
+	} {
+		ic := &types.ImageConfiguration{}
+		ic.Accounts.RunAs = runAs
+		ic.Accounts.NumericRunAs = true
+		require.NoError(t, di.MutateAccounts(o, ic), runAs)
+		require.Equal(t, want, ic.Accounts.RunAs, runAs)
+	}
+
+	// names are kept as they are unless numeric IDs are required
+	ic := &types.ImageConfiguration{}
+	ic.Accounts.RunAs = "nonroot:www-data"
+	require.NoError(t, di.MutateAccounts(o, ic))
+	require.Equal(t, "nonroot:www-data", ic.Accounts.RunAs)
+
+	ic.Accounts.NumericRunAs = true
+	ic.Accounts.RunAs = "nobody"
+	require.EqualError(t, di.MutateAccounts(o, ic), `resolving run-as user "nobody": user "nobody" not found in /etc/passwd`)
+}
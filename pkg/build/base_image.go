@@ -0,0 +1,180 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// entryEscapes reports whether the path of a base image entry, joined onto
+// the working directory, climbs out of it.
+func entryEscapes(workDir, name string) bool {
+	target := filepath.Join(workDir, name)
+	return target != workDir && !strings.HasPrefix(target, workDir+string(os.PathSeparator))
+}
+
+// Extracts a flattened image filesystem tar stream into the working directory.
+// The symlinks of earlier entries are resolved inside the working directory,
+// so that later entries are never written outside of it.
+func extractImageFilesystem(o *options.Options, r io.Reader) error {
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if entryEscapes(o.WorkDir, header.Name) {
+			return fmt.Errorf("base image entry %s escapes the working directory", header.Name)
+		}
+
+		target, err := securePath(o.WorkDir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		perms := header.FileInfo().Mode().Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// directories are resolved in full, so that one replaced by a
+			// symlink is created where the symlink points
+			if target, err = securejoin.SecureJoin(o.WorkDir, header.Name); err != nil {
+				return fmt.Errorf("resolving %s: %w", header.Name, err)
+			}
+
+			if err := os.MkdirAll(target, perms); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			if err := removeSymlink(target); err != nil {
+				return err
+			}
+
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, perms)
+			if err != nil {
+				return err
+			}
+
+			// #nosec G110 -- the base image is explicitly requested by the user
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			f.Close()
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			if entryEscapes(o.WorkDir, header.Linkname) {
+				return fmt.Errorf("base image hardlink %s to %s escapes the working directory", header.Name, header.Linkname)
+			}
+
+			source, err := securejoin.SecureJoin(o.WorkDir, header.Linkname)
+			if err != nil {
+				return fmt.Errorf("resolving %s: %w", header.Linkname, err)
+			}
+
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+			continue
+		default:
+			o.Logger().Debugf("skipping unsupported base image entry %s", header.Name)
+			continue
+		}
+
+		if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeSymlink {
+			if err := os.Chmod(target, perms); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// InitializeBaseImage populates the working directory with the filesystem
+// of the configured base image, so that packages are installed on top of it.
+func (di *defaultBuildImplementation) InitializeBaseImage(
+	o *options.Options, ic *types.ImageConfiguration,
+) error {
+	if ic.Contents.BaseImage == "" {
+		return nil
+	}
+
+	ref := ic.Contents.BaseImage
+	if o.BaseImageVerifier != nil {
+		digest, err := oci.ResolveBaseImageDigest(ref)
+		if err != nil {
+			return err
+		}
+
+		o.Logger().Infof("verifying base image %s", digest)
+		if err := o.BaseImageVerifier(digest); err != nil {
+			return fmt.Errorf("verifying base image %s: %w", digest, err)
+		}
+
+		// fetch the verified image, even if the tag has moved since
+		ref = digest.String()
+	}
+
+	o.Logger().Infof("fetching base image %s", ref)
+
+	img, err := oci.FetchBaseImage(ref, o.Arch)
+	if err != nil {
+		return err
+	}
+
+	rc := mutate.Extract(img)
+	defer rc.Close()
+
+	if err := extractImageFilesystem(o, rc); err != nil {
+		return fmt.Errorf("extracting base image %s: %w", ic.Contents.BaseImage, err)
+	}
+
+	return nil
+}
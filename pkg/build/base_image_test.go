@@ -0,0 +1,106 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestInitializeBaseImageVerifier(t *testing.T) {
+	ref := "example.com/base@sha256:" + strings.Repeat("a", 64)
+
+	var verified []string
+	o := &options.Options{
+		Log:     &logrus.Logger{},
+		WorkDir: t.TempDir(),
+		BaseImageVerifier: func(digest name.Digest) error {
+			verified = append(verified, digest.String())
+			return fmt.Errorf("no matching signatures")
+		},
+	}
+
+	ic := &types.ImageConfiguration{}
+	ic.Contents.BaseImage = ref
+
+	di := defaultBuildImplementation{}
+	require.ErrorContains(t, di.InitializeBaseImage(o, ic), "no matching signatures")
+	require.Equal(t, []string{ref}, verified)
+}
+
+// imageEntry is an entry of a base image filesystem.
+type imageEntry struct {
+	header  tar.Header
+	content string
+}
+
+// imageFilesystem returns a tar stream of the given entries.
+func imageFilesystem(t *testing.T, entries ...imageEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := e.header
+		h.Size = int64(len(e.content))
+		h.Uid, h.Gid = os.Getuid(), os.Getgid()
+		require.NoError(t, tw.WriteHeader(&h))
+		_, err := tw.Write([]byte(e.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestExtractImageFilesystem(t *testing.T) {
+	wd := t.TempDir()
+	o := &options.Options{Log: &logrus.Logger{}, WorkDir: wd}
+
+	require.NoError(t, extractImageFilesystem(o, imageFilesystem(t,
+		imageEntry{header: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		imageEntry{header: tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0o644}, content: "ID=wolfi\n"},
+		imageEntry{header: tar.Header{Name: "etc/os-release.link", Typeflag: tar.TypeLink, Linkname: "etc/os-release"}},
+	)))
+	data, err := os.ReadFile(filepath.Join(wd, "etc", "os-release"))
+	require.NoError(t, err)
+	require.Equal(t, "ID=wolfi\n", string(data))
+	data, err = os.ReadFile(filepath.Join(wd, "etc", "os-release.link"))
+	require.NoError(t, err)
+	require.Equal(t, "ID=wolfi\n", string(data))
+
+	// hardlinks to files outside of the working directory are refused
+	require.ErrorContains(t, extractImageFilesystem(o, imageFilesystem(t,
+		imageEntry{header: tar.Header{Name: "etc/shadow", Typeflag: tar.TypeLink, Linkname: "../../etc/shadow"}},
+	)), "escapes the working directory")
+
+	// files are not written through symlinks pointing outside of it
+	outside := t.TempDir()
+	require.NoError(t, extractImageFilesystem(o, imageFilesystem(t,
+		imageEntry{header: tar.Header{Name: "x", Typeflag: tar.TypeSymlink, Linkname: outside}},
+		imageEntry{header: tar.Header{Name: "x/passwd", Typeflag: tar.TypeReg, Mode: 0o644}, content: "root:x:0:0::/root:/bin/sh\n"},
+	)))
+	require.NoFileExists(t, filepath.Join(outside, "passwd"))
+	require.FileExists(t, filepath.Join(wd, outside, "passwd"))
+}
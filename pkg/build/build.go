@@ -17,23 +17,33 @@ package build
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/hashicorp/go-multierror"
 	coci "github.com/sigstore/cosign/pkg/oci"
 	"github.com/sirupsen/logrus"
 
+	chainguardAPK "chainguard.dev/apko/pkg/apk"
+	"chainguard.dev/apko/pkg/build/oci"
 	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/exec"
 	"chainguard.dev/apko/pkg/options"
 	"chainguard.dev/apko/pkg/s6"
+	"chainguard.dev/apko/pkg/tarball"
 )
 
+// errSBOMRequired is returned when an SBOM is required, but SBOM
+// generation would be skipped.
+var errSBOMRequired = errors.New("an SBOM is required, but no SBOM formats are configured")
+
 type Context struct {
 	impl               buildImplementation
 	ImageConfiguration types.ImageConfiguration
@@ -42,6 +52,27 @@ type Context struct {
 	s6                 *s6.Context
 	Assertions         []Assertion
 	Options            options.Options
+
+	// BuildArgs are the values environment and templated annotation
+	// values of the image configuration may reference. They are set on
+	// the image configuration as it is built.
+	BuildArgs map[string]string
+
+	// Profile selects one of the profiles of the image configuration,
+	// which overrides its entrypoint and command.
+	Profile string
+
+	// OverrideRunAs, when set, replaces the run-as user of the image
+	// configuration, e.g. to build a debug variant running as root. It
+	// must resolve to a user of the image.
+	OverrideRunAs string
+
+	// loadConfig is set when the configuration is to be loaded from
+	// ImageConfigFile once all options are applied, and annotations,
+	// when overrideAnnotations is set, replace its annotations.
+	loadConfig          bool
+	annotations         map[string]string
+	overrideAnnotations bool
 }
 
 func (bc *Context) Summarize() {
@@ -55,9 +86,15 @@ func (bc *Context) BuildTarball() (string, error) {
 }
 
 func (bc *Context) GenerateImageSBOM(arch types.Architecture, img coci.SignedImage) error {
+	start := time.Now()
 	opts := bc.Options
 	opts.Arch = arch
-	return bc.impl.GenerateImageSBOM(&opts, &bc.ImageConfiguration, img)
+	if err := bc.impl.GenerateImageSBOM(&opts, &bc.ImageConfiguration, img); err != nil {
+		return err
+	}
+
+	opts.ObservePhase(PhaseSBOMGenerated, start)
+	return nil
 }
 
 func (bc *Context) GenerateIndexSBOM(indexDigest name.Digest, imgs map[types.Architecture]coci.SignedImage) error {
@@ -68,9 +105,72 @@ func (bc *Context) GenerateSBOM() error {
 	return bc.impl.GenerateSBOM(&bc.Options, &bc.ImageConfiguration)
 }
 
+// CanonicalTags parses the tags of the build context, applying the
+// default registry and tag where they are omitted. Duplicate tags are
+// removed, preserving the order in which they were first given.
+func (bc *Context) CanonicalTags() ([]name.Tag, error) {
+	return canonicalTags(bc.Options.Tags)
+}
+
+func canonicalTags(tags []string) ([]name.Tag, error) {
+	seen := map[string]struct{}{}
+	canonical := make([]name.Tag, 0, len(tags))
+
+	for _, t := range tags {
+		tag, err := name.NewTag(t)
+		if err != nil {
+			return nil, fmt.Errorf("parsing tag %q: %w", t, err)
+		}
+
+		if _, ok := seen[tag.Name()]; ok {
+			continue
+		}
+		seen[tag.Name()] = struct{}{}
+
+		canonical = append(canonical, tag)
+	}
+
+	return canonical, nil
+}
+
+// ImageDigest computes the digest of the image manifest which will be
+// produced from the given layer tarball and the image configuration.
+func (bc *Context) ImageDigest(layerTarGZ string) (v1.Hash, error) {
+	return oci.ComputeImageDigest(layerTarGZ, bc.ImageConfiguration, bc.Logger(), bc.Options)
+}
+
+// ResolvePackages returns the full set of packages, with the versions apk
+// resolved against the repositories, which would be installed in the image
+// for arch. Nothing is downloaded or installed and no layer is written.
+func (bc *Context) ResolvePackages(arch types.Architecture) ([]chainguardAPK.PlannedPackage, error) {
+	bc.ImageConfiguration.BuildArgs = bc.BuildArgs
+	if err := bc.impl.ValidateImageConfiguration(&bc.ImageConfiguration); err != nil {
+		return nil, fmt.Errorf("failed to validate configuration: %w", err)
+	}
+
+	o := bc.Options
+	o.Arch = arch
+
+	pkgs, err := bc.impl.ResolvePackages(&o, &bc.ImageConfiguration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve packages for %s: %w", arch, err)
+	}
+
+	return pkgs, nil
+}
+
 func (bc *Context) BuildImage() error {
+	start := time.Now()
+	// the build args are those of the context, however the configuration
+	// was set
+	bc.ImageConfiguration.BuildArgs = bc.BuildArgs
 	// TODO(puerco): Point to final interface (see comment on buildImage fn)
-	return buildImage(bc.impl, &bc.Options, &bc.ImageConfiguration, bc.executor, bc.s6)
+	if err := buildImage(bc.impl, &bc.Options, &bc.ImageConfiguration, bc.executor, bc.s6); err != nil {
+		return err
+	}
+
+	bc.Options.ObservePhase(PhaseImageBuilt, start)
+	return nil
 }
 
 func (bc *Context) Logger() *logrus.Entry {
@@ -90,24 +190,90 @@ func (bc *Context) BuildLayer() (string, error) {
 		return "", err
 	}
 
+	// check the setuid and setgid binaries against the policy
+	if err := bc.checkSetuidBinaries(); err != nil {
+		return "", err
+	}
+
+	// check scratch images are still minimal
+	if err := bc.checkScratch(); err != nil {
+		return "", err
+	}
+
+	// check the overridden run-as user exists in the image
+	if err := bc.checkRunAsOverride(); err != nil {
+		return "", err
+	}
+
+	// check the image has the binaries it starts with
+	if err := bc.VerifyEntrypoint(); err != nil {
+		return "", err
+	}
+
 	// build layer tarball
+	start := time.Now()
 	layerTarGZ, err := bc.BuildTarball()
 	if err != nil {
 		return "", err
 	}
+	bc.Options.ObservePhase(PhaseTarballWritten, start)
+
+	// check the layer against the size budget
+	if err := bc.checkImageSize(layerTarGZ); err != nil {
+		return "", err
+	}
 
 	// generate SBOM
 	if bc.Options.WantSBOM {
+		start := time.Now()
 		if err := bc.GenerateSBOM(); err != nil {
 			return "", fmt.Errorf("generating SBOMs: %w", err)
 		}
+		bc.Options.ObservePhase(PhaseSBOMGenerated, start)
 	} else {
 		bc.Logger().Debug("Not generating SBOMs (WantSBOM = false)")
 	}
 
+	if bc.Options.ReportPath != "" {
+		if err := bc.WriteBuildReport(layerTarGZ); err != nil {
+			return "", fmt.Errorf("writing build report: %w", err)
+		}
+	}
+
+	if bc.Options.InstalledDBPath != "" {
+		if err := bc.WriteInstalledDB(); err != nil {
+			return "", err
+		}
+	}
+
+	if bc.Options.ProvenancePath != "" {
+		if err := bc.GenerateProvenance(); err != nil {
+			return "", fmt.Errorf("generating provenance: %w", err)
+		}
+	}
+
 	return layerTarGZ, nil
 }
 
+// checkImageSize checks the uncompressed size of the layer, counted as
+// the layer tarball was written, against the size budget.
+func (bc *Context) checkImageSize(layerTarGZ string) error {
+	fi, err := os.Stat(layerTarGZ)
+	if err != nil {
+		return fmt.Errorf("reading layer tarball: %w", err)
+	}
+	compressed, uncompressed := fi.Size(), bc.Options.LayerSize
+
+	bc.Logger().Infof("image size: %d bytes (%d bytes compressed)", uncompressed, compressed)
+
+	if bc.Options.MaxImageSize > 0 && uncompressed > bc.Options.MaxImageSize {
+		return fmt.Errorf("image %.2f MB exceeds limit %.2f MB",
+			float64(uncompressed)/(1<<20), float64(bc.Options.MaxImageSize)/(1<<20))
+	}
+
+	return nil
+}
+
 func (bc *Context) runAssertions() error {
 	var eg multierror.Group
 
@@ -119,15 +285,27 @@ func (bc *Context) runAssertions() error {
 	return eg.Wait().ErrorOrNil()
 }
 
-// New creates a build context.
+// New creates a build context using workDir as the working directory.
+// The working directory is removed when the context is closed.
 // The SOURCE_DATE_EPOCH env variable is supported and will
 // overwrite the provided timestamp if present.
 func New(workDir string, opts ...Option) (*Context, error) {
+	return NewWithOptions(append([]Option{WithWorkDir(workDir)}, opts...)...)
+}
+
+// NewWithOptions creates a build context entirely from options, which
+// validate their inputs as they are applied.
+// The SOURCE_DATE_EPOCH env variable is supported and will
+// overwrite the provided timestamp if present.
+func NewWithOptions(opts ...Option) (*Context, error) {
+	start := time.Now()
 	bc := Context{
 		Options: options.Default,
 		impl:    &defaultBuildImplementation{},
 	}
-	bc.Options.WorkDir = workDir
+	// Every context has a logger of its own, so that the log level and
+	// writer set by its options don't leak into other contexts.
+	bc.Options.Log = options.NewLogger(os.Stderr)
 
 	for _, opt := range opts {
 		if err := opt(&bc); err != nil {
@@ -135,6 +313,19 @@ func New(workDir string, opts ...Option) (*Context, error) {
 		}
 	}
 
+	if bc.loadConfig {
+		bc.Logger().Printf("loading config file: %s", bc.ImageConfigFile)
+
+		var ic types.ImageConfiguration
+		if err := ic.Load(bc.ImageConfigFile, bc.Logger()); err != nil {
+			return nil, fmt.Errorf("failed to load image configuration: %w", err)
+		}
+		bc.ImageConfiguration = ic
+	}
+	if bc.overrideAnnotations {
+		bc.ImageConfiguration.Annotations = bc.annotations
+	}
+
 	// SOURCE_DATE_EPOCH will always overwrite the build flag
 	if v, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok {
 		// The value MUST be an ASCII representation of an integer
@@ -150,6 +341,27 @@ func New(workDir string, opts ...Option) (*Context, error) {
 		bc.Options.SourceDateEpoch = time.Unix(sec, 0)
 	}
 
+	if err := bc.resolveSBOMFormats(); err != nil {
+		return nil, err
+	}
+
+	if bc.Options.RequireSBOM && len(bc.Options.SBOMFormats) == 0 {
+		return nil, errSBOMRequired
+	}
+
+	if err := bc.resolveSBOMPath(); err != nil {
+		return nil, err
+	}
+
+	if bc.Options.Compression == tarball.CompressionZstd {
+		if bc.Options.CompressionLevel != "" {
+			return nil, fmt.Errorf("a compression level can only be set for gzip compressed layers")
+		}
+		if bc.Options.UseDockerMediaTypes {
+			return nil, fmt.Errorf("zstd compressed layers cannot use Docker media types")
+		}
+	}
+
 	// if arch is missing default to the running program's arch
 	zeroArch := types.Architecture{}
 	if bc.Options.Arch == zeroArch {
@@ -160,9 +372,92 @@ func New(workDir string, opts ...Option) (*Context, error) {
 		bc.ImageConfiguration.ProbeVCSUrl(bc.ImageConfigFile, bc.Logger())
 	}
 
+	// the configuration may be loaded after the profile and the run-as
+	// override are set
+	if bc.Profile != "" {
+		bc.ImageConfiguration.Profile = bc.Profile
+	}
+	if bc.OverrideRunAs != "" {
+		bc.Logger().Warnf("overriding the run-as user %q of the configuration with %q", bc.ImageConfiguration.Accounts.RunAs, bc.OverrideRunAs)
+		bc.ImageConfiguration.Accounts.RunAs = bc.OverrideRunAs
+	}
+
+	bc.Options.ObservePhase(PhaseConfigLoaded, start)
+
 	return &bc, nil
 }
 
+// Close removes the temporary directory of the build context and its
+// working directory, unless PreserveWorkDir is set. Callers should
+// `defer bc.Close()` once the build context is created.
+func (bc *Context) Close() error {
+	var result *multierror.Error
+
+	if bc.Options.TempDirPath != "" {
+		if err := os.RemoveAll(bc.Options.TempDirPath); err != nil {
+			result = multierror.Append(result, fmt.Errorf("removing temporary directory: %w", err))
+		}
+		bc.Options.TempDirPath = ""
+	}
+
+	if bc.Options.PreserveWorkDir {
+		bc.Logger().Infof("preserving working directory %s", bc.Options.WorkDir)
+	} else if bc.Options.WorkDir != "" {
+		if err := os.RemoveAll(bc.Options.WorkDir); err != nil {
+			result = multierror.Append(result, fmt.Errorf("removing working directory: %w", err))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// resolveSBOMFormats sets the SBOM formats, when they were not given
+// explicitly, from the APKO_SBOM_FORMATS env variable. No SBOMs are
+// generated when neither sets them; the CLI defaults the formats.
+func (bc *Context) resolveSBOMFormats() error {
+	switch v, ok := os.LookupEnv("APKO_SBOM_FORMATS"); {
+	case bc.Options.SBOMFormats != nil:
+		bc.Logger().Debugf("using SBOM formats %v from options", bc.Options.SBOMFormats)
+	case ok:
+		formats := []string{}
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				formats = append(formats, f)
+			}
+		}
+		if err := validateSBOMFormats(formats); err != nil {
+			return fmt.Errorf("parsing APKO_SBOM_FORMATS: %w", err)
+		}
+		bc.Options.SBOMFormats = formats
+		bc.Logger().Debugf("using SBOM formats %v from APKO_SBOM_FORMATS", formats)
+	}
+
+	return nil
+}
+
+// resolveSBOMPath sets the SBOM output directory, when it was not given
+// explicitly, from the image configuration, making sure it is writable.
+func (bc *Context) resolveSBOMPath() error {
+	if bc.Options.SBOMPath != "" || bc.ImageConfiguration.SBOM.Path == "" {
+		return nil
+	}
+
+	path := bc.ImageConfiguration.SBOM.Path
+	f, err := os.CreateTemp(path, ".apko-sbom-*")
+	if err != nil {
+		return fmt.Errorf("SBOM path %s from the image configuration is not writable: %w", path, err)
+	}
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("cleaning up SBOM path %s: %w", path, err)
+	}
+
+	bc.Options.SBOMPath = path
+	bc.Logger().Infof("using SBOM path %s from the image configuration", path)
+
+	return nil
+}
+
 func (bc *Context) Refresh() error {
 	s6, executor, err := bc.impl.Refresh(&bc.Options)
 	if err != nil {
@@ -20,14 +20,17 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
 	coci "github.com/sigstore/cosign/pkg/oci"
+	"gitlab.alpinelinux.org/alpine/go/pkg/repository"
 	"sigs.k8s.io/release-utils/hash"
 
 	chainguardAPK "chainguard.dev/apko/pkg/apk"
+	"chainguard.dev/apko/pkg/build/oci"
 	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/exec"
 	apkofs "chainguard.dev/apko/pkg/fs"
@@ -45,13 +48,24 @@ type buildImplementation interface {
 	BuildTarball(*options.Options) (string, error)
 	GenerateSBOM(*options.Options, *types.ImageConfiguration) error
 	InstallBusyboxSymlinks(*options.Options, *exec.Executor) error
+	InitializeBaseImage(*options.Options, *types.ImageConfiguration) error
 	InitializeApk(*options.Options, *types.ImageConfiguration) error
+	ResolvePackages(*options.Options, *types.ImageConfiguration) ([]chainguardAPK.PlannedPackage, error)
+	ValidatePackageOrigins(*options.Options) error
+	RunPreInstallHooks(*options.Options, *types.ImageConfiguration, *exec.Executor) error
+	RunPostInstallHooks(*options.Options, *types.ImageConfiguration, *exec.Executor) error
 	MutateAccounts(*options.Options, *types.ImageConfiguration) error
 	MutatePaths(*options.Options, *types.ImageConfiguration) error
+	InstallFiles(*options.Options, *types.ImageConfiguration) error
+	StripContents(*options.Options, *types.ImageConfiguration) error
 	GenerateOSRelease(*options.Options, *types.ImageConfiguration) error
 	ValidateImageConfiguration(*types.ImageConfiguration) error
 	BuildImage(*options.Options, *types.ImageConfiguration, *exec.Executor, *s6.Context) error
 	WriteSupervisionTree(*s6.Context, *types.ImageConfiguration) error
+	WriteEntrypointDispatcher(*options.Options, *types.ImageConfiguration) error
+	WriteTimezone(*options.Options, *types.ImageConfiguration) error
+	WriteResolvConf(*options.Options, *types.ImageConfiguration) error
+	WriteHosts(*options.Options, *types.ImageConfiguration) error
 	GenerateIndexSBOM(*options.Options, *types.ImageConfiguration, name.Digest, map[types.Architecture]coci.SignedImage) error
 	GenerateImageSBOM(*options.Options, *types.ImageConfiguration, coci.SignedImage) error
 }
@@ -59,7 +73,7 @@ type buildImplementation interface {
 type defaultBuildImplementation struct{}
 
 func (di *defaultBuildImplementation) Refresh(o *options.Options) (*s6.Context, *exec.Executor, error) {
-	o.TarballPath = ""
+	o.TarballPath = o.TarballOutputPath
 
 	hostArch := types.ParseArchitecture(runtime.GOARCH)
 
@@ -84,7 +98,7 @@ func (di *defaultBuildImplementation) BuildTarball(o *options.Options) (string,
 	if o.TarballPath != "" {
 		outfile, err = os.Create(o.TarballPath)
 	} else {
-		outfile, err = os.Create(filepath.Join(o.TempDir(), o.TarballFileName()))
+		outfile, err = os.Create(o.LayerTarballPath())
 	}
 	if err != nil {
 		return "", fmt.Errorf("opening the build context tarball path failed: %w", err)
@@ -92,7 +106,22 @@ func (di *defaultBuildImplementation) BuildTarball(o *options.Options) (string,
 	o.TarballPath = outfile.Name()
 	defer outfile.Close()
 
-	tw, err := tarball.NewContext(tarball.WithSourceDateEpoch(o.SourceDateEpoch))
+	if o.KeepTimestamps {
+		o.Logger().Warnf("keeping file timestamps, the image layer will not be reproducible")
+	}
+
+	level, err := tarball.ParseCompressionLevel(o.CompressionLevel)
+	if err != nil {
+		return "", err
+	}
+
+	tw, err := tarball.NewContext(
+		tarball.WithSourceDateEpoch(o.SourceDateEpoch),
+		tarball.WithCompressionLevel(level),
+		tarball.WithCompression(o.Compression),
+		tarball.WithKeepTimestamps(o.KeepTimestamps),
+		tarball.WithSortEntries(o.SortTarEntries),
+	)
 	if err != nil {
 		return "", fmt.Errorf("failed to construct tarball build context: %w", err)
 	}
@@ -100,19 +129,46 @@ func (di *defaultBuildImplementation) BuildTarball(o *options.Options) (string,
 	if err := tw.WriteArchive(outfile, apkofs.DirFS(o.WorkDir)); err != nil {
 		return "", fmt.Errorf("failed to generate tarball for image: %w", err)
 	}
+	o.LayerSize = tw.UncompressedSize()
 
 	o.Logger().Infof("built image layer tarball as %s", outfile.Name())
 	return outfile.Name(), nil
 }
 
+// readBaseImageSBOM reads the packages listed in the SBOM attached to
+// the base image, if any, so they are merged into the generated SBOMs.
+func readBaseImageSBOM(o *options.Options, ic *types.ImageConfiguration, s *sbom.SBOM) error {
+	if ic.Contents.BaseImage == "" {
+		return nil
+	}
+
+	data, mediaType, err := oci.FetchBaseImageSBOM(ic.Contents.BaseImage, o.Arch)
+	if err != nil {
+		return fmt.Errorf("fetching base image SBOM: %w", err)
+	}
+
+	if data == nil {
+		o.Logger().Warnf("base image %s has no attached SBOM, its packages may be missing from the SBOMs", ic.Contents.BaseImage)
+		return nil
+	}
+
+	return s.ReadBaseImageSBOM(ic.Contents.BaseImage, data, string(mediaType))
+}
+
 // GenerateImageSBOM generates an sbom for an image
 func (di *defaultBuildImplementation) GenerateImageSBOM(o *options.Options, ic *types.ImageConfiguration, img coci.SignedImage) error {
 	if len(o.SBOMFormats) == 0 {
+		if o.RequireSBOM {
+			return errSBOMRequired
+		}
 		o.Logger().Warnf("skipping SBOM generation")
 		return nil
 	}
 
-	s := newSBOM(o, ic)
+	s, err := newSBOM(o, ic)
+	if err != nil {
+		return err
+	}
 
 	if err := s.ReadLayerTarball(o.TarballPath); err != nil {
 		return fmt.Errorf("reading layer tar: %w", err)
@@ -121,6 +177,11 @@ func (di *defaultBuildImplementation) GenerateImageSBOM(o *options.Options, ic *
 	if err := s.ReadPackageIndex(); err != nil {
 		return fmt.Errorf("getting installed packages from sbom: %w", err)
 	}
+	excludeSBOMPackages(o, ic, s)
+
+	if err := readBaseImageSBOM(o, ic, s); err != nil {
+		return err
+	}
 
 	// Get the image digest
 	h, err := img.Digest()
@@ -131,42 +192,114 @@ func (di *defaultBuildImplementation) GenerateImageSBOM(o *options.Options, ic *
 	s.Options.ImageInfo.ImageDigest = h.String()
 	s.Options.ImageInfo.Arch = o.Arch
 
-	if _, err := s.Generate(); err != nil {
+	files, err := s.Generate()
+	if err != nil {
 		return fmt.Errorf("generating SBOMs: %w", err)
 	}
 
+	if o.SBOMPredicates {
+		if err := writeSBOMPredicates(o, s.Options.ImageInfo.Name, h, files); err != nil {
+			return fmt.Errorf("writing SBOM predicates: %w", err)
+		}
+	}
+
 	return nil
 }
 
 // GenerateSBOM generates an SBOM for an apko layer
 func (di *defaultBuildImplementation) GenerateSBOM(o *options.Options, ic *types.ImageConfiguration) error {
 	if len(o.SBOMFormats) == 0 {
+		if o.RequireSBOM {
+			return errSBOMRequired
+		}
 		o.Logger().Warnf("skipping SBOM generation")
 		return nil
 	}
 
-	s := newSBOM(o, ic)
+	s, err := newSBOM(o, ic)
+	if err != nil {
+		return err
+	}
+
+	layerTarGZ := o.TarballPath
+	var h v1.Hash
+	if o.OutputFormat == OutputFormatOCILayout && o.LayoutPath != "" {
+		// Read the layer and the image digest from the written layout
+		layerTarGZ, h, err = oci.LayoutLayer(o.LayoutPath)
+		if err != nil {
+			return fmt.Errorf("reading OCI layout: %w", err)
+		}
+	}
 
-	if err := s.ReadLayerTarball(o.TarballPath); err != nil {
+	if err := s.ReadLayerTarball(layerTarGZ); err != nil {
 		return fmt.Errorf("reading layer tar: %w", err)
 	}
 
 	if err := s.ReadPackageIndex(); err != nil {
 		return fmt.Errorf("getting installed packages from sbom: %w", err)
 	}
+	excludeSBOMPackages(o, ic, s)
+
+	if err := readBaseImageSBOM(o, ic, s); err != nil {
+		return err
+	}
 
+	if h == (v1.Hash{}) {
+		// Get the digest the image built from this layer will have
+		h, err = oci.ComputeImageDigest(layerTarGZ, *ic, o.Logger(), *o)
+		if err != nil {
+			return fmt.Errorf("computing %s image digest: %w", o.Arch, err)
+		}
+	}
+
+	s.Options.ImageInfo.ImageDigest = h.String()
 	s.Options.ImageInfo.Arch = o.Arch
 
-	if _, err := s.Generate(); err != nil {
+	files, err := s.Generate()
+	if err != nil {
 		return fmt.Errorf("generating SBOMs: %w", err)
 	}
 
+	if o.SBOMPredicates {
+		if err := writeSBOMPredicates(o, s.Options.ImageInfo.Name, h, files); err != nil {
+			return fmt.Errorf("writing SBOM predicates: %w", err)
+		}
+	}
+
 	return nil
 }
 
 func (di *defaultBuildImplementation) InitializeApk(o *options.Options, ic *types.ImageConfiguration) error {
 	apk := chainguardAPK.NewWithOptions(*o)
-	return apk.Initialize(ic)
+	if err := apk.Initialize(ic); err != nil {
+		return err
+	}
+
+	o.APKToolsVersion = apk.Options.APKToolsVersion
+	return nil
+}
+
+// ResolvePackages resolves the packages apk would install from the image
+// configuration in a scratch working directory, so nothing is written to
+// the working directory of the build.
+func (di *defaultBuildImplementation) ResolvePackages(o *options.Options, ic *types.ImageConfiguration) ([]chainguardAPK.PlannedPackage, error) {
+	wd, err := os.MkdirTemp("", "apko-resolve-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resolution directory: %w", err)
+	}
+	defer os.RemoveAll(wd)
+
+	ro := *o
+	ro.WorkDir = wd
+
+	apk := chainguardAPK.NewWithOptions(ro)
+	pkgs, err := apk.Resolve(ic)
+	if err != nil {
+		return nil, err
+	}
+
+	o.APKToolsVersion = apk.Options.APKToolsVersion
+	return pkgs, nil
 }
 
 func (di *defaultBuildImplementation) BuildImage(
@@ -187,11 +320,53 @@ func buildImage(
 		return fmt.Errorf("failed to validate configuration: %w", err)
 	}
 
+	if o.StrictAnnotations {
+		if err := ic.ValidateAnnotations(); err != nil {
+			return fmt.Errorf("failed to validate configuration: %w", err)
+		}
+	}
+
+	if o.StrictKeyring {
+		if err := ic.ValidateKeyring(); err != nil {
+			return fmt.Errorf("failed to validate configuration: %w", err)
+		}
+	}
+
+	if o.StrictBaseImage {
+		if err := ic.ValidateBaseImageDigest(); err != nil {
+			return fmt.Errorf("failed to validate configuration: %w", err)
+		}
+	}
+
+	if o.StrictEntrypoint {
+		if err := ic.ValidateEntrypointSet(); err != nil {
+			return fmt.Errorf("failed to validate configuration: %w", err)
+		}
+	}
+
+	for _, warning := range ic.Warnings() {
+		o.Logger().Warnf("%s", warning)
+	}
+
 	o.Logger().Infof("building image fileystem in %s", o.WorkDir)
 
+	if err := di.InitializeBaseImage(o, ic); err != nil {
+		return fmt.Errorf("initializing base image: %w", err)
+	}
+
+	if err := di.RunPreInstallHooks(o, ic, e); err != nil {
+		return fmt.Errorf("failed to run pre-install hooks: %w", err)
+	}
+
+	start := time.Now()
 	if err := di.InitializeApk(o, ic); err != nil {
 		return fmt.Errorf("initializing apk: %w", err)
 	}
+	o.ObservePhase(PhasePackagesInstalled, start)
+
+	if err := di.ValidatePackageOrigins(o); err != nil {
+		return fmt.Errorf("failed to validate package origins: %w", err)
+	}
 
 	if err := di.MutateAccounts(o, ic); err != nil {
 		return fmt.Errorf("failed to mutate accounts: %w", err)
@@ -201,6 +376,10 @@ func buildImage(
 		return fmt.Errorf("failed to mutate paths: %w", err)
 	}
 
+	if err := di.InstallFiles(o, ic); err != nil {
+		return fmt.Errorf("failed to install files: %w", err)
+	}
+
 	// maybe install busybox symlinks
 	if err := di.InstallBusyboxSymlinks(o, e); err != nil {
 		return fmt.Errorf("failed to install busybox symlinks: %w", err)
@@ -210,31 +389,106 @@ func buildImage(
 		return fmt.Errorf("failed to generate /etc/os-release: %w", err)
 	}
 
+	if err := di.WriteTimezone(o, ic); err != nil {
+		return fmt.Errorf("failed to write timezone: %w", err)
+	}
+
+	if err := di.WriteResolvConf(o, ic); err != nil {
+		return fmt.Errorf("failed to write /etc/resolv.conf: %w", err)
+	}
+
+	if err := di.WriteHosts(o, ic); err != nil {
+		return fmt.Errorf("failed to write /etc/hosts: %w", err)
+	}
+
 	if err := di.WriteSupervisionTree(s6context, ic); err != nil {
 		return fmt.Errorf("failed to write supervision tree: %w", err)
 	}
 
+	if err := di.WriteEntrypointDispatcher(o, ic); err != nil {
+		return fmt.Errorf("failed to write entrypoint dispatcher: %w", err)
+	}
+
+	if err := di.RunPostInstallHooks(o, ic, e); err != nil {
+		return fmt.Errorf("failed to run post-install hooks: %w", err)
+	}
+
+	if err := di.StripContents(o, ic); err != nil {
+		return fmt.Errorf("failed to strip contents: %w", err)
+	}
+
 	o.Logger().Infof("finished building filesystem in %s", o.WorkDir)
 
 	return nil
 }
 
-func newSBOM(o *options.Options, ic *types.ImageConfiguration) *sbom.SBOM {
-	s := sbom.NewWithWorkDir(o.WorkDir, o.Arch)
+// excludeSBOMPackages removes the packages excluded by the image
+// configuration from the installed packages listed in the SBOM, warning
+// about those which are not installed.
+func excludeSBOMPackages(o *options.Options, ic *types.ImageConfiguration, s *sbom.SBOM) {
+	if len(ic.SBOM.ExcludePackages) == 0 {
+		return
+	}
+
+	excluded := map[string]bool{}
+	for _, name := range ic.SBOM.ExcludePackages {
+		excluded[name] = false
+	}
+
+	pkgs := make([]*repository.Package, 0, len(s.Options.Packages))
+	for _, pkg := range s.Options.Packages {
+		if _, ok := excluded[pkg.Name]; ok {
+			excluded[pkg.Name] = true
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	s.Options.Packages = pkgs
+
+	for _, name := range ic.SBOM.ExcludePackages {
+		if !excluded[name] {
+			o.Logger().Warnf("package %s is excluded from the SBOM, but it is not installed", name)
+		}
+	}
+}
+
+// sbomWorkDir returns the directory holding the image filesystem the
+// SBOM and the build report describe.
+func sbomWorkDir(o *options.Options) string {
+	if o.SBOMWorkDir != "" {
+		return o.SBOMWorkDir
+	}
+	return o.WorkDir
+}
+
+func newSBOM(o *options.Options, ic *types.ImageConfiguration) (*sbom.SBOM, error) {
+	s := sbom.NewWithWorkDir(sbomWorkDir(o), o.Arch)
 	// Parse the image reference
 	if len(o.Tags) > 0 {
-		tag, err := name.NewTag(o.Tags[0])
+		tags, err := canonicalTags(o.Tags)
 		if err == nil {
-			s.Options.ImageInfo.Tag = tag.TagStr()
-			s.Options.ImageInfo.Name = tag.String()
+			s.Options.ImageInfo.Tag = tags[0].TagStr()
+			s.Options.ImageInfo.Name = tags[0].String()
 		} else {
-			o.Logger().Errorf("%s parsing tag %s, ignoring", o.Tags[0], err)
+			o.Logger().Errorf("%s, ignoring tags", err)
 		}
 	}
 
 	s.Options.ImageInfo.SourceDateEpoch = o.SourceDateEpoch
 	s.Options.Formats = o.SBOMFormats
+	s.Options.Gzip = o.SBOMGzip
+	s.Options.SigningKey = o.SBOMSigningKey
+	s.Options.APKToolsVersion = o.APKToolsVersion
+
+	for _, path := range ic.Contents.LocalPackages {
+		name, err := chainguardAPK.LocalPackageName(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading local package %s: %w", path, err)
+		}
+		s.Options.LocalPackages = append(s.Options.LocalPackages, name)
+	}
 	s.Options.ImageInfo.VCSUrl = ic.VCSUrl
+	s.Options.ImageInfo.VCSSubPath = ic.VCSSubPath
 
 	if o.UseDockerMediaTypes {
 		s.Options.ImageInfo.ImageMediaType = ggcrtypes.DockerManifestSchema2
@@ -247,7 +501,7 @@ func newSBOM(o *options.Options, ic *types.ImageConfiguration) *sbom.SBOM {
 		s.Options.OutputDir = o.SBOMPath
 	}
 
-	return s
+	return s, nil
 }
 
 func (di *defaultBuildImplementation) GenerateIndexSBOM(
@@ -255,11 +509,17 @@ func (di *defaultBuildImplementation) GenerateIndexSBOM(
 	indexDigest name.Digest, imgs map[types.Architecture]coci.SignedImage,
 ) error {
 	if len(o.SBOMFormats) == 0 {
+		if o.RequireSBOM {
+			return errSBOMRequired
+		}
 		o.Logger().Warnf("skipping index SBOM generation")
 		return nil
 	}
 
-	s := newSBOM(o, ic)
+	s, err := newSBOM(o, ic)
+	if err != nil {
+		return err
+	}
 	o.Logger().Infof("Generating index SBOM")
 
 	// Add the image digest
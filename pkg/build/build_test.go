@@ -15,17 +15,83 @@
 package build_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 
+	"chainguard.dev/apko/pkg/apk"
 	"chainguard.dev/apko/pkg/build"
 	"chainguard.dev/apko/pkg/build/buildfakes"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/tarball"
 )
 
+// writeLayer writes a layer tarball holding a single file of the
+// given size and returns its path.
+func writeLayer(t *testing.T, size int) string {
+	path := filepath.Join(t.TempDir(), "layer.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "data", Mode: 0o644, Size: int64(size)}))
+	_, err = tw.Write(make([]byte, size))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+
+	return path
+}
+
+// phaseRecorder records the phases of the build reported to it.
+type phaseRecorder []string
+
+func (r *phaseRecorder) OnPhase(name string, _ time.Duration) {
+	*r = append(*r, name)
+}
+
+func TestPhaseHook(t *testing.T) {
+	phases := &phaseRecorder{}
+	sut, err := build.New("/mock", build.WithPhaseHook(phases))
+	require.NoError(t, err)
+	require.Equal(t, []string{build.PhaseConfigLoaded}, []string(*phases))
+
+	mock := buildfakes.FakeBuildImplementation{}
+	mock.BuildTarballReturns(writeLayer(t, 1024), nil)
+	sut.SetImplementation(&mock)
+	sut.Options.WantSBOM = true
+
+	_, err = sut.BuildLayer()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		build.PhaseConfigLoaded,
+		build.PhasePackagesInstalled,
+		build.PhaseImageBuilt,
+		build.PhaseTarballWritten,
+		build.PhaseSBOMGenerated,
+	}, []string(*phases))
+
+	// a nil hook removes the hook
+	sut, err = build.New("/mock", build.WithPhaseHook(phases), build.WithPhaseHook(nil))
+	require.NoError(t, err)
+	require.Nil(t, sut.Options.PhaseHook)
+}
+
 func TestBuildLayer(t *testing.T) {
 	fakeErr := fmt.Errorf("synthetic error")
+	layer := writeLayer(t, 1024)
 	for _, tc := range []struct {
 		prepare     func(*buildfakes.FakeBuildImplementation)
 		msg         string
@@ -62,6 +128,7 @@ func TestBuildLayer(t *testing.T) {
 		},
 	} {
 		mock := buildfakes.FakeBuildImplementation{}
+		mock.BuildTarballReturns(layer, nil)
 		tc.prepare(&mock)
 		sut, err := build.New("/mock")
 		sut.Options.WantSBOM = true
@@ -98,6 +165,22 @@ func TestBuildImage(t *testing.T) {
 			msg:         "ValidateImageConfiguration fails",
 			shouldError: true,
 		},
+		{
+			// InitializeBaseImage fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.InitializeBaseImageReturns(fakeErr)
+			},
+			msg:         "InitializeBaseImage fails",
+			shouldError: true,
+		},
+		{
+			// RunPreInstallHooks fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.RunPreInstallHooksReturns(fakeErr)
+			},
+			msg:         "RunPreInstallHooks fails",
+			shouldError: true,
+		},
 		{
 			// InitializeApk fails
 			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
@@ -106,6 +189,14 @@ func TestBuildImage(t *testing.T) {
 			msg:         "InitializeApk fails",
 			shouldError: true,
 		},
+		{
+			// ValidatePackageOrigins fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.ValidatePackageOriginsReturns(fakeErr)
+			},
+			msg:         "ValidatePackageOrigins fails",
+			shouldError: true,
+		},
 		{
 			// MutateAccounts fails
 			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
@@ -114,6 +205,14 @@ func TestBuildImage(t *testing.T) {
 			msg:         "MutateAccounts fails",
 			shouldError: true,
 		},
+		{
+			// InstallFiles fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.InstallFilesReturns(fakeErr)
+			},
+			msg:         "InstallFiles fails",
+			shouldError: true,
+		},
 		{
 			// InstallBusyboxSymlinks fails
 			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
@@ -122,6 +221,30 @@ func TestBuildImage(t *testing.T) {
 			msg:         "InstallBusyboxSymlinks fails",
 			shouldError: true,
 		},
+		{
+			// WriteTimezone fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.WriteTimezoneReturns(fakeErr)
+			},
+			msg:         "WriteTimezone fails",
+			shouldError: true,
+		},
+		{
+			// WriteResolvConf fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.WriteResolvConfReturns(fakeErr)
+			},
+			msg:         "WriteResolvConf fails",
+			shouldError: true,
+		},
+		{
+			// WriteHosts fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.WriteHostsReturns(fakeErr)
+			},
+			msg:         "WriteHosts fails",
+			shouldError: true,
+		},
 		{
 			// WriteSupervisionTree fails
 			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
@@ -130,6 +253,30 @@ func TestBuildImage(t *testing.T) {
 			msg:         "WriteSupervisionTree fails",
 			shouldError: true,
 		},
+		{
+			// WriteEntrypointDispatcher fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.WriteEntrypointDispatcherReturns(fakeErr)
+			},
+			msg:         "WriteEntrypointDispatcher fails",
+			shouldError: true,
+		},
+		{
+			// RunPostInstallHooks fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.RunPostInstallHooksReturns(fakeErr)
+			},
+			msg:         "RunPostInstallHooks fails",
+			shouldError: true,
+		},
+		{
+			// StripContents fails
+			prepare: func(fbi *buildfakes.FakeBuildImplementation) {
+				fbi.StripContentsReturns(fakeErr)
+			},
+			msg:         "StripContents fails",
+			shouldError: true,
+		},
 	} {
 		mock := &buildfakes.FakeBuildImplementation{}
 		tc.prepare(mock)
@@ -145,3 +292,683 @@ func TestBuildImage(t *testing.T) {
 		}
 	}
 }
+
+func TestCanonicalTags(t *testing.T) {
+	for _, tc := range []struct {
+		tags        []string
+		expected    []string
+		shouldError bool
+	}{
+		{
+			tags:     []string{"example.com/foo:v1", "example.com/foo:v1", "example.com/foo"},
+			expected: []string{"example.com/foo:v1", "example.com/foo:latest"},
+		},
+		{
+			tags:     []string{"alpine"},
+			expected: []string{"index.docker.io/library/alpine:latest"},
+		},
+		{
+			tags:     []string{"alpine:latest", "docker.io/library/alpine"},
+			expected: []string{"index.docker.io/library/alpine:latest"},
+		},
+		{
+			tags:        []string{"example.com/foo:v1", "Not A Tag"},
+			shouldError: true,
+		},
+	} {
+		sut, err := build.New("/mock")
+		require.NoError(t, err)
+		sut.Options.Tags = tc.tags
+
+		tags, err := sut.CanonicalTags()
+		if tc.shouldError {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+
+		names := []string{}
+		for _, tag := range tags {
+			names = append(names, tag.Name())
+		}
+		require.Equal(t, tc.expected, names)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	for _, tc := range []struct {
+		opts        []build.Option
+		msg         string
+		shouldError bool
+	}{
+		{
+			opts: []build.Option{
+				build.WithWorkDir("/mock"),
+				build.WithArch(types.ParseArchitecture("x86_64")),
+				build.WithSBOMFormats([]string{"spdx", "cyclonedx"}),
+				build.WithTags("example.com/foo:v1"),
+			},
+			msg:         "valid options",
+			shouldError: false,
+		},
+		{
+			opts:        []build.Option{build.WithWorkDir("")},
+			msg:         "empty work dir",
+			shouldError: true,
+		},
+		{
+			opts:        []build.Option{build.WithArch(types.ParseArchitecture("apples"))},
+			msg:         "unsupported arch",
+			shouldError: true,
+		},
+		{
+			opts:        []build.Option{build.WithSBOMFormats([]string{"spdx", "bogus"})},
+			msg:         "unsupported sbom format",
+			shouldError: true,
+		},
+		{
+			opts:        []build.Option{build.WithTags("Not A Tag")},
+			msg:         "invalid tag",
+			shouldError: true,
+		},
+	} {
+		_, err := build.NewWithOptions(tc.opts...)
+		if tc.shouldError {
+			require.Error(t, err, tc.msg)
+		} else {
+			require.NoError(t, err, tc.msg)
+		}
+	}
+}
+
+func TestSBOMPathFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	ic := types.ImageConfiguration{}
+	ic.SBOM.Path = dir
+
+	sut, err := build.New("/mock", build.WithImageConfiguration(ic))
+	require.NoError(t, err)
+	require.Equal(t, dir, sut.Options.SBOMPath)
+
+	// The path given to the build takes precedence.
+	sut, err = build.New("/mock", build.WithImageConfiguration(ic), build.WithSBOM("/other"))
+	require.NoError(t, err)
+	require.Equal(t, "/other", sut.Options.SBOMPath)
+
+	ic.SBOM.Path = filepath.Join(dir, "missing")
+	_, err = build.New("/mock", build.WithImageConfiguration(ic))
+	require.ErrorContains(t, err, "is not writable")
+}
+
+func TestOverrideRunAs(t *testing.T) {
+	wd := t.TempDir()
+	ic := types.ImageConfiguration{}
+	ic.Accounts.RunAs = "nonroot"
+
+	sut, err := build.New(wd, build.WithImageConfiguration(ic), build.WithOverrideRunAs("root"))
+	require.NoError(t, err)
+	require.Equal(t, "root", sut.ImageConfiguration.Accounts.RunAs)
+
+	// the configured user is kept without an override
+	sut, err = build.New(wd, build.WithImageConfiguration(ic))
+	require.NoError(t, err)
+	require.Equal(t, "nonroot", sut.ImageConfiguration.Accounts.RunAs)
+}
+
+func TestWithLogWriter(t *testing.T) {
+	var first, second bytes.Buffer
+
+	bc1, err := build.New(t.TempDir(), build.WithLogWriter(&first))
+	require.NoError(t, err)
+	bc2, err := build.New(t.TempDir(), build.WithLogWriter(&second))
+	require.NoError(t, err)
+
+	bc1.Summarize()
+	require.Contains(t, first.String(), "build context:")
+	require.NotContains(t, second.String(), "build context:")
+
+	bc2.Logger().Infof("second build")
+	require.Contains(t, second.String(), "second build")
+	require.NotContains(t, first.String(), "second build")
+
+	// the options apply in any order, and the config is loaded to the
+	// writer of the context
+	config := filepath.Join(t.TempDir(), "apko.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("contents:\n  packages: [busybox]\n"), 0o644))
+	var debug bytes.Buffer
+	bc3, err := build.New(t.TempDir(),
+		build.WithDebugLogging(true),
+		build.WithConfig(config),
+		build.WithLogWriter(&debug),
+	)
+	require.NoError(t, err)
+	require.Contains(t, debug.String(), "loading config file: "+config)
+	bc3.Logger().Debugf("debug build")
+	require.Contains(t, debug.String(), "debug build")
+
+	// debug logging doesn't leak into other contexts
+	require.Equal(t, logrus.InfoLevel, bc1.Options.Log.GetLevel())
+	require.Equal(t, logrus.InfoLevel, options.Default.Log.GetLevel())
+}
+
+func TestWithTarball(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "layer.tar.gz")
+
+	sut, err := build.New("/mock", build.WithTarball(path))
+	require.NoError(t, err)
+	require.Equal(t, path, sut.Options.LayerTarballPath())
+
+	// The requested path survives refreshing the build context.
+	require.NoError(t, sut.Refresh())
+	require.Equal(t, path, sut.Options.TarballPath)
+
+	_, err = build.New("/mock", build.WithTarball(filepath.Join(dir, "missing", "layer.tar.gz")))
+	require.ErrorContains(t, err, "is not writable")
+
+	// By default the tarball is written to the temporary directory.
+	sut, err = build.New("/mock")
+	require.NoError(t, err)
+	defer sut.Close()
+	require.Equal(t, filepath.Join(sut.Options.TempDir(), "apko-"+sut.Options.Arch.ToAPK()+".tar.gz"), sut.Options.LayerTarballPath())
+}
+
+func TestCompression(t *testing.T) {
+	sut, err := build.New("/mock", build.WithCompression("zstd"))
+	require.NoError(t, err)
+	require.Equal(t, "apko-"+sut.Options.Arch.ToAPK()+".tar.zst", sut.Options.TarballFileName())
+
+	_, err = build.New("/mock", build.WithCompression("xz"))
+	require.ErrorContains(t, err, "unsupported compression")
+
+	_, err = build.New("/mock", build.WithCompression("zstd"), build.WithCompressionLevel("9"))
+	require.ErrorContains(t, err, "only be set for gzip")
+
+	_, err = build.New("/mock", build.WithCompression("zstd"), build.WithDockerMediatypes(true))
+	require.ErrorContains(t, err, "cannot use Docker media types")
+}
+
+func TestMediaType(t *testing.T) {
+	sut, err := build.New("/mock", build.WithMediaType("docker"))
+	require.NoError(t, err)
+	require.True(t, sut.Options.UseDockerMediaTypes)
+
+	sut, err = build.New("/mock", build.WithDockerMediatypes(true), build.WithMediaType("oci"))
+	require.NoError(t, err)
+	require.False(t, sut.Options.UseDockerMediaTypes)
+
+	_, err = build.New("/mock", build.WithMediaType("v2"))
+	require.ErrorContains(t, err, "unsupported media type")
+
+	_, err = build.New("/mock", build.WithCompression("zstd"), build.WithMediaType("docker"))
+	require.ErrorContains(t, err, "cannot use Docker media types")
+}
+
+func TestRequireSBOM(t *testing.T) {
+	sut, err := build.New("/mock", build.WithSBOMFormats([]string{}))
+	require.NoError(t, err)
+	require.NoError(t, sut.GenerateSBOM())
+
+	_, err = build.New("/mock", build.WithSBOMFormats([]string{}), build.WithRequireSBOM(true))
+	require.ErrorContains(t, err, "no SBOM formats are configured")
+
+	_, err = build.New("/mock", build.WithRequireSBOM(true))
+	require.ErrorContains(t, err, "no SBOM formats are configured")
+
+	sut, err = build.New("/mock", build.WithSBOMFormats([]string{"spdx"}), build.WithRequireSBOM(true))
+	require.NoError(t, err)
+	sut.Options.SBOMFormats = nil
+	require.ErrorContains(t, sut.GenerateSBOM(), "no SBOM formats are configured")
+}
+
+func TestSBOMFormatsFromEnv(t *testing.T) {
+	// The library does not default the formats, the CLI does.
+	sut, err := build.New("/mock")
+	require.NoError(t, err)
+	require.Nil(t, sut.Options.SBOMFormats)
+
+	t.Setenv("APKO_SBOM_FORMATS", "spdx, cyclonedx")
+	sut, err = build.New("/mock")
+	require.NoError(t, err)
+	require.Equal(t, []string{"spdx", "cyclonedx"}, sut.Options.SBOMFormats)
+
+	// Explicit formats take precedence over the environment.
+	sut, err = build.New("/mock", build.WithSBOMFormats([]string{"idb"}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"idb"}, sut.Options.SBOMFormats)
+
+	t.Setenv("APKO_SBOM_FORMATS", "spdx,bogus")
+	_, err = build.New("/mock")
+	require.Error(t, err)
+}
+
+func TestInsecurePaths(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, d := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{"tmp", 0o777 | os.ModeSticky},
+		{"shared", 0o777},
+		{"bin", 0o755},
+	} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, d.path), 0o755))
+		require.NoError(t, os.Chmod(filepath.Join(dir, d.path), d.mode))
+	}
+
+	for _, f := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{"bin/sh", 0o755},
+		{"bin/su", 0o755 | os.ModeSetuid},
+		{"log", 0o666},
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f.path), []byte{}, 0o644))
+		require.NoError(t, os.Chmod(filepath.Join(dir, f.path), f.mode))
+	}
+
+	sut, err := build.New(dir)
+	require.NoError(t, err)
+
+	found, err := sut.InsecurePaths()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/bin/su (setuid)",
+		"/log (world-writable)",
+		"/shared (world-writable)",
+	}, found)
+
+	require.NoError(t, build.RequireNoInsecurePaths(true)(sut))
+	require.Error(t, build.RequireNoInsecurePaths(false)(sut))
+}
+
+func TestAccountAccess(t *testing.T) {
+	dir := t.TempDir()
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+
+	for _, f := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{"config", 0o644},
+		{"readonly", 0o444},
+		{"shared", 0o664},
+		{"log", 0o666},
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f.path), []byte{}, 0o644))
+		require.NoError(t, os.Chmod(filepath.Join(dir, f.path), f.mode))
+	}
+	require.NoError(t, os.Chmod(dir, 0o755))
+
+	ic := types.ImageConfiguration{}
+	ic.Accounts.Users = []types.User{
+		{UserName: "app", UID: uid, GID: gid + 1},
+		{UserName: "other", UID: uid + 1, GID: gid + 1, Groups: []string{"staff"}},
+	}
+	ic.Accounts.Groups = []types.Group{{GroupName: "staff", GID: gid}}
+
+	sut, err := build.New(dir, build.WithImageConfiguration(ic))
+	require.NoError(t, err)
+
+	access, err := sut.AccountAccess()
+	require.NoError(t, err)
+	require.Equal(t, build.AccountPaths{
+		Owned:    []string{"/", "/config", "/log", "/readonly", "/shared"},
+		Writable: []string{"/", "/config", "/log", "/shared"},
+	}, access.Users["app"])
+	require.Equal(t, build.AccountPaths{
+		Owned:    []string{},
+		Writable: []string{"/log", "/shared"},
+	}, access.Users["other"])
+	require.Equal(t, build.AccountPaths{
+		Owned:    []string{"/", "/config", "/log", "/readonly", "/shared"},
+		Writable: []string{"/log", "/shared"},
+	}, access.Groups["staff"])
+}
+
+func TestUnexpectedSetuidBinaries(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"bin", "etc", "lib/apk/db", "usr/bin"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib/apk/db/installed"), []byte(
+		"P:busybox-suid\nV:1.35.0-r17\no:busybox\nF:bin\nR:bbsuid\nR:sh\n\n",
+	), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "etc/passwd"), []byte(
+		fmt.Sprintf("builder:x:%d:%d:builder:/home/builder:/bin/sh\n", os.Getuid(), os.Getgid()),
+	), 0o644))
+	for _, f := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{"bin/bbsuid", 0o755 | os.ModeSetuid},
+		{"bin/sh", 0o755},
+		{"usr/bin/wall", 0o755 | os.ModeSetgid},
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f.path), []byte{}, 0o644))
+		require.NoError(t, os.Chmod(filepath.Join(dir, f.path), f.mode))
+	}
+
+	ic := types.ImageConfiguration{}
+	sut, err := build.New(dir, build.WithImageConfiguration(ic))
+	require.NoError(t, err)
+
+	found, err := sut.UnexpectedSetuidBinaries()
+	require.NoError(t, err)
+	require.Equal(t, []build.SetuidBinary{
+		{
+			Path: "/bin/bbsuid", Setuid: true, UID: uint32(os.Getuid()), GID: uint32(os.Getgid()),
+			Owner: "builder", Package: "busybox-suid", Origin: "busybox",
+		},
+		{
+			Path: "/usr/bin/wall", Setgid: true, UID: uint32(os.Getuid()), GID: uint32(os.Getgid()),
+			Owner: "builder",
+		},
+	}, found)
+	require.Contains(t, found[0].String(), "from package busybox-suid (origin busybox)")
+	require.Contains(t, found[1].String(), "from unknown package")
+
+	// Allowed binaries are not reported.
+	ic.Setuid.Allow = []string{"/bin/*"}
+	sut, err = build.New(dir, build.WithImageConfiguration(ic))
+	require.NoError(t, err)
+	found, err = sut.UnexpectedSetuidBinaries()
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, "/usr/bin/wall", found[0].Path)
+
+	// Enforcing the policy fails the build.
+	ic.Setuid.Enforce = true
+	sut, err = build.New(dir, build.WithImageConfiguration(ic))
+	require.NoError(t, err)
+	sut.SetImplementation(&buildfakes.FakeBuildImplementation{})
+	_, err = sut.BuildLayer()
+	require.ErrorContains(t, err, "unexpected setuid or setgid binaries found: /usr/bin/wall")
+}
+
+func TestClose(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		wd := filepath.Join(t.TempDir(), "work")
+		require.NoError(t, os.Mkdir(wd, 0o755))
+
+		sut, err := build.New(wd, build.WithPreserveWorkDir(preserve))
+		require.NoError(t, err)
+
+		sut.Options.TempDirPath = filepath.Join(t.TempDir(), "temp")
+		tempDir := sut.Options.TempDir()
+		require.NoError(t, os.Mkdir(tempDir, 0o755))
+
+		require.NoError(t, sut.Close())
+		require.NoDirExists(t, tempDir)
+		if preserve {
+			require.DirExists(t, wd)
+		} else {
+			require.NoDirExists(t, wd)
+		}
+
+		// Closing again is harmless.
+		require.NoError(t, sut.Close())
+	}
+}
+
+func TestWriteInstalledDB(t *testing.T) {
+	wd := t.TempDir()
+	out := filepath.Join(t.TempDir(), "installed")
+
+	sut, err := build.New(wd, build.WithInstalledDB(out))
+	require.NoError(t, err)
+
+	// Nothing is installed yet.
+	require.ErrorContains(t, sut.WriteInstalledDB(), "reading installed database")
+
+	db := []byte("P:busybox\nV:1.35.0-r17\n\n")
+	require.NoError(t, os.MkdirAll(filepath.Join(wd, "lib", "apk", "db"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(wd, "lib", "apk", "db", "installed"), db, 0o644))
+	require.NoError(t, sut.WriteInstalledDB())
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, db, data)
+}
+
+func TestGenerateProvenance(t *testing.T) {
+	wd := t.TempDir()
+	out := filepath.Join(t.TempDir(), "provenance.json")
+	ic := types.ImageConfiguration{VCSUrl: "https://github.com/example/images", VCSSubPath: "nginx"}
+	sut, err := build.New(wd,
+		build.WithImageConfiguration(ic),
+		build.WithArch(types.ParseArchitecture("x86_64")),
+		build.WithTags("example.com/nginx:latest"),
+		build.WithProvenance(out),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sut.ImageConfiguration.Validate())
+	sut.ImageConfigFile = "nginx/apko.yaml"
+	sut.Options.TarballPath = writeLayer(t, 16)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(wd, "lib", "apk", "db"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(wd, "lib", "apk", "db", "installed"), []byte(
+		"P:nginx\nV:1.23.1-r0\nA:x86_64\nC:Q1yB0ZJ2YjAI5gE3mMLTkHKJEOI3o=\n\nP:busybox\nV:1.35.0-r17\nA:x86_64\n\n"), 0o644))
+	require.NoError(t, sut.GenerateProvenance())
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	st := struct {
+		PredicateType string `json:"predicateType"`
+		Subject       []struct {
+			Name   string            `json:"name"`
+			Digest map[string]string `json:"digest"`
+		} `json:"subject"`
+		Predicate struct {
+			Invocation struct {
+				ConfigSource map[string]string      `json:"configSource"`
+				Parameters   map[string]interface{} `json:"parameters"`
+			} `json:"invocation"`
+			Materials []struct {
+				URI    string            `json:"uri"`
+				Digest map[string]string `json:"digest"`
+			} `json:"materials"`
+		} `json:"predicate"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &st))
+
+	digest, err := sut.ImageDigest(sut.Options.TarballPath)
+	require.NoError(t, err)
+	require.Equal(t, "https://slsa.dev/provenance/v0.2", st.PredicateType)
+	require.Len(t, st.Subject, 1)
+	require.Equal(t, "example.com/nginx:latest", st.Subject[0].Name)
+	require.Equal(t, map[string]string{"sha256": digest.Hex}, st.Subject[0].Digest)
+
+	require.Equal(t, map[string]string{"uri": "https://github.com/example/images", "entryPoint": "nginx/apko.yaml"}, st.Predicate.Invocation.ConfigSource)
+	fingerprint, err := sut.ImageConfiguration.Fingerprint()
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, st.Predicate.Invocation.Parameters["configFingerprint"])
+	require.Equal(t, "x86_64", st.Predicate.Invocation.Parameters["arch"])
+
+	require.Len(t, st.Predicate.Materials, 2)
+	require.Equal(t, "pkg:apk/alpine/busybox@1.35.0-r17?arch=x86_64", st.Predicate.Materials[0].URI)
+	require.Empty(t, st.Predicate.Materials[0].Digest)
+	require.Equal(t, "pkg:apk/alpine/nginx@1.23.1-r0?arch=x86_64", st.Predicate.Materials[1].URI)
+	require.Equal(t, map[string]string{"apkChecksum": "Q1yB0ZJ2YjAI5gE3mMLTkHKJEOI3o="}, st.Predicate.Materials[1].Digest)
+}
+
+func TestMaxImageSize(t *testing.T) {
+	layer := writeLayer(t, 1<<20)
+
+	for _, tc := range []struct {
+		max         int64
+		shouldError bool
+	}{
+		{max: 0},
+		{max: 2 << 20},
+		{max: 1 << 20, shouldError: true},
+	} {
+		mock := buildfakes.FakeBuildImplementation{}
+		mock.BuildTarballStub = func(o *options.Options) (string, error) {
+			o.LayerSize = 1<<20 + 1024
+			return layer, nil
+		}
+		sut, err := build.New("/mock", build.WithMaxImageSize(tc.max))
+		require.NoError(t, err)
+		sut.SetImplementation(&mock)
+		_, err = sut.BuildLayer()
+		if tc.shouldError {
+			require.ErrorContains(t, err, "exceeds limit 1.00 MB")
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	_, err := build.New("/mock", build.WithMaxImageSize(-1))
+	require.Error(t, err)
+}
+
+func TestResolvePackages(t *testing.T) {
+	planned := []apk.PlannedPackage{{Name: "musl", Version: "1.2.3-r0"}}
+
+	mock := buildfakes.FakeBuildImplementation{}
+	mock.ResolvePackagesReturns(planned, nil)
+	sut, err := build.New("/mock", build.WithArch(types.ParseArchitecture("amd64")))
+	require.NoError(t, err)
+	sut.SetImplementation(&mock)
+
+	pkgs, err := sut.ResolvePackages(types.ParseArchitecture("arm64"))
+	require.NoError(t, err)
+	require.Equal(t, planned, pkgs)
+	require.Equal(t, 1, mock.ResolvePackagesCallCount())
+	o, _ := mock.ResolvePackagesArgsForCall(0)
+	require.Equal(t, types.ParseArchitecture("arm64"), o.Arch)
+	require.Equal(t, 0, mock.BuildTarballCallCount())
+
+	// The configuration is validated before resolving.
+	mock.ValidateImageConfigurationReturns(fmt.Errorf("synthetic error"))
+	_, err = sut.ResolvePackages(types.ParseArchitecture("arm64"))
+	require.Error(t, err)
+	require.Equal(t, 1, mock.ResolvePackagesCallCount())
+}
+
+func TestBuildArgs(t *testing.T) {
+	args := map[string]string{"VERSION": "1.2.3"}
+	ic := types.ImageConfiguration{}
+	ic.Environment = map[string]string{"APP_VERSION": "{{ .BuildArgs.VERSION }}"}
+
+	// The build args apply whichever order the options are given in.
+	for _, opts := range [][]build.Option{
+		{build.WithBuildArgs(args), build.WithImageConfiguration(ic)},
+		{build.WithImageConfiguration(ic), build.WithBuildArgs(args)},
+	} {
+		mock := buildfakes.FakeBuildImplementation{}
+		sut, err := build.New("/mock", opts...)
+		require.NoError(t, err)
+		sut.SetImplementation(&mock)
+
+		_, err = sut.ResolvePackages(types.ParseArchitecture("amd64"))
+		require.NoError(t, err)
+		require.Equal(t, args, mock.ValidateImageConfigurationArgsForCall(0).BuildArgs)
+	}
+}
+
+func TestVerifyEntrypoint(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"bin", "usr/bin"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bin/busybox"), []byte{}, 0o755))
+	require.NoError(t, os.Symlink("/bin/busybox", filepath.Join(dir, "bin/sh")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "usr/bin/data"), []byte{}, 0o644))
+
+	for _, tc := range []struct {
+		command string
+		cmd     string
+		umask   string
+		err     string
+	}{
+		{command: "/bin/sh -c true"},
+		{cmd: "/bin/sh"},
+		// Commands looked up in PATH are not checked.
+		{command: "nginx -g 'daemon off;'"},
+		{command: "/usr/bin/nginx", err: "/usr/bin/nginx does not exist in the image"},
+		{cmd: "/usr/bin/data", err: "/usr/bin/data is not executable"},
+		{command: "/usr/bin", err: "/usr/bin is not a regular file"},
+		// The binary run by the umask wrapper is checked.
+		{command: "/usr/bin/nginx", umask: "027", err: "/usr/bin/nginx does not exist in the image"},
+		{cmd: "/bin/busybox", umask: "027"},
+	} {
+		ic := types.ImageConfiguration{Cmd: tc.cmd}
+		ic.Entrypoint.Command = tc.command
+		ic.Entrypoint.Umask = tc.umask
+		sut, err := build.New(dir, build.WithImageConfiguration(ic))
+		require.NoError(t, err)
+
+		err = sut.VerifyEntrypoint()
+		if tc.err == "" {
+			require.NoError(t, err, tc.command+tc.cmd)
+		} else {
+			require.ErrorContains(t, err, tc.err, tc.command+tc.cmd)
+		}
+	}
+
+	// Service bundles need the s6 binaries.
+	ic := types.ImageConfiguration{}
+	ic.Entrypoint.Type = "service-bundle"
+	ic.Entrypoint.Services = map[interface{}]interface{}{"sh": "/bin/sh"}
+	sut, err := build.New(dir, build.WithImageConfiguration(ic))
+	require.NoError(t, err)
+	require.ErrorContains(t, sut.VerifyEntrypoint(), "/bin/s6-svscan does not exist in the image")
+
+	for _, s6 := range []string{"bin/s6-svscan", "bin/s6-supervise"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, s6), []byte{}, 0o755))
+	}
+	require.NoError(t, sut.VerifyEntrypoint())
+}
+
+func TestVerifyReproducible(t *testing.T) {
+	layer := writeLayer(t, 1024)
+	l, err := tarball.LayerFromFile(layer, "")
+	require.NoError(t, err)
+	digest, err := l.DiffID()
+	require.NoError(t, err)
+
+	newContext := func(layers ...string) *build.Context {
+		mock := buildfakes.FakeBuildImplementation{}
+		for i, l := range layers {
+			mock.BuildTarballReturnsOnCall(i, l, nil)
+		}
+		sut, err := build.New(t.TempDir())
+		require.NoError(t, err)
+		sut.SetImplementation(&mock)
+		return sut
+	}
+
+	require.NoError(t, newContext(layer).VerifyReproducible(digest))
+
+	// Identical rebuilds differ from the reference because of their inputs.
+	other := writeLayer(t, 2048)
+	err = newContext(other, other).VerifyReproducible(digest)
+	var rerr *build.ReproducibilityError
+	require.ErrorAs(t, err, &rerr)
+	require.Empty(t, rerr.Paths)
+	require.ErrorContains(t, err, "a rebuild is identical")
+
+	// Rebuilds which differ are reported with the differing paths.
+	err = newContext(other, layer).VerifyReproducible(digest)
+	require.ErrorAs(t, err, &rerr)
+	require.Equal(t, []string{"data"}, rerr.Paths)
+
+	// The rebuild does not share the configuration of the build.
+	sut := newContext(other)
+	sut.Options.Tags = []string{"example.com/image:latest"}
+	mock := buildfakes.FakeBuildImplementation{}
+	mock.BuildTarballCalls(func(o *options.Options) (string, error) {
+		if mock.BuildTarballCallCount() == 1 {
+			return other, nil
+		}
+		o.Tags[0] = "example.com/image:rebuild"
+		return layer, nil
+	})
+	sut.SetImplementation(&mock)
+	require.ErrorAs(t, sut.VerifyReproducible(digest), &rerr)
+	require.Equal(t, []string{"example.com/image:latest"}, sut.Options.Tags)
+}
@@ -18,6 +18,7 @@ package buildfakes
 import (
 	"sync"
 
+	"chainguard.dev/apko/pkg/apk"
 	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/exec"
 	"chainguard.dev/apko/pkg/options"
@@ -117,6 +118,18 @@ type FakeBuildImplementation struct {
 	initializeApkReturnsOnCall map[int]struct {
 		result1 error
 	}
+	InitializeBaseImageStub        func(*options.Options, *types.ImageConfiguration) error
+	initializeBaseImageMutex       sync.RWMutex
+	initializeBaseImageArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}
+	initializeBaseImageReturns struct {
+		result1 error
+	}
+	initializeBaseImageReturnsOnCall map[int]struct {
+		result1 error
+	}
 	InstallBusyboxSymlinksStub        func(*options.Options, *exec.Executor) error
 	installBusyboxSymlinksMutex       sync.RWMutex
 	installBusyboxSymlinksArgsForCall []struct {
@@ -129,6 +142,18 @@ type FakeBuildImplementation struct {
 	installBusyboxSymlinksReturnsOnCall map[int]struct {
 		result1 error
 	}
+	InstallFilesStub        func(*options.Options, *types.ImageConfiguration) error
+	installFilesMutex       sync.RWMutex
+	installFilesArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}
+	installFilesReturns struct {
+		result1 error
+	}
+	installFilesReturnsOnCall map[int]struct {
+		result1 error
+	}
 	MutateAccountsStub        func(*options.Options, *types.ImageConfiguration) error
 	mutateAccountsMutex       sync.RWMutex
 	mutateAccountsArgsForCall []struct {
@@ -168,6 +193,58 @@ type FakeBuildImplementation struct {
 		result2 *exec.Executor
 		result3 error
 	}
+	ResolvePackagesStub        func(*options.Options, *types.ImageConfiguration) ([]apk.PlannedPackage, error)
+	resolvePackagesMutex       sync.RWMutex
+	resolvePackagesArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}
+	resolvePackagesReturns struct {
+		result1 []apk.PlannedPackage
+		result2 error
+	}
+	resolvePackagesReturnsOnCall map[int]struct {
+		result1 []apk.PlannedPackage
+		result2 error
+	}
+	RunPostInstallHooksStub        func(*options.Options, *types.ImageConfiguration, *exec.Executor) error
+	runPostInstallHooksMutex       sync.RWMutex
+	runPostInstallHooksArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
+	}
+	runPostInstallHooksReturns struct {
+		result1 error
+	}
+	runPostInstallHooksReturnsOnCall map[int]struct {
+		result1 error
+	}
+	RunPreInstallHooksStub        func(*options.Options, *types.ImageConfiguration, *exec.Executor) error
+	runPreInstallHooksMutex       sync.RWMutex
+	runPreInstallHooksArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
+	}
+	runPreInstallHooksReturns struct {
+		result1 error
+	}
+	runPreInstallHooksReturnsOnCall map[int]struct {
+		result1 error
+	}
+	StripContentsStub        func(*options.Options, *types.ImageConfiguration) error
+	stripContentsMutex       sync.RWMutex
+	stripContentsArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}
+	stripContentsReturns struct {
+		result1 error
+	}
+	stripContentsReturnsOnCall map[int]struct {
+		result1 error
+	}
 	ValidateImageConfigurationStub        func(*types.ImageConfiguration) error
 	validateImageConfigurationMutex       sync.RWMutex
 	validateImageConfigurationArgsForCall []struct {
@@ -179,6 +256,53 @@ type FakeBuildImplementation struct {
 	validateImageConfigurationReturnsOnCall map[int]struct {
 		result1 error
 	}
+	ValidatePackageOriginsStub        func(*options.Options) error
+	validatePackageOriginsMutex       sync.RWMutex
+	validatePackageOriginsArgsForCall []struct {
+		arg1 *options.Options
+	}
+	validatePackageOriginsReturns struct {
+		result1 error
+	}
+	validatePackageOriginsReturnsOnCall map[int]struct {
+		result1 error
+	}
+	WriteEntrypointDispatcherStub        func(*options.Options, *types.ImageConfiguration) error
+	writeEntrypointDispatcherMutex       sync.RWMutex
+	writeEntrypointDispatcherArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}
+	writeEntrypointDispatcherReturns struct {
+		result1 error
+	}
+	writeEntrypointDispatcherReturnsOnCall map[int]struct {
+		result1 error
+	}
+	WriteHostsStub        func(*options.Options, *types.ImageConfiguration) error
+	writeHostsMutex       sync.RWMutex
+	writeHostsArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}
+	writeHostsReturns struct {
+		result1 error
+	}
+	writeHostsReturnsOnCall map[int]struct {
+		result1 error
+	}
+	WriteResolvConfStub        func(*options.Options, *types.ImageConfiguration) error
+	writeResolvConfMutex       sync.RWMutex
+	writeResolvConfArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}
+	writeResolvConfReturns struct {
+		result1 error
+	}
+	writeResolvConfReturnsOnCall map[int]struct {
+		result1 error
+	}
 	WriteSupervisionTreeStub        func(*s6.Context, *types.ImageConfiguration) error
 	writeSupervisionTreeMutex       sync.RWMutex
 	writeSupervisionTreeArgsForCall []struct {
@@ -191,6 +315,18 @@ type FakeBuildImplementation struct {
 	writeSupervisionTreeReturnsOnCall map[int]struct {
 		result1 error
 	}
+	WriteTimezoneStub        func(*options.Options, *types.ImageConfiguration) error
+	writeTimezoneMutex       sync.RWMutex
+	writeTimezoneArgsForCall []struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}
+	writeTimezoneReturns struct {
+		result1 error
+	}
+	writeTimezoneReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -636,6 +772,68 @@ func (fake *FakeBuildImplementation) InitializeApkReturnsOnCall(i int, result1 e
 	}{result1}
 }
 
+func (fake *FakeBuildImplementation) InitializeBaseImage(arg1 *options.Options, arg2 *types.ImageConfiguration) error {
+	fake.initializeBaseImageMutex.Lock()
+	ret, specificReturn := fake.initializeBaseImageReturnsOnCall[len(fake.initializeBaseImageArgsForCall)]
+	fake.initializeBaseImageArgsForCall = append(fake.initializeBaseImageArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}{arg1, arg2})
+	stub := fake.InitializeBaseImageStub
+	fakeReturns := fake.initializeBaseImageReturns
+	fake.recordInvocation("InitializeBaseImage", []interface{}{arg1, arg2})
+	fake.initializeBaseImageMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) InitializeBaseImageCallCount() int {
+	fake.initializeBaseImageMutex.RLock()
+	defer fake.initializeBaseImageMutex.RUnlock()
+	return len(fake.initializeBaseImageArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) InitializeBaseImageCalls(stub func(*options.Options, *types.ImageConfiguration) error) {
+	fake.initializeBaseImageMutex.Lock()
+	defer fake.initializeBaseImageMutex.Unlock()
+	fake.InitializeBaseImageStub = stub
+}
+
+func (fake *FakeBuildImplementation) InitializeBaseImageArgsForCall(i int) (*options.Options, *types.ImageConfiguration) {
+	fake.initializeBaseImageMutex.RLock()
+	defer fake.initializeBaseImageMutex.RUnlock()
+	argsForCall := fake.initializeBaseImageArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBuildImplementation) InitializeBaseImageReturns(result1 error) {
+	fake.initializeBaseImageMutex.Lock()
+	defer fake.initializeBaseImageMutex.Unlock()
+	fake.InitializeBaseImageStub = nil
+	fake.initializeBaseImageReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) InitializeBaseImageReturnsOnCall(i int, result1 error) {
+	fake.initializeBaseImageMutex.Lock()
+	defer fake.initializeBaseImageMutex.Unlock()
+	fake.InitializeBaseImageStub = nil
+	if fake.initializeBaseImageReturnsOnCall == nil {
+		fake.initializeBaseImageReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.initializeBaseImageReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeBuildImplementation) InstallBusyboxSymlinks(arg1 *options.Options, arg2 *exec.Executor) error {
 	fake.installBusyboxSymlinksMutex.Lock()
 	ret, specificReturn := fake.installBusyboxSymlinksReturnsOnCall[len(fake.installBusyboxSymlinksArgsForCall)]
@@ -698,6 +896,68 @@ func (fake *FakeBuildImplementation) InstallBusyboxSymlinksReturnsOnCall(i int,
 	}{result1}
 }
 
+func (fake *FakeBuildImplementation) InstallFiles(arg1 *options.Options, arg2 *types.ImageConfiguration) error {
+	fake.installFilesMutex.Lock()
+	ret, specificReturn := fake.installFilesReturnsOnCall[len(fake.installFilesArgsForCall)]
+	fake.installFilesArgsForCall = append(fake.installFilesArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}{arg1, arg2})
+	stub := fake.InstallFilesStub
+	fakeReturns := fake.installFilesReturns
+	fake.recordInvocation("InstallFiles", []interface{}{arg1, arg2})
+	fake.installFilesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) InstallFilesCallCount() int {
+	fake.installFilesMutex.RLock()
+	defer fake.installFilesMutex.RUnlock()
+	return len(fake.installFilesArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) InstallFilesCalls(stub func(*options.Options, *types.ImageConfiguration) error) {
+	fake.installFilesMutex.Lock()
+	defer fake.installFilesMutex.Unlock()
+	fake.InstallFilesStub = stub
+}
+
+func (fake *FakeBuildImplementation) InstallFilesArgsForCall(i int) (*options.Options, *types.ImageConfiguration) {
+	fake.installFilesMutex.RLock()
+	defer fake.installFilesMutex.RUnlock()
+	argsForCall := fake.installFilesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBuildImplementation) InstallFilesReturns(result1 error) {
+	fake.installFilesMutex.Lock()
+	defer fake.installFilesMutex.Unlock()
+	fake.InstallFilesStub = nil
+	fake.installFilesReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) InstallFilesReturnsOnCall(i int, result1 error) {
+	fake.installFilesMutex.Lock()
+	defer fake.installFilesMutex.Unlock()
+	fake.InstallFilesStub = nil
+	if fake.installFilesReturnsOnCall == nil {
+		fake.installFilesReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.installFilesReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeBuildImplementation) MutateAccounts(arg1 *options.Options, arg2 *types.ImageConfiguration) error {
 	fake.mutateAccountsMutex.Lock()
 	ret, specificReturn := fake.mutateAccountsReturnsOnCall[len(fake.mutateAccountsArgsForCall)]
@@ -889,6 +1149,259 @@ func (fake *FakeBuildImplementation) RefreshReturnsOnCall(i int, result1 *s6.Con
 	}{result1, result2, result3}
 }
 
+func (fake *FakeBuildImplementation) ResolvePackages(arg1 *options.Options, arg2 *types.ImageConfiguration) ([]apk.PlannedPackage, error) {
+	fake.resolvePackagesMutex.Lock()
+	ret, specificReturn := fake.resolvePackagesReturnsOnCall[len(fake.resolvePackagesArgsForCall)]
+	fake.resolvePackagesArgsForCall = append(fake.resolvePackagesArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}{arg1, arg2})
+	stub := fake.ResolvePackagesStub
+	fakeReturns := fake.resolvePackagesReturns
+	fake.recordInvocation("ResolvePackages", []interface{}{arg1, arg2})
+	fake.resolvePackagesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBuildImplementation) ResolvePackagesCallCount() int {
+	fake.resolvePackagesMutex.RLock()
+	defer fake.resolvePackagesMutex.RUnlock()
+	return len(fake.resolvePackagesArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) ResolvePackagesCalls(stub func(*options.Options, *types.ImageConfiguration) ([]apk.PlannedPackage, error)) {
+	fake.resolvePackagesMutex.Lock()
+	defer fake.resolvePackagesMutex.Unlock()
+	fake.ResolvePackagesStub = stub
+}
+
+func (fake *FakeBuildImplementation) ResolvePackagesArgsForCall(i int) (*options.Options, *types.ImageConfiguration) {
+	fake.resolvePackagesMutex.RLock()
+	defer fake.resolvePackagesMutex.RUnlock()
+	argsForCall := fake.resolvePackagesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBuildImplementation) ResolvePackagesReturns(result1 []apk.PlannedPackage, result2 error) {
+	fake.resolvePackagesMutex.Lock()
+	defer fake.resolvePackagesMutex.Unlock()
+	fake.ResolvePackagesStub = nil
+	fake.resolvePackagesReturns = struct {
+		result1 []apk.PlannedPackage
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBuildImplementation) ResolvePackagesReturnsOnCall(i int, result1 []apk.PlannedPackage, result2 error) {
+	fake.resolvePackagesMutex.Lock()
+	defer fake.resolvePackagesMutex.Unlock()
+	fake.ResolvePackagesStub = nil
+	if fake.resolvePackagesReturnsOnCall == nil {
+		fake.resolvePackagesReturnsOnCall = make(map[int]struct {
+			result1 []apk.PlannedPackage
+			result2 error
+		})
+	}
+	fake.resolvePackagesReturnsOnCall[i] = struct {
+		result1 []apk.PlannedPackage
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBuildImplementation) RunPostInstallHooks(arg1 *options.Options, arg2 *types.ImageConfiguration, arg3 *exec.Executor) error {
+	fake.runPostInstallHooksMutex.Lock()
+	ret, specificReturn := fake.runPostInstallHooksReturnsOnCall[len(fake.runPostInstallHooksArgsForCall)]
+	fake.runPostInstallHooksArgsForCall = append(fake.runPostInstallHooksArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
+	}{arg1, arg2, arg3})
+	stub := fake.RunPostInstallHooksStub
+	fakeReturns := fake.runPostInstallHooksReturns
+	fake.recordInvocation("RunPostInstallHooks", []interface{}{arg1, arg2, arg3})
+	fake.runPostInstallHooksMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) RunPostInstallHooksCallCount() int {
+	fake.runPostInstallHooksMutex.RLock()
+	defer fake.runPostInstallHooksMutex.RUnlock()
+	return len(fake.runPostInstallHooksArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) RunPostInstallHooksCalls(stub func(*options.Options, *types.ImageConfiguration, *exec.Executor) error) {
+	fake.runPostInstallHooksMutex.Lock()
+	defer fake.runPostInstallHooksMutex.Unlock()
+	fake.RunPostInstallHooksStub = stub
+}
+
+func (fake *FakeBuildImplementation) RunPostInstallHooksArgsForCall(i int) (*options.Options, *types.ImageConfiguration, *exec.Executor) {
+	fake.runPostInstallHooksMutex.RLock()
+	defer fake.runPostInstallHooksMutex.RUnlock()
+	argsForCall := fake.runPostInstallHooksArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeBuildImplementation) RunPostInstallHooksReturns(result1 error) {
+	fake.runPostInstallHooksMutex.Lock()
+	defer fake.runPostInstallHooksMutex.Unlock()
+	fake.RunPostInstallHooksStub = nil
+	fake.runPostInstallHooksReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) RunPostInstallHooksReturnsOnCall(i int, result1 error) {
+	fake.runPostInstallHooksMutex.Lock()
+	defer fake.runPostInstallHooksMutex.Unlock()
+	fake.RunPostInstallHooksStub = nil
+	if fake.runPostInstallHooksReturnsOnCall == nil {
+		fake.runPostInstallHooksReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.runPostInstallHooksReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) RunPreInstallHooks(arg1 *options.Options, arg2 *types.ImageConfiguration, arg3 *exec.Executor) error {
+	fake.runPreInstallHooksMutex.Lock()
+	ret, specificReturn := fake.runPreInstallHooksReturnsOnCall[len(fake.runPreInstallHooksArgsForCall)]
+	fake.runPreInstallHooksArgsForCall = append(fake.runPreInstallHooksArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+		arg3 *exec.Executor
+	}{arg1, arg2, arg3})
+	stub := fake.RunPreInstallHooksStub
+	fakeReturns := fake.runPreInstallHooksReturns
+	fake.recordInvocation("RunPreInstallHooks", []interface{}{arg1, arg2, arg3})
+	fake.runPreInstallHooksMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) RunPreInstallHooksCallCount() int {
+	fake.runPreInstallHooksMutex.RLock()
+	defer fake.runPreInstallHooksMutex.RUnlock()
+	return len(fake.runPreInstallHooksArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) RunPreInstallHooksCalls(stub func(*options.Options, *types.ImageConfiguration, *exec.Executor) error) {
+	fake.runPreInstallHooksMutex.Lock()
+	defer fake.runPreInstallHooksMutex.Unlock()
+	fake.RunPreInstallHooksStub = stub
+}
+
+func (fake *FakeBuildImplementation) RunPreInstallHooksArgsForCall(i int) (*options.Options, *types.ImageConfiguration, *exec.Executor) {
+	fake.runPreInstallHooksMutex.RLock()
+	defer fake.runPreInstallHooksMutex.RUnlock()
+	argsForCall := fake.runPreInstallHooksArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeBuildImplementation) RunPreInstallHooksReturns(result1 error) {
+	fake.runPreInstallHooksMutex.Lock()
+	defer fake.runPreInstallHooksMutex.Unlock()
+	fake.RunPreInstallHooksStub = nil
+	fake.runPreInstallHooksReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) RunPreInstallHooksReturnsOnCall(i int, result1 error) {
+	fake.runPreInstallHooksMutex.Lock()
+	defer fake.runPreInstallHooksMutex.Unlock()
+	fake.RunPreInstallHooksStub = nil
+	if fake.runPreInstallHooksReturnsOnCall == nil {
+		fake.runPreInstallHooksReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.runPreInstallHooksReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) StripContents(arg1 *options.Options, arg2 *types.ImageConfiguration) error {
+	fake.stripContentsMutex.Lock()
+	ret, specificReturn := fake.stripContentsReturnsOnCall[len(fake.stripContentsArgsForCall)]
+	fake.stripContentsArgsForCall = append(fake.stripContentsArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}{arg1, arg2})
+	stub := fake.StripContentsStub
+	fakeReturns := fake.stripContentsReturns
+	fake.recordInvocation("StripContents", []interface{}{arg1, arg2})
+	fake.stripContentsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) StripContentsCallCount() int {
+	fake.stripContentsMutex.RLock()
+	defer fake.stripContentsMutex.RUnlock()
+	return len(fake.stripContentsArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) StripContentsCalls(stub func(*options.Options, *types.ImageConfiguration) error) {
+	fake.stripContentsMutex.Lock()
+	defer fake.stripContentsMutex.Unlock()
+	fake.StripContentsStub = stub
+}
+
+func (fake *FakeBuildImplementation) StripContentsArgsForCall(i int) (*options.Options, *types.ImageConfiguration) {
+	fake.stripContentsMutex.RLock()
+	defer fake.stripContentsMutex.RUnlock()
+	argsForCall := fake.stripContentsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBuildImplementation) StripContentsReturns(result1 error) {
+	fake.stripContentsMutex.Lock()
+	defer fake.stripContentsMutex.Unlock()
+	fake.StripContentsStub = nil
+	fake.stripContentsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) StripContentsReturnsOnCall(i int, result1 error) {
+	fake.stripContentsMutex.Lock()
+	defer fake.stripContentsMutex.Unlock()
+	fake.StripContentsStub = nil
+	if fake.stripContentsReturnsOnCall == nil {
+		fake.stripContentsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.stripContentsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeBuildImplementation) ValidateImageConfiguration(arg1 *types.ImageConfiguration) error {
 	fake.validateImageConfigurationMutex.Lock()
 	ret, specificReturn := fake.validateImageConfigurationReturnsOnCall[len(fake.validateImageConfigurationArgsForCall)]
@@ -950,6 +1463,253 @@ func (fake *FakeBuildImplementation) ValidateImageConfigurationReturnsOnCall(i i
 	}{result1}
 }
 
+func (fake *FakeBuildImplementation) ValidatePackageOrigins(arg1 *options.Options) error {
+	fake.validatePackageOriginsMutex.Lock()
+	ret, specificReturn := fake.validatePackageOriginsReturnsOnCall[len(fake.validatePackageOriginsArgsForCall)]
+	fake.validatePackageOriginsArgsForCall = append(fake.validatePackageOriginsArgsForCall, struct {
+		arg1 *options.Options
+	}{arg1})
+	stub := fake.ValidatePackageOriginsStub
+	fakeReturns := fake.validatePackageOriginsReturns
+	fake.recordInvocation("ValidatePackageOrigins", []interface{}{arg1})
+	fake.validatePackageOriginsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) ValidatePackageOriginsCallCount() int {
+	fake.validatePackageOriginsMutex.RLock()
+	defer fake.validatePackageOriginsMutex.RUnlock()
+	return len(fake.validatePackageOriginsArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) ValidatePackageOriginsCalls(stub func(*options.Options) error) {
+	fake.validatePackageOriginsMutex.Lock()
+	defer fake.validatePackageOriginsMutex.Unlock()
+	fake.ValidatePackageOriginsStub = stub
+}
+
+func (fake *FakeBuildImplementation) ValidatePackageOriginsArgsForCall(i int) *options.Options {
+	fake.validatePackageOriginsMutex.RLock()
+	defer fake.validatePackageOriginsMutex.RUnlock()
+	argsForCall := fake.validatePackageOriginsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeBuildImplementation) ValidatePackageOriginsReturns(result1 error) {
+	fake.validatePackageOriginsMutex.Lock()
+	defer fake.validatePackageOriginsMutex.Unlock()
+	fake.ValidatePackageOriginsStub = nil
+	fake.validatePackageOriginsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) ValidatePackageOriginsReturnsOnCall(i int, result1 error) {
+	fake.validatePackageOriginsMutex.Lock()
+	defer fake.validatePackageOriginsMutex.Unlock()
+	fake.ValidatePackageOriginsStub = nil
+	if fake.validatePackageOriginsReturnsOnCall == nil {
+		fake.validatePackageOriginsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.validatePackageOriginsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) WriteEntrypointDispatcher(arg1 *options.Options, arg2 *types.ImageConfiguration) error {
+	fake.writeEntrypointDispatcherMutex.Lock()
+	ret, specificReturn := fake.writeEntrypointDispatcherReturnsOnCall[len(fake.writeEntrypointDispatcherArgsForCall)]
+	fake.writeEntrypointDispatcherArgsForCall = append(fake.writeEntrypointDispatcherArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}{arg1, arg2})
+	stub := fake.WriteEntrypointDispatcherStub
+	fakeReturns := fake.writeEntrypointDispatcherReturns
+	fake.recordInvocation("WriteEntrypointDispatcher", []interface{}{arg1, arg2})
+	fake.writeEntrypointDispatcherMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) WriteEntrypointDispatcherCallCount() int {
+	fake.writeEntrypointDispatcherMutex.RLock()
+	defer fake.writeEntrypointDispatcherMutex.RUnlock()
+	return len(fake.writeEntrypointDispatcherArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) WriteEntrypointDispatcherCalls(stub func(*options.Options, *types.ImageConfiguration) error) {
+	fake.writeEntrypointDispatcherMutex.Lock()
+	defer fake.writeEntrypointDispatcherMutex.Unlock()
+	fake.WriteEntrypointDispatcherStub = stub
+}
+
+func (fake *FakeBuildImplementation) WriteEntrypointDispatcherArgsForCall(i int) (*options.Options, *types.ImageConfiguration) {
+	fake.writeEntrypointDispatcherMutex.RLock()
+	defer fake.writeEntrypointDispatcherMutex.RUnlock()
+	argsForCall := fake.writeEntrypointDispatcherArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBuildImplementation) WriteEntrypointDispatcherReturns(result1 error) {
+	fake.writeEntrypointDispatcherMutex.Lock()
+	defer fake.writeEntrypointDispatcherMutex.Unlock()
+	fake.WriteEntrypointDispatcherStub = nil
+	fake.writeEntrypointDispatcherReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) WriteEntrypointDispatcherReturnsOnCall(i int, result1 error) {
+	fake.writeEntrypointDispatcherMutex.Lock()
+	defer fake.writeEntrypointDispatcherMutex.Unlock()
+	fake.WriteEntrypointDispatcherStub = nil
+	if fake.writeEntrypointDispatcherReturnsOnCall == nil {
+		fake.writeEntrypointDispatcherReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.writeEntrypointDispatcherReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) WriteHosts(arg1 *options.Options, arg2 *types.ImageConfiguration) error {
+	fake.writeHostsMutex.Lock()
+	ret, specificReturn := fake.writeHostsReturnsOnCall[len(fake.writeHostsArgsForCall)]
+	fake.writeHostsArgsForCall = append(fake.writeHostsArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}{arg1, arg2})
+	stub := fake.WriteHostsStub
+	fakeReturns := fake.writeHostsReturns
+	fake.recordInvocation("WriteHosts", []interface{}{arg1, arg2})
+	fake.writeHostsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) WriteHostsCallCount() int {
+	fake.writeHostsMutex.RLock()
+	defer fake.writeHostsMutex.RUnlock()
+	return len(fake.writeHostsArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) WriteHostsCalls(stub func(*options.Options, *types.ImageConfiguration) error) {
+	fake.writeHostsMutex.Lock()
+	defer fake.writeHostsMutex.Unlock()
+	fake.WriteHostsStub = stub
+}
+
+func (fake *FakeBuildImplementation) WriteHostsArgsForCall(i int) (*options.Options, *types.ImageConfiguration) {
+	fake.writeHostsMutex.RLock()
+	defer fake.writeHostsMutex.RUnlock()
+	argsForCall := fake.writeHostsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBuildImplementation) WriteHostsReturns(result1 error) {
+	fake.writeHostsMutex.Lock()
+	defer fake.writeHostsMutex.Unlock()
+	fake.WriteHostsStub = nil
+	fake.writeHostsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) WriteHostsReturnsOnCall(i int, result1 error) {
+	fake.writeHostsMutex.Lock()
+	defer fake.writeHostsMutex.Unlock()
+	fake.WriteHostsStub = nil
+	if fake.writeHostsReturnsOnCall == nil {
+		fake.writeHostsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.writeHostsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) WriteResolvConf(arg1 *options.Options, arg2 *types.ImageConfiguration) error {
+	fake.writeResolvConfMutex.Lock()
+	ret, specificReturn := fake.writeResolvConfReturnsOnCall[len(fake.writeResolvConfArgsForCall)]
+	fake.writeResolvConfArgsForCall = append(fake.writeResolvConfArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}{arg1, arg2})
+	stub := fake.WriteResolvConfStub
+	fakeReturns := fake.writeResolvConfReturns
+	fake.recordInvocation("WriteResolvConf", []interface{}{arg1, arg2})
+	fake.writeResolvConfMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) WriteResolvConfCallCount() int {
+	fake.writeResolvConfMutex.RLock()
+	defer fake.writeResolvConfMutex.RUnlock()
+	return len(fake.writeResolvConfArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) WriteResolvConfCalls(stub func(*options.Options, *types.ImageConfiguration) error) {
+	fake.writeResolvConfMutex.Lock()
+	defer fake.writeResolvConfMutex.Unlock()
+	fake.WriteResolvConfStub = stub
+}
+
+func (fake *FakeBuildImplementation) WriteResolvConfArgsForCall(i int) (*options.Options, *types.ImageConfiguration) {
+	fake.writeResolvConfMutex.RLock()
+	defer fake.writeResolvConfMutex.RUnlock()
+	argsForCall := fake.writeResolvConfArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBuildImplementation) WriteResolvConfReturns(result1 error) {
+	fake.writeResolvConfMutex.Lock()
+	defer fake.writeResolvConfMutex.Unlock()
+	fake.WriteResolvConfStub = nil
+	fake.writeResolvConfReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) WriteResolvConfReturnsOnCall(i int, result1 error) {
+	fake.writeResolvConfMutex.Lock()
+	defer fake.writeResolvConfMutex.Unlock()
+	fake.WriteResolvConfStub = nil
+	if fake.writeResolvConfReturnsOnCall == nil {
+		fake.writeResolvConfReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.writeResolvConfReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeBuildImplementation) WriteSupervisionTree(arg1 *s6.Context, arg2 *types.ImageConfiguration) error {
 	fake.writeSupervisionTreeMutex.Lock()
 	ret, specificReturn := fake.writeSupervisionTreeReturnsOnCall[len(fake.writeSupervisionTreeArgsForCall)]
@@ -1012,6 +1772,68 @@ func (fake *FakeBuildImplementation) WriteSupervisionTreeReturnsOnCall(i int, re
 	}{result1}
 }
 
+func (fake *FakeBuildImplementation) WriteTimezone(arg1 *options.Options, arg2 *types.ImageConfiguration) error {
+	fake.writeTimezoneMutex.Lock()
+	ret, specificReturn := fake.writeTimezoneReturnsOnCall[len(fake.writeTimezoneArgsForCall)]
+	fake.writeTimezoneArgsForCall = append(fake.writeTimezoneArgsForCall, struct {
+		arg1 *options.Options
+		arg2 *types.ImageConfiguration
+	}{arg1, arg2})
+	stub := fake.WriteTimezoneStub
+	fakeReturns := fake.writeTimezoneReturns
+	fake.recordInvocation("WriteTimezone", []interface{}{arg1, arg2})
+	fake.writeTimezoneMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBuildImplementation) WriteTimezoneCallCount() int {
+	fake.writeTimezoneMutex.RLock()
+	defer fake.writeTimezoneMutex.RUnlock()
+	return len(fake.writeTimezoneArgsForCall)
+}
+
+func (fake *FakeBuildImplementation) WriteTimezoneCalls(stub func(*options.Options, *types.ImageConfiguration) error) {
+	fake.writeTimezoneMutex.Lock()
+	defer fake.writeTimezoneMutex.Unlock()
+	fake.WriteTimezoneStub = stub
+}
+
+func (fake *FakeBuildImplementation) WriteTimezoneArgsForCall(i int) (*options.Options, *types.ImageConfiguration) {
+	fake.writeTimezoneMutex.RLock()
+	defer fake.writeTimezoneMutex.RUnlock()
+	argsForCall := fake.writeTimezoneArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBuildImplementation) WriteTimezoneReturns(result1 error) {
+	fake.writeTimezoneMutex.Lock()
+	defer fake.writeTimezoneMutex.Unlock()
+	fake.WriteTimezoneStub = nil
+	fake.writeTimezoneReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBuildImplementation) WriteTimezoneReturnsOnCall(i int, result1 error) {
+	fake.writeTimezoneMutex.Lock()
+	defer fake.writeTimezoneMutex.Unlock()
+	fake.WriteTimezoneStub = nil
+	if fake.writeTimezoneReturnsOnCall == nil {
+		fake.writeTimezoneReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.writeTimezoneReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeBuildImplementation) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -1029,18 +1851,40 @@ func (fake *FakeBuildImplementation) Invocations() map[string][][]interface{} {
 	defer fake.generateSBOMMutex.RUnlock()
 	fake.initializeApkMutex.RLock()
 	defer fake.initializeApkMutex.RUnlock()
+	fake.initializeBaseImageMutex.RLock()
+	defer fake.initializeBaseImageMutex.RUnlock()
 	fake.installBusyboxSymlinksMutex.RLock()
 	defer fake.installBusyboxSymlinksMutex.RUnlock()
+	fake.installFilesMutex.RLock()
+	defer fake.installFilesMutex.RUnlock()
 	fake.mutateAccountsMutex.RLock()
 	defer fake.mutateAccountsMutex.RUnlock()
 	fake.mutatePathsMutex.RLock()
 	defer fake.mutatePathsMutex.RUnlock()
 	fake.refreshMutex.RLock()
 	defer fake.refreshMutex.RUnlock()
+	fake.resolvePackagesMutex.RLock()
+	defer fake.resolvePackagesMutex.RUnlock()
+	fake.runPostInstallHooksMutex.RLock()
+	defer fake.runPostInstallHooksMutex.RUnlock()
+	fake.runPreInstallHooksMutex.RLock()
+	defer fake.runPreInstallHooksMutex.RUnlock()
+	fake.stripContentsMutex.RLock()
+	defer fake.stripContentsMutex.RUnlock()
 	fake.validateImageConfigurationMutex.RLock()
 	defer fake.validateImageConfigurationMutex.RUnlock()
+	fake.validatePackageOriginsMutex.RLock()
+	defer fake.validatePackageOriginsMutex.RUnlock()
+	fake.writeEntrypointDispatcherMutex.RLock()
+	defer fake.writeEntrypointDispatcherMutex.RUnlock()
+	fake.writeHostsMutex.RLock()
+	defer fake.writeHostsMutex.RUnlock()
+	fake.writeResolvConfMutex.RLock()
+	defer fake.writeResolvConfMutex.RUnlock()
 	fake.writeSupervisionTreeMutex.RLock()
 	defer fake.writeSupervisionTreeMutex.RUnlock()
+	fake.writeTimezoneMutex.RLock()
+	defer fake.writeTimezoneMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
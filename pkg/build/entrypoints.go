@@ -0,0 +1,220 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/shlex"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// entrypointDispatcher renders a shell script which execs the named
+// entrypoint selected with APP_ENTRYPOINT, passing on its arguments.
+func entrypointDispatcher(ic *types.ImageConfiguration) string {
+	names := make([]string, 0, len(ic.Entrypoints))
+	for name := range ic.Entrypoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Generated by apko: runs the entrypoint selected with APP_ENTRYPOINT.\n")
+	fmt.Fprintf(&b, "case \"${APP_ENTRYPOINT:-%s}\" in\n", ic.DefaultEntrypoint)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s) exec %s \"$@\" ;;\n", name, ic.Entrypoints[name])
+	}
+	b.WriteString("*) echo \"unknown entrypoint: $APP_ENTRYPOINT\" >&2; exit 1 ;;\n")
+	b.WriteString("esac\n")
+
+	return b.String()
+}
+
+// WriteEntrypointDispatcher writes the script dispatching to the named
+// entrypoints, if any are configured.
+func (di *defaultBuildImplementation) WriteEntrypointDispatcher(o *options.Options, ic *types.ImageConfiguration) error {
+	if len(ic.Entrypoints) == 0 {
+		return nil
+	}
+
+	o.Logger().Infof("writing entrypoint dispatcher for %d entrypoints", len(ic.Entrypoints))
+
+	path := filepath.Join(o.WorkDir, types.EntrypointDispatcherPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating entrypoint dispatcher directory: %w", err)
+	}
+
+	// #nosec G306 -- the dispatcher must be executable by any user
+	if err := os.WriteFile(path, []byte(entrypointDispatcher(ic)), 0o755); err != nil {
+		return fmt.Errorf("writing entrypoint dispatcher: %w", err)
+	}
+
+	return nil
+}
+
+// The s6 binaries which supervise service bundles.
+var s6Binaries = []string{"/bin/s6-svscan", "/bin/s6-supervise"}
+
+// VerifyEntrypoint checks that the binaries the image starts with exist
+// in the working directory, so an image which cannot start fails to
+// build: the first token of the entrypoint, or of the cmd when there is
+// no entrypoint, when it is an absolute path, and for service bundles the
+// s6 binaries and the service commands. Images built on a base image are
+// not checked, as the binaries may be provided by its layers.
+func (bc *Context) VerifyEntrypoint() error {
+	ic := &bc.ImageConfiguration
+	if ic.Contents.BaseImage != "" {
+		return nil
+	}
+
+	// the umask wrapper is checked on its own, so that the binary it runs
+	// is checked too
+	unwrapped := *ic
+	unwrapped.Entrypoint.Umask = ""
+	cfg, err := unwrapped.ToOCIConfig(bc.Options.Arch.ToAPK())
+	if err != nil {
+		return fmt.Errorf("resolving entrypoint: %w", err)
+	}
+
+	binaries := []string{}
+	switch {
+	case len(cfg.Entrypoint) > 0:
+		binaries = append(binaries, cfg.Entrypoint[0])
+	case len(cfg.Cmd) > 0:
+		binaries = append(binaries, cfg.Cmd[0])
+	}
+
+	if ic.Entrypoint.Umask != "" && len(binaries) > 0 {
+		binaries = append(binaries, "/bin/sh")
+	}
+
+	managed := ic.Entrypoint.ManageServices == nil || *ic.Entrypoint.ManageServices
+	if ic.Entrypoint.Type == "service-bundle" && managed {
+		binaries = append(binaries, s6Binaries...)
+
+		for _, descriptor := range ic.Entrypoint.Services {
+			command, ok := descriptor.(string)
+			if !ok {
+				continue
+			}
+			// the commands are checked when the configuration is validated
+			if args, err := shlex.Split(command); err == nil && len(args) > 0 {
+				binaries = append(binaries, args[0])
+			}
+		}
+	}
+
+	for _, binary := range binaries {
+		if !path.IsAbs(binary) {
+			// looked up in PATH by the runtime
+			continue
+		}
+
+		if err := checkExecutable(bc.Options.WorkDir, binary); err != nil {
+			return fmt.Errorf("entrypoint binary %s: %w", binary, err)
+		}
+	}
+
+	return nil
+}
+
+// checkExecutable checks that the path p of the image filesystem in root
+// resolves to an executable regular file.
+func checkExecutable(root, p string) error {
+	resolved, err := resolveInRoot(root, p)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(filepath.Join(root, resolved))
+	if err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", resolved)
+	}
+
+	if fi.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", resolved)
+	}
+
+	return nil
+}
+
+// The number of symlinks followed when resolving a path, as in Linux.
+const maxSymlinks = 40
+
+// resolveInRoot resolves the symlinks in the path p of the image
+// filesystem in root, with absolute symlinks relative to root, returning
+// the resolved path in the image.
+func resolveInRoot(root, p string) (string, error) {
+	resolved := "/"
+	rest := strings.Split(p, "/")
+	links := 0
+
+	for len(rest) > 0 {
+		c := rest[0]
+		rest = rest[1:]
+
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, c)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", fmt.Errorf("%s does not exist in the image", next)
+			}
+			return "", err
+		}
+
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links resolving %s", p)
+		}
+
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+
+	return resolved, nil
+}
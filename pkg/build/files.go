@@ -0,0 +1,124 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// securePath returns the path of name inside the filesystem at root, with
+// the symlinks of its parent directories resolved within root, so that
+// writing to it never escapes root. The last element is not resolved, so
+// that a symlink there can be replaced rather than followed.
+func securePath(root, name string) (string, error) {
+	name = filepath.Clean(string(filepath.Separator) + name)
+
+	dir, err := securejoin.SecureJoin(root, filepath.Dir(name))
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", name, err)
+	}
+
+	return filepath.Join(dir, filepath.Base(name)), nil
+}
+
+// removeSymlink removes the file at p when it is a symlink, so that it is
+// replaced rather than written through.
+func removeSymlink(p string) error {
+	fi, err := os.Lstat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&fs.ModeSymlink == 0 {
+		return nil
+	}
+	return os.Remove(p)
+}
+
+func installFile(o *options.Options, f types.File) error {
+	target, err := securePath(o.WorkDir, f.Destination)
+	if err != nil {
+		return err
+	}
+
+	perms := fs.FileMode(f.Permissions)
+	if perms == 0 {
+		perms = 0o644
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if err := removeSymlink(target); err != nil {
+		return err
+	}
+
+	src, err := os.Open(f.Source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, perms)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+
+	if err := dst.Chmod(perms); err != nil {
+		return err
+	}
+
+	if err := dst.Chown(int(f.UID), int(f.GID)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InstallFiles copies the files listed in the image configuration
+// into the working directory.
+func (di *defaultBuildImplementation) InstallFiles(
+	o *options.Options, ic *types.ImageConfiguration,
+) error {
+	for _, f := range ic.Contents.Files {
+		o.Logger().Printf("installing file %s to %s", f.Source, f.Destination)
+
+		if err := installFile(o, f); err != nil {
+			return fmt.Errorf("installing %s: %w", f.Destination, err)
+		}
+	}
+
+	return nil
+}
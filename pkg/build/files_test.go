@@ -0,0 +1,72 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestInstallFile(t *testing.T) {
+	wd := t.TempDir()
+	o := &options.Options{WorkDir: wd}
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+
+	src := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(src, []byte("listen: 8080\n"), 0o600))
+
+	// parent directories are created, and the default mode is 0644
+	require.NoError(t, installFile(o, types.File{Source: src, Destination: "/etc/myapp/config.yaml", UID: uid, GID: gid}))
+	data, err := os.ReadFile(filepath.Join(wd, "etc", "myapp", "config.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "listen: 8080\n", string(data))
+	fi, err := os.Stat(filepath.Join(wd, "etc", "myapp", "config.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o644), fi.Mode().Perm())
+
+	require.NoError(t, installFile(o, types.File{Source: src, Destination: "/etc/myapp/secret", UID: uid, GID: gid, Permissions: 0o400}))
+	fi, err = os.Stat(filepath.Join(wd, "etc", "myapp", "secret"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o400), fi.Mode().Perm())
+
+	// a destination climbing out of the root stays inside it
+	require.NoError(t, installFile(o, types.File{Source: src, Destination: "/../../escaped", UID: uid, GID: gid}))
+	require.FileExists(t, filepath.Join(wd, "escaped"))
+
+	// symlinks installed by packages are resolved inside the root...
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(wd, "opt")))
+	require.NoError(t, installFile(o, types.File{Source: src, Destination: "/opt/app.yaml", UID: uid, GID: gid}))
+	require.NoFileExists(t, filepath.Join(outside, "app.yaml"))
+	require.FileExists(t, filepath.Join(wd, outside, "app.yaml"))
+
+	// ... and replaced rather than written through
+	host := filepath.Join(outside, "host.conf")
+	require.NoError(t, os.WriteFile(host, []byte("host"), 0o644))
+	require.NoError(t, os.Symlink(host, filepath.Join(wd, "etc", "app.conf")))
+	require.NoError(t, installFile(o, types.File{Source: src, Destination: "/etc/app.conf", UID: uid, GID: gid}))
+	data, err = os.ReadFile(host)
+	require.NoError(t, err)
+	require.Equal(t, "host", string(data))
+	fi, err = os.Lstat(filepath.Join(wd, "etc", "app.conf"))
+	require.NoError(t, err)
+	require.True(t, fi.Mode().IsRegular())
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/exec"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func runHooks(o *options.Options, e *exec.Executor, phase string, hooks []string) error {
+	for _, hook := range hooks {
+		o.Logger().Infof("running %s hook %s", phase, hook)
+
+		if err := e.ExecuteInWorkDir(hook); err != nil {
+			return fmt.Errorf("%s hook %s failed: %w", phase, hook, err)
+		}
+	}
+
+	return nil
+}
+
+// RunPreInstallHooks runs the configured pre-install scripts, before
+// any packages are installed into the working directory.
+func (di *defaultBuildImplementation) RunPreInstallHooks(
+	o *options.Options, ic *types.ImageConfiguration, e *exec.Executor,
+) error {
+	return runHooks(o, e, "pre-install", ic.Contents.PreInstall)
+}
+
+// RunPostInstallHooks runs the configured post-install scripts, once
+// the image filesystem has been assembled and before the layer tarball
+// is created.
+func (di *defaultBuildImplementation) RunPostInstallHooks(
+	o *options.Options, ic *types.ImageConfiguration, e *exec.Executor,
+) error {
+	return runHooks(o, e, "post-install", ic.Contents.PostInstall)
+}
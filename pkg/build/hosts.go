@@ -0,0 +1,75 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// WriteHosts adds the configured entries, if any, to /etc/hosts. The
+// entries installed by packages, e.g. localhost, are kept.
+func (di *defaultBuildImplementation) WriteHosts(o *options.Options, ic *types.ImageConfiguration) error {
+	if len(ic.Contents.Hosts) == 0 {
+		return nil
+	}
+
+	o.Logger().Infof("writing /etc/hosts")
+
+	etc := filepath.Join(o.WorkDir, "etc")
+	if err := os.MkdirAll(etc, 0o755); err != nil {
+		return fmt.Errorf("creating /etc: %w", err)
+	}
+
+	path := filepath.Join(etc, "hosts")
+
+	var sb strings.Builder
+	fi, err := os.Lstat(path)
+	switch {
+	case err == nil && fi.Mode().IsRegular():
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading /etc/hosts: %w", err)
+		}
+		sb.Write(data)
+		if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+			sb.WriteString("\n")
+		}
+	case err == nil:
+		// a symlink is replaced rather than written through
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("removing /etc/hosts: %w", err)
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("reading /etc/hosts: %w", err)
+	}
+
+	for _, entry := range ic.Contents.Hosts {
+		sb.WriteString(strings.Join(strings.Fields(entry), " "))
+		sb.WriteString("\n")
+	}
+
+	// #nosec G306 -- /etc/hosts must be readable by any user
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		return fmt.Errorf("writing /etc/hosts: %w", err)
+	}
+
+	return nil
+}
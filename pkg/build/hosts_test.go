@@ -0,0 +1,50 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestWriteHosts(t *testing.T) {
+	wd := t.TempDir()
+	di := defaultBuildImplementation{}
+	o := &options.Options{Log: &logrus.Logger{}, WorkDir: wd}
+	path := filepath.Join(wd, "etc", "hosts")
+
+	// Nothing is written without entries.
+	ic := &types.ImageConfiguration{}
+	require.NoError(t, di.WriteHosts(o, ic))
+	require.NoFileExists(t, path)
+
+	// The entries installed by packages are kept.
+	require.NoError(t, os.MkdirAll(filepath.Join(wd, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("127.0.0.1\tlocalhost"), 0o644))
+
+	ic.Contents.Hosts = []string{"10.0.0.1 db.internal db", "fd00::1   cache.internal"}
+	require.NoError(t, di.WriteHosts(o, ic))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1\tlocalhost\n10.0.0.1 db.internal db\nfd00::1 cache.internal\n", string(data))
+}
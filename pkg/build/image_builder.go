@@ -19,11 +19,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/exec"
 	"chainguard.dev/apko/pkg/options"
 	"chainguard.dev/apko/pkg/s6"
+	"chainguard.dev/apko/pkg/sbom"
 )
 
 func (di *defaultBuildImplementation) ValidateImageConfiguration(ic *types.ImageConfiguration) error {
@@ -33,6 +35,38 @@ func (di *defaultBuildImplementation) ValidateImageConfiguration(ic *types.Image
 	return nil
 }
 
+// ValidatePackageOrigins checks that every installed package comes from
+// one of the allowed origins. If no allowed origins are configured, all
+// origins are permitted.
+func (di *defaultBuildImplementation) ValidatePackageOrigins(o *options.Options) error {
+	if len(o.AllowedOrigins) == 0 {
+		return nil
+	}
+
+	allowed := map[string]struct{}{}
+	for _, origin := range o.AllowedOrigins {
+		allowed[origin] = struct{}{}
+	}
+
+	s := sbom.NewWithWorkDir(o.WorkDir, o.Arch)
+	if err := s.ReadPackageIndex(); err != nil {
+		return fmt.Errorf("reading installed packages: %w", err)
+	}
+
+	disallowed := []string{}
+	for _, pkg := range s.Options.Packages {
+		if _, ok := allowed[pkg.Origin]; !ok {
+			disallowed = append(disallowed, fmt.Sprintf("%s-%s (origin: %s)", pkg.Name, pkg.Version, pkg.Origin))
+		}
+	}
+
+	if len(disallowed) > 0 {
+		return fmt.Errorf("packages from disallowed origins installed: %s", strings.Join(disallowed, ", "))
+	}
+
+	return nil
+}
+
 func (di *defaultBuildImplementation) WriteSupervisionTree(
 	s6context *s6.Context, imageConfig *types.ImageConfiguration,
 ) error {
@@ -0,0 +1,48 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestValidatePackageOrigins(t *testing.T) {
+	wd := t.TempDir()
+	db := filepath.Join(wd, "lib", "apk", "db", "installed")
+	require.NoError(t, os.MkdirAll(filepath.Dir(db), 0o755))
+	require.NoError(t, os.WriteFile(db, []byte(
+		"P:musl\nV:1.2.3-r0\no:musl\n\nP:busybox-suid\nV:1.35-r0\no:busybox\n\nP:nginx\nV:1.22-r0\no:nginx\n\n",
+	), 0o644))
+
+	di := &defaultBuildImplementation{}
+	o := &options.Options{WorkDir: wd}
+
+	// every origin is allowed without an allowlist
+	require.NoError(t, di.ValidatePackageOrigins(o))
+
+	o.AllowedOrigins = []string{"musl", "busybox", "nginx"}
+	require.NoError(t, di.ValidatePackageOrigins(o))
+
+	// subpackages are checked against the origin they were built from
+	o.AllowedOrigins = []string{"musl", "busybox"}
+	require.EqualError(t, di.ValidatePackageOrigins(o),
+		"packages from disallowed origins installed: nginx-1.22-r0 (origin: nginx)")
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// installedDBPath is the path of the installed database in the image.
+const installedDBPath = "lib/apk/db/installed"
+
+// WriteInstalledDB copies the installed database of the image, which
+// lists the installed packages and their files, to the configured path,
+// e.g. for scanners which can't read the image itself.
+func (bc *Context) WriteInstalledDB() error {
+	data, err := os.ReadFile(filepath.Join(bc.Options.WorkDir, installedDBPath))
+	if err != nil {
+		return fmt.Errorf("reading installed database: %w", err)
+	}
+
+	// #nosec G306 -- the installed database is part of the image
+	if err := os.WriteFile(bc.Options.InstalledDBPath, data, 0o644); err != nil {
+		return fmt.Errorf("writing installed database: %w", err)
+	}
+
+	bc.Logger().Infof("wrote installed database to %s", bc.Options.InstalledDBPath)
+
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/sbom"
+)
+
+const intotoStatementType = "https://in-toto.io/Statement/v0.1"
+
+// The in-toto predicate types of the supported SBOM formats.
+var sbomPredicateTypes = map[string]string{
+	"spdx":      "https://spdx.dev/Document",
+	"cyclonedx": "https://cyclonedx.org/bom",
+	"idb":       "https://apko.dev/installed-db",
+}
+
+type intotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type sbomReference struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+type sbomStatement struct {
+	Type          string          `json:"_type"`
+	PredicateType string          `json:"predicateType"`
+	Subject       []intotoSubject `json:"subject"`
+	Predicate     struct {
+		SBOM sbomReference `json:"sbom"`
+	} `json:"predicate"`
+}
+
+// writeSBOMPredicates writes an in-toto statement next to each of the
+// generated SBOM documents (as <sbom>.intoto.json), binding the SBOM
+// content to the image digest. The statements reference the SBOM
+// documents, not their compressed copies or signatures.
+func writeSBOMPredicates(o *options.Options, imageName string, imageDigest v1.Hash, generated []sbom.File) error {
+	if imageName == "" {
+		imageName = "image"
+	}
+
+	for _, f := range generated {
+		if f.Kind != sbom.FileKindSBOM {
+			continue
+		}
+		path := f.Path
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading SBOM: %w", err)
+		}
+
+		st := sbomStatement{
+			Type:          intotoStatementType,
+			PredicateType: sbomPredicateTypes[f.Format],
+			Subject: []intotoSubject{{
+				Name:   imageName,
+				Digest: map[string]string{imageDigest.Algorithm: imageDigest.Hex},
+			}},
+		}
+		st.Predicate.SBOM = sbomReference{
+			URI:    filepath.Base(path),
+			Digest: map[string]string{"sha256": fmt.Sprintf("%x", sha256.Sum256(data))},
+		}
+
+		out, err := json.MarshalIndent(st, "", "  ")
+		if err != nil {
+			return fmt.Errorf("serializing in-toto statement: %w", err)
+		}
+
+		predicatePath := path + ".intoto.json"
+		// #nosec G306 -- the statement is as public as the SBOM
+		if err := os.WriteFile(predicatePath, out, 0o644); err != nil {
+			return fmt.Errorf("writing in-toto statement: %w", err)
+		}
+
+		o.Logger().Infof("wrote in-toto statement for %s to %s", filepath.Base(path), predicatePath)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/sbom"
+)
+
+func TestWriteSBOMPredicates(t *testing.T) {
+	dir := t.TempDir()
+	sbomPath := filepath.Join(dir, "sbom-x86_64.spdx.json")
+	data := []byte(`{"spdxVersion": "SPDX-2.2"}`)
+	require.NoError(t, os.WriteFile(sbomPath, data, 0o644))
+
+	digest := v1.Hash{Algorithm: "sha256", Hex: "abc123"}
+	o := &options.Options{Log: &logrus.Logger{}}
+	require.NoError(t, writeSBOMPredicates(o, "example.com/image", digest, []sbom.File{
+		{Format: "spdx", Path: sbomPath, Kind: sbom.FileKindSBOM},
+		{Format: "spdx", Path: sbomPath + ".gz", Kind: sbom.FileKindGzip},
+		{Format: "spdx", Path: sbomPath + ".sig", Kind: sbom.FileKindSignature},
+	}))
+
+	out, err := os.ReadFile(sbomPath + ".intoto.json")
+	require.NoError(t, err)
+
+	st := sbomStatement{}
+	require.NoError(t, json.Unmarshal(out, &st))
+	require.Equal(t, intotoStatementType, st.Type)
+	require.Equal(t, "https://spdx.dev/Document", st.PredicateType)
+	require.Equal(t, []intotoSubject{{
+		Name:   "example.com/image",
+		Digest: map[string]string{"sha256": "abc123"},
+	}}, st.Subject)
+	require.Equal(t, "sbom-x86_64.spdx.json", st.Predicate.SBOM.URI)
+	require.Equal(t, fmt.Sprintf("%x", sha256.Sum256(data)), st.Predicate.SBOM.Digest["sha256"])
+
+	// Only the SBOM documents get a statement.
+	require.NoFileExists(t, sbomPath+".gz.intoto.json")
+	require.NoFileExists(t, sbomPath+".sig.intoto.json")
+}
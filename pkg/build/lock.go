@@ -0,0 +1,199 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"chainguard.dev/apko/pkg/sbom"
+)
+
+// lockEntry is a package pinned by a lockfile. Arch and Checksum are
+// only compared when the lockfile records them.
+type lockEntry struct {
+	Version  string
+	Arch     string
+	Checksum string
+}
+
+// String returns the entry in the format of a lockfile line, without
+// the package name.
+func (e lockEntry) String() string {
+	s := e.Version
+	if e.Arch != "" {
+		s += " arch=" + e.Arch
+	}
+	if e.Checksum != "" {
+		s += " checksum=" + e.Checksum
+	}
+	return s
+}
+
+// installedLock maps the names of the packages installed in the image to
+// their lockfile entries. Checksums are written like apk does, as Q1
+// followed by the base64 encoded SHA-1 of the package control section.
+func (bc *Context) installedLock() (map[string]lockEntry, error) {
+	s := sbom.NewWithWorkDir(bc.Options.WorkDir, bc.Options.Arch)
+	if err := s.ReadPackageIndex(); err != nil {
+		return nil, fmt.Errorf("reading installed packages: %w", err)
+	}
+
+	entries := map[string]lockEntry{}
+	for _, pkg := range s.Options.Packages {
+		entry := lockEntry{Version: pkg.Version, Arch: pkg.Arch}
+		if len(pkg.Checksum) != 0 {
+			entry.Checksum = "Q1" + base64.StdEncoding.EncodeToString(pkg.Checksum)
+		}
+		entries[pkg.Name] = entry
+	}
+
+	return entries, nil
+}
+
+// Lock returns the lockfile of the packages installed in the image, a
+// line per package sorted by name, e.g.
+//
+//	musl=1.2.3-r0 arch=x86_64 checksum=Q1...=
+//
+// The same packages always give the same lockfile, so that its changes
+// can be reviewed as a diff.
+func (bc *Context) Lock() ([]byte, error) {
+	entries, err := bc.installedLock()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%s\n", name, entries[name])
+	}
+
+	return []byte(b.String()), nil
+}
+
+// WriteLock writes the lockfile of the packages installed in the image
+// to lockPath, to be checked by later builds with CheckLock.
+func (bc *Context) WriteLock(lockPath string) error {
+	data, err := bc.Lock()
+	if err != nil {
+		return err
+	}
+
+	// #nosec G306 -- the lockfile is not sensitive
+	if err := os.WriteFile(lockPath, data, 0o644); err != nil {
+		return fmt.Errorf("writing lockfile: %w", err)
+	}
+
+	bc.Logger().Infof("wrote lockfile to %s", lockPath)
+
+	return nil
+}
+
+// CheckLock compares the packages installed in the image against the
+// lockfile at lockPath, failing with the list of the packages which were
+// added, removed or installed at another version. The arch and checksum
+// of a package are checked too when the lockfile records them.
+func (bc *Context) CheckLock(lockPath string) error {
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return fmt.Errorf("reading lockfile: %w", err)
+	}
+
+	locked, err := parseLock(data)
+	if err != nil {
+		return fmt.Errorf("parsing lockfile %s: %w", lockPath, err)
+	}
+
+	installed, err := bc.installedLock()
+	if err != nil {
+		return err
+	}
+
+	drift := []string{}
+	for name, entry := range installed {
+		lockedEntry, ok := locked[name]
+		switch {
+		case !ok:
+			drift = append(drift, fmt.Sprintf("%s=%s added", name, entry.Version))
+		case lockedEntry.Version != entry.Version:
+			drift = append(drift, fmt.Sprintf("%s changed from %s to %s", name, lockedEntry.Version, entry.Version))
+		case lockedEntry.Arch != "" && lockedEntry.Arch != entry.Arch:
+			drift = append(drift, fmt.Sprintf("%s arch changed from %s to %s", name, lockedEntry.Arch, entry.Arch))
+		case lockedEntry.Checksum != "" && lockedEntry.Checksum != entry.Checksum:
+			drift = append(drift, fmt.Sprintf("%s checksum changed from %s to %s", name, lockedEntry.Checksum, entry.Checksum))
+		}
+	}
+	for name, entry := range locked {
+		if _, ok := installed[name]; !ok {
+			drift = append(drift, fmt.Sprintf("%s=%s removed", name, entry.Version))
+		}
+	}
+
+	if len(drift) > 0 {
+		sort.Strings(drift)
+		return fmt.Errorf("installed packages drifted from lockfile %s: %s", lockPath, strings.Join(drift, ", "))
+	}
+
+	return nil
+}
+
+// parseLock returns the entries of a lockfile, keyed by package name.
+// Each line is a name=version entry, optionally followed by arch= and
+// checksum= fields. Blank lines and lines starting with # are ignored.
+func parseLock(data []byte) (map[string]lockEntry, error) {
+	locked := map[string]lockEntry{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		name, version, ok := strings.Cut(fields[0], "=")
+		if !ok || name == "" || version == "" {
+			return nil, fmt.Errorf("line %d: %q is not a name=version entry", i+1, line)
+		}
+
+		entry := lockEntry{Version: version}
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "arch":
+				entry.Arch = value
+			case "checksum":
+				entry.Checksum = value
+			default:
+				return nil, fmt.Errorf("line %d: unknown field %q", i+1, field)
+			}
+		}
+
+		if _, ok := locked[name]; ok {
+			return nil, fmt.Errorf("line %d: package %s is listed more than once", i+1, name)
+		}
+		locked[name] = entry
+	}
+
+	return locked, nil
+}
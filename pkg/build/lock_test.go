@@ -0,0 +1,66 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestLock(t *testing.T) {
+	wd := t.TempDir()
+	bc := &Context{Options: options.Options{WorkDir: wd, Log: options.Default.Log}}
+
+	db := filepath.Join(wd, "lib", "apk", "db", "installed")
+	require.NoError(t, os.MkdirAll(filepath.Dir(db), 0o755))
+	require.NoError(t, os.WriteFile(db, []byte(
+		"P:musl\nV:1.2.3-r0\nA:x86_64\nC:Q1yB0ZJ2YjAI5gE3mMLTkHKJEOI3o=\n\n"+
+			"P:busybox\nV:1.35.0-r0\nA:x86_64\n\n"), 0o644))
+
+	data, err := bc.Lock()
+	require.NoError(t, err)
+	require.Equal(t, "busybox=1.35.0-r0 arch=x86_64\nmusl=1.2.3-r0 arch=x86_64 checksum=Q1yB0ZJ2YjAI5gE3mMLTkHKJEOI3o=\n", string(data))
+
+	lockPath := filepath.Join(t.TempDir(), "packages.lock")
+	require.NoError(t, bc.WriteLock(lockPath))
+	require.NoError(t, bc.CheckLock(lockPath))
+
+	// comments and blank lines are ignored, and arches and checksums
+	// are only checked when they are locked
+	require.NoError(t, os.WriteFile(lockPath, []byte("# base\nbusybox=1.35.0-r0\n\nmusl=1.2.3-r0\n"), 0o644))
+	require.NoError(t, bc.CheckLock(lockPath))
+
+	require.NoError(t, os.WriteFile(lockPath, []byte("busybox=1.35.0-r0 arch=aarch64\nmusl=1.2.3-r0 checksum=Q1AAAA\n"), 0o644))
+	require.EqualError(t, bc.CheckLock(lockPath), "installed packages drifted from lockfile "+lockPath+
+		": busybox arch changed from aarch64 to x86_64, musl checksum changed from Q1AAAA to Q1yB0ZJ2YjAI5gE3mMLTkHKJEOI3o=")
+
+	require.NoError(t, os.WriteFile(lockPath, []byte("busybox=1.34.0-r0\nzlib=1.2.13-r0\n"), 0o644))
+	require.EqualError(t, bc.CheckLock(lockPath), "installed packages drifted from lockfile "+lockPath+
+		": busybox changed from 1.34.0-r0 to 1.35.0-r0, musl=1.2.3-r0 added, zlib=1.2.13-r0 removed")
+
+	require.NoError(t, os.WriteFile(lockPath, []byte("busybox\n"), 0o644))
+	require.ErrorContains(t, bc.CheckLock(lockPath), `line 1: "busybox" is not a name=version entry`)
+
+	require.NoError(t, os.WriteFile(lockPath, []byte("busybox=1.35.0-r0 origin=busybox\n"), 0o644))
+	require.ErrorContains(t, bc.CheckLock(lockPath), `line 1: unknown field "origin=busybox"`)
+
+	require.NoError(t, os.WriteFile(lockPath, []byte("musl=1.2.3-r0\nmusl=1.2.4-r0\n"), 0o644))
+	require.ErrorContains(t, bc.CheckLock(lockPath), "line 2: package musl is listed more than once")
+}
@@ -19,9 +19,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/avast/retry-go"
@@ -33,11 +35,12 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/google"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	v1tar "github.com/google/go-containerregistry/pkg/v1/tarball"
 	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
-	"github.com/google/shlex"
 	"github.com/sigstore/cosign/pkg/oci"
 	ocimutate "github.com/sigstore/cosign/pkg/oci/mutate"
 	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
@@ -49,6 +52,7 @@ import (
 
 	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/tarball"
 )
 
 var keychain = authn.NewMultiKeychain(
@@ -63,7 +67,7 @@ func buildImageFromLayerWithMediaType(mediaType ggcrtypes.MediaType, layerTarGZ
 	imageType := humanReadableImageType(mediaType)
 	logger.Printf("building %s image from layer '%s'", imageType, layerTarGZ)
 
-	v1Layer, err := v1tar.LayerFromFile(layerTarGZ, v1tar.WithMediaType(mediaType))
+	v1Layer, err := tarball.LayerFromFile(layerTarGZ, mediaType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create %s layer from tar.gz: %w", imageType, err)
 	}
@@ -83,13 +87,8 @@ func buildImageFromLayerWithMediaType(mediaType ggcrtypes.MediaType, layerTarGZ
 
 	adds := make([]mutate.Addendum, 0, 1)
 	adds = append(adds, mutate.Addendum{
-		Layer: v1Layer,
-		History: v1.History{
-			Author:    "apko",
-			Comment:   "This is an apko single-layer image",
-			CreatedBy: "apko",
-			Created:   v1.Time{Time: created},
-		},
+		Layer:   v1Layer,
+		History: layerHistory(ic, arch, created),
 	})
 
 	emptyImage := empty.Image
@@ -103,8 +102,8 @@ func buildImageFromLayerWithMediaType(mediaType ggcrtypes.MediaType, layerTarGZ
 		return nil, fmt.Errorf("unable to append %s layer to empty image: %w", imageType, err)
 	}
 
-	if mediaType != ggcrtypes.DockerLayer && len(ic.Annotations) > 0 {
-		v1Image = mutate.Annotations(v1Image, ic.Annotations).(v1.Image)
+	if annotations := ic.ResolvedAnnotations(); mediaType != ggcrtypes.DockerLayer && len(annotations) > 0 {
+		v1Image = mutate.Annotations(v1Image, annotations).(v1.Image)
 	}
 
 	cfg, err := v1Image.ConfigFile()
@@ -114,57 +113,16 @@ func buildImageFromLayerWithMediaType(mediaType ggcrtypes.MediaType, layerTarGZ
 
 	cfg = cfg.DeepCopy()
 	cfg.Author = "github.com/chainguard-dev/apko"
+	if ic.History.Author != "" {
+		cfg.Author = ic.History.Author
+	}
 	cfg.Architecture = arch.String()
 	cfg.Created = v1.Time{Time: created}
-	cfg.Config.Labels = make(map[string]string)
 	cfg.OS = "linux"
 
-	// NOTE: Need to allow empty Entrypoints. The runtime will override to `/bin/sh -c` and handle quoting
-	switch {
-	case ic.Entrypoint.ShellFragment != "":
-		cfg.Config.Entrypoint = []string{"/bin/sh", "-c", ic.Entrypoint.ShellFragment}
-	case ic.Entrypoint.Command != "":
-		splitcmd, err := shlex.Split(ic.Entrypoint.Command)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse entrypoint command: %w", err)
-		}
-		cfg.Config.Entrypoint = splitcmd
-	}
-
-	if ic.Cmd != "" {
-		splitcmd, err := shlex.Split(ic.Cmd)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse cmd: %w", err)
-		}
-		cfg.Config.Cmd = splitcmd
-	}
-
-	if ic.WorkDir != "" {
-		cfg.Config.WorkingDir = ic.WorkDir
-	}
-
-	if ic.VCSUrl != "" {
-		cfg.Config.Labels["org.opencontainers.image.source"] = ic.VCSUrl
-	}
-
-	if len(ic.Environment) > 0 {
-		envs := []string{}
-
-		for k, v := range ic.Environment {
-			envs = append(envs, fmt.Sprintf("%s=%s", k, v))
-		}
-		sort.Strings(envs)
-
-		cfg.Config.Env = envs
-	} else {
-		cfg.Config.Env = []string{
-			"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
-			"SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt",
-		}
-	}
-
-	if ic.Accounts.RunAs != "" {
-		cfg.Config.User = ic.Accounts.RunAs
+	cfg.Config, err = ic.ToOCIConfig(arch.String())
+	if err != nil {
+		return nil, fmt.Errorf("unable to build %s config: %w", imageType, err)
 	}
 
 	v1Image, err = mutate.ConfigFile(v1Image, cfg)
@@ -182,6 +140,32 @@ func buildImageFromLayerWithMediaType(mediaType ggcrtypes.MediaType, layerTarGZ
 	return ent.(oci.SignedImage), nil
 }
 
+// layerHistory returns the history entry of the image layer, as set in the
+// image configuration. When unset, the entry is created by a summary of the
+// apko invocation listing the packages installed. The apko version is left
+// out, so that the image digest does not change with every apko release.
+func layerHistory(ic types.ImageConfiguration, arch types.Architecture, created time.Time) v1.History {
+	history := v1.History{
+		Author:    "apko",
+		Comment:   "This is an apko single-layer image",
+		CreatedBy: ic.History.CreatedBy,
+		Created:   v1.Time{Time: created},
+	}
+
+	if ic.History.Author != "" {
+		history.Author = ic.History.Author
+	}
+
+	if history.CreatedBy == "" {
+		history.CreatedBy = "apko build"
+		if pkgs, err := ic.ResolvedPackages(arch); err == nil && len(pkgs) > 0 {
+			history.CreatedBy += ": apk add " + strings.Join(pkgs, " ")
+		}
+	}
+
+	return history
+}
+
 // PostAttachSBOM attaches the sboms to an already published image
 func PostAttachSBOM(si oci.SignedEntity, sbomPath string, sbomFormats []string,
 	arch types.Architecture, logger *logrus.Entry, tags ...string,
@@ -204,6 +188,26 @@ func PostAttachSBOM(si oci.SignedEntity, sbomPath string, sbomFormats []string,
 	return si, nil
 }
 
+// sbomSignatureAnnotation holds the base64 encoded signature of an
+// attached sbom, as written by cosign sign-blob, on its manifest.
+const sbomSignatureAnnotation = "io.apko.sbom.signature"
+
+// annotatedFile is an attached file whose manifest has annotations.
+type annotatedFile struct {
+	oci.SignedImage
+	file oci.File
+}
+
+func (f *annotatedFile) FileMediaType() (ggcrtypes.MediaType, error) {
+	return f.file.FileMediaType()
+}
+
+func (f *annotatedFile) Payload() ([]byte, error) {
+	return f.file.Payload()
+}
+
+// attachSBOM attaches the sbom of the first format to the image or index,
+// along with its signature, if it was signed.
 func attachSBOM(
 	si oci.SignedEntity, sbomPath string, sbomFormats []string,
 	arch types.Architecture, logger *logrus.Entry,
@@ -211,7 +215,7 @@ func attachSBOM(
 	// Attach the SBOM, e.g.
 	// TODO(kaniini): Allow all SBOM types to be uploaded.
 	if len(sbomFormats) == 0 {
-		logrus.Debug("Not building sboms, no formats requested")
+		logger.Debug("Not building sboms, no formats requested")
 		return si, nil
 	}
 
@@ -248,6 +252,21 @@ func attachSBOM(
 	if err != nil {
 		return nil, err
 	}
+
+	// the signature written when signing the sbom is published with it
+	sig, err := os.ReadFile(path + ".sig")
+	switch {
+	case err == nil:
+		f = &annotatedFile{
+			SignedImage: signed.Image(mutate.Annotations(f, map[string]string{
+				sbomSignatureAnnotation: strings.TrimSpace(string(sig)),
+			}).(v1.Image)),
+			file: f,
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("reading sbom signature: %w", err)
+	}
+
 	var aterr error
 	if i, ok := si.(oci.SignedImage); ok {
 		si, aterr = ocimutate.AttachFileToImage(i, "sbom", f)
@@ -263,6 +282,135 @@ func attachSBOM(
 	return si, nil
 }
 
+// FetchBaseImage fetches the image for the given architecture from the
+// base image reference. If the reference points to an index, the image
+// matching the architecture's platform is selected.
+func FetchBaseImage(imageRef string, arch types.Architecture) (v1.Image, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse reference: %w", err)
+	}
+
+	var img v1.Image
+	if err := retry.Do(func() error {
+		img, err = remote.Image(ref,
+			remote.WithAuthFromKeychain(keychain),
+			remote.WithPlatform(*arch.ToOCIPlatform()),
+		)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to fetch base image %s: %w", imageRef, err)
+	}
+
+	return img, nil
+}
+
+// ResolveBaseImageDigest returns the digest the base image reference
+// points to, which is the reference itself if it includes a digest.
+func ResolveBaseImageDigest(imageRef string) (name.Digest, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("unable to parse reference: %w", err)
+	}
+
+	if digest, ok := ref.(name.Digest); ok {
+		return digest, nil
+	}
+
+	var desc *v1.Descriptor
+	if err := retry.Do(func() error {
+		desc, err = remote.Head(ref, remote.WithAuthFromKeychain(keychain))
+		return err
+	}); err != nil {
+		return name.Digest{}, fmt.Errorf("failed to resolve base image %s: %w", imageRef, err)
+	}
+
+	return ref.Context().Digest(desc.Digest.String()), nil
+}
+
+// FetchBaseImageSBOM fetches the SBOM attached to the image for the given
+// architecture of the base image, returning a nil SBOM when none is
+// attached.
+func FetchBaseImageSBOM(imageRef string, arch types.Architecture) ([]byte, ggcrtypes.MediaType, error) {
+	img, err := FetchBaseImage(imageRef, arch)
+	if err != nil {
+		return nil, "", err
+	}
+
+	h, err := img.Digest()
+	if err != nil {
+		return nil, "", fmt.Errorf("computing base image digest: %w", err)
+	}
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to parse reference: %w", err)
+	}
+
+	tag, err := ociremote.SBOMTag(ref.Context().Digest(h.String()))
+	if err != nil {
+		return nil, "", fmt.Errorf("computing base image SBOM tag: %w", err)
+	}
+
+	sbomImg, err := remote.Image(tag, remote.WithAuthFromKeychain(keychain))
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("fetching base image SBOM: %w", err)
+	}
+
+	layers, err := sbomImg.Layers()
+	if err != nil {
+		return nil, "", fmt.Errorf("reading base image SBOM: %w", err)
+	}
+	if len(layers) != 1 {
+		return nil, "", fmt.Errorf("expected a single base image SBOM, found %d", len(layers))
+	}
+
+	mt, err := layers[0].MediaType()
+	if err != nil {
+		return nil, "", fmt.Errorf("reading base image SBOM media type: %w", err)
+	}
+
+	// Attachments are not compressed, so read the raw blob.
+	rc, err := layers[0].Compressed()
+	if err != nil {
+		return nil, "", fmt.Errorf("reading base image SBOM: %w", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading base image SBOM: %w", err)
+	}
+
+	return data, mt, nil
+}
+
+// ComputeImageDigest computes the digest of the image manifest built
+// from the given layer, without writing or publishing the image.
+func ComputeImageDigest(layerTarGZ string, ic types.ImageConfiguration, logger *logrus.Entry, opts options.Options) (v1.Hash, error) {
+	mediaType := ggcrtypes.OCILayer
+	if opts.UseDockerMediaTypes {
+		mediaType = ggcrtypes.DockerLayer
+	}
+
+	// SBOMs are attached as separate artifacts and do not change the
+	// image digest, so skip them here.
+	v1Image, err := buildImageFromLayerWithMediaType(mediaType, layerTarGZ, ic, opts.SourceDateEpoch, opts.Arch, logger, "", []string{})
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	h, err := v1Image.Digest()
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("failed to compute digest: %w", err)
+	}
+
+	return h, nil
+}
+
 func BuildImageTarballFromLayer(imageRef string, layerTarGZ string, outputTarGZ string, ic types.ImageConfiguration, logger *logrus.Entry, opts options.Options) error {
 	if opts.UseDockerMediaTypes {
 		return buildImageTarballFromLayerWithMediaType(ggcrtypes.DockerLayer, imageRef, layerTarGZ, outputTarGZ, ic, logger, opts)
@@ -293,6 +441,81 @@ func buildImageTarballFromLayerWithMediaType(mediaType ggcrtypes.MediaType, imag
 	return nil
 }
 
+// BuildImageLayoutFromLayer writes the image built from the given layer
+// as an OCI image layout directory at outputDir, tagged as imageRef.
+func BuildImageLayoutFromLayer(imageRef string, layerTarGZ string, outputDir string, ic types.ImageConfiguration, logger *logrus.Entry, opts options.Options) error {
+	mediaType := ggcrtypes.OCILayer
+	if opts.UseDockerMediaTypes {
+		mediaType = ggcrtypes.DockerLayer
+	}
+	imageType := humanReadableImageType(mediaType)
+
+	// SBOMs are not part of the layout, so skip attaching them here.
+	v1Image, err := buildImageFromLayerWithMediaType(mediaType, layerTarGZ, ic, opts.SourceDateEpoch, opts.Arch, logger, "", []string{})
+	if err != nil {
+		return err
+	}
+
+	imgRefTag, err := name.NewTag(imageRef)
+	if err != nil {
+		return fmt.Errorf("unable to validate image reference tag: %w", err)
+	}
+
+	p, err := layout.Write(outputDir, empty.Index)
+	if err != nil {
+		return fmt.Errorf("unable to write OCI layout: %w", err)
+	}
+
+	if err := p.AppendImage(v1Image, layout.WithAnnotations(map[string]string{
+		"org.opencontainers.image.ref.name": imgRefTag.String(),
+	})); err != nil {
+		return fmt.Errorf("unable to write %s image to OCI layout: %w", imageType, err)
+	}
+
+	logger.Printf("output %s image layout to %s", imageType, outputDir)
+	return nil
+}
+
+// LayoutLayer returns the path to the layer blob and the digest of the
+// single-layer image stored in the OCI image layout at path.
+func LayoutLayer(path string) (string, v1.Hash, error) {
+	idx, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return "", v1.Hash{}, fmt.Errorf("reading OCI layout index: %w", err)
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return "", v1.Hash{}, fmt.Errorf("reading OCI layout index manifest: %w", err)
+	}
+
+	if len(manifest.Manifests) != 1 {
+		return "", v1.Hash{}, fmt.Errorf("expected a single image in OCI layout, found %d", len(manifest.Manifests))
+	}
+	h := manifest.Manifests[0].Digest
+
+	img, err := idx.Image(h)
+	if err != nil {
+		return "", v1.Hash{}, fmt.Errorf("reading image %s from OCI layout: %w", h, err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return "", v1.Hash{}, fmt.Errorf("reading image layers: %w", err)
+	}
+
+	if len(layers) != 1 {
+		return "", v1.Hash{}, fmt.Errorf("expected a single layer image, found %d layers", len(layers))
+	}
+
+	ld, err := layers[0].Digest()
+	if err != nil {
+		return "", v1.Hash{}, fmt.Errorf("computing layer digest: %w", err)
+	}
+
+	return filepath.Join(path, "blobs", ld.Algorithm, ld.Hex), h, nil
+}
+
 func publishTagFromImage(image oci.SignedImage, imageRef string, hash v1.Hash, logger *logrus.Entry) (name.Digest, error) {
 	imgRef, err := name.ParseReference(imageRef)
 	if err != nil {
@@ -0,0 +1,109 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/sigstore/cosign/pkg/oci/signed"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestImageLayout(t *testing.T) {
+	dir := t.TempDir()
+	layerTarGZ := filepath.Join(dir, "layer.tar.gz")
+	f, err := os.Create(layerTarGZ)
+	require.NoError(t, err)
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "data", Mode: 0o644, Size: 4}))
+	_, err = tw.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	require.NoError(t, f.Close())
+
+	logger := logrus.NewEntry(&logrus.Logger{})
+	opts := options.Options{Arch: types.ParseArchitecture("amd64")}
+	ic := types.ImageConfiguration{}
+
+	layoutDir := filepath.Join(dir, "layout")
+	require.NoError(t, BuildImageLayoutFromLayer("example.com/image:latest", layerTarGZ, layoutDir, ic, logger, opts))
+	require.FileExists(t, filepath.Join(layoutDir, "oci-layout"))
+	require.FileExists(t, filepath.Join(layoutDir, "index.json"))
+
+	blob, h, err := LayoutLayer(layoutDir)
+	require.NoError(t, err)
+	require.FileExists(t, blob)
+
+	want, err := ComputeImageDigest(layerTarGZ, ic, logger, opts)
+	require.NoError(t, err)
+	require.Equal(t, want, h)
+}
+
+func TestLayerHistory(t *testing.T) {
+	amd64 := types.ParseArchitecture("amd64")
+	created := time.Unix(0, 0)
+
+	ic := types.ImageConfiguration{}
+	ic.Contents.Packages = []string{"busybox", "nginx"}
+	h := layerHistory(ic, amd64, created)
+	require.Equal(t, "apko", h.Author)
+	require.Equal(t, "apko build: apk add busybox nginx", h.CreatedBy)
+	require.Equal(t, created, h.Created.Time)
+
+	ic.History.CreatedBy = "make image"
+	ic.History.Author = "Jane Doe <jane@example.com>"
+	h = layerHistory(ic, amd64, created)
+	require.Equal(t, "make image", h.CreatedBy)
+	require.Equal(t, "Jane Doe <jane@example.com>", h.Author)
+}
+
+func TestAttachSBOMSignature(t *testing.T) {
+	dir := t.TempDir()
+	logger := logrus.NewEntry(&logrus.Logger{})
+	arch := types.ParseArchitecture("amd64")
+	path := filepath.Join(dir, "sbom-x86_64.spdx.json")
+	require.NoError(t, os.WriteFile(path, []byte("{}"), 0o644))
+
+	annotations := func(se oci.SignedEntity) map[string]string {
+		f, err := se.Attachment("sbom")
+		require.NoError(t, err)
+		m, err := f.Manifest()
+		require.NoError(t, err)
+		return m.Annotations
+	}
+
+	se, err := attachSBOM(signed.Image(empty.Image), dir, []string{"spdx"}, arch, logger)
+	require.NoError(t, err)
+	require.NotContains(t, annotations(se), sbomSignatureAnnotation)
+
+	// The signature written next to the sbom is attached with it.
+	require.NoError(t, os.WriteFile(path+".sig", []byte("c2lnbmF0dXJl\n"), 0o644))
+	se, err = attachSBOM(signed.Image(empty.Image), dir, []string{"spdx"}, arch, logger)
+	require.NoError(t, err)
+	require.Equal(t, "c2lnbmF0dXJl", annotations(se)[sbomSignatureAnnotation])
+}
@@ -15,31 +15,31 @@
 package build
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/sirupsen/logrus"
 
 	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/sbom/generator"
+	"chainguard.dev/apko/pkg/tarball"
 )
 
 // Option is an option for the build context.
 type Option func(*Context) error
 
 // WithConfig sets the image configuration for the build context.
-// The image configuration is parsed from given config file.
+// The image configuration is parsed from given config file once all
+// options are applied, so that it is logged as they configure.
 func WithConfig(configFile string) Option {
 	return func(bc *Context) error {
-		bc.Options.Log.Printf("loading config file: %s", configFile)
-
-		var ic types.ImageConfiguration
-		if err := ic.Load(configFile, bc.Logger()); err != nil {
-			return fmt.Errorf("failed to load image configuration: %w", err)
-		}
-
-		bc.ImageConfiguration = ic
 		bc.ImageConfigFile = configFile
-
+		bc.loadConfig = true
 		return nil
 	}
 }
@@ -52,17 +52,52 @@ func WithProot(enable bool) Option {
 	}
 }
 
+// WithWorkDir sets the working directory where the image
+// filesystem is assembled.
+func WithWorkDir(workDir string) Option {
+	return func(bc *Context) error {
+		if workDir == "" {
+			return errors.New("working directory must not be empty")
+		}
+		bc.Options.WorkDir = workDir
+		return nil
+	}
+}
+
 // WithTags sets the tags for the build context.
+// Each tag must be a valid image tag reference.
 func WithTags(tags ...string) Option {
 	return func(bc *Context) error {
+		for _, tag := range tags {
+			if _, err := name.NewTag(tag); err != nil {
+				return fmt.Errorf("invalid tag %q: %w", tag, err)
+			}
+		}
 		bc.Options.Tags = tags
 		return nil
 	}
 }
 
-// WithTarball sets the output path of the layer tarball.
+// WithTarball sets the output path of the layer tarball, which is
+// otherwise written to the temporary directory of the build. The
+// directory of the path must exist and be writable.
 func WithTarball(path string) Option {
 	return func(bc *Context) error {
+		if path == "" {
+			return nil
+		}
+
+		dir := filepath.Dir(path)
+		f, err := os.CreateTemp(dir, ".apko-tarball-*")
+		if err != nil {
+			return fmt.Errorf("tarball directory %s is not writable: %w", dir, err)
+		}
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			return fmt.Errorf("cleaning up tarball directory %s: %w", dir, err)
+		}
+
+		bc.Options.TarballOutputPath = path
 		bc.Options.TarballPath = path
 		return nil
 	}
@@ -102,6 +137,28 @@ func WithBuildDate(s string) Option {
 	}
 }
 
+// WithKeepTimestamps keeps the timestamps of the files in the image
+// layer instead of clamping them to the build date. This makes the
+// layer digest differ between builds.
+func WithKeepTimestamps(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.KeepTimestamps = enable
+		return nil
+	}
+}
+
+// WithSortedTarEntries writes the entries of the image layer ordered
+// lexicographically by their full path, rather than depth first, so that
+// e.g. "etc/apk.conf" comes before "etc/apk/world". Both orders are
+// deterministic, as directories are walked in lexical order; the option
+// only changes the layer, and so its digest, compared to a build without it.
+func WithSortedTarEntries(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.SortTarEntries = enable
+		return nil
+	}
+}
+
 func WithSBOM(path string) Option {
 	return func(bc *Context) error {
 		bc.Options.SBOMPath = path
@@ -109,13 +166,233 @@ func WithSBOM(path string) Option {
 	}
 }
 
+// WithSBOMWorkDir sets the directory holding the image filesystem
+// which is scanned to generate SBOMs. It defaults to the build
+// working directory.
+func WithSBOMWorkDir(path string) Option {
+	return func(bc *Context) error {
+		bc.Options.SBOMWorkDir = path
+		return nil
+	}
+}
+
+// WithSBOMFormats sets the SBOM formats to generate.
+// Each format must have a registered SBOM generator. Formats set
+// this way take precedence over the APKO_SBOM_FORMATS env variable.
 func WithSBOMFormats(formats []string) Option {
 	return func(bc *Context) error {
+		if err := validateSBOMFormats(formats); err != nil {
+			return err
+		}
 		bc.Options.SBOMFormats = formats
 		return nil
 	}
 }
 
+func validateSBOMFormats(formats []string) error {
+	generators := generator.Generators()
+	for _, format := range formats {
+		if _, ok := generators[format]; !ok {
+			return fmt.Errorf("unsupported SBOM format %q", format)
+		}
+	}
+	return nil
+}
+
+// The supported formats of the image written by the build command.
+const (
+	OutputFormatTarGZ     = "tar.gz"
+	OutputFormatOCILayout = "oci-layout"
+)
+
+// WithOutputFormat sets the format of the built image, either a
+// tar.gz image tarball (the default) or an OCI image layout directory.
+func WithOutputFormat(format string) Option {
+	return func(bc *Context) error {
+		switch format {
+		case "", OutputFormatTarGZ, OutputFormatOCILayout:
+		default:
+			return fmt.Errorf("unsupported output format %q, must be %q or %q",
+				format, OutputFormatTarGZ, OutputFormatOCILayout)
+		}
+		bc.Options.OutputFormat = format
+		return nil
+	}
+}
+
+// The media types of the built image.
+const (
+	MediaTypeOCI    = "oci"
+	MediaTypeDocker = "docker"
+)
+
+// WithMediaType sets the media types of the manifest, config and layer of
+// the built image, either OCI (the default) or Docker, e.g. for registries
+// which only accept Docker v2 manifests.
+func WithMediaType(mediaType string) Option {
+	return func(bc *Context) error {
+		switch mediaType {
+		case "", MediaTypeOCI:
+			bc.Options.UseDockerMediaTypes = false
+		case MediaTypeDocker:
+			bc.Options.UseDockerMediaTypes = true
+		default:
+			return fmt.Errorf("unsupported media type %q, must be %q or %q",
+				mediaType, MediaTypeOCI, MediaTypeDocker)
+		}
+		return nil
+	}
+}
+
+// WithPreserveWorkDir keeps the working directory when the build
+// context is closed, e.g. to inspect the image filesystem.
+func WithPreserveWorkDir(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.PreserveWorkDir = enable
+		return nil
+	}
+}
+
+// WithCompressionLevel sets the gzip level of the image layer, from 0 to 9
+// or "none". The layer is otherwise compressed in parallel at the default
+// level.
+func WithCompressionLevel(level string) Option {
+	return func(bc *Context) error {
+		if _, err := tarball.ParseCompressionLevel(level); err != nil {
+			return err
+		}
+		bc.Options.CompressionLevel = level
+		return nil
+	}
+}
+
+// WithCompression sets the compression of the image layer, "gzip" (the
+// default) or "zstd". Zstd layers are only supported with OCI media types.
+func WithCompression(compression string) Option {
+	return func(bc *Context) error {
+		if _, err := tarball.NewContext(tarball.WithCompression(compression)); err != nil {
+			return err
+		}
+		bc.Options.Compression = compression
+		return nil
+	}
+}
+
+// WithMaxImageSize sets the maximum uncompressed size, in bytes, of the
+// image layer. A build exceeding it fails. Zero disables the limit.
+func WithMaxImageSize(size int64) Option {
+	return func(bc *Context) error {
+		if size < 0 {
+			return fmt.Errorf("invalid maximum image size %d", size)
+		}
+		bc.Options.MaxImageSize = size
+		return nil
+	}
+}
+
+// WithBuildReport sets the path where a JSON report summarizing
+// the build is written. A relative path is resolved against the SBOM
+// output directory. No report is written if the path is empty.
+func WithBuildReport(path string) Option {
+	return func(bc *Context) error {
+		bc.Options.ReportPath = path
+		return nil
+	}
+}
+
+// WithProvenance sets the path where the SLSA provenance of the image
+// is written as an in-toto statement. No provenance is written if the
+// path is empty.
+func WithProvenance(path string) Option {
+	return func(bc *Context) error {
+		bc.Options.ProvenancePath = path
+		return nil
+	}
+}
+
+// WithInstalledDB sets the path where the installed database of the
+// image, /lib/apk/db/installed, is copied once the image is built, as a
+// build artifact. Nothing is copied if the path is empty.
+func WithInstalledDB(path string) Option {
+	return func(bc *Context) error {
+		bc.Options.InstalledDBPath = path
+		return nil
+	}
+}
+
+// WithCacheDir points apk at a local cache, overriding the cache
+// directory of the image configuration, which must exist.
+func WithCacheDir(dir string) Option {
+	return func(bc *Context) error {
+		if dir == "" {
+			return nil
+		}
+
+		if err := types.ValidateCacheDir(dir); err != nil {
+			return err
+		}
+
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("resolving cache directory: %w", err)
+		}
+
+		bc.Options.CacheDir = abs
+		return nil
+	}
+}
+
+// WithOffline forbids apk from using the network, so that the packages
+// and repository indexes are only read from the cache or local
+// repositories, failing the build when one is missing. Keys given as
+// https URLs are not fetched either.
+func WithOffline(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.Offline = enable
+		return nil
+	}
+}
+
+// WithSBOMSigningKey signs each SBOM with the private key at the path,
+// or the KMS key of the URI, writing the signature next to it as
+// <sbom>.sig, which is published along the attached SBOM. The password
+// of encrypted keys is read from COSIGN_PASSWORD. KMS URIs need the
+// sigstore KMS provider of their scheme to be registered.
+func WithSBOMSigningKey(keyRef string) Option {
+	return func(bc *Context) error {
+		bc.Options.SBOMSigningKey = keyRef
+		return nil
+	}
+}
+
+// WithSBOMGzip also writes a gzip compressed copy of each SBOM, as
+// <sbom>.gz, next to the plain one.
+func WithSBOMGzip(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.SBOMGzip = enable
+		return nil
+	}
+}
+
+// WithSBOMPredicates enables writing an in-toto statement next to each
+// SBOM, referencing the image digest and the SBOM content hash, which
+// can be used to attest the SBOM. It is written as <sbom>.intoto.json.
+func WithSBOMPredicates(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.SBOMPredicates = enable
+		return nil
+	}
+}
+
+// WithRequireSBOM makes the build fail when no SBOM formats are
+// configured, instead of skipping the generation of SBOMs.
+func WithRequireSBOM(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.RequireSBOM = enable
+		return nil
+	}
+}
+
 func WithExtraKeys(keys []string) Option {
 	return func(bc *Context) error {
 		bc.Options.ExtraKeyFiles = keys
@@ -130,18 +407,34 @@ func WithExtraRepos(repos []string) Option {
 	}
 }
 
+// WithAllowedOrigins sets the package origins which are permitted
+// in the image. Any installed package with an origin not in this
+// list will fail the build. An empty list allows all origins.
+func WithAllowedOrigins(origins []string) Option {
+	return func(bc *Context) error {
+		bc.Options.AllowedOrigins = origins
+		return nil
+	}
+}
+
 // WithImageConfiguration sets the ImageConfiguration object
 // to use when building.
 func WithImageConfiguration(ic types.ImageConfiguration) Option {
 	return func(bc *Context) error {
 		bc.ImageConfiguration = ic
+		bc.loadConfig = false
 		return nil
 	}
 }
 
 // WithArch sets the architecture for the build context.
+// The architecture must be one of the supported architectures.
+// A zero architecture defaults to the running program's arch.
 func WithArch(arch types.Architecture) Option {
 	return func(bc *Context) error {
+		if arch != (types.Architecture{}) && !arch.IsSupported() {
+			return fmt.Errorf("unsupported architecture %q", arch)
+		}
 		bc.Options.Arch = arch
 		return nil
 	}
@@ -165,6 +458,15 @@ func WithDebugLogging(enable bool) Option {
 	}
 }
 
+// WithLogWriter writes the logs of the build context to w rather than
+// to stderr.
+func WithLogWriter(w io.Writer) Option {
+	return func(bc *Context) error {
+		bc.Options.Log.SetOutput(w)
+		return nil
+	}
+}
+
 // WithVCS enables VCS URL probing for the build context.
 func WithVCS(enable bool) Option {
 	return func(bc *Context) error {
@@ -176,7 +478,106 @@ func WithVCS(enable bool) Option {
 // WithAnnotations parses and populates the annotations in the ImageConfiguration
 func WithAnnotations(annotations map[string]string) Option {
 	return func(bc *Context) error {
-		bc.ImageConfiguration.Annotations = annotations
+		bc.annotations = annotations
+		bc.overrideAnnotations = true
+		return nil
+	}
+}
+
+// WithBuildArgs sets the build args which environment and templated
+// annotation values of the image configuration may reference, e.g.
+// `{{ .BuildArgs.VERSION }}`. Referencing an unset build arg fails the
+// validation of the configuration.
+func WithBuildArgs(args map[string]string) Option {
+	return func(bc *Context) error {
+		for k := range args {
+			if k == "" {
+				return fmt.Errorf("build arg names cannot be empty")
+			}
+		}
+		bc.BuildArgs = args
+		return nil
+	}
+}
+
+// WithProfile selects the profile of the image configuration, e.g. a
+// debug variant, whose entrypoint and command replace the configured
+// ones.
+func WithProfile(profile string) Option {
+	return func(bc *Context) error {
+		bc.Profile = profile
+		return nil
+	}
+}
+
+// WithOverrideRunAs replaces the run-as user of the image configuration
+// with runAs, a user[:group] of names or IDs, for this build only, e.g.
+// for debug builds running as root.
+func WithOverrideRunAs(runAs string) Option {
+	return func(bc *Context) error {
+		bc.OverrideRunAs = runAs
+		return nil
+	}
+}
+
+// WithStrictAnnotations makes the build fail when the image annotations
+// conflict with reserved OCI keys or values derived by apko, instead of
+// only warning about it.
+func WithStrictAnnotations(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.StrictAnnotations = enable
+		return nil
+	}
+}
+
+// WithStrictBaseImage makes the build fail when the base image is not
+// referenced by digest, instead of only warning about it.
+func WithStrictBaseImage(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.StrictBaseImage = enable
+		return nil
+	}
+}
+
+// WithStrictEntrypoint makes the build fail when a plain image has no
+// entrypoint command, shell fragment or cmd, instead of only warning
+// about it.
+func WithStrictEntrypoint(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.StrictEntrypoint = enable
+		return nil
+	}
+}
+
+// WithBaseImageVerifier sets a function called with the digest of the
+// base image before it is used, e.g. to verify its signatures against a
+// cosign policy. The build fails when it returns an error.
+func WithBaseImageVerifier(verify func(name.Digest) error) Option {
+	return func(bc *Context) error {
+		bc.Options.BaseImageVerifier = verify
+		return nil
+	}
+}
+
+// WithPhaseHook sets the hook notified as each phase of the build
+// completes, e.g. to emit metrics. A nil hook removes it.
+func WithPhaseHook(hook PhaseHook) Option {
+	return func(bc *Context) error {
+		if hook == nil {
+			bc.Options.PhaseHook = nil
+			return nil
+		}
+		bc.Options.PhaseHook = hook.OnPhase
+		return nil
+	}
+}
+
+// WithStrictKeyring makes the build fail when repositories are configured
+// without a keyring or a keyring without repositories, instead of only
+// warning about it.
+func WithStrictKeyring(enable bool) Option {
+	return func(bc *Context) error {
+		bc.Options.StrictKeyring = enable
 		return nil
 	}
 }
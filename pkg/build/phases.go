@@ -0,0 +1,41 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import "time"
+
+// The phases of the build reported to a PhaseHook.
+const (
+	// PhaseConfigLoaded is the creation of the build context, including
+	// loading the image configuration.
+	PhaseConfigLoaded = "config-loaded"
+	// PhasePackagesInstalled is the installation of the packages.
+	PhasePackagesInstalled = "packages-installed"
+	// PhaseImageBuilt is the assembly of the whole image filesystem,
+	// including the packages.
+	PhaseImageBuilt = "image-built"
+	// PhaseTarballWritten is the writing of the layer tarball.
+	PhaseTarballWritten = "tarball-written"
+	// PhaseSBOMGenerated is the generation of the SBOMs.
+	PhaseSBOMGenerated = "sbom-generated"
+)
+
+// PhaseHook is notified as each phase of the build completes, e.g. to
+// instrument builds.
+type PhaseHook interface {
+	// OnPhase is called with the name of the completed phase and the
+	// time it took.
+	OnPhase(name string, d time.Duration)
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	purl "github.com/package-url/packageurl-go"
+
+	"chainguard.dev/apko/pkg/sbom"
+)
+
+const (
+	slsaProvenancePredicateType = "https://slsa.dev/provenance/v0.2"
+
+	// The builder and build type recorded in the provenance of the
+	// images apko builds.
+	apkoBuilderID = "https://github.com/chainguard-dev/apko"
+	apkoBuildType = "https://apko.dev/build@v1"
+
+	// The digest key of the apk checksums of the materials.
+	apkChecksumDigestKey = "apkChecksum"
+)
+
+type provenanceStatement struct {
+	Type          string          `json:"_type"`
+	PredicateType string          `json:"predicateType"`
+	Subject       []intotoSubject `json:"subject"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type slsaProvenance struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string         `json:"buildType"`
+	Invocation slsaInvocation `json:"invocation"`
+	Metadata   struct {
+		Completeness struct {
+			Parameters  bool `json:"parameters"`
+			Environment bool `json:"environment"`
+			Materials   bool `json:"materials"`
+		} `json:"completeness"`
+	} `json:"metadata"`
+	Materials []slsaMaterial `json:"materials"`
+}
+
+type slsaInvocation struct {
+	ConfigSource struct {
+		URI        string `json:"uri,omitempty"`
+		EntryPoint string `json:"entryPoint,omitempty"`
+	} `json:"configSource"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+type slsaMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// provenance assembles the SLSA provenance of the image built from the
+// layer tarball, as an in-toto statement: the image digest is its
+// subject, the configuration, found by its VCS URL and fingerprint, its
+// invocation, and the installed packages its materials.
+func (bc *Context) provenance(layerTarGZ string) (*provenanceStatement, error) {
+	digest, err := bc.ImageDigest(layerTarGZ)
+	if err != nil {
+		return nil, fmt.Errorf("computing image digest: %w", err)
+	}
+
+	fingerprint, err := bc.ImageConfiguration.Fingerprint()
+	if err != nil {
+		return nil, fmt.Errorf("computing configuration fingerprint: %w", err)
+	}
+
+	s := sbom.NewWithWorkDir(bc.Options.WorkDir, bc.Options.Arch)
+	if err := s.ReadPackageIndex(); err != nil {
+		return nil, fmt.Errorf("reading installed packages: %w", err)
+	}
+
+	imageName := "image"
+	if tags, err := bc.CanonicalTags(); err == nil && len(tags) > 0 {
+		imageName = tags[0].String()
+	}
+
+	st := &provenanceStatement{
+		Type:          intotoStatementType,
+		PredicateType: slsaProvenancePredicateType,
+		Subject: []intotoSubject{{
+			Name:   imageName,
+			Digest: map[string]string{digest.Algorithm: digest.Hex},
+		}},
+	}
+
+	p := &st.Predicate
+	p.Builder.ID = apkoBuilderID
+	p.BuildType = apkoBuildType
+
+	p.Invocation.ConfigSource.URI = bc.ImageConfiguration.VCSUrl
+	if bc.ImageConfigFile != "" {
+		p.Invocation.ConfigSource.EntryPoint = path.Join(bc.ImageConfiguration.VCSSubPath, filepath.Base(bc.ImageConfigFile))
+	}
+	p.Invocation.Parameters = map[string]interface{}{
+		"arch":              bc.Options.Arch.ToAPK(),
+		"configFingerprint": fingerprint,
+	}
+	if bc.Profile != "" {
+		p.Invocation.Parameters["profile"] = bc.Profile
+	}
+	if len(bc.BuildArgs) > 0 {
+		p.Invocation.Parameters["buildArgs"] = bc.BuildArgs
+	}
+
+	p.Metadata.Completeness.Parameters = true
+	p.Metadata.Completeness.Materials = true
+
+	p.Materials = make([]slsaMaterial, 0, len(s.Options.Packages))
+	for _, pkg := range s.Options.Packages {
+		m := slsaMaterial{
+			URI: purl.NewPackageURL(
+				"apk", bc.ImageConfiguration.OSRelease.ID, pkg.Name, pkg.Version,
+				purl.QualifiersFromMap(map[string]string{"arch": bc.Options.Arch.ToAPK()}), "",
+			).String(),
+		}
+		if len(pkg.Checksum) != 0 {
+			// The checksum is apk's Q1 control checksum rather than a
+			// digest of the package file, so it is recorded as apk
+			// writes it, under a key of its own.
+			m.Digest = map[string]string{apkChecksumDigestKey: "Q1" + base64.StdEncoding.EncodeToString(pkg.Checksum)}
+		}
+		p.Materials = append(p.Materials, m)
+	}
+	sort.Slice(p.Materials, func(i, j int) bool {
+		return p.Materials[i].URI < p.Materials[j].URI
+	})
+
+	return st, nil
+}
+
+// GenerateProvenance writes the SLSA provenance of the image built from
+// the layer tarball of the build as JSON to the configured provenance
+// path.
+func (bc *Context) GenerateProvenance() error {
+	st, err := bc.provenance(bc.Options.TarballPath)
+	if err != nil {
+		return fmt.Errorf("assembling provenance: %w", err)
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing provenance: %w", err)
+	}
+
+	// #nosec G306 -- the provenance is as public as the image
+	if err := os.WriteFile(bc.Options.ProvenancePath, data, 0o644); err != nil {
+		return fmt.Errorf("writing provenance: %w", err)
+	}
+
+	bc.Logger().Infof("wrote SLSA provenance to %s", bc.Options.ProvenancePath)
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"chainguard.dev/apko/pkg/sbom"
+)
+
+// Report is a concise summary of a built image.
+type Report struct {
+	Arch          string   `json:"arch"`
+	Digest        string   `json:"digest"`
+	Tags          []string `json:"tags"`
+	PackageCount  int      `json:"packageCount"`
+	InstalledSize uint64   `json:"installedSize"`
+	LayerSize     int64    `json:"layerSize"`
+	VCSUrl        string   `json:"vcsUrl,omitempty"`
+	VCSSubPath    string   `json:"vcsSubPath,omitempty"`
+	// APKToolsVersion is the version of apk-tools which installed the
+	// packages, when it is known.
+	APKToolsVersion string `json:"apkToolsVersion,omitempty"`
+}
+
+// BuildReport assembles the report of the image built from the
+// given layer tarball.
+func (bc *Context) BuildReport(layerTarGZ string) (*Report, error) {
+	digest, err := bc.ImageDigest(layerTarGZ)
+	if err != nil {
+		return nil, fmt.Errorf("computing image digest: %w", err)
+	}
+
+	tags, err := bc.CanonicalTags()
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(layerTarGZ)
+	if err != nil {
+		return nil, fmt.Errorf("reading layer tarball: %w", err)
+	}
+
+	s := sbom.NewWithWorkDir(sbomWorkDir(&bc.Options), bc.Options.Arch)
+	if err := s.ReadPackageIndex(); err != nil {
+		return nil, fmt.Errorf("reading installed packages: %w", err)
+	}
+
+	report := &Report{
+		Arch:         bc.Options.Arch.String(),
+		Digest:       digest.String(),
+		Tags:         make([]string, 0, len(tags)),
+		PackageCount: len(s.Options.Packages),
+		LayerSize:    fi.Size(),
+		VCSUrl:       bc.ImageConfiguration.VCSUrl,
+		VCSSubPath:   bc.ImageConfiguration.VCSSubPath,
+
+		APKToolsVersion: bc.Options.APKToolsVersion,
+	}
+
+	for _, tag := range tags {
+		report.Tags = append(report.Tags, tag.Name())
+	}
+
+	for _, pkg := range s.Options.Packages {
+		report.InstalledSize += pkg.InstalledSize
+	}
+
+	return report, nil
+}
+
+// reportPath returns the path the build report is written to. A relative
+// report path is resolved against the SBOM output directory, so that the
+// report is written next to the SBOMs.
+func (bc *Context) reportPath() string {
+	if filepath.IsAbs(bc.Options.ReportPath) || bc.Options.SBOMPath == "" {
+		return bc.Options.ReportPath
+	}
+	return filepath.Join(bc.Options.SBOMPath, bc.Options.ReportPath)
+}
+
+// WriteBuildReport writes the report of the image built from the given
+// layer tarball as JSON to the configured report path.
+func (bc *Context) WriteBuildReport(layerTarGZ string) error {
+	report, err := bc.BuildReport(layerTarGZ)
+	if err != nil {
+		return fmt.Errorf("building report: %w", err)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing report: %w", err)
+	}
+
+	path := bc.reportPath()
+
+	// #nosec G306 -- the build report is not sensitive
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	bc.Logger().Infof("wrote build report to %s", path)
+
+	return nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportPath(t *testing.T) {
+	bc := &Context{}
+	bc.Options.ReportPath = "report.json"
+	require.Equal(t, "report.json", bc.reportPath())
+
+	// Relative report paths are written next to the SBOMs.
+	bc.Options.SBOMPath = "/out/sboms"
+	require.Equal(t, "/out/sboms/report.json", bc.reportPath())
+
+	bc.Options.ReportPath = "/tmp/report.json"
+	require.Equal(t, "/tmp/report.json", bc.reportPath())
+}
@@ -0,0 +1,221 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/jinzhu/copier"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/tarball"
+)
+
+// ReproducibilityError is returned by VerifyReproducible when the layer
+// built does not have the reference diff ID.
+type ReproducibilityError struct {
+	Reference v1.Hash
+	Digest    v1.Hash
+	// Paths are the layer entries which differ between two builds of the
+	// same configuration. It is empty when both builds are identical, and
+	// so differ from the reference because their inputs changed.
+	Paths []string
+}
+
+func (e *ReproducibilityError) Error() string {
+	msg := fmt.Sprintf("layer diff ID %s does not match the reference %s", e.Digest, e.Reference)
+	if len(e.Paths) == 0 {
+		return msg + ": a rebuild is identical, so the inputs of the build (packages, configuration or source date) differ from those of the reference"
+	}
+	return fmt.Sprintf("%s: the build is not reproducible, these paths differ between two builds: %s", msg, strings.Join(e.Paths, ", "))
+}
+
+// VerifyReproducible builds the layer and checks that its diff ID, the
+// digest of its uncompressed contents, is the reference diff ID. When it
+// is not, the layer is built a second time in a fresh working directory
+// and both builds are compared, to tell a build which is not reproducible
+// from one whose inputs changed. The error returned is then a
+// *ReproducibilityError.
+func (bc *Context) VerifyReproducible(reference v1.Hash) error {
+	layerTarGZ, err := bc.BuildLayer()
+	if err != nil {
+		return err
+	}
+
+	digest, err := layerDigest(layerTarGZ)
+	if err != nil {
+		return err
+	}
+
+	if digest == reference {
+		return nil
+	}
+
+	bc.Logger().Warnf("layer diff ID %s does not match the reference %s, rebuilding to compare", digest, reference)
+
+	rebuild, err := bc.clone()
+	if err != nil {
+		return err
+	}
+
+	wd, err := os.MkdirTemp("", "apko-reproducible-*")
+	if err != nil {
+		return fmt.Errorf("creating working directory: %w", err)
+	}
+
+	rebuild.Options.WorkDir = wd
+	rebuild.Options.PreserveWorkDir = false
+	rebuild.Options.TempDirPath = ""
+	rebuild.Options.TarballPath = ""
+	rebuild.Options.TarballOutputPath = ""
+	rebuild.Options.WantSBOM = false
+	rebuild.Options.ReportPath = ""
+	rebuild.Options.InstalledDBPath = ""
+	rebuild.Options.ProvenancePath = ""
+	defer rebuild.Close()
+
+	if err := rebuild.Refresh(); err != nil {
+		return fmt.Errorf("refreshing build context: %w", err)
+	}
+
+	rebuiltTarGZ, err := rebuild.BuildLayer()
+	if err != nil {
+		return fmt.Errorf("rebuilding layer: %w", err)
+	}
+
+	paths, err := DiffLayers(layerTarGZ, rebuiltTarGZ)
+	if err != nil {
+		return fmt.Errorf("comparing layers: %w", err)
+	}
+
+	return &ReproducibilityError{Reference: reference, Digest: digest, Paths: paths}
+}
+
+// clone returns a copy of the build context which shares none of the
+// configuration of bc, so that building it leaves bc untouched.
+func (bc *Context) clone() (*Context, error) {
+	c := *bc
+
+	c.ImageConfiguration = types.ImageConfiguration{}
+	if err := copier.CopyWithOption(&c.ImageConfiguration, &bc.ImageConfiguration, copier.Option{DeepCopy: true}); err != nil {
+		return nil, fmt.Errorf("copying image configuration: %w", err)
+	}
+
+	c.BuildArgs = make(map[string]string, len(bc.BuildArgs))
+	for k, v := range bc.BuildArgs {
+		c.BuildArgs[k] = v
+	}
+
+	c.Assertions = append([]Assertion(nil), bc.Assertions...)
+	c.Options.Tags = append([]string(nil), bc.Options.Tags...)
+	c.Options.SBOMFormats = append([]string(nil), bc.Options.SBOMFormats...)
+	c.Options.ExtraKeyFiles = append([]string(nil), bc.Options.ExtraKeyFiles...)
+	c.Options.ExtraRepos = append([]string(nil), bc.Options.ExtraRepos...)
+	c.Options.AllowedOrigins = append([]string(nil), bc.Options.AllowedOrigins...)
+
+	return &c, nil
+}
+
+// layerDigest returns the diff ID of the layer tarball, the digest of its
+// uncompressed contents, which does not depend on the compression.
+func layerDigest(layerTarGZ string) (v1.Hash, error) {
+	layer, err := tarball.LayerFromFile(layerTarGZ, "")
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("opening layer tarball: %w", err)
+	}
+
+	digest, err := layer.DiffID()
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("computing layer diff ID: %w", err)
+	}
+
+	return digest, nil
+}
+
+// DiffLayers compares the entries of two layer tarballs, returning the
+// sorted paths which are only in one of them or which differ in their
+// header or contents.
+func DiffLayers(a, b string) ([]string, error) {
+	entriesA, err := layerEntries(a)
+	if err != nil {
+		return nil, err
+	}
+
+	entriesB, err := layerEntries(b)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for p, ea := range entriesA {
+		if eb, ok := entriesB[p]; !ok || ea != eb {
+			paths = append(paths, p)
+		}
+	}
+	for p := range entriesB {
+		if _, ok := entriesA[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// layerEntries maps the paths of the entries of a layer tarball to a
+// fingerprint of their header and contents.
+func layerEntries(layerTarGZ string) (map[string]string, error) {
+	layer, err := tarball.LayerFromFile(layerTarGZ, "")
+	if err != nil {
+		return nil, fmt.Errorf("opening layer tarball %s: %w", layerTarGZ, err)
+	}
+
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("reading layer tarball %s: %w", layerTarGZ, err)
+	}
+	defer rc.Close()
+
+	entries := map[string]string{}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading layer tarball %s: %w", layerTarGZ, err)
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, fmt.Errorf("reading %s in layer tarball %s: %w", hdr.Name, layerTarGZ, err)
+		}
+
+		entries[hdr.Name] = fmt.Sprintf("%c %o %d:%d %d %d %s %v %x",
+			hdr.Typeflag, hdr.Mode, hdr.Uid, hdr.Gid, hdr.Size, hdr.ModTime.Unix(),
+			hdr.Linkname, hdr.PAXRecords, h.Sum(nil))
+	}
+
+	return entries, nil
+}
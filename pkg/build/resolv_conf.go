@@ -0,0 +1,63 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// WriteResolvConf writes /etc/resolv.conf from the configured resolver
+// configuration, if any, replacing the one installed by packages.
+func (di *defaultBuildImplementation) WriteResolvConf(o *options.Options, ic *types.ImageConfiguration) error {
+	rc := ic.Contents.ResolvConf
+	if rc.IsEmpty() {
+		return nil
+	}
+
+	o.Logger().Infof("writing /etc/resolv.conf")
+
+	var sb strings.Builder
+	for _, ns := range rc.Nameservers {
+		fmt.Fprintf(&sb, "nameserver %s\n", ns)
+	}
+	if len(rc.Search) > 0 {
+		fmt.Fprintf(&sb, "search %s\n", strings.Join(rc.Search, " "))
+	}
+
+	etc := filepath.Join(o.WorkDir, "etc")
+	if err := os.MkdirAll(etc, 0o755); err != nil {
+		return fmt.Errorf("creating /etc: %w", err)
+	}
+
+	// a symlink, e.g. to a resolver managed file, is replaced rather
+	// than written through
+	path := filepath.Join(etc, "resolv.conf")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing /etc/resolv.conf: %w", err)
+	}
+
+	// #nosec G306 -- /etc/resolv.conf must be readable by any user
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		return fmt.Errorf("writing /etc/resolv.conf: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestWriteResolvConf(t *testing.T) {
+	wd := t.TempDir()
+	di := defaultBuildImplementation{}
+	o := &options.Options{Log: &logrus.Logger{}, WorkDir: wd}
+	path := filepath.Join(wd, "etc", "resolv.conf")
+
+	// Nothing is written without a resolver configuration.
+	ic := &types.ImageConfiguration{}
+	require.NoError(t, di.WriteResolvConf(o, ic))
+	require.NoFileExists(t, path)
+
+	// A symlink installed by a package is replaced.
+	require.NoError(t, os.MkdirAll(filepath.Join(wd, "etc"), 0o755))
+	require.NoError(t, os.Symlink("/run/resolv.conf", path))
+
+	ic.Contents.ResolvConf.Nameservers = []string{"1.1.1.1", "2606:4700:4700::1111"}
+	ic.Contents.ResolvConf.Search = []string{"svc.cluster.local", "example.com"}
+	require.NoError(t, di.WriteResolvConf(o, ic))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "nameserver 1.1.1.1\nnameserver 2606:4700:4700::1111\nsearch svc.cluster.local example.com\n", string(data))
+}
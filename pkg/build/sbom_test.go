@@ -0,0 +1,55 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"gitlab.alpinelinux.org/alpine/go/pkg/repository"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/sbom"
+)
+
+func TestExcludeSBOMPackages(t *testing.T) {
+	o := &options.Options{Log: &logrus.Logger{}}
+	s := sbom.NewWithWorkDir(t.TempDir(), types.ParseArchitecture("amd64"))
+	s.Options.Packages = []*repository.Package{
+		{Name: "busybox"}, {Name: "build-helper"}, {Name: "ca-certificates-bundle"},
+	}
+
+	ic := &types.ImageConfiguration{}
+	ic.SBOM.ExcludePackages = []string{"build-helper", "not-installed"}
+	excludeSBOMPackages(o, ic, s)
+
+	names := []string{}
+	for _, pkg := range s.Options.Packages {
+		names = append(names, pkg.Name)
+	}
+	require.Equal(t, []string{"busybox", "ca-certificates-bundle"}, names)
+}
+
+func TestNewSBOMLocalPackages(t *testing.T) {
+	o := &options.Options{Log: &logrus.Logger{}}
+	ic := &types.ImageConfiguration{}
+	ic.Contents.LocalPackages = []string{filepath.Join(t.TempDir(), "missing.apk")}
+
+	_, err := newSBOM(o, ic)
+	require.ErrorContains(t, err, "reading local package")
+}
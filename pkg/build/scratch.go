@@ -0,0 +1,81 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	chainguardAPK "chainguard.dev/apko/pkg/apk"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// scratchForbiddenPaths are the shells and package managers which
+// scratch images may not contain, whichever package installed them.
+var scratchForbiddenPaths = []string{
+	"/bin/sh",
+	"/bin/ash",
+	"/bin/bash",
+	"/bin/dash",
+	"/bin/zsh",
+	"/usr/bin/bash",
+	"/usr/bin/zsh",
+	"/sbin/apk",
+	"/usr/bin/apk",
+}
+
+// checkScratch fails when a scratch image ends up with a shell or package
+// manager, e.g. pulled in as the dependency of a package, which Validate
+// can't see.
+func (bc *Context) checkScratch() error {
+	if !bc.ImageConfiguration.Scratch {
+		return nil
+	}
+
+	found := []string{}
+
+	pkgs, err := chainguardAPK.InstalledPackages(bc.Options.WorkDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	names := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if types.ForbiddenInScratch(name) {
+			found = append(found, "package "+name)
+		}
+	}
+
+	for _, p := range scratchForbiddenPaths {
+		if _, err := os.Lstat(filepath.Join(bc.Options.WorkDir, p)); err == nil {
+			found = append(found, p)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("checking %s: %w", p, err)
+		}
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("scratch image contains a shell or package manager: %s", strings.Join(found, ", "))
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestCheckScratch(t *testing.T) {
+	wd := t.TempDir()
+	bc := &Context{
+		ImageConfiguration: types.ImageConfiguration{Scratch: true},
+		Options:            options.Options{WorkDir: wd},
+	}
+
+	db := filepath.Join(wd, "lib", "apk", "db", "installed")
+	require.NoError(t, os.MkdirAll(filepath.Dir(db), 0o755))
+	require.NoError(t, os.WriteFile(db, []byte("P:ca-certificates-bundle\nV:1-r0\n\nP:app\nV:1.0-r0\n\n"), 0o644))
+	require.NoError(t, bc.checkScratch())
+
+	// a shell pulled in as a dependency is found
+	require.NoError(t, os.WriteFile(db, []byte("P:app\nV:1.0-r0\n\nP:busybox\nV:1.35-r0\n\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(wd, "bin"), 0o755))
+	require.NoError(t, os.Symlink("/bin/busybox", filepath.Join(wd, "bin", "sh")))
+	require.EqualError(t, bc.checkScratch(), "scratch image contains a shell or package manager: package busybox, /bin/sh")
+
+	// nothing is checked for other images
+	bc.ImageConfiguration.Scratch = false
+	require.NoError(t, bc.checkScratch())
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	chainguardAPK "chainguard.dev/apko/pkg/apk"
+	"chainguard.dev/apko/pkg/passwd"
+)
+
+// SetuidBinary is a setuid or setgid binary found in the image.
+type SetuidBinary struct {
+	Path   string
+	Setuid bool
+	Setgid bool
+	UID    uint32
+	GID    uint32
+	// Owner is the name of the owning user, when it is in /etc/passwd.
+	Owner string
+	// Package and Origin identify the package which installed the
+	// binary, when it is known.
+	Package string
+	Origin  string
+}
+
+func (b SetuidBinary) String() string {
+	bits := []string{}
+	if b.Setuid {
+		bits = append(bits, "setuid")
+	}
+	if b.Setgid {
+		bits = append(bits, "setgid")
+	}
+
+	owner := fmt.Sprintf("%d:%d", b.UID, b.GID)
+	if b.Owner != "" {
+		owner = fmt.Sprintf("%s (%s)", b.Owner, owner)
+	}
+
+	provider := "unknown package"
+	if b.Package != "" {
+		provider = "package " + b.Package
+		if b.Origin != "" && b.Origin != b.Package {
+			provider = fmt.Sprintf("%s (origin %s)", provider, b.Origin)
+		}
+	}
+
+	return fmt.Sprintf("%s (%s, owned by %s, from %s)", b.Path, strings.Join(bits, ", "), owner, provider)
+}
+
+// UnexpectedSetuidBinaries walks the image filesystem in the working
+// directory and reports the setuid and setgid binaries which are not
+// allowed by the setuid policy of the image configuration, along with
+// their owner and the installed package which provided them.
+func (bc *Context) UnexpectedSetuidBinaries() ([]SetuidBinary, error) {
+	owners, err := installedFileOwners(bc.Options.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := userNames(bc.Options.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []SetuidBinary{}
+	err = walkImage(bc.Options.WorkDir, func(rel string, fi fs.FileInfo) error {
+		mode := fi.Mode()
+		if !mode.IsRegular() || mode&(fs.ModeSetuid|fs.ModeSetgid) == 0 {
+			return nil
+		}
+
+		if setuidAllowed(bc.ImageConfiguration.Setuid.Allow, rel) {
+			return nil
+		}
+
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("unable to read the owner of %s", rel)
+		}
+
+		owner := owners[rel]
+		found = append(found, SetuidBinary{
+			Path:    rel,
+			Setuid:  mode&fs.ModeSetuid != 0,
+			Setgid:  mode&fs.ModeSetgid != 0,
+			UID:     stat.Uid,
+			GID:     stat.Gid,
+			Owner:   users[stat.Uid],
+			Package: owner.name,
+			Origin:  owner.origin,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// checkSetuidBinaries fails when the setuid policy of the image
+// configuration is enforced and unexpected setuid or setgid binaries
+// are found.
+func (bc *Context) checkSetuidBinaries() error {
+	if !bc.ImageConfiguration.Setuid.Enforce {
+		return nil
+	}
+
+	found, err := bc.UnexpectedSetuidBinaries()
+	if err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	report := make([]string, 0, len(found))
+	for _, b := range found {
+		report = append(report, b.String())
+	}
+	return fmt.Errorf("unexpected setuid or setgid binaries found: %s", strings.Join(report, "; "))
+}
+
+func setuidAllowed(allow []string, p string) bool {
+	for _, pattern := range allow {
+		// the patterns are checked when the configuration is validated
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fileOwner is the installed package which provided a file.
+type fileOwner struct {
+	name   string
+	origin string
+}
+
+// installedFileOwners maps the files listed in the installed database of
+// root to the packages which provided them. It is empty when no packages
+// are installed.
+func installedFileOwners(root string) (map[string]fileOwner, error) {
+	pkgs, err := chainguardAPK.InstalledPackages(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]fileOwner{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	owners := map[string]fileOwner{}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			owners[file] = fileOwner{name: pkg.Name, origin: pkg.Origin}
+		}
+	}
+
+	return owners, nil
+}
+
+// userNames maps UIDs to the user names in the /etc/passwd file of root,
+// if any.
+func userNames(root string) (map[uint32]string, error) {
+	names := map[uint32]string{}
+
+	f, err := os.Open(filepath.Join(root, "etc", "passwd"))
+	if errors.Is(err, os.ErrNotExist) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening /etc/passwd: %w", err)
+	}
+	defer f.Close()
+
+	uf := passwd.UserFile{}
+	if err := uf.Load(f); err != nil {
+		return nil, fmt.Errorf("parsing /etc/passwd: %w", err)
+	}
+
+	for _, ue := range uf.Entries {
+		if _, ok := names[ue.UID]; !ok {
+			names[ue.UID] = ue.UserName
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// StripContents removes the files matching the strip patterns of the
+// image configuration from the working directory.
+func (di *defaultBuildImplementation) StripContents(
+	o *options.Options, ic *types.ImageConfiguration,
+) error {
+	patterns := ic.Contents.Strip.Patterns()
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	root, err := filepath.EvalSymlinks(o.WorkDir)
+	if err != nil {
+		return err
+	}
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return fmt.Errorf("matching %s: %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			// The glob follows symlinks in the image, make sure they
+			// do not lead it out of the working directory.
+			dir, err := filepath.EvalSymlinks(filepath.Dir(match))
+			if err != nil {
+				return err
+			}
+			if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+				o.Logger().Warnf("not stripping %s, it is outside of the image filesystem", match)
+				continue
+			}
+
+			o.Logger().Debugf("stripping %s", strings.TrimPrefix(match, root))
+			if err := os.RemoveAll(match); err != nil {
+				return fmt.Errorf("stripping %s: %w", match, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestStripContents(t *testing.T) {
+	wd := t.TempDir()
+	outside := t.TempDir()
+	for _, p := range []string{
+		"usr/share/doc/busybox/README",
+		"usr/share/man/man1/ls.1",
+		"usr/share/locale/de/LC_MESSAGES/app.mo",
+		"usr/share/locale/locale.alias",
+		"usr/bin/app",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(wd, filepath.Dir(p)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(wd, p), []byte("data"), 0o644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "keep"), []byte("data"), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(wd, "escape")))
+
+	ic := &types.ImageConfiguration{}
+	ic.Contents.Strip.Docs = true
+	ic.Contents.Strip.Paths = []string{"/usr/share/locale/de", "/escape/*"}
+
+	di := defaultBuildImplementation{}
+	o := &options.Options{Log: &logrus.Logger{}, WorkDir: wd}
+	require.NoError(t, di.StripContents(o, ic))
+
+	require.NoDirExists(t, filepath.Join(wd, "usr/share/doc"))
+	require.NoDirExists(t, filepath.Join(wd, "usr/share/man"))
+	require.NoDirExists(t, filepath.Join(wd, "usr/share/locale/de"))
+	require.FileExists(t, filepath.Join(wd, "usr/share/locale/locale.alias"))
+	require.FileExists(t, filepath.Join(wd, "usr/bin/app"))
+	require.FileExists(t, filepath.Join(outside, "keep"))
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// WriteTimezone writes /etc/timezone and points /etc/localtime at the
+// zoneinfo file of the configured timezone, if any.
+func (di *defaultBuildImplementation) WriteTimezone(o *options.Options, ic *types.ImageConfiguration) error {
+	if ic.Timezone == "" {
+		return nil
+	}
+
+	o.Logger().Infof("setting timezone to %s", ic.Timezone)
+
+	etc := filepath.Join(o.WorkDir, "etc")
+	if err := os.MkdirAll(etc, 0o755); err != nil {
+		return fmt.Errorf("creating /etc: %w", err)
+	}
+
+	// #nosec G306 -- /etc/timezone must be readable by any user
+	if err := os.WriteFile(filepath.Join(etc, "timezone"), []byte(ic.Timezone+"\n"), 0o644); err != nil {
+		return fmt.Errorf("writing /etc/timezone: %w", err)
+	}
+
+	localtime := filepath.Join(etc, "localtime")
+	if err := os.Remove(localtime); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing /etc/localtime: %w", err)
+	}
+
+	if err := os.Symlink(filepath.Join("/usr/share/zoneinfo", ic.Timezone), localtime); err != nil {
+		return fmt.Errorf("linking /etc/localtime: %w", err)
+	}
+
+	return nil
+}
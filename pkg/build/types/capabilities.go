@@ -0,0 +1,108 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The prefix of the annotations describing the capabilities of the image.
+const capabilitiesAnnotationPrefix = "io.apko.security.capabilities."
+
+// The Linux capabilities, see capabilities(7), named as in Kubernetes
+// security contexts.
+var linuxCapabilities = map[string]struct{}{
+	"AUDIT_CONTROL": {}, "AUDIT_READ": {}, "AUDIT_WRITE": {}, "BLOCK_SUSPEND": {},
+	"BPF": {}, "CHECKPOINT_RESTORE": {}, "CHOWN": {}, "DAC_OVERRIDE": {},
+	"DAC_READ_SEARCH": {}, "FOWNER": {}, "FSETID": {}, "IPC_LOCK": {},
+	"IPC_OWNER": {}, "KILL": {}, "LEASE": {}, "LINUX_IMMUTABLE": {},
+	"MAC_ADMIN": {}, "MAC_OVERRIDE": {}, "MKNOD": {}, "NET_ADMIN": {},
+	"NET_BIND_SERVICE": {}, "NET_BROADCAST": {}, "NET_RAW": {}, "PERFMON": {},
+	"SETFCAP": {}, "SETGID": {}, "SETPCAP": {}, "SETUID": {},
+	"SYS_ADMIN": {}, "SYS_BOOT": {}, "SYS_CHROOT": {}, "SYS_MODULE": {},
+	"SYS_NICE": {}, "SYS_PACCT": {}, "SYS_PTRACE": {}, "SYS_RAWIO": {},
+	"SYS_RESOURCE": {}, "SYS_TIME": {}, "SYS_TTY_CONFIG": {}, "SYSLOG": {},
+	"WAKE_ALARM": {},
+}
+
+// allCapabilities drops every capability not required.
+const allCapabilities = "ALL"
+
+// normalizeCapability returns the name of a capability without the CAP_
+// prefix, in upper case.
+func normalizeCapability(name string) string {
+	return strings.TrimPrefix(strings.ToUpper(name), "CAP_")
+}
+
+// validate checks that the capabilities are known, and that none is both
+// required and dropped. ALL may only be dropped.
+func (c Capabilities) validate() error {
+	required := map[string]struct{}{}
+	for _, name := range c.Required {
+		capability := normalizeCapability(name)
+		if _, ok := linuxCapabilities[capability]; !ok {
+			return fmt.Errorf("required capability %q is not a known Linux capability", name)
+		}
+		required[capability] = struct{}{}
+	}
+
+	for _, name := range c.Dropped {
+		capability := normalizeCapability(name)
+		if capability == allCapabilities {
+			continue
+		}
+		if _, ok := linuxCapabilities[capability]; !ok {
+			return fmt.Errorf("dropped capability %q is not a known Linux capability", name)
+		}
+		if _, ok := required[capability]; ok {
+			return fmt.Errorf("capability %q is both required and dropped", name)
+		}
+	}
+
+	return nil
+}
+
+// capabilityList returns the normalized capabilities, sorted and without
+// duplicates, joined with commas.
+func capabilityList(names []string) string {
+	seen := map[string]struct{}{}
+	list := []string{}
+	for _, name := range names {
+		capability := normalizeCapability(name)
+		if _, ok := seen[capability]; ok {
+			continue
+		}
+		seen[capability] = struct{}{}
+		list = append(list, capability)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// addCapabilityAnnotations adds io.apko.security.capabilities.required
+// and .dropped annotations listing the configured capabilities, keeping
+// any annotation which is already configured.
+func (ic *ImageConfiguration) addCapabilityAnnotations() {
+	capabilities := map[string]string{}
+	if len(ic.Capabilities.Required) > 0 {
+		capabilities[capabilitiesAnnotationPrefix+"required"] = capabilityList(ic.Capabilities.Required)
+	}
+	if len(ic.Capabilities.Dropped) > 0 {
+		capabilities[capabilitiesAnnotationPrefix+"dropped"] = capabilityList(ic.Capabilities.Dropped)
+	}
+	ic.addDefaultAnnotations(capabilities)
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilities(t *testing.T) {
+	ic := ImageConfiguration{Annotations: map[string]string{
+		"io.apko.security.capabilities.dropped": "ALL",
+	}}
+	ic.Capabilities.Required = []string{"net_bind_service", "CAP_CHOWN", "CHOWN"}
+	ic.Capabilities.Dropped = []string{"all", "NET_RAW"}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, map[string]string{
+		"io.apko.security.capabilities.required": "CHOWN,NET_BIND_SERVICE",
+		"io.apko.security.capabilities.dropped":  "ALL",
+	}, ic.Annotations)
+
+	for _, tc := range []struct {
+		required, dropped []string
+		msg               string
+	}{
+		{required: []string{"NET_FLY"}, msg: "required capability \"NET_FLY\" is not a known"},
+		{required: []string{"ALL"}, msg: "not a known Linux capability"},
+		{dropped: []string{"CAP_SYS_EVERYTHING"}, msg: "dropped capability"},
+		{required: []string{"NET_RAW"}, dropped: []string{"cap_net_raw"}, msg: "both required and dropped"},
+	} {
+		ic = ImageConfiguration{}
+		ic.Capabilities.Required = tc.required
+		ic.Capabilities.Dropped = tc.dropped
+		require.ErrorContains(t, ic.Validate(), tc.msg)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import "fmt"
+
+// The prefix of the annotations describing the CI run.
+const ciAnnotationPrefix = "io.apko.build."
+
+// ciProvider maps the environment variables of a CI provider to the
+// build annotations they populate.
+type ciProvider struct {
+	name string
+	// detect is the variable which is set to "true" by the provider.
+	detect string
+	vars   map[string]string
+	// runURL builds the URL of the CI run, if the provider has no
+	// variable holding it.
+	runURL func(getenv func(string) string) string
+}
+
+var ciProviders = []ciProvider{
+	{
+		name:   "github-actions",
+		detect: "GITHUB_ACTIONS",
+		vars: map[string]string{
+			"run-id":   "GITHUB_RUN_ID",
+			"workflow": "GITHUB_WORKFLOW",
+			"actor":    "GITHUB_ACTOR",
+			"revision": "GITHUB_SHA",
+			"ref":      "GITHUB_REF",
+		},
+		runURL: func(getenv func(string) string) string {
+			server, repo, id := getenv("GITHUB_SERVER_URL"), getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID")
+			if server == "" || repo == "" || id == "" {
+				return ""
+			}
+			return fmt.Sprintf("%s/%s/actions/runs/%s", server, repo, id)
+		},
+	},
+	{
+		name:   "gitlab-ci",
+		detect: "GITLAB_CI",
+		vars: map[string]string{
+			"run-id":   "CI_PIPELINE_ID",
+			"run-url":  "CI_PIPELINE_URL",
+			"workflow": "CI_JOB_NAME",
+			"actor":    "GITLAB_USER_LOGIN",
+			"revision": "CI_COMMIT_SHA",
+			"ref":      "CI_COMMIT_REF_NAME",
+		},
+	},
+}
+
+// ciAnnotations returns the annotations describing the CI run detected
+// from the environment, or nil when not running in a known CI provider.
+func ciAnnotations(getenv func(string) string) map[string]string {
+	for _, p := range ciProviders {
+		if getenv(p.detect) != "true" {
+			continue
+		}
+
+		annotations := map[string]string{ciAnnotationPrefix + "provider": p.name}
+		for key, env := range p.vars {
+			if v := getenv(env); v != "" {
+				annotations[ciAnnotationPrefix+key] = v
+			}
+		}
+
+		if p.runURL != nil {
+			if url := p.runURL(getenv); url != "" {
+				annotations[ciAnnotationPrefix+"run-url"] = url
+			}
+		}
+
+		return annotations
+	}
+
+	return nil
+}
+
+// addCIAnnotations adds the annotations describing the detected CI run,
+// keeping any annotation which is already configured.
+func (ic *ImageConfiguration) addCIAnnotations(getenv func(string) string) {
+	ic.addDefaultAnnotations(ciAnnotations(getenv))
+}
@@ -0,0 +1,50 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddCIAnnotations(t *testing.T) {
+	env := map[string]string{
+		"GITHUB_ACTIONS":    "true",
+		"GITHUB_RUN_ID":     "42",
+		"GITHUB_WORKFLOW":   "release",
+		"GITHUB_ACTOR":      "octocat",
+		"GITHUB_SERVER_URL": "https://github.com",
+		"GITHUB_REPOSITORY": "chainguard-dev/apko",
+	}
+	getenv := func(k string) string { return env[k] }
+
+	ic := ImageConfiguration{Annotations: map[string]string{
+		"io.apko.build.actor": "release-bot",
+	}}
+	ic.addCIAnnotations(getenv)
+	require.Equal(t, map[string]string{
+		"io.apko.build.provider": "github-actions",
+		"io.apko.build.run-id":   "42",
+		"io.apko.build.run-url":  "https://github.com/chainguard-dev/apko/actions/runs/42",
+		"io.apko.build.workflow": "release",
+		"io.apko.build.actor":    "release-bot",
+	}, ic.Annotations)
+
+	// Nothing is added outside of CI.
+	ic = ImageConfiguration{}
+	ic.addCIAnnotations(func(string) string { return "" })
+	require.Empty(t, ic.Annotations)
+}
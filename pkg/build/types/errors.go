@@ -0,0 +1,73 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"errors"
+)
+
+// ErrConfigParse matches, with errors.Is, the errors returned when an
+// image configuration cannot be parsed.
+var ErrConfigParse = errors.New("failed to parse image configuration")
+
+// ErrVCSProbe matches, with errors.Is, the errors returned when the VCS
+// URL of an image configuration cannot be detected.
+var ErrVCSProbe = errors.New("failed to probe VCS URL")
+
+// ValidationError is returned by Validate when the image configuration
+// is invalid.
+type ValidationError struct {
+	// Field is the path of the invalid field, as written in the YAML of
+	// the configuration, e.g. "contents.packages".
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// invalid wraps the error found validating field into a ValidationError,
+// unless it already is one.
+func invalid(field string, err error) error {
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return err
+	}
+	return &ValidationError{Field: field, Err: err}
+}
+
+// sentinelError wraps an error so that it also matches a sentinel error,
+// while keeping its message.
+type sentinelError struct {
+	sentinel error
+	err      error
+}
+
+func (e *sentinelError) Error() string {
+	return e.sentinel.Error() + ": " + e.err.Error()
+}
+
+func (e *sentinelError) Unwrap() error {
+	return e.err
+}
+
+func (e *sentinelError) Is(target error) bool {
+	return target == e.sentinel
+}
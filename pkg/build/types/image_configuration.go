@@ -15,9 +15,29 @@
 package types
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
+	// The timezone is validated against the embedded zone database, so
+	// that validation does not depend on the tzdata of the host.
+	_ "time/tzdata"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/shlex"
 	"github.com/jinzhu/copier"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -26,24 +46,110 @@ import (
 	"chainguard.dev/apko/pkg/vcs"
 )
 
+// The version policies of the packages to install.
+const (
+	VersionPolicyFloating = "floating"
+	VersionPolicyPinned   = "pinned"
+)
+
+// The commands, by package, of the init processes which entrypoints are
+// wrapped with when entrypoint.init is set.
+var initCommands = map[string][]string{
+	"tini":      {"/sbin/tini", "--"},
+	"dumb-init": {"/usr/bin/dumb-init", "--"},
+}
+
+const defaultInitPackage = "tini"
+
+// The annotation recording the stop timeout of the entrypoint, which has
+// no field in the OCI image config.
+const stopTimeoutAnnotation = "io.apko.stop-timeout"
+
+// The annotation listing the paths to mount as tmpfs at runtime, which
+// OCI images have no field for.
+const tmpfsAnnotation = "io.apko.tmpfs"
+
+// The environment variables set in the image unless the configuration
+// sets them.
+var defaultEnvironment = map[string]string{
+	"PATH":          "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	"SSL_CERT_FILE": "/etc/ssl/certs/ca-certificates.crt",
+}
+
+// The annotation recording the source of the image, derived from the VCS
+// URL as the label of the same name is.
+const sourceAnnotation = "org.opencontainers.image.source"
+
+// The format of umasks: three octal digits, optionally with a leading 0.
+var umaskRegexp = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+// umaskWrapper runs the entrypoint or cmd, given as its arguments, with
+// the umask applied. The shell execs it, so it keeps the PID of the
+// wrapper, e.g. 1.
+func umaskWrapper(umask string) []string {
+	return []string{"/bin/sh", "-c", fmt.Sprintf(`umask %s && exec "$@"`, umask), "umask"}
+}
+
+// AllowedAPKOptions are the apk flags which may be passed to the apk
+// operations of a build with contents.apk-options. Flags which change
+// where apk reads or writes, or how packages are verified, are left out.
+var AllowedAPKOptions = []string{
+	"--clean-protected",
+	"--force-broken-world",
+	"--force-overwrite",
+	"--force-refresh",
+	"--no-network",
+	"--no-progress",
+	"--no-scripts",
+	"--purge",
+	"--quiet",
+	"--verbose",
+}
+
+// The entrypoint command used to start the supervision tree of a
+// service bundle.
+const serviceBundleCommand = "/bin/s6-svscan /sv"
+
+// EntrypointDispatcherPath is the path of the generated script which
+// runs the named entrypoint selected with APP_ENTRYPOINT.
+const EntrypointDispatcherPath = "/usr/local/bin/apko-entrypoint"
+
 // Attempt to probe an upstream VCS URL if known.
 func (ic *ImageConfiguration) ProbeVCSUrl(imageConfigPath string, logger *logrus.Entry) {
-	url, err := vcs.ProbeDirFromPath(imageConfigPath)
-	if err != nil {
-		logger.Debugf("failed to probe VCS URL: %v", err)
+	if err := ic.DetectVCSUrl(imageConfigPath); err != nil {
+		logger.Debugf("%v", err)
 		return
 	}
 
+	if ic.VCSUrl != "" {
+		logger.Printf("detected %s as VCS URL", ic.VCSUrl)
+	}
+	if ic.VCSSubPath != "" {
+		logger.Printf("detected %s as path in the VCS repository", ic.VCSSubPath)
+	}
+}
+
+// DetectVCSUrl sets VCSUrl to the upstream URL of the Git repository
+// containing the image configuration, if any, and VCSSubPath to the
+// directory of the configuration in it. The URL is kept when the
+// directory cannot be determined. The errors returned match ErrVCSProbe.
+func (ic *ImageConfiguration) DetectVCSUrl(imageConfigPath string) error {
+	url, subPath, err := vcs.ProbeFromPath(imageConfigPath)
 	if url != "" {
 		ic.VCSUrl = url
-		logger.Printf("detected %s as VCS URL", ic.VCSUrl)
 	}
+	if err != nil {
+		return &sentinelError{sentinel: ErrVCSProbe, err: err}
+	}
+
+	ic.VCSSubPath = subPath
+	return nil
 }
 
 // Parse a configuration blob into an ImageConfiguration struct.
 func (ic *ImageConfiguration) parse(configData []byte, logger *logrus.Entry) error {
 	if err := yaml.Unmarshal(configData, ic); err != nil {
-		return fmt.Errorf("failed to parse image configuration: %w", err)
+		return &sentinelError{sentinel: ErrConfigParse, err: err}
 	}
 
 	if ic.Include != "" {
@@ -54,46 +160,227 @@ func (ic *ImageConfiguration) parse(configData []byte, logger *logrus.Entry) err
 		if err := baseIc.Load(ic.Include, logger); err != nil {
 			return fmt.Errorf("failed to read include file: %w", err)
 		}
+		// copying the configurations below may reset them
+		includes := baseIc.configFiles
 
-		mergedIc := ImageConfiguration{}
-
-		// Copy the base configuration...
-		if err := copier.Copy(&mergedIc, &baseIc); err != nil {
-			return fmt.Errorf("failed to copy base configuration: %w", err)
-		}
-
-		// ... and then overlay the local configuration on top.
-		if err := copier.CopyWithOption(&mergedIc, ic, copier.Option{IgnoreEmpty: true}); err != nil {
-			return fmt.Errorf("failed to overlay specific configuration: %w", err)
+		// Overlay the local configuration on top of the base configuration.
+		if err := baseIc.Merge(ic); err != nil {
+			return err
 		}
 
 		// Now copy the merged configuration back to ic.
-		if err := copier.Copy(ic, &mergedIc); err != nil {
+		if err := copier.Copy(ic, &baseIc); err != nil {
 			return fmt.Errorf("failed to copy merged configuration: %w", err)
 		}
+		ic.configFiles = includes
+	}
+
+	return nil
+}
+
+// Merge layers the overlay configuration on top of ic. Non-empty fields
+// of the overlay replace those of ic, while the repositories, keyrings and
+// packages of both configurations are concatenated, and their package
+// groups combined.
+func (ic *ImageConfiguration) Merge(overlay *ImageConfiguration) error {
+	baseIc := ImageConfiguration{}
+	mergedIc := ImageConfiguration{}
+
+	// Keep a copy of the base configuration...
+	if err := copier.Copy(&baseIc, ic); err != nil {
+		return fmt.Errorf("failed to copy base configuration: %w", err)
+	}
+
+	if err := copier.Copy(&mergedIc, ic); err != nil {
+		return fmt.Errorf("failed to copy base configuration: %w", err)
+	}
+
+	// ... and then overlay the other configuration on top.
+	if err := copier.CopyWithOption(&mergedIc, overlay, copier.Option{IgnoreEmpty: true}); err != nil {
+		return fmt.Errorf("failed to overlay specific configuration: %w", err)
+	}
+
+	// Now copy the merged configuration back to ic.
+	if err := copier.Copy(ic, &mergedIc); err != nil {
+		return fmt.Errorf("failed to copy merged configuration: %w", err)
+	}
+
+	// Merge packages, repositories and keyrings.
+	keyring := append([]string{}, baseIc.Contents.Keyring...)
+	keyring = append(keyring, mergedIc.Contents.Keyring...)
+	ic.Contents.Keyring = keyring
+
+	repos := append([]string{}, baseIc.Contents.Repositories...)
+	repos = append(repos, mergedIc.Contents.Repositories...)
+	ic.Contents.Repositories = repos
 
-		// Merge packages, repositories and keyrings.
-		keyring := append([]string{}, baseIc.Contents.Keyring...)
-		keyring = append(keyring, mergedIc.Contents.Keyring...)
-		ic.Contents.Keyring = keyring
+	pkgs := append([]string{}, baseIc.Contents.Packages...)
+	pkgs = append(pkgs, mergedIc.Contents.Packages...)
+	ic.Contents.Packages = pkgs
 
-		repos := append([]string{}, baseIc.Contents.Repositories...)
-		repos = append(repos, mergedIc.Contents.Repositories...)
-		ic.Contents.Repositories = repos
+	// Groups of the overlay replace those of the base with the same name.
+	if len(baseIc.Contents.PackageGroups) > 0 || len(overlay.Contents.PackageGroups) > 0 {
+		groups := map[string][]string{}
+		for name, group := range baseIc.Contents.PackageGroups {
+			groups[name] = group
+		}
+		for name, group := range overlay.Contents.PackageGroups {
+			groups[name] = group
+		}
+		ic.Contents.PackageGroups = groups
+	}
 
-		pkgs := append([]string{}, baseIc.Contents.Packages...)
-		pkgs = append(pkgs, mergedIc.Contents.Packages...)
-		ic.Contents.Packages = pkgs
+	// As are profiles.
+	if len(baseIc.Profiles) > 0 || len(overlay.Profiles) > 0 {
+		profiles := map[string]Profile{}
+		for name, profile := range baseIc.Profiles {
+			profiles[name] = profile
+		}
+		for name, profile := range overlay.Profiles {
+			profiles[name] = profile
+		}
+		ic.Profiles = profiles
 	}
 
 	return nil
 }
 
+// Make any relative paths in the configuration relative to the
+// directory containing the configuration file.
+func (ic *ImageConfiguration) resolvePaths(configDir string) {
+	resolve := func(paths []string) {
+		for i, p := range paths {
+			if !filepath.IsAbs(p) {
+				paths[i] = filepath.Join(configDir, p)
+			}
+		}
+	}
+
+	resolve(ic.Contents.PreInstall)
+	resolve(ic.Contents.PostInstall)
+	resolve(ic.Contents.LocalPackages)
+	resolve(ic.EnvironmentFiles)
+
+	for i, f := range ic.Contents.Files {
+		if f.Source != "" && !filepath.IsAbs(f.Source) {
+			ic.Contents.Files[i].Source = filepath.Join(configDir, f.Source)
+		}
+	}
+
+	for i, entry := range ic.Contents.Repositories {
+		// the predicate, if any, is kept as is and checked on validation
+		repo, predicate := entry, ""
+		if start := strings.LastIndex(entry, repositoryArchPredicate); start != -1 && strings.HasSuffix(entry, "]") {
+			repo, predicate = strings.TrimSpace(entry[:start]), entry[start:]
+		}
+		ic.Contents.Repositories[i] = resolveFileRepository(configDir, repo) + predicate
+	}
+
+	if ic.Contents.CacheDir != "" && !filepath.IsAbs(ic.Contents.CacheDir) {
+		ic.Contents.CacheDir = filepath.Join(configDir, ic.Contents.CacheDir)
+	}
+
+	if ic.SBOM.Path != "" && !filepath.IsAbs(ic.SBOM.Path) {
+		ic.SBOM.Path = filepath.Join(configDir, ic.SBOM.Path)
+	}
+}
+
+// resolveFileRepository rewrites a relative file:// repository, optionally
+// tagged (e.g. "@local file://./packages"), to an absolute file:// URL
+// based on configDir. Any other repository is returned as is.
+func resolveFileRepository(configDir, repo string) string {
+	tag, url := "", repo
+	if strings.HasPrefix(repo, "@") {
+		if fields := strings.Fields(repo); len(fields) == 2 {
+			tag, url = fields[0]+" ", fields[1]
+		}
+	}
+
+	if !strings.HasPrefix(url, "file://") {
+		return repo
+	}
+	path := strings.TrimPrefix(url, "file://")
+	if filepath.IsAbs(path) {
+		return repo
+	}
+
+	dir, err := filepath.Abs(configDir)
+	if err != nil {
+		dir = configDir
+	}
+
+	return tag + "file://" + filepath.Join(dir, path)
+}
+
+var environmentKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnvironmentFile parses the KEY=VALUE lines of an environment file.
+// Empty lines and lines starting with # are ignored, and values may be
+// quoted.
+func parseEnvironmentFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading environment file: %w", err)
+	}
+
+	env := map[string]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || !environmentKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: malformed environment line %q, expected KEY=VALUE", path, i+1, line)
+		}
+
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[key] = value
+	}
+
+	return env, nil
+}
+
+// loadEnvironmentFiles merges the environment files into the environment.
+// Later files override earlier ones, and the inline environment overrides
+// them all. Files which can't be parsed are skipped, Validate reports them.
+func (ic *ImageConfiguration) loadEnvironmentFiles() {
+	if len(ic.EnvironmentFiles) == 0 {
+		return
+	}
+
+	merged := map[string]string{}
+	for _, path := range ic.EnvironmentFiles {
+		env, err := parseEnvironmentFile(path)
+		if err != nil {
+			continue
+		}
+		for k, v := range env {
+			merged[k] = v
+		}
+	}
+
+	for k, v := range ic.Environment {
+		merged[k] = v
+	}
+	ic.Environment = merged
+}
+
 // Loads an image configuration given a configuration file path.
 func (ic *ImageConfiguration) Load(imageConfigPath string, logger *logrus.Entry) error {
 	data, err := os.ReadFile(imageConfigPath)
 	if err == nil {
-		return ic.parse(data, logger)
+		if err := ic.parse(data, logger); err != nil {
+			return err
+		}
+
+		ic.resolvePaths(filepath.Dir(imageConfigPath))
+		ic.loadEnvironmentFiles()
+		ic.configFiles = append([]string{imageConfigPath}, ic.configFiles...)
+		return nil
 	}
 
 	// At this point, we're doing a remote config file.
@@ -104,39 +391,386 @@ func (ic *ImageConfiguration) Load(imageConfigPath string, logger *logrus.Entry)
 		return fmt.Errorf("unable to fetch remote include from git: %w", err)
 	}
 
+	return ic.parseRemote(imageConfigPath, data, logger)
+}
+
+// parseRemote parses a configuration fetched from a remote location.
+// Its environment files could only be resolved against the working
+// directory rather than its own location, so they are rejected.
+func (ic *ImageConfiguration) parseRemote(imageConfigPath string, data []byte, logger *logrus.Entry) error {
+	var remote struct {
+		EnvironmentFiles []string `yaml:"environment-files"`
+	}
+	if err := yaml.Unmarshal(data, &remote); err != nil {
+		return &sentinelError{sentinel: ErrConfigParse, err: err}
+	}
+	if len(remote.EnvironmentFiles) > 0 {
+		return fmt.Errorf("remote configuration %s sets environment-files, which are only supported in local configurations", imageConfigPath)
+	}
+
 	return ic.parse(data, logger)
 }
 
+// LoadMany loads several image configuration files and merges them in
+// order, using the first file as the base configuration. Each following
+// file is layered on top according to the semantics of Merge.
+func LoadMany(paths []string, logger *logrus.Entry) (*ImageConfiguration, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no configuration files given")
+	}
+
+	ic := &ImageConfiguration{}
+	if err := ic.Load(paths[0], logger); err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", paths[0], err)
+	}
+	typeSource := paths[0]
+
+	for _, path := range paths[1:] {
+		overlay := ImageConfiguration{}
+		if err := overlay.Load(path, logger); err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", path, err)
+		}
+
+		if overlay.Entrypoint.Type != "" {
+			if ic.Entrypoint.Type != "" && ic.Entrypoint.Type != overlay.Entrypoint.Type {
+				return nil, fmt.Errorf(
+					"%s sets entrypoint type %q, which conflicts with type %q set in %s",
+					path, overlay.Entrypoint.Type, ic.Entrypoint.Type, typeSource,
+				)
+			}
+			typeSource = path
+		}
+
+		configFiles := append(ic.configFiles, overlay.configFiles...)
+		if err := ic.Merge(&overlay); err != nil {
+			return nil, fmt.Errorf("failed to merge %s: %w", path, err)
+		}
+		ic.configFiles = configFiles
+	}
+
+	return ic, nil
+}
+
+// LoadWithOverlay loads the base image configuration and layers the
+// environment specific overlay, e.g. config.prod.yaml, on top of it with
+// the semantics of Merge. Unless one of them sets it, the VCS URL is
+// probed from the directory of the base configuration.
+func LoadWithOverlay(base, overlay string, logger *logrus.Entry) (*ImageConfiguration, error) {
+	ic, err := LoadMany([]string{base, overlay}, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if ic.VCSUrl == "" {
+		ic.ProbeVCSUrl(base, logger)
+	}
+
+	return ic, nil
+}
+
 // Do preflight checks and mutations on an image configuration.
 func (ic *ImageConfiguration) Validate() error {
+	return ic.validate(true, os.Getenv)
+}
+
+// validate checks the image configuration, also checking the files it
+// references, such as hooks and local packages, and the tz database for
+// the timezone when checkFiles is set. The CI annotations are derived from getenv, unless it is nil.
+func (ic *ImageConfiguration) validate(checkFiles bool, getenv func(string) string) error {
+	// The entrypoint of the profile is checked as the base one is.
+	if err := ic.applyProfile(); err != nil {
+		return invalid("profiles", err)
+	}
+
 	if ic.Entrypoint.Type == "service-bundle" {
 		if err := ic.ValidateServiceBundle(); err != nil {
-			return err
+			return invalid("entrypoint", err)
+		}
+	}
+
+	if err := ic.validateEntrypoints(); err != nil {
+		return invalid("entrypoints", err)
+	}
+
+	if len(ic.CmdArgs) != 0 {
+		if ic.Cmd != "" {
+			return invalid("cmd-args", fmt.Errorf("cmd and cmd-args are mutually exclusive"))
+		}
+
+		for i, arg := range ic.CmdArgs {
+			if strings.TrimSpace(arg) == "" {
+				return invalid("cmd-args", fmt.Errorf("cmd-args entry %d is empty", i))
+			}
+		}
+	}
+
+	switch ic.Contents.VersionPolicy {
+	case "", VersionPolicyFloating, VersionPolicyPinned:
+	default:
+		return invalid("contents.version-policy", fmt.Errorf("unknown version policy %q, must be %q or %q",
+			ic.Contents.VersionPolicy, VersionPolicyFloating, VersionPolicyPinned))
+	}
+
+	if err := ic.validatePackageGroups(); err != nil {
+		return invalid("contents.package-groups", err)
+	}
+
+	for _, pkg := range ic.expandedPackages() {
+		spec, _, err := parsePackage(pkg)
+		if err != nil {
+			return invalid("contents.packages", err)
+		}
+
+		if ic.Contents.VersionPolicy == VersionPolicyPinned && !isPinned(spec) {
+			return invalid("contents.packages", fmt.Errorf("package %q is not pinned to a version as required by the pinned version policy", pkg))
+		}
+	}
+
+	if err := ic.validateInit(); err != nil {
+		return invalid("entrypoint.init", err)
+	}
+
+	if err := ic.validateScratch(); err != nil {
+		return invalid("scratch", err)
+	}
+
+	if err := ic.validatePorts(); err != nil {
+		return invalid("ports", err)
+	}
+
+	if ic.Entrypoint.Umask != "" && !umaskRegexp.MatchString(ic.Entrypoint.Umask) {
+		return invalid("entrypoint.umask", fmt.Errorf("umask %q must be a 3 or 4 digit octal mask, e.g. 022 or 0027", ic.Entrypoint.Umask))
+	}
+
+	if len(ic.Contents.InstallOrder) != 0 {
+		pkgs := map[string]struct{}{}
+		for _, pkg := range ic.expandedPackages() {
+			pkgs[packageName(pkg)] = struct{}{}
+		}
+
+		for _, pkg := range ic.Contents.InstallOrder {
+			if _, ok := pkgs[packageName(pkg)]; !ok {
+				return invalid("contents.install-order", fmt.Errorf("package %q in install order is not listed in packages", pkg))
+			}
+		}
+	}
+
+	if err := ic.validatePackageChecksums(); err != nil {
+		return invalid("contents.package-checksums", err)
+	}
+
+	for _, opt := range ic.Contents.APKOptions {
+		if !isAllowedAPKOption(opt) {
+			return invalid("contents.apk-options", fmt.Errorf("apk option %q is not allowed, must be one of %s", opt, strings.Join(AllowedAPKOptions, ", ")))
+		}
+	}
+
+	if checkFiles {
+		for _, hooks := range []struct {
+			field string
+			hooks []string
+		}{
+			{"contents.pre-install", ic.Contents.PreInstall},
+			{"contents.post-install", ic.Contents.PostInstall},
+		} {
+			for _, hook := range hooks.hooks {
+				if err := validateHook(hook); err != nil {
+					return invalid(hooks.field, err)
+				}
+			}
+		}
+	}
+
+	for _, pkg := range ic.SBOM.ExcludePackages {
+		if pkg == "" || packageName(pkg) != pkg || strings.ContainsAny(pkg, " ]") {
+			return invalid("sbom.exclude-packages", fmt.Errorf("excluded package %q must be a package name, without a version or predicate", pkg))
+		}
+	}
+
+	if checkFiles {
+		for _, pkg := range ic.Contents.LocalPackages {
+			if err := validateLocalPackage(pkg); err != nil {
+				return invalid("contents.local-packages", err)
+			}
+		}
+
+		if ic.Contents.CacheDir != "" {
+			if err := ValidateCacheDir(ic.Contents.CacheDir); err != nil {
+				return invalid("contents.cache-dir", err)
+			}
+		}
+	}
+
+	if ic.Contents.BaseImage != "" {
+		if _, err := name.ParseReference(ic.Contents.BaseImage); err != nil {
+			return invalid("contents.base-image", fmt.Errorf("parsing base image reference %q: %w", ic.Contents.BaseImage, err))
+		}
+	}
+
+	for _, f := range ic.Contents.Files {
+		if f.Source == "" {
+			return invalid("contents.files", fmt.Errorf("configured file %v has no source", f))
+		}
+
+		if !filepath.IsAbs(f.Destination) {
+			return invalid("contents.files", fmt.Errorf("configured file destination %q is not an absolute path", f.Destination))
+		}
+
+		if escapesRoot(f.Destination) {
+			return invalid("contents.files", fmt.Errorf("configured file destination %q is outside of the image", f.Destination))
+		}
+	}
+
+	if ic.Contents.MaxFileSize < 0 {
+		return invalid("contents.max-file-size", fmt.Errorf("maximum file size %d must not be negative", ic.Contents.MaxFileSize))
+	}
+	if ic.Contents.MaxTotalSize < 0 {
+		return invalid("contents.max-total-size", fmt.Errorf("maximum size of the files %d must not be negative", ic.Contents.MaxTotalSize))
+	}
+
+	if checkFiles {
+		sizes := make([]int64, 0, len(ic.Contents.Files))
+		for _, f := range ic.Contents.Files {
+			fi, err := os.Stat(f.Source)
+			if err != nil {
+				return invalid("contents.files", fmt.Errorf("configured file source %s is not accessible: %w", f.Source, err))
+			}
+
+			if !fi.Mode().IsRegular() {
+				return invalid("contents.files", fmt.Errorf("configured file source %s is not a regular file", f.Source))
+			}
+			sizes = append(sizes, fi.Size())
+		}
+
+		if err := ic.checkFileSizes(sizes); err != nil {
+			return invalid("contents.files", err)
+		}
+
+		for _, path := range ic.EnvironmentFiles {
+			if _, err := parseEnvironmentFile(path); err != nil {
+				return invalid("environment-files", err)
+			}
+		}
+	}
+
+	for _, pattern := range ic.Contents.Strip.Paths {
+		if err := validateStripPattern(pattern); err != nil {
+			return invalid("contents.strip.paths", err)
+		}
+	}
+
+	for _, repo := range ic.Contents.Repositories {
+		if _, _, err := parseRepository(repo); err != nil {
+			return invalid("contents.repositories", err)
+		}
+	}
+
+	if err := ic.Contents.ResolvConf.validate(); err != nil {
+		return invalid("contents.resolv-conf", err)
+	}
+
+	for _, entry := range ic.Contents.Hosts {
+		if err := validateHostsEntry(entry); err != nil {
+			return invalid("contents.hosts", err)
+		}
+	}
+
+	if v := ic.Contents.APKToolsVersion; v != "" && !apkToolsVersionRegexp.MatchString(v) {
+		return invalid("contents.apk-tools-version", fmt.Errorf("apk-tools version %q is not a valid version", v))
+	}
+
+	for _, pattern := range ic.Setuid.Allow {
+		if !filepath.IsAbs(pattern) {
+			return invalid("setuid.allow", fmt.Errorf("setuid allow path %q is not an absolute path", pattern))
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return invalid("setuid.allow", fmt.Errorf("setuid allow path %q is not a valid pattern: %w", pattern, err))
 		}
 	}
 
 	for _, u := range ic.Accounts.Users {
 		if u.UserName == "" {
-			return fmt.Errorf("configured user %v has no configured user name", u)
+			return invalid("accounts.users", fmt.Errorf("configured user %v has no configured user name", u))
 		}
 
 		if u.UID == 0 {
-			return fmt.Errorf("configured user %v has UID 0", u)
+			return invalid("accounts.users", fmt.Errorf("configured user %v has UID 0", u))
+		}
+
+		if !ic.Accounts.AllowLegacyNames && !portableNameRegexp.MatchString(u.UserName) {
+			return invalid("accounts.users", fmt.Errorf("user name %q is not a portable POSIX name", u.UserName))
 		}
 	}
 
 	for _, g := range ic.Accounts.Groups {
 		if g.GroupName == "" {
-			return fmt.Errorf("configured group %v has no configured group name", g)
+			return invalid("accounts.groups", fmt.Errorf("configured group %v has no configured group name", g))
 		}
 
 		if g.GID == 0 {
-			return fmt.Errorf("configured group %v has GID 0", g)
+			return invalid("accounts.groups", fmt.Errorf("configured group %v has GID 0", g))
+		}
+
+		if !ic.Accounts.AllowLegacyNames && !portableNameRegexp.MatchString(g.GroupName) {
+			return invalid("accounts.groups", fmt.Errorf("group name %q is not a portable POSIX name", g.GroupName))
+		}
+	}
+
+	if err := ic.validateIDs(); err != nil {
+		return err
+	}
+
+	groups := map[string]struct{}{}
+	for _, g := range ic.Accounts.Groups {
+		groups[g.GroupName] = struct{}{}
+	}
+
+	if err := ic.validateGroupMembership(); err != nil {
+		return invalid("accounts.groups", err)
+	}
+
+	for _, u := range ic.Accounts.Users {
+		for _, g := range u.Groups {
+			_, configured := groups[g]
+			_, packaged := packagedGroups[g]
+			if !configured && !packaged {
+				return invalid("accounts.users", fmt.Errorf("user %s is a member of unknown group %s", u.UserName, g))
+			}
+		}
+	}
+
+	if ic.Timezone != "" {
+		if ic.Timezone == "Local" {
+			return invalid("timezone", fmt.Errorf("timezone %q is not a zone name", ic.Timezone))
+		}
+		if checkFiles {
+			if _, err := time.LoadLocation(ic.Timezone); err != nil {
+				return invalid("timezone", fmt.Errorf("unknown timezone %q: %w", ic.Timezone, err))
+			}
+		}
+	}
+
+	if ic.Locale != "" {
+		if !localeRegexp.MatchString(ic.Locale) {
+			return invalid("locale", fmt.Errorf("locale %q is not of the form language[_territory][.codeset][@modifier]", ic.Locale))
+		}
+
+		if _, ok := ic.Environment["LANG"]; !ok {
+			// As in expandEnvironment, build a new map rather than
+			// modifying a possibly shared one.
+			environment := make(map[string]string, len(ic.Environment)+1)
+			for k, v := range ic.Environment {
+				environment[k] = v
+			}
+			environment["LANG"] = ic.Locale
+			ic.Environment = environment
 		}
 	}
 
 	if ic.OSRelease.ID == "" {
 		ic.OSRelease.ID = "alpine"
+	} else if !osReleaseIDRegexp.MatchString(ic.OSRelease.ID) {
+		return invalid("os-release.id", fmt.Errorf("os-release ID %q may only contain lowercase letters, digits, '.', '_' and '-'", ic.OSRelease.ID))
 	}
 
 	if ic.OSRelease.Name == "" {
@@ -152,40 +786,1434 @@ func (ic *ImageConfiguration) Validate() error {
 		ic.OSRelease.HomeURL = "https://github.com/chainguard-dev/apko"
 	}
 
-	return nil
-}
+	// the placeholders are checked once the version is defaulted
+	for _, repo := range ic.Contents.Repositories {
+		if err := ic.validateRepositoryPlaceholders(repo); err != nil {
+			return invalid("contents.repositories", err)
+		}
+	}
 
-// Do preflight checks and mutations on an image configured to manage
-// a service bundle.
-func (ic *ImageConfiguration) ValidateServiceBundle() error {
-	ic.Entrypoint.Command = "/bin/s6-svscan /sv"
+	if err := ic.expandEnvironment(); err != nil {
+		return invalid("environment", err)
+	}
 
-	// It's harmless to have a duplicate entry in /etc/apk/world,
-	// apk will fix it up when the fixate op happens.
-	ic.Contents.Packages = append(ic.Contents.Packages, "s6")
+	if err := ic.expandAnnotations(); err != nil {
+		return invalid("annotations", err)
+	}
 
-	return nil
+	if ic.VCSUrl != "" {
+		ic.addDefaultAnnotations(map[string]string{sourceAnnotation: ic.VCSUrl})
+	}
+
+	if ic.CIAnnotations && getenv != nil {
+		ic.addCIAnnotations(getenv)
+	}
+
+	if err := ic.Capabilities.validate(); err != nil {
+		return invalid("capabilities", err)
+	}
+	ic.addCapabilityAnnotations()
+
+	if ic.Entrypoint.StopTimeout != "" {
+		timeout, err := time.ParseDuration(ic.Entrypoint.StopTimeout)
+		if err != nil {
+			return invalid("entrypoint.stop-timeout", fmt.Errorf("parsing stop timeout %q: %w", ic.Entrypoint.StopTimeout, err))
+		}
+		if timeout <= 0 {
+			return invalid("entrypoint.stop-timeout", fmt.Errorf("stop timeout %q must be positive", ic.Entrypoint.StopTimeout))
+		}
+		ic.addDefaultAnnotations(map[string]string{stopTimeoutAnnotation: timeout.String()})
+	}
+
+	for _, p := range ic.Tmpfs {
+		if err := validateTmpfsPath(p); err != nil {
+			return invalid("tmpfs", err)
+		}
+	}
+	if len(ic.Tmpfs) > 0 {
+		ic.addDefaultAnnotations(map[string]string{tmpfsAnnotation: tmpfsList(ic.Tmpfs)})
+	}
+
+	return nil
+}
+
+// Check validates a copy of the configuration, leaving ic untouched, and
+// returns the copy with the changes made by Validate, such as the defaults
+// it sets and the packages it adds. The build uses Validate instead.
+func (ic *ImageConfiguration) Check() (*ImageConfiguration, error) {
+	c := &ImageConfiguration{}
+	if err := copier.CopyWithOption(c, ic, copier.Option{DeepCopy: true}); err != nil {
+		return nil, fmt.Errorf("failed to copy configuration: %w", err)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ValidateBytes checks the YAML image configuration in data without
+// side effects, e.g. for editors: unknown fields are rejected, and the
+// configuration is validated as Validate does, but neither the files it
+// references nor its VCS repository are looked at, and its include, if
+// any, is not loaded. The timezone is not looked up in the tz database
+// and no CI annotations are added. Parse errors match ErrConfigParse and
+// invalid fields are reported as a *ValidationError.
+func ValidateBytes(data []byte) error {
+	_, err := validateBytes(data)
+	return err
+}
+
+// validateBytes decodes and validates data as ValidateBytes does and
+// returns the validated configuration.
+func validateBytes(data []byte) (*ImageConfiguration, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	ic := &ImageConfiguration{}
+	if err := dec.Decode(ic); err != nil && !errors.Is(err, io.EOF) {
+		return nil, &sentinelError{sentinel: ErrConfigParse, err: err}
+	}
+
+	if err := ic.validate(false, nil); err != nil {
+		return nil, err
+	}
+	return ic, nil
+}
+
+// Fingerprint returns a hash of the validated configuration and of the
+// contents of the files it references, which only changes when the image
+// built from it may change. Formatting, comments and the order of
+// packages, keyrings and architectures are ignored, as are the
+// annotations derived from the CI environment. Referenced directories,
+// such as local repositories, are only hashed by path.
+func (ic *ImageConfiguration) Fingerprint() (string, error) {
+	c := &ImageConfiguration{}
+	if err := copier.CopyWithOption(c, ic, copier.Option{DeepCopy: true}); err != nil {
+		return "", fmt.Errorf("failed to copy configuration: %w", err)
+	}
+
+	if err := c.validate(true, nil); err != nil {
+		return "", err
+	}
+
+	// The included configuration is already merged in.
+	c.Include = ""
+
+	sort.Strings(c.Contents.Packages)
+	sort.Strings(c.Contents.Keyring)
+	sort.Slice(c.Archs, func(i, j int) bool {
+		return c.Archs[i].String() < c.Archs[j].String()
+	})
+
+	// Maps are encoded with sorted keys.
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode configuration: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write(data)
+
+	digests, err := ic.referencedFileDigests()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "%v", digests)
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// referencedFileDigests returns the SHA-256 digests of the contents of
+// the referenced files, keyed by path. The configuration files are left
+// out, as their parsed contents are already hashed, and directories get
+// no digest.
+func (ic *ImageConfiguration) referencedFileDigests() (map[string]string, error) {
+	configFiles := map[string]struct{}{}
+	for _, p := range ic.configFiles {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		configFiles[p] = struct{}{}
+	}
+
+	digests := map[string]string{}
+	for _, p := range ic.ReferencedFiles() {
+		if _, ok := configFiles[p]; ok {
+			continue
+		}
+
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read referenced file: %w", err)
+		}
+		if fi.IsDir() {
+			digests[p] = ""
+			continue
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read referenced file: %w", err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read referenced file %s: %w", p, err)
+		}
+		digests[p] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+
+	return digests, nil
+}
+
+// Returns the configuration fields and build args which may be
+// referenced from templated annotation values.
+func (ic *ImageConfiguration) templateData() map[string]interface{} {
+	// referencing a build arg which is not set fails, as missing keys do
+	buildArgs := ic.BuildArgs
+	if buildArgs == nil {
+		buildArgs = map[string]string{}
+	}
+
+	return map[string]interface{}{
+		"OSRelease": map[string]string{
+			"ID":           ic.OSRelease.ID,
+			"Name":         ic.OSRelease.Name,
+			"PrettyName":   ic.OSRelease.PrettyName,
+			"VersionID":    ic.OSRelease.VersionID,
+			"HomeURL":      ic.OSRelease.HomeURL,
+			"BugReportURL": ic.OSRelease.BugReportURL,
+		},
+		"VCSUrl":    ic.VCSUrl,
+		"BuildArgs": buildArgs,
+	}
+}
+
+// The size limits, in bytes, of the files copied into the image, when
+// none are configured.
+const (
+	DefaultMaxFileSize  int64 = 256 << 20
+	DefaultMaxTotalSize int64 = 1 << 30
+)
+
+// checkFileSizes checks the sizes of the sources of the configured files,
+// in the same order, against the size limits of the configuration, naming
+// the files which are too large.
+func (ic *ImageConfiguration) checkFileSizes(sizes []int64) error {
+	maxFile, maxTotal := ic.Contents.MaxFileSize, ic.Contents.MaxTotalSize
+	if maxFile == 0 {
+		maxFile = DefaultMaxFileSize
+	}
+	if maxTotal == 0 {
+		maxTotal = DefaultMaxTotalSize
+	}
+
+	var total int64
+	tooLarge := []string{}
+	for i, f := range ic.Contents.Files {
+		total += sizes[i]
+		if sizes[i] > maxFile {
+			tooLarge = append(tooLarge, fmt.Sprintf("%s (%d bytes)", f.Source, sizes[i]))
+		}
+	}
+
+	if len(tooLarge) > 0 {
+		return fmt.Errorf("configured files exceed the limit of %d bytes per file: %s", maxFile, strings.Join(tooLarge, ", "))
+	}
+	if total > maxTotal {
+		return fmt.Errorf("configured files total %d bytes, more than the limit of %d bytes", total, maxTotal)
+	}
+
+	return nil
+}
+
+// escapesRoot reports whether the absolute path p, joined onto the root of
+// the image filesystem, resolves outside of it, e.g. /../etc/passwd.
+func escapesRoot(p string) bool {
+	depth := 0
+	for _, elem := range strings.Split(filepath.ToSlash(p), "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if depth == 0 {
+				return true
+			}
+			depth--
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
+// validateTmpfsPath checks that a tmpfs path is absolute and can be
+// listed in the comma separated tmpfs annotation.
+func validateTmpfsPath(p string) error {
+	if !filepath.IsAbs(p) {
+		return fmt.Errorf("tmpfs path %q is not an absolute path", p)
+	}
+	if strings.Contains(p, ",") {
+		return fmt.Errorf("tmpfs path %q must not contain a comma", p)
+	}
+	return nil
+}
+
+// tmpfsList returns the cleaned tmpfs paths, sorted and without
+// duplicates, joined with commas.
+func tmpfsList(paths []string) string {
+	seen := map[string]struct{}{}
+	list := []string{}
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		list = append(list, p)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// addDefaultAnnotations adds the annotations derived by apko, keeping any
+// annotation which is already configured.
+func (ic *ImageConfiguration) addDefaultAnnotations(defaults map[string]string) {
+	if len(defaults) == 0 {
+		return
+	}
+
+	// As in expandAnnotations, build a new map rather than modifying a
+	// possibly shared one.
+	annotations := make(map[string]string, len(ic.Annotations)+len(defaults))
+	for k, v := range defaults {
+		annotations[k] = v
+	}
+	for k, v := range ic.Annotations {
+		annotations[k] = v
+	}
+
+	ic.Annotations = annotations
+}
+
+// Expand annotation values which reference other configuration
+// fields or build args, e.g. `{{ .OSRelease.VersionID }}`.
+func (ic *ImageConfiguration) expandAnnotations() error {
+	annotations, err := expandTemplates("annotation", ic.Annotations, ic.templateData())
+	if err != nil {
+		return err
+	}
+
+	ic.Annotations = annotations
+	return nil
+}
+
+// buildArgRegexp matches a reference to a build arg in an environment
+// value, e.g. `{{ .BuildArgs.VERSION }}`.
+var buildArgRegexp = regexp.MustCompile(`{{\s*\.BuildArgs\.([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+
+// Expand the build args referenced by environment values, e.g.
+// `{{ .BuildArgs.VERSION }}`. Unlike annotations, environment values are
+// not templates: anything else, including other `{{`, is kept as is.
+func (ic *ImageConfiguration) expandEnvironment() error {
+	if len(ic.Environment) == 0 {
+		return nil
+	}
+
+	// The map may be shared with copies of this configuration, so build a
+	// new map instead of modifying it in place.
+	environment := make(map[string]string, len(ic.Environment))
+	for k, v := range ic.Environment {
+		var missing []string
+		environment[k] = buildArgRegexp.ReplaceAllStringFunc(v, func(ref string) string {
+			name := buildArgRegexp.FindStringSubmatch(ref)[1]
+			arg, ok := ic.BuildArgs[name]
+			if !ok {
+				missing = append(missing, name)
+			}
+			return arg
+		})
+		if len(missing) > 0 {
+			return fmt.Errorf("environment variable %s references unset build args: %s", k, strings.Join(missing, ", "))
+		}
+	}
+
+	ic.Environment = environment
+	return nil
+}
+
+// expandTemplates returns a copy of values with the templated values
+// expanded using data. Referencing a missing key is an error.
+func expandTemplates(kind string, values map[string]string, data map[string]interface{}) (map[string]string, error) {
+	if len(values) == 0 {
+		return values, nil
+	}
+
+	// The map may be shared with copies of this configuration (e.g. one
+	// per architecture), so build a new map instead of modifying it in
+	// place.
+	expanded := make(map[string]string, len(values))
+	for k, v := range values {
+		if !strings.Contains(v, "{{") {
+			expanded[k] = v
+			continue
+		}
+
+		tmpl, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing template for %s %s: %w", kind, k, err)
+		}
+
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("expanding template for %s %s: %w", kind, k, err)
+		}
+
+		expanded[k] = buf.String()
+	}
+
+	return expanded, nil
+}
+
+// validateGroupMembership checks that configured groups do not contain
+// each other in a cycle, directly or through other groups. A member name
+// refers to a group when it is not also the name of a configured user.
+func (ic *ImageConfiguration) validateGroupMembership() error {
+	users := map[string]struct{}{}
+	for _, u := range ic.Accounts.Users {
+		users[u.UserName] = struct{}{}
+	}
+
+	members := map[string][]string{}
+	for _, g := range ic.Accounts.Groups {
+		members[g.GroupName] = append(members[g.GroupName], g.Members...)
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := map[string]int{}
+	path := []string{}
+
+	var visit func(group string) error
+	visit = func(group string) error {
+		switch state[group] {
+		case visited:
+			return nil
+		case visiting:
+			for i, g := range path {
+				if g == group {
+					cycle := append(append([]string{}, path[i:]...), group)
+					return fmt.Errorf("group membership cycle detected: %s", strings.Join(cycle, " -> "))
+				}
+			}
+		}
+
+		state[group] = visiting
+		path = append(path, group)
+
+		for _, m := range members[group] {
+			if _, ok := users[m]; ok {
+				continue
+			}
+			if _, ok := members[m]; !ok {
+				continue
+			}
+			if err := visit(m); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[group] = visited
+		return nil
+	}
+
+	for _, g := range ic.Accounts.Groups {
+		if err := visit(g.GroupName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The characters allowed in the names of entrypoints, which are used
+// as patterns in the dispatcher script.
+var entrypointNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// validateEntrypoints checks the named entrypoints and, if there are
+// any, sets the entrypoint command to the dispatcher script.
+func (ic *ImageConfiguration) validateEntrypoints() error {
+	if len(ic.Entrypoints) == 0 {
+		if ic.DefaultEntrypoint != "" {
+			return fmt.Errorf("default entrypoint %q is set, but no entrypoints are configured", ic.DefaultEntrypoint)
+		}
+		return nil
+	}
+
+	if ic.Entrypoint.Type == "service-bundle" || ic.Entrypoint.ShellFragment != "" ||
+		(ic.Entrypoint.Command != "" && ic.Entrypoint.Command != EntrypointDispatcherPath) {
+		return fmt.Errorf("named entrypoints cannot be combined with an entrypoint command, shell fragment or service bundle")
+	}
+
+	for name, command := range ic.Entrypoints {
+		if !entrypointNameRegexp.MatchString(name) {
+			return fmt.Errorf("entrypoint name %q may only contain letters, digits, '.', '_' and '-'", name)
+		}
+
+		if strings.TrimSpace(command) == "" {
+			return fmt.Errorf("entrypoint %s has an empty command", name)
+		}
+	}
+
+	if _, ok := ic.Entrypoints[ic.DefaultEntrypoint]; !ok {
+		return fmt.Errorf("default entrypoint %q is not one of the configured entrypoints", ic.DefaultEntrypoint)
+	}
+
+	ic.Entrypoint.Command = EntrypointDispatcherPath
+
+	return nil
+}
+
+// The characters allowed in the os-release ID field, see os-release(5).
+var osReleaseIDRegexp = regexp.MustCompile(`^[a-z0-9._-]+$`)
+
+// The format of locale names, e.g. en_US.UTF-8, C.UTF-8 or POSIX.
+var localeRegexp = regexp.MustCompile(`^[A-Za-z]+(_[A-Za-z0-9]+)?(\.[A-Za-z0-9-]+)?(@[A-Za-z0-9]+)?$`)
+
+// The format of DNS domain names: dot separated labels of letters, digits
+// and inner hyphens, optionally with a trailing dot.
+var domainRegexp = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.?$`)
+
+// The number of name servers read from /etc/resolv.conf by the musl and
+// glibc resolvers.
+const maxNameservers = 3
+
+func (r ResolvConf) validate() error {
+	if len(r.Nameservers) > maxNameservers {
+		return fmt.Errorf("resolv-conf lists %d nameservers, at most %d are used", len(r.Nameservers), maxNameservers)
+	}
+
+	for _, ns := range r.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return fmt.Errorf("resolv-conf nameserver %q is not an IP address", ns)
+		}
+	}
+
+	for _, domain := range r.Search {
+		if len(domain) > 253 || !domainRegexp.MatchString(domain) {
+			return fmt.Errorf("resolv-conf search domain %q is not a valid domain name", domain)
+		}
+	}
+
+	return nil
+}
+
+// The format of apk-tools versions, e.g. 2.12.9 or 2.14.0_rc1.
+var apkToolsVersionRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*[A-Za-z0-9._]*$`)
+
+// validateLocalPackage checks that a local package is a readable .apk
+// file.
+func validateLocalPackage(path string) error {
+	if filepath.Ext(path) != ".apk" {
+		return fmt.Errorf("local package %s is not an .apk file", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("local package %s is not readable: %w", path, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("local package %s is not readable: %w", path, err)
+	}
+
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("local package %s is not a regular file", path)
+	}
+
+	return nil
+}
+
+// ValidateCacheDir checks that an apk cache is an existing directory.
+func ValidateCacheDir(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cache directory %s is not readable: %w", path, err)
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("cache directory %s is not a directory", path)
+	}
+
+	return nil
+}
+
+// validateHostsEntry checks that an /etc/hosts entry is an IP address
+// followed by at least one host name.
+func validateHostsEntry(entry string) error {
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return fmt.Errorf("hosts entry %q must be an IP address followed by host names", entry)
+	}
+
+	if net.ParseIP(fields[0]) == nil {
+		return fmt.Errorf("hosts entry %q: %q is not an IP address", entry, fields[0])
+	}
+
+	for _, host := range fields[1:] {
+		if len(host) > 253 || !domainRegexp.MatchString(host) {
+			return fmt.Errorf("hosts entry %q: %q is not a valid host name", entry, host)
+		}
+	}
+
+	return nil
+}
+
+// MaxID is the largest UID or GID allowed in an image configuration. IDs
+// above it do not fit in the signed 32-bit integers used by some runtimes
+// and tools, and the largest unsigned values are reserved, e.g. -1.
+const MaxID = math.MaxInt32
+
+// validateIDs checks that the UIDs and GIDs of the accounts, files and
+// paths, and those of a numeric run-as value, are at most MaxID.
+func (ic *ImageConfiguration) validateIDs() error {
+	check := func(field, what string, id uint64) error {
+		if id > MaxID {
+			return invalid(field, fmt.Errorf("%s %d is above the maximum ID %d", what, id, MaxID))
+		}
+		return nil
+	}
+
+	for _, u := range ic.Accounts.Users {
+		if err := check("accounts.users", fmt.Sprintf("user %s UID", u.UserName), uint64(u.UID)); err != nil {
+			return err
+		}
+		if err := check("accounts.users", fmt.Sprintf("user %s GID", u.UserName), uint64(u.GID)); err != nil {
+			return err
+		}
+	}
+
+	for _, g := range ic.Accounts.Groups {
+		if err := check("accounts.groups", fmt.Sprintf("group %s GID", g.GroupName), uint64(g.GID)); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range ic.Contents.Files {
+		if err := check("contents.files", fmt.Sprintf("file %s UID", f.Destination), uint64(f.UID)); err != nil {
+			return err
+		}
+		if err := check("contents.files", fmt.Sprintf("file %s GID", f.Destination), uint64(f.GID)); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range ic.Paths {
+		if err := check("paths", fmt.Sprintf("path %s UID", p.Path), uint64(p.UID)); err != nil {
+			return err
+		}
+		if err := check("paths", fmt.Sprintf("path %s GID", p.Path), uint64(p.GID)); err != nil {
+			return err
+		}
+	}
+
+	// run-as may name the user and group, or give their IDs
+	user, group, _ := strings.Cut(ic.Accounts.RunAs, ":")
+	for _, id := range []struct {
+		what  string
+		value string
+	}{{"run-as UID", user}, {"run-as GID", group}} {
+		if id.value == "" || strings.TrimLeft(id.value, "0123456789") != "" {
+			continue
+		}
+
+		n, err := strconv.ParseUint(id.value, 10, 32)
+		if err != nil {
+			return invalid("accounts.run-as", fmt.Errorf("%s %s does not fit in 32 bits", id.what, id.value))
+		}
+		if err := check("accounts.run-as", id.what, n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The POSIX portable user and group names: a letter or underscore
+// followed by letters, digits, '_' or '-', up to 32 characters.
+var portableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]{0,31}$`)
+
+// The groups provided by alpine-baselayout, which users may be members
+// of without configuring them.
+var packagedGroups = map[string]struct{}{
+	"root": {}, "bin": {}, "daemon": {}, "sys": {}, "adm": {}, "tty": {},
+	"disk": {}, "lp": {}, "mem": {}, "kmem": {}, "wheel": {}, "floppy": {},
+	"mail": {}, "news": {}, "uucp": {}, "man": {}, "cron": {}, "console": {},
+	"audio": {}, "cdrom": {}, "dialout": {}, "ftp": {}, "sshd": {},
+	"input": {}, "at": {}, "tape": {}, "video": {}, "netdev": {},
+	"readproc": {}, "squid": {}, "xfs": {}, "kvm": {}, "games": {},
+	"shadow": {}, "cdrw": {}, "www-data": {}, "ping": {}, "nogroup": {},
+	"utmp": {}, "users": {},
+}
+
+// The annotation keys predefined by the OCI image specification.
+var ociAnnotations = map[string]struct{}{
+	"org.opencontainers.image.created":       {},
+	"org.opencontainers.image.authors":       {},
+	"org.opencontainers.image.url":           {},
+	"org.opencontainers.image.documentation": {},
+	"org.opencontainers.image.source":        {},
+	"org.opencontainers.image.version":       {},
+	"org.opencontainers.image.revision":      {},
+	"org.opencontainers.image.vendor":        {},
+	"org.opencontainers.image.licenses":      {},
+	"org.opencontainers.image.ref.name":      {},
+	"org.opencontainers.image.title":         {},
+	"org.opencontainers.image.description":   {},
+	"org.opencontainers.image.base.digest":   {},
+	"org.opencontainers.image.base.name":     {},
+}
+
+// ValidateAnnotations checks that the annotations do not misuse the
+// reserved org.opencontainers namespace, and that they do not override
+// values which apko derives on its own, such as the image source.
+func (ic *ImageConfiguration) ValidateAnnotations() error {
+	keys := make([]string, 0, len(ic.Annotations))
+	for k := range ic.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	problems := []string{}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, "org.opencontainers.") {
+			continue
+		}
+
+		if _, ok := ociAnnotations[k]; !ok {
+			problems = append(problems, fmt.Sprintf("%s is not defined by the OCI image specification", k))
+			continue
+		}
+
+		if k == sourceAnnotation && ic.VCSUrl != "" && ic.Annotations[k] != ic.VCSUrl {
+			problems = append(problems, fmt.Sprintf("%s overrides the source derived from the VCS URL %s", k, ic.VCSUrl))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("annotations conflict with reserved keys: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// IsLocalRepository returns whether the repository, optionally tagged or
+// restricted to architectures, is a directory on the local filesystem
+// rather than a remote URL.
+func IsLocalRepository(repo string) bool {
+	return localRepositoryPath(repo) != ""
+}
+
+// ValidateKeyring checks that the repositories and the keyring are
+// configured together: remote repositories need keys to verify the
+// packages they serve, and keys are of no use without repositories.
+// Local repositories and untrusted contents do not need any keys.
+func (ic *ImageConfiguration) ValidateKeyring() error {
+	remote := []string{}
+	for _, repo := range ic.Contents.Repositories {
+		if !IsLocalRepository(repo) {
+			remote = append(remote, repo)
+		}
+	}
+
+	if len(remote) > 0 && len(ic.Contents.Keyring) == 0 && !ic.Contents.AllowUntrusted {
+		return fmt.Errorf("repositories %s are configured without any keyring entries", strings.Join(remote, ", "))
+	}
+
+	if len(ic.Contents.Keyring) > 0 && len(ic.Contents.Repositories) == 0 {
+		return fmt.Errorf("keyring entries are configured without any repositories")
+	}
+
+	return nil
+}
+
+func isAllowedAPKOption(opt string) bool {
+	for _, allowed := range AllowedAPKOptions {
+		if opt == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// validateStripPattern checks that a glob pattern of files to strip is
+// absolute and cannot match anything outside of the image filesystem, or
+// the whole of it.
+func validateStripPattern(pattern string) error {
+	if !filepath.IsAbs(pattern) {
+		return fmt.Errorf("strip path %q is not an absolute path", pattern)
+	}
+
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == ".." {
+			return fmt.Errorf("strip path %q escapes the image filesystem", pattern)
+		}
+	}
+
+	if filepath.Clean(pattern) == "/" {
+		return fmt.Errorf("strip path %q matches the whole image filesystem", pattern)
+	}
+
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("strip path %q is not a valid glob: %w", pattern, err)
+	}
+
+	return nil
+}
+
+// ValidateBaseImageDigest checks that the base image, if any, is
+// referenced by digest, so the build can't change when its tag is moved.
+func (ic *ImageConfiguration) ValidateBaseImageDigest() error {
+	if ic.Contents.BaseImage == "" {
+		return nil
+	}
+
+	if _, err := name.NewDigest(ic.Contents.BaseImage); err != nil {
+		return fmt.Errorf("base image %s is not referenced by digest", ic.Contents.BaseImage)
+	}
+
+	return nil
+}
+
+// ValidateEntrypointSet checks that a plain image has something to run:
+// an entrypoint command or shell fragment, named entrypoints, or a cmd.
+// Service bundles are exempt, being started by their supervisor.
+func (ic *ImageConfiguration) ValidateEntrypointSet() error {
+	if ic.Entrypoint.Type != "" {
+		return nil
+	}
+
+	if ic.Entrypoint.Command != "" || ic.Entrypoint.ShellFragment != "" || len(ic.Entrypoints) != 0 ||
+		ic.Cmd != "" || len(ic.CmdArgs) != 0 {
+		return nil
+	}
+
+	return fmt.Errorf("neither entrypoint.command, entrypoint.shell-fragment nor cmd is set, containers run from the image will exit immediately")
+}
+
+// Check that a hook script exists and is executable.
+func validateHook(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("hook script %s is not accessible: %w", path, err)
+	}
+
+	if fi.IsDir() {
+		return fmt.Errorf("hook script %s is a directory", path)
+	}
+
+	if fi.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("hook script %s is not executable", path)
+	}
+
+	return nil
+}
+
+// Do preflight checks and mutations on an image configured to manage
+// a service bundle.
+func (ic *ImageConfiguration) ValidateServiceBundle() error {
+	managed := ic.Entrypoint.ManageServices == nil || *ic.Entrypoint.ManageServices
+
+	if managed && ic.Entrypoint.Command != "" && ic.Entrypoint.Command != serviceBundleCommand {
+		return fmt.Errorf("entrypoint command %q is ignored for service bundles, remove it or use a different entrypoint type", ic.Entrypoint.Command)
+	}
+
+	if managed && ic.Entrypoint.ShellFragment != "" {
+		return fmt.Errorf("entrypoint shell fragment %q is ignored for service bundles, remove it or use a different entrypoint type", ic.Entrypoint.ShellFragment)
+	}
+
+	for service, descriptor := range ic.Entrypoint.Services {
+		command, ok := descriptor.(string)
+		if !ok {
+			continue
+		}
+
+		args, err := shlex.Split(command)
+		if err != nil {
+			return fmt.Errorf("unable to parse command for service %v: %w", service, err)
+		}
+
+		if len(args) == 0 {
+			return fmt.Errorf("service %v has an empty command", service)
+		}
+
+		// PATH is not necessarily set in the supervision environment.
+		if !filepath.IsAbs(args[0]) {
+			return fmt.Errorf("command %q for service %v must be an absolute path", args[0], service)
+		}
+	}
+
+	// The supervision setup is left to the user.
+	if !managed {
+		return nil
+	}
+
+	ic.Entrypoint.Command = serviceBundleCommand
+
+	// It's harmless to have a duplicate entry in /etc/apk/world,
+	// apk will fix it up when the fixate op happens.
+	ic.Contents.Packages = append(ic.Contents.Packages, "s6")
+
+	return nil
+}
+
+// validateInit checks that the init process wrapping the entrypoint, if
+// any, is provided by a recognized package, adding it to the packages to
+// install when it is not listed.
+func (ic *ImageConfiguration) validateInit() error {
+	if !ic.Entrypoint.Init {
+		if ic.Entrypoint.InitPackage != "" {
+			return fmt.Errorf("init package %q is set, but entrypoint init is not enabled", ic.Entrypoint.InitPackage)
+		}
+		return nil
+	}
+
+	if ic.Entrypoint.Type == "service-bundle" {
+		return fmt.Errorf("entrypoint init cannot be used with service bundles, which are supervised by s6")
+	}
+
+	if ic.Entrypoint.InitPackage == "" {
+		ic.Entrypoint.InitPackage = defaultInitPackage
+	}
+
+	if _, ok := initCommands[ic.Entrypoint.InitPackage]; !ok {
+		return fmt.Errorf("init package %q is not recognized, must be tini or dumb-init", ic.Entrypoint.InitPackage)
+	}
+
+	if !ic.hasPackage(ic.Entrypoint.InitPackage) {
+		ic.Contents.Packages = append(ic.Contents.Packages, ic.Entrypoint.InitPackage)
+	}
+
+	return nil
+}
+
+// packageName strips the version constraint and predicate, if any, from
+// a package entry.
+func packageName(pkg string) string {
+	if i := strings.IndexAny(pkg, "[=<>~"); i != -1 {
+		return pkg[:i]
+	}
+	return pkg
+}
+
+// The format of a hex encoded SHA-256 digest.
+var sha256Regexp = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// validatePackageChecksums checks that the pinned package checksums are
+// keyed by name=version and are hex encoded SHA-256 digests.
+func (ic *ImageConfiguration) validatePackageChecksums() error {
+	pkgs := make([]string, 0, len(ic.Contents.PackageChecksums))
+	for pkg := range ic.Contents.PackageChecksums {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+
+	for _, pkg := range pkgs {
+		name, version, ok := strings.Cut(pkg, "=")
+		if !ok || name == "" || version == "" {
+			return fmt.Errorf("package checksum key %q is not of the form name=version", pkg)
+		}
+
+		if sum := ic.Contents.PackageChecksums[pkg]; !sha256Regexp.MatchString(sum) {
+			return fmt.Errorf("checksum %q of package %s is not a hex encoded SHA-256 digest", sum, pkg)
+		}
+	}
+
+	return nil
+}
+
+// isPinned reports whether a package specification requires an exact
+// version, e.g. `nginx=1.22.0-r1`.
+func isPinned(spec string) bool {
+	name := packageName(spec)
+	return len(spec) > len(name)+1 && spec[len(name)] == '='
+}
+
+// packageGroupReference returns the name of the package group referenced
+// by the package specification, if it references one, e.g. "@web-stack".
+func packageGroupReference(spec string) (string, bool) {
+	if !strings.HasPrefix(spec, "@") {
+		return "", false
+	}
+	return strings.TrimPrefix(spec, "@"), true
+}
+
+// validatePackageGroups checks that the package groups only list packages,
+// and that the groups referenced by the packages to install are defined.
+func (ic *ImageConfiguration) validatePackageGroups() error {
+	for group, pkgs := range ic.Contents.PackageGroups {
+		if len(pkgs) == 0 {
+			return fmt.Errorf("package group %q is empty", group)
+		}
+
+		for _, pkg := range pkgs {
+			spec, _, err := parsePackage(pkg)
+			if err != nil {
+				return fmt.Errorf("package group %q: %w", group, err)
+			}
+
+			if _, ok := packageGroupReference(spec); ok {
+				return fmt.Errorf("package group %q references another package group %q", group, pkg)
+			}
+		}
+	}
+
+	for _, pkg := range ic.Contents.Packages {
+		spec, _, err := parsePackage(pkg)
+		if err != nil {
+			return invalid("contents.packages", err)
+		}
+
+		if group, ok := packageGroupReference(spec); ok {
+			if _, ok := ic.Contents.PackageGroups[group]; !ok {
+				return invalid("contents.packages", fmt.Errorf("package %q references undefined package group %q", pkg, group))
+			}
+		}
+	}
+
+	return nil
+}
+
+// expandedPackages returns the package entries to install, with the
+// references to package groups replaced by the packages of the groups.
+func (ic *ImageConfiguration) expandedPackages() []string {
+	pkgs := make([]string, 0, len(ic.Contents.Packages))
+	for _, pkg := range ic.Contents.Packages {
+		spec, _, _ := parsePackage(pkg)
+		if group, ok := packageGroupReference(spec); ok {
+			pkgs = append(pkgs, ic.Contents.PackageGroups[group]...)
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs
+}
+
+// hasPackage reports whether the named package is listed in the
+// packages to install.
+func (ic *ImageConfiguration) hasPackage(name string) bool {
+	for _, pkg := range ic.expandedPackages() {
+		if packageName(pkg) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePackage splits a package entry of the form `name[arch=a,b]` into
+// the package specification and the architectures it is restricted to.
+// Entries without a predicate apply to all architectures.
+func parsePackage(entry string) (string, []Architecture, error) {
+	start := strings.Index(entry, "[")
+	if start == -1 {
+		if strings.Contains(entry, "]") {
+			return "", nil, fmt.Errorf("package %q has a malformed predicate", entry)
+		}
+		return entry, nil, nil
+	}
+
+	if !strings.HasSuffix(entry, "]") || start == 0 {
+		return "", nil, fmt.Errorf("package %q has a malformed predicate", entry)
+	}
+
+	spec := entry[:start]
+	key, value, ok := strings.Cut(entry[start+1:len(entry)-1], "=")
+	if !ok || key != "arch" || value == "" {
+		return "", nil, fmt.Errorf("package %q has an unsupported predicate, expected [arch=...]", entry)
+	}
+
+	archs := []Architecture{}
+	for _, a := range strings.Split(value, ",") {
+		arch := ParseArchitecture(a)
+		if !arch.IsSupported() {
+			return "", nil, fmt.Errorf("package %q is restricted to unknown architecture %q", entry, a)
+		}
+		archs = append(archs, arch)
+	}
+
+	return spec, archs, nil
+}
+
+// repositoryArchPredicate starts the architecture predicate of a
+// repository entry.
+const repositoryArchPredicate = "[arch="
+
+// parseRepository splits a repository entry of the form `url[arch=a,b]`
+// into the repository and the architectures it is restricted to, as for
+// packages. Other brackets, e.g. of IPv6 hosts, are left alone.
+func parseRepository(entry string) (string, []Architecture, error) {
+	start := strings.LastIndex(entry, repositoryArchPredicate)
+	if start == -1 || !strings.HasSuffix(entry, "]") {
+		return entry, nil, nil
+	}
+
+	repo := strings.TrimSpace(entry[:start])
+	value := entry[start+len(repositoryArchPredicate) : len(entry)-1]
+	if repo == "" || value == "" {
+		return "", nil, fmt.Errorf("repository %q has a malformed predicate", entry)
+	}
+
+	archs := []Architecture{}
+	for _, a := range strings.Split(value, ",") {
+		arch := ParseArchitecture(a)
+		if !arch.IsSupported() {
+			return "", nil, fmt.Errorf("repository %q is restricted to unknown architecture %q", entry, a)
+		}
+		archs = append(archs, arch)
+	}
+
+	return repo, archs, nil
+}
+
+// The placeholders of repository URLs, e.g.
+// https://example.com/{version}/{arch}, replaced by the apk name of the
+// architecture and the version of os-release.
+const (
+	repositoryArchPlaceholder    = "{arch}"
+	repositoryVersionPlaceholder = "{version}"
+)
+
+var repositoryPlaceholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// expandRepository replaces the placeholders of the repository for the
+// given architecture.
+func (ic *ImageConfiguration) expandRepository(repo string, arch Architecture) string {
+	return strings.NewReplacer(
+		repositoryArchPlaceholder, arch.ToAPK(),
+		repositoryVersionPlaceholder, ic.OSRelease.VersionID,
+	).Replace(repo)
+}
+
+// validateRepositoryPlaceholders checks that the repository has no
+// placeholders left once expanded.
+func (ic *ImageConfiguration) validateRepositoryPlaceholders(repo string) error {
+	if p := repositoryPlaceholderRegexp.FindString(ic.expandRepository(repo, Architecture{})); p != "" {
+		return fmt.Errorf("repository %q has unknown placeholder %s, must be %s or %s", repo, p, repositoryArchPlaceholder, repositoryVersionPlaceholder)
+	}
+	return nil
+}
+
+// ResolvedRepositories returns the repositories to use for the given
+// architecture, with architecture predicates evaluated and removed, and
+// placeholders expanded.
+func (ic *ImageConfiguration) ResolvedRepositories(arch Architecture) ([]string, error) {
+	repos := make([]string, 0, len(ic.Contents.Repositories))
+
+	for _, entry := range ic.Contents.Repositories {
+		repo, archs, err := parseRepository(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		if appliesTo(archs, arch) {
+			repos = append(repos, ic.expandRepository(repo, arch))
+		}
+	}
+
+	return repos, nil
+}
+
+// ResolvedAnnotations returns a copy of the annotations applied to the
+// manifest of OCI images: those configured, along with those which
+// Validate derives, such as the image source from the VCS URL and the
+// io.apko.build.* CI annotations, and so must be called after it. apko
+// derives neither the revision, which only the CI annotations record,
+// nor the creation time, which is the created field of the image config.
+// Images built with Docker media types carry no annotations at all.
+func (ic *ImageConfiguration) ResolvedAnnotations() map[string]string {
+	annotations := make(map[string]string, len(ic.Annotations))
+	for k, v := range ic.Annotations {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// ResolvedPackages returns the package specifications to install for
+// the given architecture, with architecture predicates evaluated and
+// removed, and package groups expanded.
+func (ic *ImageConfiguration) ResolvedPackages(arch Architecture) ([]string, error) {
+	pkgs := make([]string, 0, len(ic.Contents.Packages))
+
+	for _, entry := range ic.Contents.Packages {
+		spec, archs, err := parsePackage(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		if !appliesTo(archs, arch) {
+			continue
+		}
+
+		group, ok := packageGroupReference(spec)
+		if !ok {
+			pkgs = append(pkgs, spec)
+			continue
+		}
+
+		members, ok := ic.Contents.PackageGroups[group]
+		if !ok {
+			return nil, fmt.Errorf("package %q references undefined package group %q", entry, group)
+		}
+
+		for _, member := range members {
+			spec, archs, err := parsePackage(member)
+			if err != nil {
+				return nil, fmt.Errorf("package group %q: %w", group, err)
+			}
+
+			if appliesTo(archs, arch) {
+				pkgs = append(pkgs, spec)
+			}
+		}
+	}
+
+	return pkgs, nil
+}
+
+// PhasePackages returns the resolved packages for arch whose names are
+// among names, keeping their version constraints, in the order of
+// ResolvedPackages. It is used to install the install order in phases.
+func (ic *ImageConfiguration) PhasePackages(arch Architecture, names []string) ([]string, error) {
+	pkgs, err := ic.ResolvedPackages(arch)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		wanted[packageName(name)] = struct{}{}
+	}
+
+	phase := make([]string, 0, len(names))
+	for _, pkg := range pkgs {
+		if _, ok := wanted[packageName(pkg)]; ok {
+			phase = append(phase, pkg)
+		}
+	}
+
+	return phase, nil
+}
+
+// appliesTo reports whether a package restricted to archs, or to none,
+// is installed for arch.
+func appliesTo(archs []Architecture, arch Architecture) bool {
+	if len(archs) == 0 {
+		return true
+	}
+
+	for _, a := range archs {
+		if a == arch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ToOCIConfig maps the image configuration to the OCI image config
+// which is produced for the given architecture.
+func (ic *ImageConfiguration) ToOCIConfig(arch string) (v1.Config, error) {
+	cfg := v1.Config{
+		Labels: make(map[string]string),
+	}
+
+	if !ParseArchitecture(arch).IsSupported() {
+		return cfg, fmt.Errorf("unsupported architecture %q", arch)
+	}
+
+	// NOTE: Need to allow empty Entrypoints. The runtime will override to `/bin/sh -c` and handle quoting
+	switch {
+	case ic.Entrypoint.ShellFragment != "":
+		cfg.Entrypoint = []string{"/bin/sh", "-c", ic.Entrypoint.ShellFragment}
+	case ic.Entrypoint.Command != "":
+		splitcmd, err := shlex.Split(ic.Entrypoint.Command)
+		if err != nil {
+			return cfg, fmt.Errorf("unable to parse entrypoint command: %w", err)
+		}
+		cfg.Entrypoint = splitcmd
+	}
+
+	if ic.Entrypoint.ExecSignals {
+		cfg.Entrypoint = execShellScript(cfg.Entrypoint)
+	}
+
+	if ic.Entrypoint.Init {
+		initPackage := ic.Entrypoint.InitPackage
+		if initPackage == "" {
+			initPackage = defaultInitPackage
+		}
+		cfg.Entrypoint = append(append([]string{}, initCommands[initPackage]...), cfg.Entrypoint...)
+	}
+
+	if ic.Cmd != "" {
+		splitcmd, err := shlex.Split(ic.Cmd)
+		if err != nil {
+			return cfg, fmt.Errorf("unable to parse cmd: %w", err)
+		}
+		cfg.Cmd = splitcmd
+	}
+
+	if len(ic.CmdArgs) != 0 {
+		cfg.Cmd = append([]string{}, ic.CmdArgs...)
+	}
+
+	if ic.Entrypoint.ExecSignals {
+		cfg.Cmd = execShellScript(cfg.Cmd)
+	}
+
+	// the wrapper runs whatever the image starts with, so there is
+	// nothing to wrap without an entrypoint or cmd
+	if ic.Entrypoint.Umask != "" && (len(cfg.Entrypoint) > 0 || len(cfg.Cmd) > 0) {
+		cfg.Entrypoint = append(umaskWrapper(ic.Entrypoint.Umask), cfg.Entrypoint...)
+	}
+
+	if ic.WorkDir != "" {
+		cfg.WorkingDir = ic.WorkDir
+	}
+
+	if len(ic.Ports) != 0 {
+		cfg.ExposedPorts = map[string]struct{}{}
+		for _, port := range ic.Ports {
+			cfg.ExposedPorts[port] = struct{}{}
+		}
+	}
+
+	if ic.VCSUrl != "" {
+		cfg.Labels[sourceAnnotation] = ic.VCSUrl
+	}
+
+	envs := []string{}
+	for k, v := range ic.Environment {
+		envs = append(envs, fmt.Sprintf("%s=%s", k, v))
+	}
+	for k, v := range defaultEnvironment {
+		if _, ok := ic.Environment[k]; !ok {
+			envs = append(envs, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	sort.Strings(envs)
+
+	cfg.Env = envs
+
+	if ic.Accounts.RunAs != "" {
+		cfg.User = ic.Accounts.RunAs
+	}
+
+	return cfg, nil
+}
+
+// Warnings returns the problems found in the image configuration which
+// do not prevent the build, but should be brought to the user's attention.
+func (ic *ImageConfiguration) Warnings() []string {
+	warnings := []string{}
+
+	if ic.Contents.AllowUntrusted {
+		warnings = append(warnings,
+			"INSECURE: contents.allow-untrusted is enabled, package signatures will not be verified. "+
+				"Do not use this configuration in production!")
+	}
+
+	if err := ic.ValidateAnnotations(); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+
+	if err := ic.ValidateKeyring(); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+
+	if err := ic.ValidateBaseImageDigest(); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+
+	if err := ic.ValidateEntrypointSet(); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+
+	if err := ic.ValidateExecSignals(); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+
+	if ic.Timezone != "" && !ic.hasPackage("tzdata") {
+		warnings = append(warnings, fmt.Sprintf(
+			"timezone is set to %s, but the tzdata package is not listed in contents.packages", ic.Timezone))
+	}
+
+	return warnings
 }
 
 func (ic *ImageConfiguration) Summarize(logger *logrus.Entry) {
 	logger.Printf("image configuration:")
 	logger.Printf("  contents:")
+	if ic.Contents.BaseImage != "" {
+		logger.Printf("    base image:   %s", ic.Contents.BaseImage)
+	}
 	logger.Printf("    repositories: %v", ic.Contents.Repositories)
 	logger.Printf("    keyring:      %v", ic.Contents.Keyring)
 	logger.Printf("    packages:     %v", ic.Contents.Packages)
+	if len(ic.Contents.InstallOrder) != 0 {
+		logger.Printf("    install order: %v", ic.Contents.InstallOrder)
+	}
+	if ic.Contents.AllowUntrusted {
+		logger.Printf("    allow untrusted: %t", ic.Contents.AllowUntrusted)
+	}
+	if len(ic.Contents.PreInstall) != 0 {
+		logger.Printf("    pre-install:  %v", ic.Contents.PreInstall)
+	}
+	if len(ic.Contents.PostInstall) != 0 {
+		logger.Printf("    post-install: %v", ic.Contents.PostInstall)
+	}
+	if len(ic.Contents.Files) != 0 {
+		logger.Printf("    files:")
+		for _, f := range ic.Contents.Files {
+			logger.Printf("      - %s => %s", f.Source, f.Destination)
+		}
+	}
 	if ic.Entrypoint.Type != "" || ic.Entrypoint.Command != "" || len(ic.Entrypoint.Services) != 0 {
 		logger.Printf("  entrypoint:")
 		logger.Printf("    type:    %s", ic.Entrypoint.Type)
 		logger.Printf("    command:     %s", ic.Entrypoint.Command)
 		logger.Printf("    service: %v", ic.Entrypoint.Services)
 		logger.Printf("    shell fragment: %v", ic.Entrypoint.ShellFragment)
+		if ic.Entrypoint.ExecSignals {
+			logger.Printf("    exec signals: %t", ic.Entrypoint.ExecSignals)
+		}
 	}
-	if ic.Cmd != "" {
-		logger.Printf("  cmd: %s", ic.Cmd)
+	if ic.Cmd != "" || len(ic.CmdArgs) != 0 {
+		cmd := ic.Cmd
+		if len(ic.CmdArgs) != 0 {
+			cmd = fmt.Sprintf("%q", ic.CmdArgs)
+		}
+
+		switch {
+		case ic.Entrypoint.Command != "" || ic.Entrypoint.ShellFragment != "":
+			logger.Printf("  cmd: %s (passed as arguments to the entrypoint)", cmd)
+		default:
+			logger.Printf("  cmd: %s (run by the runtime default entrypoint)", cmd)
+		}
 	}
 	if ic.Accounts.RunAs != "" || len(ic.Accounts.Users) != 0 || len(ic.Accounts.Groups) != 0 {
 		logger.Printf("  accounts:")
 		logger.Printf("    runas:  %s", ic.Accounts.RunAs)
+		if ic.Accounts.NumericRunAs {
+			logger.Printf("    numeric runas: %t", ic.Accounts.NumericRunAs)
+		}
 		logger.Printf("    users:")
 		for _, u := range ic.Accounts.Users {
 			logger.Printf("      - uid=%d(%s) gid=%d", u.UID, u.UserName, u.GID)
@@ -195,10 +2223,22 @@ func (ic *ImageConfiguration) Summarize(logger *logrus.Entry) {
 			logger.Printf("      - gid=%d(%s) members=%v", g.GID, g.GroupName, g.Members)
 		}
 	}
-	if len(ic.Annotations) > 0 {
+	if ic.Timezone != "" {
+		logger.Printf("  timezone: %s", ic.Timezone)
+	}
+	if ic.Locale != "" {
+		logger.Printf("  locale: %s", ic.Locale)
+	}
+	if annotations := ic.ResolvedAnnotations(); len(annotations) > 0 {
+		keys := make([]string, 0, len(annotations))
+		for k := range annotations {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
 		logger.Printf("    annotations:")
-		for k, v := range ic.Annotations {
-			logger.Printf("      %s: %s", k, v)
+		for _, k := range keys {
+			logger.Printf("      %s: %s", k, annotations[k])
 		}
 	}
 }
@@ -0,0 +1,1340 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateServiceBundle(t *testing.T) {
+	for _, c := range []struct {
+		desc          string
+		command       string
+		shellFragment string
+		unmanaged     bool
+		shouldError   bool
+	}{{
+		desc:        "no command",
+		shouldError: false,
+	}, {
+		desc:      "unmanaged with custom command",
+		command:   "/usr/bin/my-svscan",
+		unmanaged: true,
+	}, {
+		desc:        "custom command",
+		command:     "/usr/bin/myserver",
+		shouldError: true,
+	}, {
+		desc:          "shell fragment",
+		shellFragment: "echo hello",
+		shouldError:   true,
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			ic := ImageConfiguration{}
+			ic.Entrypoint.Type = "service-bundle"
+			ic.Entrypoint.Command = c.command
+			ic.Entrypoint.ShellFragment = c.shellFragment
+			if c.unmanaged {
+				manage := false
+				ic.Entrypoint.ManageServices = &manage
+			}
+
+			err := ic.Validate()
+			if c.shouldError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if c.unmanaged {
+				require.Equal(t, c.command, ic.Entrypoint.Command)
+				require.NotContains(t, ic.Contents.Packages, "s6")
+			} else {
+				require.Equal(t, serviceBundleCommand, ic.Entrypoint.Command)
+			}
+
+			// Validating again must not be confused by the generated command.
+			require.NoError(t, ic.Validate())
+		})
+	}
+}
+
+func TestValidateServiceCommands(t *testing.T) {
+	for _, c := range []struct {
+		desc        string
+		command     string
+		shouldError bool
+	}{{
+		desc:    "absolute path",
+		command: "/usr/sbin/nginx -g \"daemon off;\"",
+	}, {
+		desc:        "relative path",
+		command:     "nginx -g \"daemon off;\"",
+		shouldError: true,
+	}, {
+		desc:        "empty command",
+		command:     "",
+		shouldError: true,
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			ic := ImageConfiguration{}
+			ic.Entrypoint.Type = "service-bundle"
+			ic.Entrypoint.Services = map[interface{}]interface{}{"nginx": c.command}
+
+			err := ic.Validate()
+			if c.shouldError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestExpandAnnotations(t *testing.T) {
+	for _, c := range []struct {
+		desc        string
+		value       string
+		want        string
+		shouldError bool
+	}{{
+		desc:  "plain value",
+		value: "1.2.3",
+		want:  "1.2.3",
+	}, {
+		desc:  "os release field",
+		value: "v{{ .OSRelease.VersionID }}",
+		want:  "v3.16",
+	}, {
+		desc:        "unknown field",
+		value:       "{{ .OSRelease.Codename }}",
+		shouldError: true,
+	}, {
+		desc:        "field outside allowlist",
+		value:       "{{ .Accounts.RunAs }}",
+		shouldError: true,
+	}, {
+		desc:        "syntax error",
+		value:       "{{ .OSRelease.VersionID",
+		shouldError: true,
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			ic := ImageConfiguration{
+				Annotations: map[string]string{"org.opencontainers.image.version": c.value},
+			}
+
+			err := ic.Validate()
+			if c.shouldError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.want, ic.Annotations["org.opencontainers.image.version"])
+		})
+	}
+}
+
+func TestExpandBuildArgs(t *testing.T) {
+	for _, c := range []struct {
+		desc        string
+		value       string
+		buildArgs   map[string]string
+		want        string
+		shouldError bool
+	}{{
+		desc:      "build arg",
+		value:     "{{ .BuildArgs.VERSION }}",
+		buildArgs: map[string]string{"VERSION": "1.2.3"},
+		want:      "1.2.3",
+	}, {
+		desc:      "several build args",
+		value:     "{{.BuildArgs.NAME}}-{{ .BuildArgs.VERSION }}",
+		buildArgs: map[string]string{"NAME": "app", "VERSION": "1.2.3"},
+		want:      "app-1.2.3",
+	}, {
+		desc:        "unset build arg",
+		value:       "{{ .BuildArgs.VERSION }}",
+		buildArgs:   map[string]string{"OTHER": "x"},
+		shouldError: true,
+	}, {
+		desc:        "no build args",
+		value:       "{{ .BuildArgs.VERSION }}",
+		shouldError: true,
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			ic := ImageConfiguration{
+				Environment: map[string]string{"APP_VERSION": c.value},
+				Annotations: map[string]string{"org.opencontainers.image.version": c.value},
+				BuildArgs:   c.buildArgs,
+			}
+
+			err := ic.Validate()
+			if c.shouldError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.want, ic.Environment["APP_VERSION"])
+			require.Equal(t, c.want, ic.Annotations["org.opencontainers.image.version"])
+		})
+	}
+
+	// Only build args are expanded in environment values, other braces
+	// are kept as they are.
+	ic := ImageConfiguration{
+		Environment: map[string]string{
+			"GOTMPL":  "{{ .Name }} {{ index .BuildArgs \"VERSION\" }}",
+			"VERSION": "v{{ .BuildArgs.VERSION }}",
+		},
+		BuildArgs: map[string]string{"VERSION": "1.2.3"},
+	}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, "{{ .Name }} {{ index .BuildArgs \"VERSION\" }}", ic.Environment["GOTMPL"])
+	require.Equal(t, "v1.2.3", ic.Environment["VERSION"])
+
+	// Annotations are templates, which may reference optional build args.
+	ic = ImageConfiguration{
+		Annotations: map[string]string{"org.opencontainers.image.version": "v{{ index .BuildArgs \"VERSION\" }}"},
+	}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, "v", ic.Annotations["org.opencontainers.image.version"])
+}
+
+func TestParseRemote(t *testing.T) {
+	logger := logrus.NewEntry(&logrus.Logger{})
+	const ref = "github.com/example/images/base.yaml@main"
+
+	ic := ImageConfiguration{}
+	require.NoError(t, ic.parseRemote(ref, []byte("contents:\n  packages: [busybox]\n"), logger))
+	require.Equal(t, []string{"busybox"}, ic.Contents.Packages)
+
+	ic = ImageConfiguration{}
+	err := ic.parseRemote(ref, []byte("environment-files:\n  - common.env\n"), logger)
+	require.ErrorContains(t, err, "remote configuration "+ref+" sets environment-files")
+}
+
+func TestLoadWithOverlay(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(base, []byte(`
+contents:
+  packages:
+    - alpine-baselayout
+work-dir: /app
+environment:
+  LOG_LEVEL: debug
+`), 0o644))
+	prod := filepath.Join(dir, "config.prod.yaml")
+	require.NoError(t, os.WriteFile(prod, []byte(`
+contents:
+  packages:
+    - ca-certificates-bundle
+environment:
+  LOG_LEVEL: warn
+`), 0o644))
+
+	ic, err := LoadWithOverlay(base, prod, logrus.NewEntry(&logrus.Logger{}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"alpine-baselayout", "ca-certificates-bundle"}, ic.Contents.Packages)
+	require.Equal(t, "/app", ic.WorkDir)
+	require.Equal(t, "warn", ic.Environment["LOG_LEVEL"])
+	require.Equal(t, []string{prod, base}, ic.ReferencedFiles())
+
+	_, err = LoadWithOverlay(base, filepath.Join(dir, "config.staging.yaml"), logrus.NewEntry(&logrus.Logger{}))
+	require.ErrorContains(t, err, "config.staging.yaml")
+}
+
+func TestLoadMany(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		return path
+	}
+
+	base := writeConfig("base.yaml", `
+contents:
+  packages:
+    - alpine-baselayout
+entrypoint:
+  command: /bin/sh -l
+work-dir: /base
+`)
+	extra := writeConfig("extra.yaml", `
+contents:
+  packages:
+    - nginx
+work-dir: /extra
+`)
+	bundle := writeConfig("bundle.yaml", `
+entrypoint:
+  type: service-bundle
+`)
+	other := writeConfig("other.yaml", `
+entrypoint:
+  type: other
+`)
+
+	logger := logrus.NewEntry(&logrus.Logger{})
+
+	ic, err := LoadMany([]string{base, extra}, logger)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alpine-baselayout", "nginx"}, ic.Contents.Packages)
+	require.Equal(t, "/extra", ic.WorkDir)
+	require.Equal(t, "/bin/sh -l", ic.Entrypoint.Command)
+
+	_, err = LoadMany([]string{base, bundle, other}, logger)
+	require.ErrorContains(t, err, other)
+	require.ErrorContains(t, err, bundle)
+
+	_, err = LoadMany([]string{}, logger)
+	require.Error(t, err)
+}
+
+func TestValidateInstallOrder(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.Packages = []string{"busybox", "nginx>1.22"}
+
+	ic.Contents.InstallOrder = []string{"nginx", "busybox"}
+	require.NoError(t, ic.Validate())
+
+	ic.Contents.InstallOrder = []string{"ca-certificates"}
+	require.ErrorContains(t, ic.Validate(), "ca-certificates")
+}
+
+func TestValidateAnnotations(t *testing.T) {
+	for _, c := range []struct {
+		desc        string
+		annotations map[string]string
+		vcsURL      string
+		shouldError bool
+	}{{
+		desc:        "custom keys",
+		annotations: map[string]string{"dev.chainguard.team": "images"},
+	}, {
+		desc:        "predefined key",
+		annotations: map[string]string{"org.opencontainers.image.version": "1.0"},
+	}, {
+		desc:        "undefined reserved key",
+		annotations: map[string]string{"org.opencontainers.image.tag": "latest"},
+		shouldError: true,
+	}, {
+		desc:        "source matching VCS URL",
+		annotations: map[string]string{"org.opencontainers.image.source": "https://github.com/chainguard-dev/apko"},
+		vcsURL:      "https://github.com/chainguard-dev/apko",
+	}, {
+		desc:        "source overriding VCS URL",
+		annotations: map[string]string{"org.opencontainers.image.source": "https://example.com/other"},
+		vcsURL:      "https://github.com/chainguard-dev/apko",
+		shouldError: true,
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			ic := ImageConfiguration{Annotations: c.annotations, VCSUrl: c.vcsURL, Cmd: "/bin/sh"}
+			err := ic.ValidateAnnotations()
+			if c.shouldError {
+				require.Error(t, err)
+				require.Len(t, ic.Warnings(), 1)
+				return
+			}
+			require.NoError(t, err)
+			require.Empty(t, ic.Warnings())
+		})
+	}
+}
+
+func TestValidateKeyring(t *testing.T) {
+	for _, c := range []struct {
+		desc           string
+		repositories   []string
+		keyring        []string
+		allowUntrusted bool
+		shouldError    bool
+	}{{
+		desc: "neither",
+	}, {
+		desc:         "both",
+		repositories: []string{"https://dl-cdn.alpinelinux.org/alpine/edge/main"},
+		keyring:      []string{"https://alpinelinux.org/keys/alpine-devel@lists.alpinelinux.org-4a6a0840.rsa.pub"},
+	}, {
+		desc:         "remote repository without keyring",
+		repositories: []string{"https://dl-cdn.alpinelinux.org/alpine/edge/main"},
+		shouldError:  true,
+	}, {
+		desc:           "untrusted remote repository without keyring",
+		repositories:   []string{"https://dl-cdn.alpinelinux.org/alpine/edge/main"},
+		allowUntrusted: true,
+	}, {
+		desc:         "local repositories without keyring",
+		repositories: []string{"/home/user/packages", "@local file:///home/user/packages", "./packages"},
+	}, {
+		desc:         "local repositories restricted to architectures without keyring",
+		repositories: []string{"/home/user/packages [arch=x86_64]", "@local ./packages [arch=aarch64]"},
+	}, {
+		desc:         "remote repository restricted to architectures without keyring",
+		repositories: []string{"https://dl-cdn.alpinelinux.org/alpine/edge/main [arch=x86_64]"},
+		shouldError:  true,
+	}, {
+		desc:        "keyring without repositories",
+		keyring:     []string{"/etc/apk/keys/local.rsa.pub"},
+		shouldError: true,
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			ic := ImageConfiguration{Cmd: "/bin/sh"}
+			ic.Contents.Repositories = c.repositories
+			ic.Contents.Keyring = c.keyring
+			ic.Contents.AllowUntrusted = c.allowUntrusted
+			err := ic.ValidateKeyring()
+			if c.shouldError {
+				require.Error(t, err)
+				require.Len(t, ic.Warnings(), 1)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestToOCIConfig(t *testing.T) {
+	ic := ImageConfiguration{
+		Cmd:         "--config /etc/app.yaml",
+		WorkDir:     "/app",
+		VCSUrl:      "https://github.com/chainguard-dev/apko",
+		Environment: map[string]string{"PATH": "/usr/bin:/bin", "FOO": "bar"},
+	}
+	ic.Entrypoint.Command = "/usr/bin/app -v"
+	ic.Accounts.RunAs = "65532"
+
+	cfg, err := ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/usr/bin/app", "-v"}, cfg.Entrypoint)
+	require.Equal(t, []string{"--config", "/etc/app.yaml"}, cfg.Cmd)
+	require.Equal(t, "/app", cfg.WorkingDir)
+	require.Equal(t, "65532", cfg.User)
+	require.Equal(t, []string{"FOO=bar", "PATH=/usr/bin:/bin", "SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt"}, cfg.Env)
+	require.Equal(t, map[string]string{"org.opencontainers.image.source": ic.VCSUrl}, cfg.Labels)
+
+	ic.Entrypoint.ShellFragment = "echo hello"
+	cfg, err = ic.ToOCIConfig("arm64")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/bin/sh", "-c", "echo hello"}, cfg.Entrypoint)
+
+	_, err = ic.ToOCIConfig("sparc")
+	require.Error(t, err)
+}
+
+func TestResolvedPackages(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.Packages = []string{"busybox", "arm-tools[arch=arm64]", "x86-tools>1.0[arch=amd64,386]"}
+	require.NoError(t, ic.Validate())
+
+	pkgs, err := ic.ResolvedPackages(ParseArchitecture("aarch64"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"busybox", "arm-tools"}, pkgs)
+
+	pkgs, err = ic.ResolvedPackages(ParseArchitecture("amd64"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"busybox", "x86-tools>1.0"}, pkgs)
+
+	for _, pkg := range []string{"foo[arch=sparc]", "foo[os=linux]", "foo[arch=arm64", "[arch=arm64]", "foo]"} {
+		ic.Contents.Packages = []string{pkg}
+		require.Error(t, ic.Validate(), pkg)
+	}
+}
+
+func TestPhasePackages(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.Packages = []string{"nginx=1.22-r0", "busybox>1.35[arch=amd64]", "ca-certificates", "@base"}
+	ic.Contents.PackageGroups = map[string][]string{"base": {"tzdata~2022", "wolfi-baselayout"}}
+
+	pkgs, err := ic.PhasePackages(ParseArchitecture("amd64"), []string{"busybox", "tzdata", "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx=1.22-r0", "busybox>1.35", "tzdata~2022"}, pkgs)
+
+	pkgs, err = ic.PhasePackages(ParseArchitecture("aarch64"), []string{"busybox"})
+	require.NoError(t, err)
+	require.Empty(t, pkgs)
+}
+
+func TestValidateUserGroups(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Accounts.Groups = []Group{{GroupName: "app", GID: 10000}}
+	ic.Accounts.Users = []User{{UserName: "app", UID: 10000, Groups: []string{"app", "wheel"}}}
+	require.NoError(t, ic.Validate())
+
+	ic.Accounts.Users[0].Groups = append(ic.Accounts.Users[0].Groups, "docker")
+	require.ErrorContains(t, ic.Validate(), "docker")
+}
+
+func TestValidateGroupMembershipCycles(t *testing.T) {
+	for _, c := range []struct {
+		desc   string
+		groups []Group
+		cycle  string
+	}{{
+		desc: "nested groups",
+		groups: []Group{
+			{GroupName: "a", GID: 1000, Members: []string{"b", "app"}},
+			{GroupName: "b", GID: 1001, Members: []string{"app"}},
+		},
+	}, {
+		desc: "user named like its group",
+		groups: []Group{
+			{GroupName: "app", GID: 1000, Members: []string{"app"}},
+		},
+	}, {
+		desc: "direct cycle",
+		groups: []Group{
+			{GroupName: "a", GID: 1000, Members: []string{"b"}},
+			{GroupName: "b", GID: 1001, Members: []string{"a"}},
+		},
+		cycle: "a -> b -> a",
+	}, {
+		desc: "indirect cycle",
+		groups: []Group{
+			{GroupName: "a", GID: 1000, Members: []string{"b"}},
+			{GroupName: "b", GID: 1001, Members: []string{"c"}},
+			{GroupName: "c", GID: 1002, Members: []string{"b"}},
+		},
+		cycle: "b -> c -> b",
+	}, {
+		desc: "self membership",
+		groups: []Group{
+			{GroupName: "a", GID: 1000, Members: []string{"a"}},
+		},
+		cycle: "a -> a",
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			ic := ImageConfiguration{}
+			ic.Accounts.Users = []User{{UserName: "app", UID: 1000}}
+			ic.Accounts.Groups = c.groups
+
+			err := ic.Validate()
+			if c.cycle == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, c.cycle)
+		})
+	}
+}
+
+func TestCmdArgs(t *testing.T) {
+	ic := ImageConfiguration{CmdArgs: []string{"--serve", "--name=my app"}}
+	ic.Entrypoint.Command = "/app"
+	require.NoError(t, ic.Validate())
+
+	cfg, err := ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/app"}, cfg.Entrypoint)
+	require.Equal(t, []string{"--serve", "--name=my app"}, cfg.Cmd)
+
+	ic.CmdArgs = []string{"--serve", " "}
+	require.Error(t, ic.Validate())
+
+	ic.CmdArgs = []string{"--serve"}
+	ic.Cmd = "--serve"
+	require.Error(t, ic.Validate())
+}
+
+func TestValidateOSReleaseID(t *testing.T) {
+	for _, c := range []struct {
+		id          string
+		expected    string
+		shouldError bool
+	}{
+		{id: "", expected: "alpine"},
+		{id: "wolfi", expected: "wolfi"},
+		{id: "my-distro_1.0", expected: "my-distro_1.0"},
+		{id: "Wolfi", shouldError: true},
+		{id: "my distro", shouldError: true},
+	} {
+		ic := ImageConfiguration{}
+		ic.OSRelease.ID = c.id
+
+		err := ic.Validate()
+		if c.shouldError {
+			require.Error(t, err, c.id)
+			continue
+		}
+		require.NoError(t, err, c.id)
+		require.Equal(t, c.expected, ic.OSRelease.ID)
+	}
+}
+
+func TestValidateEntrypoints(t *testing.T) {
+	ic := ImageConfiguration{
+		Entrypoints:       map[string]string{"serve": "/usr/bin/app serve", "migrate": "/usr/bin/app migrate"},
+		DefaultEntrypoint: "serve",
+	}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, EntrypointDispatcherPath, ic.Entrypoint.Command)
+	require.NoError(t, ic.Validate())
+
+	ic.DefaultEntrypoint = "debug"
+	require.Error(t, ic.Validate())
+
+	ic.DefaultEntrypoint = "serve"
+	ic.Entrypoints["bad name"] = "/bin/sh"
+	require.Error(t, ic.Validate())
+
+	delete(ic.Entrypoints, "bad name")
+	ic.Entrypoints["empty"] = " "
+	require.Error(t, ic.Validate())
+
+	delete(ic.Entrypoints, "empty")
+	ic.Entrypoint.Command = "/usr/bin/app"
+	require.Error(t, ic.Validate())
+
+	ic = ImageConfiguration{DefaultEntrypoint: "serve"}
+	require.Error(t, ic.Validate())
+}
+
+func TestLoadResolvesFileRepositories(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "apko.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+contents:
+  repositories:
+    - file://./packages
+    - "@local file://local"
+    - file:///srv/packages
+    - https://dl-cdn.alpinelinux.org/alpine/edge/main
+`), 0o644))
+
+	ic := ImageConfiguration{}
+	require.NoError(t, ic.Load(path, logrus.NewEntry(&logrus.Logger{})))
+	require.Equal(t, []string{
+		"file://" + filepath.Join(dir, "packages"),
+		"@local file://" + filepath.Join(dir, "local"),
+		"file:///srv/packages",
+		"https://dl-cdn.alpinelinux.org/alpine/edge/main",
+	}, ic.Contents.Repositories)
+}
+
+func TestValidatePortableNames(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		valid bool
+	}{
+		{"nginx", true},
+		{"_apt", true},
+		{"www-data", true},
+		{"my user", false},
+		{"1user", false},
+		{"-user", false},
+		{"averyveryveryverylongusernamethatisover32", false},
+	} {
+		ic := ImageConfiguration{}
+		ic.Accounts.Users = []User{{UserName: c.name, UID: 10000}}
+		ic.Accounts.Groups = []Group{{GroupName: c.name, GID: 10000}}
+		if c.valid {
+			require.NoError(t, ic.Validate(), c.name)
+			continue
+		}
+		require.ErrorContains(t, ic.Validate(), c.name)
+
+		ic.Accounts.AllowLegacyNames = true
+		require.NoError(t, ic.Validate(), c.name)
+	}
+}
+
+func TestValidateTimezoneAndLocale(t *testing.T) {
+	ic := ImageConfiguration{Timezone: "Europe/Berlin", Locale: "en_US.UTF-8", Cmd: "/bin/sh"}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, "en_US.UTF-8", ic.Environment["LANG"])
+	require.Len(t, ic.Warnings(), 1)
+
+	ic.Contents.Packages = []string{"tzdata"}
+	require.Empty(t, ic.Warnings())
+
+	// The image keeps the default PATH.
+	cfg, err := ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"LANG=en_US.UTF-8",
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt",
+	}, cfg.Env)
+
+	// An explicit LANG is kept.
+	ic = ImageConfiguration{Locale: "C.UTF-8", Environment: map[string]string{"LANG": "POSIX"}}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, "POSIX", ic.Environment["LANG"])
+
+	// A shared environment is not modified.
+	environment := map[string]string{"FOO": "bar"}
+	ic = ImageConfiguration{Locale: "C.UTF-8", Environment: environment}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, "C.UTF-8", ic.Environment["LANG"])
+	require.Equal(t, map[string]string{"FOO": "bar"}, environment)
+
+	for _, tz := range []string{"Mars/Olympus_Mons", "Local", "../etc/passwd"} {
+		ic = ImageConfiguration{Timezone: tz}
+		require.Error(t, ic.Validate(), tz)
+	}
+
+	for _, locale := range []string{"en US", "en_US.", ".UTF-8"} {
+		ic = ImageConfiguration{Locale: locale}
+		require.Error(t, ic.Validate(), locale)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	logger := logrus.NewEntry(&logrus.Logger{})
+	fingerprint := func(data string) string {
+		path := filepath.Join(t.TempDir(), "apko.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+
+		ic := ImageConfiguration{}
+		require.NoError(t, ic.Load(path, logger))
+		fp, err := ic.Fingerprint()
+		require.NoError(t, err)
+		return fp
+	}
+
+	base := fingerprint(`
+contents:
+  packages:
+    - alpine-baselayout
+    - nginx
+environment:
+  A: a
+  B: b
+archs:
+  - amd64
+  - arm64
+`)
+	require.Equal(t, base, fingerprint(`
+# reordered
+archs: [arm64, amd64]
+environment: {B: b, A: a}
+contents:
+  packages: [nginx, alpine-baselayout]
+`))
+	require.NotEqual(t, base, fingerprint(`
+contents:
+  packages: [nginx, alpine-baselayout]
+environment: {B: b, A: a}
+archs: [amd64]
+`))
+}
+
+func TestFingerprintInputs(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "app.env")
+	path := filepath.Join(dir, "apko.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+ci-annotations: true
+environment-files: [app.env]
+`), 0o644))
+
+	fingerprint := func() string {
+		ic := ImageConfiguration{}
+		require.NoError(t, ic.Load(path, logrus.NewEntry(&logrus.Logger{})))
+		fp, err := ic.Fingerprint()
+		require.NoError(t, err)
+		return fp
+	}
+
+	require.NoError(t, os.WriteFile(envFile, []byte("A=a\n"), 0o644))
+	base := fingerprint()
+
+	// The annotations derived from the CI environment are ignored.
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("GITHUB_RUN_ID", "42")
+	require.Equal(t, base, fingerprint())
+
+	// The contents of the referenced files are not.
+	require.NoError(t, os.WriteFile(envFile, []byte("A=b\n"), 0o644))
+	require.NotEqual(t, base, fingerprint())
+}
+
+func TestValidatePackageChecksums(t *testing.T) {
+	sum := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	ic := ImageConfiguration{}
+	ic.Contents.PackageChecksums = map[string]string{"nginx=1.22.0-r1": sum}
+	require.NoError(t, ic.Validate())
+
+	for pkg, s := range map[string]string{
+		"nginx":            sum,
+		"=1.22.0-r1":       sum,
+		"nginx=":           sum,
+		"nginx=1.22.0-r1":  sum[:63],
+		"nginx=1.22.0-r2":  "z" + sum[1:],
+		"busybox=1.35.0-r": "",
+	} {
+		ic.Contents.PackageChecksums = map[string]string{pkg: s}
+		require.Error(t, ic.Validate(), pkg)
+	}
+}
+
+func TestValidateVersionPolicy(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.Packages = []string{"alpine-baselayout=3.2.0-r22", "nginx", "curl>=7.80"}
+	require.NoError(t, ic.Validate())
+
+	ic.Contents.VersionPolicy = VersionPolicyPinned
+	require.ErrorContains(t, ic.Validate(), `"nginx"`)
+
+	ic.Contents.Packages = []string{"alpine-baselayout=3.2.0-r22", "curl>=7.80"}
+	require.ErrorContains(t, ic.Validate(), `"curl>=7.80"`)
+
+	ic.Contents.Packages = []string{"alpine-baselayout=3.2.0-r22", "nginx=1.22.0-r1[arch=amd64]"}
+	require.NoError(t, ic.Validate())
+
+	ic.Contents.VersionPolicy = "latest"
+	require.Error(t, ic.Validate())
+}
+
+func TestValidateStrip(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.Strip.Docs = true
+	ic.Contents.Strip.Paths = []string{"/usr/share/locale/*", "/usr/lib/*.a"}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, []string{
+		"/usr/share/doc", "/usr/share/man", "/usr/share/info", "/usr/share/locale/*", "/usr/lib/*.a",
+	}, ic.Contents.Strip.Patterns())
+
+	for _, pattern := range []string{"usr/share/locale/*", "/usr/../../etc/*", "/*/../..", "/", "/usr/[a"} {
+		ic.Contents.Strip.Paths = []string{pattern}
+		require.Error(t, ic.Validate(), pattern)
+	}
+}
+
+func TestValidateAPKOptions(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.APKOptions = []string{"--no-scripts", "--force-overwrite"}
+	require.NoError(t, ic.Validate())
+
+	for _, opt := range []string{"--allow-untrusted", "--root=/", "--keys-dir", ""} {
+		ic.Contents.APKOptions = []string{opt}
+		require.Error(t, ic.Validate(), opt)
+	}
+}
+
+func TestLoadEnvironmentFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "common.env"), []byte(`
+# shared settings
+PATH=/usr/sbin:/usr/bin
+LANG="en_US.UTF-8"
+MODE=common
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.env"), []byte("MODE='app'\n"), 0o644))
+	path := filepath.Join(dir, "apko.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+environment-files:
+  - common.env
+  - app.env
+environment:
+  PATH: /bin
+`), 0o644))
+
+	ic := ImageConfiguration{}
+	require.NoError(t, ic.Load(path, logrus.NewEntry(&logrus.Logger{})))
+	require.Equal(t, map[string]string{
+		"PATH": "/bin",
+		"LANG": "en_US.UTF-8",
+		"MODE": "app",
+	}, ic.Environment)
+	require.NoError(t, ic.Validate())
+
+	bad := filepath.Join(dir, "bad.env")
+	require.NoError(t, os.WriteFile(bad, []byte("# comment\nGOOD=1\nnot a variable\n"), 0o644))
+	ic.EnvironmentFiles = []string{bad}
+	require.ErrorContains(t, ic.Validate(), bad+":3: malformed environment line")
+
+	ic.EnvironmentFiles = []string{filepath.Join(dir, "missing.env")}
+	require.Error(t, ic.Validate())
+}
+
+func TestEntrypointInit(t *testing.T) {
+	ic := ImageConfiguration{Cmd: "--verbose"}
+	ic.Contents.Packages = []string{"busybox"}
+	ic.Entrypoint.Command = "/usr/bin/app"
+	ic.Entrypoint.Init = true
+	require.NoError(t, ic.Validate())
+	require.Equal(t, []string{"busybox", "tini"}, ic.Contents.Packages)
+
+	cfg, err := ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/sbin/tini", "--", "/usr/bin/app"}, cfg.Entrypoint)
+	require.Equal(t, []string{"--verbose"}, cfg.Cmd)
+
+	// A listed init package is not added again.
+	ic.Contents.Packages = []string{"dumb-init=1.2.5-r1"}
+	ic.Entrypoint.InitPackage = "dumb-init"
+	require.NoError(t, ic.Validate())
+	require.Equal(t, []string{"dumb-init=1.2.5-r1"}, ic.Contents.Packages)
+
+	cfg, err = ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/usr/bin/dumb-init", "--", "/usr/bin/app"}, cfg.Entrypoint)
+
+	ic.Entrypoint.InitPackage = "systemd"
+	require.Error(t, ic.Validate())
+
+	ic.Entrypoint.Init = false
+	ic.Entrypoint.InitPackage = "tini"
+	require.Error(t, ic.Validate())
+
+	ic = ImageConfiguration{}
+	ic.Entrypoint.Type = "service-bundle"
+	ic.Entrypoint.Init = true
+	require.Error(t, ic.Validate())
+}
+
+func TestEntrypointUmask(t *testing.T) {
+	ic := ImageConfiguration{Cmd: "--verbose"}
+	ic.Entrypoint.Command = "/usr/bin/app"
+	ic.Entrypoint.Init = true
+	ic.Entrypoint.Umask = "027"
+	require.NoError(t, ic.Validate())
+
+	cfg, err := ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/bin/sh", "-c", `umask 027 && exec "$@"`, "umask", "/sbin/tini", "--", "/usr/bin/app",
+	}, cfg.Entrypoint)
+	require.Equal(t, []string{"--verbose"}, cfg.Cmd)
+
+	// Nothing is wrapped when the image has no entrypoint or cmd.
+	ic = ImageConfiguration{}
+	ic.Entrypoint.Umask = "0022"
+	require.NoError(t, ic.Validate())
+	cfg, err = ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Empty(t, cfg.Entrypoint)
+
+	for _, umask := range []string{"22", "1022", "0o22", "089"} {
+		ic.Entrypoint.Umask = umask
+		require.ErrorContains(t, ic.Validate(), "octal mask", umask)
+	}
+}
+
+func TestEntrypointStopTimeout(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Entrypoint.StopTimeout = "90s"
+	require.NoError(t, ic.Validate())
+	require.Equal(t, map[string]string{"io.apko.stop-timeout": "1m30s"}, ic.Annotations)
+
+	for timeout, msg := range map[string]string{
+		"30":  "parsing stop timeout",
+		"0s":  "must be positive",
+		"-5m": "must be positive",
+	} {
+		ic = ImageConfiguration{}
+		ic.Entrypoint.StopTimeout = timeout
+		require.ErrorContains(t, ic.Validate(), msg, timeout)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	manage := true
+	ic := ImageConfiguration{Archs: []Architecture{ParseArchitecture("x86_64")}}
+	ic.Contents.Packages = []string{"busybox"}
+	ic.Entrypoint.Type = "service-bundle"
+	ic.Entrypoint.ManageServices = &manage
+	ic.Entrypoint.Services = map[interface{}]interface{}{"nginx": "/usr/sbin/nginx"}
+
+	checked, err := ic.Check()
+	require.NoError(t, err)
+	require.Equal(t, []string{"busybox", "s6"}, checked.Contents.Packages)
+	require.Equal(t, serviceBundleCommand, checked.Entrypoint.Command)
+	require.Equal(t, ic.Archs, checked.Archs)
+	require.True(t, *checked.Entrypoint.ManageServices)
+
+	// The receiver is left untouched.
+	require.Equal(t, []string{"busybox"}, ic.Contents.Packages)
+	require.Empty(t, ic.Entrypoint.Command)
+	*checked.Entrypoint.ManageServices = false
+	require.True(t, *ic.Entrypoint.ManageServices)
+
+	ic.Contents.Packages = []string{"busybox[arch=sparc]"}
+	_, err = ic.Check()
+	require.Error(t, err)
+}
+
+func TestPackageGroups(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.PackageGroups = map[string][]string{
+		"web-stack": {"nginx", "php81-fpm", "x86-tools[arch=amd64]"},
+	}
+	ic.Contents.Packages = []string{"busybox", "@web-stack", "@web-stack[arch=arm64]"}
+	require.NoError(t, ic.Validate())
+	require.True(t, ic.hasPackage("php81-fpm"))
+
+	pkgs, err := ic.ResolvedPackages(ParseArchitecture("amd64"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"busybox", "nginx", "php81-fpm", "x86-tools"}, pkgs)
+
+	pkgs, err = ic.ResolvedPackages(ParseArchitecture("arm64"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"busybox", "nginx", "php81-fpm", "nginx", "php81-fpm"}, pkgs)
+
+	// The packages of groups must be pinned by the pinned version policy.
+	ic.Contents.VersionPolicy = VersionPolicyPinned
+	ic.Contents.Packages = []string{"busybox=1.35.0-r17", "@web-stack"}
+	require.ErrorContains(t, ic.Validate(), `"nginx"`)
+	ic.Contents.VersionPolicy = ""
+
+	ic.Contents.Packages = []string{"@db-stack"}
+	require.ErrorContains(t, ic.Validate(), "undefined package group")
+
+	ic.Contents.Packages = []string{"@web-stack"}
+	ic.Contents.PackageGroups["nested"] = []string{"@web-stack"}
+	require.Error(t, ic.Validate())
+
+	// Groups of an overlay replace the groups of the base with the same name.
+	base := ImageConfiguration{}
+	base.Contents.PackageGroups = map[string][]string{"web-stack": {"nginx"}, "tools": {"curl"}}
+	overlay := ImageConfiguration{}
+	overlay.Contents.PackageGroups = map[string][]string{"web-stack": {"caddy"}}
+	require.NoError(t, base.Merge(&overlay))
+	require.Equal(t, map[string][]string{"web-stack": {"caddy"}, "tools": {"curl"}}, base.Contents.PackageGroups)
+}
+
+func TestValidateEntrypointSet(t *testing.T) {
+	ic := ImageConfiguration{}
+	require.ErrorContains(t, ic.ValidateEntrypointSet(), "containers run from the image will exit immediately")
+	require.Len(t, ic.Warnings(), 1)
+
+	for _, set := range []func(*ImageConfiguration){
+		func(ic *ImageConfiguration) { ic.Entrypoint.Command = "/usr/bin/app" },
+		func(ic *ImageConfiguration) { ic.Entrypoint.ShellFragment = "exec /usr/bin/app" },
+		func(ic *ImageConfiguration) { ic.Entrypoints = map[string]string{"app": "/usr/bin/app"} },
+		func(ic *ImageConfiguration) { ic.Cmd = "/bin/sh" },
+		func(ic *ImageConfiguration) { ic.CmdArgs = []string{"/bin/sh"} },
+		// service bundles are started by their supervisor
+		func(ic *ImageConfiguration) { ic.Entrypoint.Type = "service-bundle" },
+	} {
+		ic := ImageConfiguration{}
+		set(&ic)
+		require.NoError(t, ic.ValidateEntrypointSet())
+		require.Empty(t, ic.Warnings())
+	}
+}
+
+func TestValidateBaseImageDigest(t *testing.T) {
+	ic := ImageConfiguration{Cmd: "/bin/sh"}
+	require.NoError(t, ic.ValidateBaseImageDigest())
+
+	ic.Contents.BaseImage = "cgr.dev/chainguard/static@sha256:" + strings.Repeat("0", 64)
+	require.NoError(t, ic.ValidateBaseImageDigest())
+	require.Empty(t, ic.Warnings())
+
+	ic.Contents.BaseImage = "cgr.dev/chainguard/static:latest"
+	require.Error(t, ic.ValidateBaseImageDigest())
+	require.Len(t, ic.Warnings(), 1)
+}
+
+func TestValidateSetuidAllow(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Setuid.Allow = []string{"/bin/bbsuid", "/usr/bin/*"}
+	require.NoError(t, ic.Validate())
+
+	ic.Setuid.Allow = []string{"bin/bbsuid"}
+	require.ErrorContains(t, ic.Validate(), "not an absolute path")
+
+	ic.Setuid.Allow = []string{"/bin/["}
+	require.ErrorContains(t, ic.Validate(), "not a valid pattern")
+}
+
+func TestValidateResolvConf(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.ResolvConf.Nameservers = []string{"8.8.8.8", "::1"}
+	ic.Contents.ResolvConf.Search = []string{"example.com", "svc.cluster.local."}
+	require.NoError(t, ic.Validate())
+
+	ic.Contents.ResolvConf.Nameservers = []string{"dns.example.com"}
+	require.ErrorContains(t, ic.Validate(), "not an IP address")
+
+	ic.Contents.ResolvConf.Nameservers = []string{"1.1.1.1", "1.0.0.1", "8.8.8.8", "8.8.4.4"}
+	require.ErrorContains(t, ic.Validate(), "at most 3")
+
+	ic.Contents.ResolvConf.Nameservers = nil
+	for _, domain := range []string{"-bad.example.com", "a..b", "under_score.com"} {
+		ic.Contents.ResolvConf.Search = []string{domain}
+		require.ErrorContains(t, ic.Validate(), "not a valid domain name", domain)
+	}
+}
+
+func TestValidateLocalPackages(t *testing.T) {
+	dir := t.TempDir()
+	pkg := filepath.Join(dir, "hello-1.0-r0.apk")
+	require.NoError(t, os.WriteFile(pkg, []byte{}, 0o644))
+
+	ic := ImageConfiguration{}
+	ic.Contents.LocalPackages = []string{pkg}
+	require.NoError(t, ic.Validate())
+
+	for path, msg := range map[string]string{
+		filepath.Join(dir, "missing.apk"): "is not readable",
+		filepath.Join(dir, "hello.tar"):   "is not an .apk file",
+		dir + "/.apk":                     "is not readable",
+	} {
+		ic.Contents.LocalPackages = []string{path}
+		require.ErrorContains(t, ic.Validate(), msg, path)
+	}
+}
+
+func TestValidateCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "APKINDEX.tar.gz")
+	require.NoError(t, os.WriteFile(file, []byte{}, 0o644))
+
+	ic := ImageConfiguration{}
+	ic.Contents.CacheDir = dir
+	require.NoError(t, ic.Validate())
+
+	for path, msg := range map[string]string{
+		filepath.Join(dir, "missing"): "is not readable",
+		file:                          "is not a directory",
+	} {
+		ic.Contents.CacheDir = path
+		require.ErrorContains(t, ic.Validate(), msg, path)
+	}
+}
+
+func TestValidateBytes(t *testing.T) {
+	// referenced files are not looked at
+	require.NoError(t, ValidateBytes([]byte(`
+contents:
+  packages:
+    - alpine-baselayout
+  local-packages:
+    - ./missing.apk
+  pre-install:
+    - ./missing.sh
+environment-files:
+  - ./missing.env
+ports:
+  - 8080
+`)))
+	require.NoError(t, ValidateBytes([]byte{}))
+
+	// neither the tz database nor the CI environment is looked at
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("GITHUB_SHA", "0123456789abcdef")
+	ic, err := validateBytes([]byte("timezone: Mars/Olympus_Mons\nci-annotations: true\n"))
+	require.NoError(t, err)
+	for k := range ic.Annotations {
+		require.False(t, strings.HasPrefix(k, ciAnnotationPrefix), k)
+	}
+	require.Error(t, ic.Validate())
+
+	err = ValidateBytes([]byte("contents:\n  pakages: [busybox]\n"))
+	require.ErrorIs(t, err, ErrConfigParse)
+	require.ErrorContains(t, err, "field pakages not found")
+
+	err = ValidateBytes([]byte("ports:\n  - 8080/http\n"))
+	var ve *ValidationError
+	require.ErrorAs(t, err, &ve)
+	require.Equal(t, "ports", ve.Field)
+}
+
+func TestValidateSBOMExcludePackages(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.SBOM.ExcludePackages = []string{"build-helper"}
+	require.NoError(t, ic.Validate())
+
+	for _, pkg := range []string{"", "build-helper=1.0-r0", "build-helper[arch=x86_64]"} {
+		ic.SBOM.ExcludePackages = []string{pkg}
+		require.ErrorContains(t, ic.Validate(), "must be a package name", pkg)
+	}
+}
+
+func TestValidateHosts(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.Hosts = []string{"10.0.0.1 db.internal db", "::1 ip6-localhost"}
+	require.NoError(t, ic.Validate())
+
+	for entry, msg := range map[string]string{
+		"10.0.0.1":                "followed by host names",
+		"db.internal 10.0.0.1":    "not an IP address",
+		"10.0.0.1 under_score.io": "not a valid host name",
+	} {
+		ic.Contents.Hosts = []string{entry}
+		require.ErrorContains(t, ic.Validate(), msg, entry)
+	}
+}
+
+func TestValidateAPKToolsVersion(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.APKToolsVersion = "2.12.9"
+	require.NoError(t, ic.Validate())
+
+	ic.Contents.APKToolsVersion = "latest"
+	require.ErrorContains(t, ic.Validate(), "not a valid version")
+}
+
+func TestErrorTypes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "apko.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("contents: [\n"), 0o644))
+
+	ic := ImageConfiguration{}
+	err := ic.Load(path, logrus.NewEntry(logrus.New()))
+	require.ErrorIs(t, err, ErrConfigParse)
+	require.ErrorContains(t, err, "failed to parse image configuration")
+
+	ic = ImageConfiguration{}
+	ic.Contents.Packages = []string{"busybox[arch=sparc]"}
+	var ve *ValidationError
+	require.ErrorAs(t, ic.Validate(), &ve)
+	require.Equal(t, "contents.packages", ve.Field)
+
+	ic = ImageConfiguration{}
+	ic.Contents.PostInstall = []string{""}
+	require.ErrorAs(t, ic.Validate(), &ve)
+	require.Equal(t, "contents.post-install", ve.Field)
+
+	require.ErrorIs(t, ic.DetectVCSUrl(filepath.Join(dir, "missing", "apko.yaml")), ErrVCSProbe)
+}
+
+func TestValidateIDs(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Accounts.Users = []User{{UserName: "app", UID: 65532, GID: 65532}}
+	ic.Accounts.Groups = []Group{{GroupName: "app", GID: 65532}}
+	ic.Accounts.RunAs = "65532:65532"
+	require.NoError(t, ic.Validate())
+
+	ic.Accounts.Users[0].UID = MaxID + 1
+	var ve *ValidationError
+	err := ic.Validate()
+	require.ErrorAs(t, err, &ve)
+	require.Equal(t, "accounts.users", ve.Field)
+	require.ErrorContains(t, err, "user app UID 2147483648 is above the maximum ID")
+	ic.Accounts.Users[0].UID = 65532
+
+	ic.Paths = []PathMutation{{Path: "/data", Type: "directory", GID: 4294967295}}
+	require.ErrorContains(t, ic.Validate(), "path /data GID 4294967295")
+	ic.Paths = nil
+
+	ic.Accounts.RunAs = "65532:99999999999"
+	require.ErrorContains(t, ic.Validate(), "run-as GID 99999999999 does not fit in 32 bits")
+
+	ic.Accounts.RunAs = "3000000000"
+	require.ErrorContains(t, ic.Validate(), "run-as UID 3000000000 is above the maximum ID")
+
+	// Names are not checked here.
+	ic.Accounts.RunAs = "app:app"
+	require.NoError(t, ic.Validate())
+}
+
+func TestTmpfs(t *testing.T) {
+	ic := ImageConfiguration{Tmpfs: []string{"/tmp", "/run/", "/var/cache/../run", "/tmp"}}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, map[string]string{"io.apko.tmpfs": "/run,/tmp,/var/run"}, ic.Annotations)
+
+	ic = ImageConfiguration{Tmpfs: []string{"/tmp"}, Annotations: map[string]string{"io.apko.tmpfs": "/cache"}}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, "/cache", ic.Annotations["io.apko.tmpfs"])
+
+	for p, msg := range map[string]string{
+		"tmp":       "not an absolute path",
+		"/tmp,/run": "must not contain a comma",
+	} {
+		ic = ImageConfiguration{Tmpfs: []string{p}}
+		require.ErrorContains(t, ic.Validate(), msg, p)
+	}
+}
+
+func TestFileSizeLimits(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.txt")
+	large := filepath.Join(dir, "large.bin")
+	require.NoError(t, os.WriteFile(small, make([]byte, 10), 0o644))
+	require.NoError(t, os.WriteFile(large, make([]byte, 100), 0o644))
+
+	ic := ImageConfiguration{}
+	ic.Contents.Files = []File{
+		{Source: small, Destination: "/etc/small.txt"},
+		{Source: large, Destination: "/opt/large.bin"},
+	}
+	require.NoError(t, ic.Validate())
+
+	ic.Contents.MaxFileSize = 50
+	err := ic.Validate()
+	require.ErrorContains(t, err, "limit of 50 bytes per file")
+	require.ErrorContains(t, err, large+" (100 bytes)")
+	require.NotContains(t, err.Error(), small)
+
+	ic.Contents.MaxFileSize = 0
+	ic.Contents.MaxTotalSize = 100
+	require.ErrorContains(t, ic.Validate(), "total 110 bytes, more than the limit of 100 bytes")
+
+	ic.Contents.MaxTotalSize = -1
+	require.ErrorContains(t, ic.Validate(), "must not be negative")
+
+	require.NoError(t, ValidateBytes([]byte("contents:\n  max-file-size: 50\n  max-total-size: 100\n")))
+}
+
+func TestResolvedAnnotations(t *testing.T) {
+	ic := ImageConfiguration{
+		Annotations: map[string]string{"org.opencontainers.image.vendor": "Chainguard"},
+		Tmpfs:       []string{"/tmp"},
+	}
+	ic.Entrypoint.StopTimeout = "30s"
+	ic.Capabilities.Dropped = []string{"ALL"}
+	require.NoError(t, ic.Validate())
+
+	annotations := ic.ResolvedAnnotations()
+	require.Equal(t, map[string]string{
+		"org.opencontainers.image.vendor":       "Chainguard",
+		"io.apko.tmpfs":                         "/tmp",
+		"io.apko.stop-timeout":                  "30s",
+		"io.apko.security.capabilities.dropped": "ALL",
+	}, annotations)
+
+	// the returned map is a copy
+	annotations["io.apko.tmpfs"] = "/run"
+	require.Equal(t, "/tmp", ic.Annotations["io.apko.tmpfs"])
+
+	// the source is derived from the VCS URL
+	ic = ImageConfiguration{VCSUrl: "https://github.com/example/images"}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, map[string]string{
+		"org.opencontainers.image.source": "https://github.com/example/images",
+	}, ic.ResolvedAnnotations())
+}
+
+func TestFileDestinations(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(src, []byte("listen: 8080\n"), 0o644))
+
+	for dest, msg := range map[string]string{
+		"/etc/myapp/config.yaml":  "",
+		"/etc/../etc/config.yaml": "",
+		"etc/config.yaml":         "not an absolute path",
+		"/../../root/.bashrc":     "outside of the image",
+		"/etc/../../config.yaml":  "outside of the image",
+	} {
+		ic := ImageConfiguration{}
+		ic.Contents.Files = []File{{Source: src, Destination: dest}}
+		if msg == "" {
+			require.NoError(t, ic.Validate(), dest)
+		} else {
+			require.ErrorContains(t, ic.Validate(), msg, dest)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The protocols of exposed ports, as in the OCI image specification.
+var portProtocols = map[string]struct{}{
+	"tcp":  {},
+	"udp":  {},
+	"sctp": {},
+}
+
+// normalizePort parses a port entry, e.g. 8080 or 53/UDP, returning its
+// number and its lower case protocol, which defaults to tcp.
+func normalizePort(entry string) (int, string, error) {
+	number, protocol, ok := strings.Cut(strings.TrimSpace(entry), "/")
+	if !ok {
+		protocol = "tcp"
+	}
+	protocol = strings.ToLower(protocol)
+
+	port, err := strconv.Atoi(number)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, "", fmt.Errorf("port %q must be a number between 1 and 65535", entry)
+	}
+
+	if _, ok := portProtocols[protocol]; !ok {
+		return 0, "", fmt.Errorf("port %q has an unknown protocol %q, must be tcp, udp or sctp", entry, protocol)
+	}
+
+	return port, protocol, nil
+}
+
+// validatePorts normalizes the exposed ports to <port>/<protocol>, sorted
+// by port and protocol, failing on malformed and duplicate entries.
+func (ic *ImageConfiguration) validatePorts() error {
+	if len(ic.Ports) == 0 {
+		return nil
+	}
+
+	type port struct {
+		number   int
+		protocol string
+	}
+
+	seen := map[port]string{}
+	ports := make([]port, 0, len(ic.Ports))
+	for _, entry := range ic.Ports {
+		number, protocol, err := normalizePort(entry)
+		if err != nil {
+			return err
+		}
+
+		p := port{number: number, protocol: protocol}
+		if first, ok := seen[p]; ok {
+			return fmt.Errorf("port %q duplicates port %q", entry, first)
+		}
+		seen[p] = entry
+		ports = append(ports, p)
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].number != ports[j].number {
+			return ports[i].number < ports[j].number
+		}
+		return ports[i].protocol < ports[j].protocol
+	})
+
+	normalized := make([]string, 0, len(ports))
+	for _, p := range ports {
+		normalized = append(normalized, fmt.Sprintf("%d/%s", p.number, p.protocol))
+	}
+	ic.Ports = normalized
+
+	return nil
+}
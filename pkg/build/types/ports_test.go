@@ -0,0 +1,47 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPorts(t *testing.T) {
+	ic := ImageConfiguration{Ports: []string{"8443", "53/UDP", "8080/tcp", "53/tcp", " 9000/sctp"}}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, []string{"53/tcp", "53/udp", "8080/tcp", "8443/tcp", "9000/sctp"}, ic.Ports)
+
+	cfg, err := ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Len(t, cfg.ExposedPorts, 5)
+	require.Contains(t, cfg.ExposedPorts, "53/udp")
+
+	for _, tc := range []struct {
+		ports []string
+		msg   string
+	}{
+		{ports: []string{"8080/tcp", "8080"}, msg: "port \"8080\" duplicates port \"8080/tcp\""},
+		{ports: []string{"53/udp", "53/UDP"}, msg: "duplicates"},
+		{ports: []string{"http"}, msg: "must be a number"},
+		{ports: []string{"70000"}, msg: "must be a number between 1 and 65535"},
+		{ports: []string{"8080/tcp/udp"}, msg: "unknown protocol"},
+		{ports: []string{"8080/quic"}, msg: "unknown protocol \"quic\""},
+	} {
+		ic := ImageConfiguration{Ports: tc.ports}
+		require.ErrorContains(t, ic.Validate(), tc.msg, tc.ports)
+	}
+}
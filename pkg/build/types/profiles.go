@@ -0,0 +1,57 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import "fmt"
+
+// The entrypoint types apko knows how to set up. An empty type runs the
+// entrypoint command directly.
+var entrypointTypes = map[string]struct{}{
+	"":               {},
+	"service-bundle": {},
+}
+
+// applyProfile replaces the entrypoint and command of the configuration
+// with those of the selected profile, if any.
+func (ic *ImageConfiguration) applyProfile() error {
+	for name := range ic.Profiles {
+		if !entrypointNameRegexp.MatchString(name) {
+			return fmt.Errorf("profile name %q may only contain letters, digits, '.', '_' and '-'", name)
+		}
+	}
+
+	if ic.Profile == "" {
+		return nil
+	}
+
+	p, ok := ic.Profiles[ic.Profile]
+	if !ok {
+		return fmt.Errorf("profile %q is not one of the configured profiles", ic.Profile)
+	}
+
+	if p.Entrypoint != nil {
+		if _, ok := entrypointTypes[p.Entrypoint.Type]; !ok {
+			return fmt.Errorf("profile %s has an unknown entrypoint type %q", ic.Profile, p.Entrypoint.Type)
+		}
+		ic.Entrypoint = *p.Entrypoint
+	}
+
+	if p.Cmd != "" || len(p.CmdArgs) != 0 {
+		ic.Cmd = p.Cmd
+		ic.CmdArgs = p.CmdArgs
+	}
+
+	return nil
+}
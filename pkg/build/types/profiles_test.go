@@ -0,0 +1,67 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+entrypoint:
+  command: /usr/bin/app
+cmd: --serve
+profiles:
+  debug:
+    entrypoint:
+      command: /bin/sh
+    cmd-args: ["-l"]
+  invalid:
+    entrypoint:
+      type: supervisor
+      command: /usr/bin/app
+`), 0o644))
+
+	load := func(profile string) *ImageConfiguration {
+		ic := &ImageConfiguration{}
+		require.NoError(t, ic.Load(path, logrus.NewEntry(&logrus.Logger{})))
+		ic.Profile = profile
+		return ic
+	}
+
+	ic := load("")
+	require.NoError(t, ic.Validate())
+	require.Equal(t, "/usr/bin/app", ic.Entrypoint.Command)
+	require.Equal(t, "--serve", ic.Cmd)
+
+	ic = load("debug")
+	require.NoError(t, ic.Validate())
+	require.Equal(t, "/bin/sh", ic.Entrypoint.Command)
+	require.Empty(t, ic.Cmd)
+	require.Equal(t, []string{"-l"}, ic.CmdArgs)
+
+	// validating again keeps the profile applied
+	require.NoError(t, ic.Validate())
+	require.Equal(t, "/bin/sh", ic.Entrypoint.Command)
+
+	require.ErrorContains(t, load("invalid").Validate(), "unknown entrypoint type \"supervisor\"")
+	require.ErrorContains(t, load("release").Validate(), "not one of the configured profiles")
+}
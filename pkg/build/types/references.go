@@ -0,0 +1,101 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReferencedFiles returns the absolute paths of the files the
+// configuration depends on: the configuration files it was loaded from,
+// including the included ones, and the environment files, local packages,
+// installed files, scripts, keys, apk cache and local repositories it
+// references.
+// Remote includes, keys and repositories are left out. Changing any of
+// the files may change the image built from the configuration.
+func (ic *ImageConfiguration) ReferencedFiles() []string {
+	seen := map[string]struct{}{}
+	add := func(p string) {
+		if p == "" {
+			return
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		seen[p] = struct{}{}
+	}
+
+	for _, p := range ic.configFiles {
+		add(p)
+	}
+	for _, p := range ic.EnvironmentFiles {
+		add(p)
+	}
+	for _, p := range ic.Contents.LocalPackages {
+		add(p)
+	}
+	for _, p := range ic.Contents.PreInstall {
+		add(p)
+	}
+	for _, p := range ic.Contents.PostInstall {
+		add(p)
+	}
+	for _, f := range ic.Contents.Files {
+		add(f.Source)
+	}
+	for _, key := range ic.Contents.Keyring {
+		if !strings.Contains(key, "://") {
+			add(key)
+		}
+	}
+	add(ic.Contents.CacheDir)
+	for _, entry := range ic.Contents.Repositories {
+		add(localRepositoryPath(entry))
+	}
+
+	files := make([]string, 0, len(seen))
+	for p := range seen {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// localRepositoryPath returns the directory of a local repository entry,
+// either a file:// URL or a plain path, optionally tagged or restricted to
+// architectures, or "" for remote repositories.
+func localRepositoryPath(entry string) string {
+	if repo, _, err := parseRepository(entry); err == nil {
+		entry = repo
+	}
+
+	fields := strings.Fields(entry)
+	if len(fields) == 0 {
+		return ""
+	}
+	url := fields[len(fields)-1]
+
+	if strings.HasPrefix(url, "file://") {
+		return strings.TrimPrefix(url, "file://")
+	}
+	if strings.HasPrefix(url, "/") || strings.HasPrefix(url, ".") {
+		return url
+	}
+
+	return ""
+}
@@ -0,0 +1,74 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferencedFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	base := write("base.yaml", `
+contents:
+  keyring:
+    - https://alpinelinux.org/keys/alpine-devel@lists.alpinelinux.org-4a6a0840.rsa.pub
+`)
+	config := write("config.yaml", `
+include: `+base+`
+contents:
+  repositories:
+    - https://dl-cdn.alpinelinux.org/alpine/edge/main
+    - "@local file://./packages[arch=x86_64]"
+    - /srv/apk/main
+  cache-dir: cache
+  local-packages:
+    - app.apk
+  files:
+    - source: motd
+      destination: /etc/motd
+  post-install:
+    - scripts/cleanup.sh
+environment-files:
+  - app.env
+`)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "cache"), 0o755))
+
+	ic := &ImageConfiguration{}
+	require.NoError(t, ic.Load(config, logrus.NewEntry(&logrus.Logger{})))
+
+	require.Equal(t, []string{
+		"/srv/apk/main",
+		filepath.Join(dir, "app.apk"),
+		filepath.Join(dir, "app.env"),
+		base,
+		filepath.Join(dir, "cache"),
+		config,
+		filepath.Join(dir, "motd"),
+		filepath.Join(dir, "packages"),
+		filepath.Join(dir, "scripts", "cleanup.sh"),
+	}, ic.ReferencedFiles())
+}
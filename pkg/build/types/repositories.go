@@ -0,0 +1,129 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// CheckRepositories verifies that the APKINDEX of every configured
+// repository is reachable for each architecture of the image, or the
+// host architecture if none is configured. Remote indexes are requested
+// over HTTP, unless offline, when apk reads them from its cache instead,
+// while local repositories are checked for existence. All failures are
+// reported together.
+func (ic *ImageConfiguration) CheckRepositories(ctx context.Context, offline bool) error {
+	archs := ic.Archs
+	if len(archs) == 0 {
+		archs = []Architecture{ParseArchitecture(runtime.GOARCH)}
+	}
+
+	var result *multierror.Error
+
+	for _, entry := range ic.Contents.Repositories {
+		repo, repoArchs, err := parseRepository(entry)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+
+		for _, arch := range archs {
+			if !appliesTo(repoArchs, arch) {
+				continue
+			}
+
+			location := repositoryLocation(ic.expandRepository(repo, arch))
+			if offline && !IsLocalRepository(location) {
+				continue
+			}
+			if err := checkIndex(ctx, location, arch); err != nil {
+				result = multierror.Append(result, fmt.Errorf("repository %s (%s): %w", repo, arch, err))
+			}
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// repositoryLocation strips the tag, if any, from a repository entry,
+// e.g. `@local /github/workspace/packages`.
+func repositoryLocation(repo string) string {
+	if strings.HasPrefix(repo, "@") {
+		if _, location, ok := strings.Cut(repo, " "); ok {
+			return strings.TrimSpace(location)
+		}
+	}
+	return repo
+}
+
+func checkIndex(ctx context.Context, location string, arch Architecture) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("parsing repository location: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		index := strings.TrimSuffix(location, "/") + "/" + arch.ToAPK() + "/APKINDEX.tar.gz"
+		return checkRemoteIndex(ctx, index)
+	case "", "file":
+		path := location
+		if u.Scheme == "file" {
+			path = u.Path
+		}
+		if _, err := os.Stat(filepath.Join(path, arch.ToAPK(), "APKINDEX.tar.gz")); err != nil {
+			return fmt.Errorf("index is not accessible: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("scheme %s not supported", u.Scheme)
+	}
+}
+
+func checkRemoteIndex(ctx context.Context, index string) error {
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, index, nil)
+		if err != nil {
+			return fmt.Errorf("building request: %w", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("fetching index: %w", err)
+		}
+		resp.Body.Close()
+
+		// Some servers don't support HEAD requests, retry those with GET.
+		if resp.StatusCode == http.StatusMethodNotAllowed && method == http.MethodHead {
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("fetching index %s: %s", index, resp.Status)
+		}
+		return nil
+	}
+
+	return nil
+}
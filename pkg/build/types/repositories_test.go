@@ -0,0 +1,150 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRepositories(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/main/x86_64/APKINDEX.tar.gz" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	local := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "x86_64"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "x86_64", "APKINDEX.tar.gz"), []byte{}, 0o644))
+
+	ic := ImageConfiguration{Archs: []Architecture{ParseArchitecture("amd64")}}
+	ic.Contents.Repositories = []string{srv.URL + "/main", "@local " + local, "file://" + local}
+	require.NoError(t, ic.CheckRepositories(context.Background(), false))
+
+	ic.Contents.Repositories = append(ic.Contents.Repositories, srv.URL+"/community", filepath.Join(local, "missing"))
+	err := ic.CheckRepositories(context.Background(), false)
+	require.ErrorContains(t, err, "/community")
+	require.ErrorContains(t, err, "missing")
+
+	ic.Contents.Repositories = []string{srv.URL + "/main"}
+	ic.Archs = append(ic.Archs, ParseArchitecture("arm64"))
+	require.ErrorContains(t, ic.CheckRepositories(context.Background(), false), "arm64")
+
+	// Repositories restricted to other architectures are not checked.
+	ic.Contents.Repositories = []string{srv.URL + "/main[arch=x86_64]"}
+	require.NoError(t, ic.CheckRepositories(context.Background(), false))
+
+	// Offline, no requests are made and only local repositories are
+	// checked.
+	rejectRequests(t)
+	ic.Archs = []Architecture{ParseArchitecture("amd64")}
+	ic.Contents.Repositories = []string{srv.URL + "/community", "@local " + local}
+	require.NoError(t, ic.CheckRepositories(context.Background(), true))
+	ic.Contents.Repositories = []string{filepath.Join(local, "missing")}
+	require.ErrorContains(t, ic.CheckRepositories(context.Background(), true), "missing")
+}
+
+// rejectRequests makes the default HTTP client fail every request for the
+// duration of the test.
+func rejectRequests(t *testing.T) {
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = &http.Transport{
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			t.Errorf("unexpected request to %s", r.URL)
+			return nil, fmt.Errorf("requests are rejected")
+		},
+	}
+	t.Cleanup(func() { http.DefaultClient.Transport = transport })
+}
+
+func TestResolvedRepositories(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.Contents.Repositories = []string{
+		"https://dl-cdn.alpinelinux.org/alpine/edge/main",
+		"https://example.com/x86[arch=x86_64,x86]",
+		"@arm https://example.com/arm [arch=aarch64]",
+		"http://[::1]:8080/repo",
+	}
+	require.NoError(t, ic.Validate())
+
+	repos, err := ic.ResolvedRepositories(ParseArchitecture("amd64"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"https://dl-cdn.alpinelinux.org/alpine/edge/main",
+		"https://example.com/x86",
+		"http://[::1]:8080/repo",
+	}, repos)
+
+	repos, err = ic.ResolvedRepositories(ParseArchitecture("arm64"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"https://dl-cdn.alpinelinux.org/alpine/edge/main",
+		"@arm https://example.com/arm",
+		"http://[::1]:8080/repo",
+	}, repos)
+
+	ic.Contents.Repositories = []string{"https://example.com/repo[arch=sparc]"}
+	require.ErrorContains(t, ic.Validate(), "unknown architecture")
+
+	ic.Contents.Repositories = []string{"[arch=x86_64]"}
+	require.ErrorContains(t, ic.Validate(), "malformed predicate")
+
+	// Predicates are kept when relative local repositories are resolved.
+	ic.Contents.Repositories = []string{"file://./packages[arch=x86_64]"}
+	ic.resolvePaths("/config")
+	require.Equal(t, []string{"file:///config/packages[arch=x86_64]"}, ic.Contents.Repositories)
+}
+
+func TestRepositoryPlaceholders(t *testing.T) {
+	ic := ImageConfiguration{}
+	ic.OSRelease.VersionID = "3.17"
+	ic.Contents.Repositories = []string{
+		"https://example.com/internal/{arch}/{version}",
+		"@arm https://example.com/{version}/arm [arch=aarch64]",
+	}
+	require.NoError(t, ic.Validate())
+
+	repos, err := ic.ResolvedRepositories(ParseArchitecture("amd64"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://example.com/internal/x86_64/3.17"}, repos)
+
+	repos, err = ic.ResolvedRepositories(ParseArchitecture("arm64"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"https://example.com/internal/aarch64/3.17",
+		"@arm https://example.com/3.17/arm",
+	}, repos)
+
+	// the version defaults as the one of os-release does
+	ic = ImageConfiguration{}
+	ic.Contents.Repositories = []string{"https://example.com/{version}/main"}
+	require.NoError(t, ic.Validate())
+	repos, err = ic.ResolvedRepositories(ParseArchitecture("amd64"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://example.com/3.16/main"}, repos)
+
+	ic.Contents.Repositories = []string{"https://example.com/{release}/{arch}"}
+	require.ErrorContains(t, ic.Validate(), `repository "https://example.com/{release}/{arch}" has unknown placeholder {release}`)
+}
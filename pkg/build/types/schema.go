@@ -0,0 +1,205 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// jsonSchema is the subset of JSON Schema needed to describe the image
+// configuration.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Minimum              *int                   `json:"minimum,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+}
+
+var architectureType = reflect.TypeOf(Architecture{})
+
+// schemaFor returns the schema of the values of type t, as they are
+// written in the YAML of an image configuration.
+func schemaFor(t reflect.Type) *jsonSchema {
+	if t == architectureType {
+		return &jsonSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		minimum := 0
+		return &jsonSchema{Type: "integer", Minimum: &minimum}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: schemaFor(t.Elem())}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String || t.Elem().Kind() == reflect.Interface {
+			return &jsonSchema{Type: "object"}
+		}
+		return &jsonSchema{Type: "object", AdditionalProperties: schemaFor(t.Elem())}
+	case reflect.Struct:
+		s := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}, AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			// fields are named like yaml.v3 does: by their tag, or
+			// their lowercased name
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			s.Properties[name] = schemaFor(f.Type)
+		}
+		return s
+	default:
+		return &jsonSchema{}
+	}
+}
+
+func imageConfigurationSchema() *jsonSchema {
+	s := schemaFor(reflect.TypeOf(ImageConfiguration{}))
+	s.Schema = "http://json-schema.org/draft-07/schema#"
+	s.Title = "apko image configuration"
+	return s
+}
+
+// Schema returns the JSON Schema of the image configuration, which can be
+// used by editors or other tools to check configuration files.
+func Schema() []byte {
+	data, err := json.MarshalIndent(imageConfigurationSchema(), "", "  ")
+	if err != nil {
+		// the schema is built from plain types, which always marshal
+		panic(fmt.Sprintf("marshaling image configuration schema: %v", err))
+	}
+	return data
+}
+
+// ValidateAgainstSchema checks that the YAML or JSON image configuration
+// in data matches the structure described by Schema, without loading it.
+// All the problems found are reported, with the line they were found on.
+func ValidateAgainstSchema(data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return &sentinelError{sentinel: ErrConfigParse, err: err}
+	}
+
+	problems := []string{}
+	if len(doc.Content) > 0 {
+		problems = validateNode(doc.Content[0], imageConfigurationSchema(), "", problems)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("image configuration does not match the schema: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// validateNode checks the YAML node against the schema, appending any
+// problems found to problems.
+func validateNode(n *yaml.Node, s *jsonSchema, path string, problems []string) []string {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	// empty values are left unset
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return problems
+	}
+
+	problem := func(format string, args ...interface{}) []string {
+		where := path
+		if where == "" {
+			where = "configuration"
+		}
+		return append(problems, fmt.Sprintf("line %d: %s: %s", n.Line, where, fmt.Sprintf(format, args...)))
+	}
+
+	switch s.Type {
+	case "object":
+		if n.Kind != yaml.MappingNode {
+			return problem("expected an object")
+		}
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i].Value, n.Content[i+1]
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+
+			if ps, ok := s.Properties[key]; ok {
+				problems = validateNode(value, ps, keyPath, problems)
+				continue
+			}
+
+			switch additional := s.AdditionalProperties.(type) {
+			case *jsonSchema:
+				problems = validateNode(value, additional, keyPath, problems)
+			case bool:
+				if !additional {
+					problems = append(problems, fmt.Sprintf("line %d: %s: unknown field", n.Content[i].Line, keyPath))
+				}
+			}
+		}
+	case "array":
+		if n.Kind != yaml.SequenceNode {
+			return problem("expected an array")
+		}
+
+		for i, item := range n.Content {
+			problems = validateNode(item, s.Items, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case "string":
+		// yaml.v3 decodes any scalar into a string
+		if n.Kind != yaml.ScalarNode {
+			return problem("expected a string")
+		}
+	case "boolean":
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!bool" {
+			return problem("expected a boolean")
+		}
+	case "integer":
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!int" {
+			return problem("expected an integer")
+		}
+
+		if s.Minimum != nil && strings.HasPrefix(n.Value, "-") {
+			return problem("expected a non-negative integer")
+		}
+	}
+
+	return problems
+}
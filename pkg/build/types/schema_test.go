@@ -0,0 +1,69 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal(Schema(), &schema))
+	require.Equal(t, "object", schema["type"])
+
+	props := schema["properties"].(map[string]interface{})
+	contents := props["contents"].(map[string]interface{})["properties"].(map[string]interface{})
+	require.Equal(t, "array", contents["packages"].(map[string]interface{})["type"])
+	require.Contains(t, contents, "install-order")
+	require.Contains(t, props, "archs")
+}
+
+func TestValidateAgainstSchema(t *testing.T) {
+	examples, err := filepath.Glob("../../../examples/*.yaml")
+	require.NoError(t, err)
+	require.NotEmpty(t, examples)
+	for _, example := range examples {
+		data, err := os.ReadFile(example)
+		require.NoError(t, err)
+		require.NoError(t, ValidateAgainstSchema(data), example)
+	}
+
+	require.NoError(t, ValidateAgainstSchema([]byte(`{"contents": {"packages": ["busybox"]}, "archs": ["x86_64"]}`)))
+
+	err = ValidateAgainstSchema([]byte(`
+contents:
+  packages: busybox
+  pakages:
+    - nginx
+accounts:
+  users:
+    - username: nonroot
+      uid: nobody
+os-release:
+  version-id: 3.16
+`))
+	require.ErrorContains(t, err, "line 3: contents.packages: expected an array")
+	require.ErrorContains(t, err, "line 4: contents.pakages: unknown field")
+	require.ErrorContains(t, err, "line 9: accounts.users[0].uid: expected an integer")
+	require.NotContains(t, err.Error(), "version-id")
+
+	require.Error(t, ValidateAgainstSchema([]byte("- not\n- an object\n")))
+	require.Error(t, ValidateAgainstSchema([]byte("contents: [")))
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import "fmt"
+
+// scratchForbiddenPackages are the packages which provide a shell or a
+// package manager, and so may not be installed in scratch images.
+var scratchForbiddenPackages = map[string]struct{}{
+	"alpine-base":      {},
+	"apk-tools":        {},
+	"apk-tools-static": {},
+	"bash":             {},
+	"busybox":          {},
+	"busybox-static":   {},
+	"dash":             {},
+	"fish":             {},
+	"loksh":            {},
+	"mksh":             {},
+	"oksh":             {},
+	"tcsh":             {},
+	"yash":             {},
+	"zsh":              {},
+}
+
+// ForbiddenInScratch reports whether the package, given by name, provides
+// a shell or a package manager, which scratch images may not contain.
+func ForbiddenInScratch(name string) bool {
+	_, ok := scratchForbiddenPackages[name]
+	return ok
+}
+
+// validateScratch checks that a scratch image installs no shell or
+// package manager, and starts with an exec form entrypoint, which needs
+// no shell to run.
+func (ic *ImageConfiguration) validateScratch() error {
+	if !ic.Scratch {
+		return nil
+	}
+
+	for _, pkg := range ic.expandedPackages() {
+		if name := packageName(pkg); ForbiddenInScratch(name) {
+			return fmt.Errorf("package %q provides a shell or package manager, which scratch images may not contain", name)
+		}
+	}
+
+	switch {
+	case ic.Entrypoint.Type == "service-bundle":
+		return fmt.Errorf("scratch images cannot run a service bundle")
+	case len(ic.Entrypoints) != 0:
+		return fmt.Errorf("scratch images cannot use named entrypoints, which are dispatched by a shell script")
+	case ic.Entrypoint.ShellFragment != "":
+		return fmt.Errorf("scratch images cannot run an entrypoint shell fragment")
+	case ic.Entrypoint.Umask != "":
+		return fmt.Errorf("scratch images cannot set an entrypoint umask, which is set by a shell")
+	case ic.Entrypoint.Command == "":
+		return fmt.Errorf("scratch images must set an entrypoint command, which is run without a shell")
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateScratch(t *testing.T) {
+	ic := ImageConfiguration{Scratch: true}
+	ic.Contents.Packages = []string{"ca-certificates-bundle", "app=1.0-r0"}
+	ic.Entrypoint.Command = "/usr/bin/app"
+	require.NoError(t, ic.Validate())
+
+	for _, tc := range []struct {
+		desc   string
+		modify func(ic *ImageConfiguration)
+		msg    string
+	}{{
+		desc:   "shell package",
+		modify: func(ic *ImageConfiguration) { ic.Contents.Packages = append(ic.Contents.Packages, "busybox") },
+		msg:    "package \"busybox\" provides a shell or package manager",
+	}, {
+		desc: "pinned package manager",
+		modify: func(ic *ImageConfiguration) {
+			ic.Contents.Packages = append(ic.Contents.Packages, "apk-tools=2.12.9-r3")
+		},
+		msg: "package \"apk-tools\" provides a shell or package manager",
+	}, {
+		desc:   "shell fragment",
+		modify: func(ic *ImageConfiguration) { ic.Entrypoint.ShellFragment = "exec /usr/bin/app" },
+		msg:    "shell fragment",
+	}, {
+		desc:   "no entrypoint command",
+		modify: func(ic *ImageConfiguration) { ic.Entrypoint.Command = "" },
+		msg:    "must set an entrypoint command",
+	}, {
+		desc:   "umask",
+		modify: func(ic *ImageConfiguration) { ic.Entrypoint.Umask = "027" },
+		msg:    "umask",
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			ic := ImageConfiguration{Scratch: true}
+			ic.Contents.Packages = []string{"ca-certificates-bundle"}
+			ic.Entrypoint.Command = "/usr/bin/app"
+			tc.modify(&ic)
+			require.ErrorContains(t, ic.Validate(), tc.msg)
+		})
+	}
+}
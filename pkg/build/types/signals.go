@@ -0,0 +1,155 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// The shells whose -c form, e.g. /bin/sh -c 'nginx', exec-signals
+// rewrites.
+var signalShells = map[string]struct{}{
+	"ash": {}, "bash": {}, "dash": {}, "ksh": {}, "mksh": {}, "sh": {}, "zsh": {},
+}
+
+// The shell reserved words and builtins which cannot follow exec.
+var shellKeywords = map[string]struct{}{
+	"!": {}, "[[": {}, "{": {}, "}": {}, "case": {}, "do": {}, "done": {},
+	"elif": {}, "else": {}, "esac": {}, "fi": {}, "for": {}, "function": {},
+	"if": {}, "select": {}, "then": {}, "time": {}, "until": {}, "while": {},
+}
+
+var shellAssignmentRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// shellScript returns the script run by a shell form command, e.g.
+// /bin/sh -c 'nginx', and whether cmd is one.
+func shellScript(cmd []string) (string, bool) {
+	if len(cmd) < 3 || cmd[1] != "-c" {
+		return "", false
+	}
+	if _, ok := signalShells[path.Base(cmd[0])]; !ok {
+		return "", false
+	}
+	return cmd[2], true
+}
+
+// singleShellCommand reports whether the script is a single simple
+// command, which the shell can replace itself with by exec'ing it. Lists,
+// pipelines, subshells, compound commands and leading variable assignments
+// are not.
+func singleShellCommand(script string) bool {
+	var quote rune
+	escaped := false
+	for _, r := range script {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case strings.ContainsRune(";&|()`\n", r):
+			return false
+		}
+	}
+
+	words, err := shlex.Split(script)
+	if err != nil || len(words) == 0 {
+		return false
+	}
+	if _, ok := shellKeywords[words[0]]; ok {
+		return false
+	}
+	return !shellAssignmentRegexp.MatchString(words[0])
+}
+
+// execShellScript returns cmd with its script prefixed with exec, when it
+// is a shell form command running a single command, so that the command
+// replaces the shell and receives the signals sent to it. Other commands
+// are returned as they are.
+func execShellScript(cmd []string) []string {
+	script, ok := shellScript(cmd)
+	if !ok || !singleShellCommand(script) {
+		return cmd
+	}
+
+	script = strings.TrimSpace(script)
+	if strings.HasPrefix(script, "exec ") {
+		return cmd
+	}
+
+	wrapped := append([]string{}, cmd...)
+	wrapped[2] = "exec " + script
+	return wrapped
+}
+
+// shellScripts returns the scripts of the shell form entrypoint and cmd of
+// the image, if any. Commands which can't be parsed are left out, Validate
+// reports them.
+func (ic *ImageConfiguration) shellScripts() []string {
+	scripts := []string{}
+
+	switch {
+	case ic.Entrypoint.ShellFragment != "":
+		scripts = append(scripts, ic.Entrypoint.ShellFragment)
+	case ic.Entrypoint.Command != "":
+		if cmd, err := shlex.Split(ic.Entrypoint.Command); err == nil {
+			if script, ok := shellScript(cmd); ok {
+				scripts = append(scripts, script)
+			}
+		}
+	}
+
+	cmd := ic.CmdArgs
+	if len(cmd) == 0 && ic.Cmd != "" {
+		cmd, _ = shlex.Split(ic.Cmd)
+	}
+	if script, ok := shellScript(cmd); ok {
+		scripts = append(scripts, script)
+	}
+
+	return scripts
+}
+
+// ValidateExecSignals checks that entrypoint.exec-signals, when set, can
+// be honored: the entrypoint or the cmd must be a shell form command, and
+// the scripts they run single commands which can be exec'ed.
+func (ic *ImageConfiguration) ValidateExecSignals() error {
+	if !ic.Entrypoint.ExecSignals {
+		return nil
+	}
+
+	scripts := ic.shellScripts()
+	if len(scripts) == 0 {
+		return fmt.Errorf("entrypoint.exec-signals is set, but neither the entrypoint nor the cmd runs a shell script")
+	}
+
+	for _, script := range scripts {
+		if !singleShellCommand(script) {
+			return fmt.Errorf("entrypoint.exec-signals cannot be honored for shell script %q, which is not a single command", script)
+		}
+	}
+
+	return nil
+}
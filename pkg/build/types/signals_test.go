@@ -0,0 +1,66 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecSignals(t *testing.T) {
+	ic := ImageConfiguration{CmdArgs: []string{"/bin/sh", "-c", "exec /usr/bin/worker"}}
+	ic.Entrypoint.ShellFragment = `nginx -g "daemon off;"`
+	ic.Entrypoint.ExecSignals = true
+	ic.Entrypoint.Init = true
+	require.NoError(t, ic.Validate())
+	require.NoError(t, ic.ValidateExecSignals())
+
+	cfg, err := ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/sbin/tini", "--", "/bin/sh", "-c", `exec nginx -g "daemon off;"`}, cfg.Entrypoint)
+	require.Equal(t, []string{"/bin/sh", "-c", "exec /usr/bin/worker"}, cfg.Cmd)
+
+	// shell form commands are rewritten too
+	ic = ImageConfiguration{Cmd: `bash -c "/usr/bin/app --port 8080"`}
+	ic.Entrypoint.ExecSignals = true
+	cfg, err = ic.ToOCIConfig("amd64")
+	require.NoError(t, err)
+	require.Equal(t, []string{"bash", "-c", "exec /usr/bin/app --port 8080"}, cfg.Cmd)
+
+	// scripts which are not single commands are left as they are
+	for _, script := range []string{
+		"migrate && serve",
+		"app | tee /tmp/log",
+		"PORT=80 app",
+		"if true; then app; fi",
+		"(app)",
+	} {
+		ic = ImageConfiguration{}
+		ic.Entrypoint.ShellFragment = script
+		ic.Entrypoint.ExecSignals = true
+		require.ErrorContains(t, ic.ValidateExecSignals(), "not a single command", script)
+
+		cfg, err = ic.ToOCIConfig("amd64")
+		require.NoError(t, err)
+		require.Equal(t, []string{"/bin/sh", "-c", script}, cfg.Entrypoint)
+	}
+
+	ic = ImageConfiguration{}
+	ic.Entrypoint.Command = "/usr/bin/app"
+	ic.Entrypoint.ExecSignals = true
+	require.ErrorContains(t, ic.ValidateExecSignals(), "neither the entrypoint nor the cmd runs a shell script")
+	require.Contains(t, ic.Warnings(), ic.ValidateExecSignals().Error())
+}
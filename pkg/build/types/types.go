@@ -24,6 +24,8 @@ type User struct {
 	UserName string
 	UID      uint32
 	GID      uint32
+	// Groups lists the supplementary groups the user is a member of.
+	Groups []string
 }
 
 type Group struct {
@@ -42,6 +44,57 @@ type PathMutation struct {
 	Recursive   bool
 }
 
+type File struct {
+	Source      string
+	Destination string
+	UID         uint32
+	GID         uint32
+	Permissions uint32
+}
+
+// Strip describes the files to remove from the image filesystem.
+type Strip struct {
+	// Docs removes the documentation, man and info pages.
+	Docs bool `yaml:"docs"`
+	// Locales removes the locale data.
+	Locales bool `yaml:"locales"`
+	// Paths are absolute glob patterns of additional files to remove.
+	Paths []string `yaml:"paths"`
+}
+
+// Patterns returns the glob patterns of all the files to remove.
+func (s Strip) Patterns() []string {
+	patterns := []string{}
+	if s.Docs {
+		patterns = append(patterns, "/usr/share/doc", "/usr/share/man", "/usr/share/info")
+	}
+	if s.Locales {
+		patterns = append(patterns, "/usr/share/locale")
+	}
+	return append(patterns, s.Paths...)
+}
+
+// Capabilities lists the Linux capabilities, e.g. NET_BIND_SERVICE, the
+// image requires and those it runs without.
+type Capabilities struct {
+	Required []string `yaml:"required"`
+	// Dropped may include ALL, to drop every capability not required.
+	Dropped []string `yaml:"dropped"`
+}
+
+// ResolvConf describes the DNS resolver configuration of the image.
+type ResolvConf struct {
+	// Nameservers are the IP addresses of the name servers, in order.
+	Nameservers []string `yaml:"nameservers"`
+	// Search lists the domains searched for short host names.
+	Search []string `yaml:"search"`
+}
+
+// IsEmpty reports whether no resolver configuration is set.
+func (r ResolvConf) IsEmpty() bool {
+	return len(r.Nameservers) == 0 && len(r.Search) == 0
+}
+
 type OSRelease struct {
 	Name         string
 	ID           string
@@ -56,29 +109,221 @@ type ImageConfiguration struct {
 		Repositories []string
 		Keyring      []string
 		Packages     []string
-	}
-	Entrypoint struct {
-		Type          string
-		Command       string
-		ShellFragment string `yaml:"shell-fragment"`
+		PreInstall   []string `yaml:"pre-install"`
+		PostInstall  []string `yaml:"post-install"`
+		Files        []File
+		BaseImage    string `yaml:"base-image"`
+
+		// InstallOrder lists packages which are installed, together with
+		// their dependencies, one after the other before the rest.
+		InstallOrder []string `yaml:"install-order"`
+
+		// AllowUntrusted disables the verification of repository and
+		// package signatures. It must only be used for local testing.
+		AllowUntrusted bool `yaml:"allow-untrusted"`
+
+		// AppendKeyring keeps the keys already installed in the image,
+		// e.g. by a base image, adding the keyring to them.
+		AppendKeyring bool `yaml:"append-keyring"`
+
+		// PackageChecksums pins the SHA-256 checksums of package files,
+		// keyed by name=version, which are verified after installation.
+		PackageChecksums map[string]string `yaml:"package-checksums"`
+
+		// VersionPolicy is either "floating" (the default), allowing
+		// packages without a version, or "pinned", requiring every
+		// package to be pinned to an exact version.
+		VersionPolicy string `yaml:"version-policy"`
+
+		// Strip lists the files removed from the image after the
+		// packages are installed.
+		Strip Strip `yaml:"strip"`
+
+		// PackageGroups maps group names to lists of packages, which are
+		// installed by listing "@<group>" in Packages.
+		PackageGroups map[string][]string `yaml:"package-groups"`
+
+		// APKOptions are extra flags, out of AllowedAPKOptions, passed
+		// to apk when installing the packages.
+		APKOptions []string `yaml:"apk-options"`
 
-		// TBD: presently a map of service names and the command to run
-		Services map[interface{}]interface{}
+		// ResolvConf is written to /etc/resolv.conf, when set.
+		ResolvConf ResolvConf `yaml:"resolv-conf"`
+
+		// Hosts are "ip hostname..." entries added to /etc/hosts.
+		Hosts []string `yaml:"hosts"`
+
+		// LocalPackages are paths of .apk files, e.g. pre-release
+		// builds which are in no repository, installed directly.
+		LocalPackages []string `yaml:"local-packages"`
+
+		// APKToolsVersion is the exact apk-tools version the build
+		// must run with, when set.
+		APKToolsVersion string `yaml:"apk-tools-version"`
+
+		// CacheDir is a local apk cache, e.g. pre-populated for
+		// disconnected builds, which apk reads packages and indexes
+		// from before fetching them.
+		CacheDir string `yaml:"cache-dir"`
+
+		// MaxFileSize and MaxTotalSize limit the size, in bytes, of each
+		// of the files copied into the image and of all of them, when
+		// set. Otherwise DefaultMaxFileSize and DefaultMaxTotalSize apply.
+		MaxFileSize  int64 `yaml:"max-file-size,omitempty"`
+		MaxTotalSize int64 `yaml:"max-total-size,omitempty"`
 	}
+	Entrypoint ImageEntrypoint
+
+	// Entrypoints maps names to alternate entrypoint commands, one of
+	// which is selected at runtime with the APP_ENTRYPOINT env variable.
+	Entrypoints       map[string]string
+	DefaultEntrypoint string `yaml:"default-entrypoint"`
+
+	// Ports are the ports the image exposes, e.g. 8080 or 53/udp, the
+	// protocol defaulting to tcp.
+	Ports []string `yaml:"ports"`
+
 	Cmd      string
-	WorkDir  string `yaml:"work-dir"`
+	CmdArgs  []string `yaml:"cmd-args"`
+	WorkDir  string   `yaml:"work-dir"`
 	Accounts struct {
-		RunAs  string `yaml:"run-as"`
-		Users  []User
-		Groups []Group
+		RunAs        string `yaml:"run-as"`
+		NumericRunAs bool   `yaml:"numeric-run-as"`
+		Users        []User
+		Groups       []Group
+
+		// AllowLegacyNames disables the check that user and group
+		// names follow the POSIX portable naming rules.
+		AllowLegacyNames bool `yaml:"allow-legacy-names"`
 	}
 	Archs       []Architecture
 	Environment map[string]string
+
+	// EnvironmentFiles are files of KEY=VALUE lines merged into the
+	// environment, the values set in Environment taking precedence.
+	EnvironmentFiles []string `yaml:"environment-files"`
+
 	Paths       []PathMutation
 	OSRelease   OSRelease         `yaml:"os-release"`
 	VCSUrl      string            `yaml:"vcs-url"`
+	Timezone    string            `yaml:"timezone"`
+	Locale      string            `yaml:"locale"`
 	Annotations map[string]string `yaml:"annotations"`
 	Include     string
+
+	// VCSSubPath is the directory of the configuration in the repository
+	// at VCSUrl, with forward slashes, and empty at its root. It is
+	// detected along VCSUrl and is not part of the file.
+	VCSSubPath string `yaml:"-"`
+
+	// Profiles are variants of the image, e.g. a debug image, which
+	// override the entrypoint and command when selected at build time.
+	Profiles map[string]Profile `yaml:"profiles"`
+
+	// Profile is the name of the profile selected at build time, if any.
+	// It is not part of the file.
+	Profile string `yaml:"-"`
+
+	// configFiles are the local files the configuration was loaded
+	// from, including the included ones.
+	configFiles []string
+
+	// BuildArgs are the values given at build time which environment and
+	// templated annotation values may reference, e.g.
+	// `{{ .BuildArgs.VERSION }}`. They are not part of the file.
+	BuildArgs map[string]string `yaml:"-"`
+
+	// CIAnnotations adds io.apko.build.* annotations describing the CI
+	// run the image is built in, when one is detected.
+	CIAnnotations bool `yaml:"ci-annotations"`
+
+	// Scratch declares a minimal image, without a shell or package
+	// manager, which is started with an exec form entrypoint.
+	Scratch bool `yaml:"scratch"`
+
+	// Capabilities documents the Linux capabilities the image needs at
+	// runtime, as io.apko.security.capabilities.* annotations.
+	Capabilities Capabilities `yaml:"capabilities"`
+
+	// History sets the provenance recorded in the history entry of the
+	// image layer.
+	History History `yaml:"history"`
+
+	// Setuid is the policy for setuid and setgid binaries in the image.
+	Setuid Setuid `yaml:"setuid"`
+
+	// Tmpfs lists the absolute paths which should be mounted as tmpfs
+	// at runtime, recorded as the io.apko.tmpfs annotation.
+	Tmpfs []string `yaml:"tmpfs"`
+
+	SBOM struct {
+		// Path is the default directory the SBOMs are written to, when
+		// none is given to the build.
+		Path string `yaml:"path"`
+
+		// ExcludePackages are the names of installed packages left out
+		// of the SBOMs, e.g. build helpers removed from the image.
+		ExcludePackages []string `yaml:"exclude-packages"`
+	} `yaml:"sbom"`
+}
+
+// ImageEntrypoint describes how the image is started.
+type ImageEntrypoint struct {
+	Type          string
+	Command       string
+	ShellFragment string `yaml:"shell-fragment"`
+
+	// TBD: presently a map of service names and the command to run
+	Services map[interface{}]interface{}
+
+	// ManageServices controls whether apko sets up the s6 supervisor
+	// for service bundles. Defaults to true when unset.
+	ManageServices *bool `yaml:"manage-services,omitempty"`
+
+	// Init wraps the entrypoint with an init process, which reaps
+	// zombie processes and forwards signals, when running as PID 1.
+	Init bool `yaml:"init"`
+	// InitPackage is the package providing the init process, one of
+	// tini (the default) or dumb-init.
+	InitPackage string `yaml:"init-package"`
+
+	// Umask is the octal file mode creation mask, e.g. 027, which
+	// the entrypoint is started with.
+	Umask string `yaml:"umask,omitempty"`
+
+	// StopTimeout is the grace period, e.g. 30s, the entrypoint is
+	// given to shut down once stopped, recorded as an annotation.
+	StopTimeout string `yaml:"stop-timeout,omitempty"`
+
+	// ExecSignals execs the single command run by a shell form
+	// entrypoint or cmd, e.g. a shell fragment, so that it replaces the
+	// shell and receives the signals sent to the container.
+	ExecSignals bool `yaml:"exec-signals,omitempty"`
+}
+
+// Profile is a variant of the image. Its entrypoint replaces the one of
+// the configuration when set, as its cmd or cmd-args do.
+type Profile struct {
+	Entrypoint *ImageEntrypoint `yaml:"entrypoint,omitempty"`
+	Cmd        string           `yaml:"cmd,omitempty"`
+	CmdArgs    []string         `yaml:"cmd-args,omitempty"`
+}
+
+// Setuid lists the setuid and setgid binaries expected in the image.
+type Setuid struct {
+	// Enforce fails the build when setuid or setgid binaries which are
+	// not allowed are found.
+	Enforce bool `yaml:"enforce"`
+	// Allow lists the paths, or glob patterns, of the allowed binaries.
+	Allow []string `yaml:"allow"`
+}
+
+// History describes the history entry of the image layer.
+type History struct {
+	// CreatedBy defaults to a summary of the apko invocation.
+	CreatedBy string `yaml:"created-by"`
+	// Author defaults to apko.
+	Author string `yaml:"author"`
 }
 
 // Architecture represents a CPU architecture for the container image.
@@ -96,6 +341,10 @@ func (a *Architecture) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+func (a Architecture) MarshalYAML() (interface{}, error) {
+	return a.s, nil
+}
+
 var (
 	_386    = Architecture{"386"}
 	amd64   = Architecture{"amd64"}
@@ -120,6 +369,16 @@ var AllArchs = []Architecture{
 	s390x,
 }
 
+// IsSupported returns true if the Architecture is one of AllArchs.
+func (a Architecture) IsSupported() bool {
+	for _, b := range AllArchs {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
 // ToAPK returns the apk-style equivalent string for the Architecture.
 func (a Architecture) ToAPK() string {
 	switch a {
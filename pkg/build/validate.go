@@ -16,8 +16,11 @@ package build
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 )
 
 type Assertion func(*Context) error
@@ -53,3 +56,176 @@ func RequireGroupFile(optional bool) Assertion {
 		return nil
 	}
 }
+
+// walkImage calls fn with the path in the image, e.g. /etc/passwd, and the
+// file info of every file and directory of the image filesystem at root.
+// Symlinks are skipped, as their permissions are meaningless.
+func walkImage(root string, fn func(p string, fi fs.FileInfo) error) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		return fn(filepath.Join("/", rel), fi)
+	})
+	if err != nil {
+		return fmt.Errorf("scanning image filesystem: %w", err)
+	}
+
+	return nil
+}
+
+// InsecurePaths walks the image filesystem in the working directory and
+// reports world-writable files and directories, as well as setuid and
+// setgid binaries. World-writable directories with the sticky bit set,
+// such as /tmp, are not reported.
+func (bc *Context) InsecurePaths() ([]string, error) {
+	found := []string{}
+
+	err := walkImage(bc.Options.WorkDir, func(rel string, fi fs.FileInfo) error {
+		mode := fi.Mode()
+		switch {
+		case mode.Perm()&0o002 != 0 && !(mode.IsDir() && mode&fs.ModeSticky != 0):
+			found = append(found, fmt.Sprintf("%s (world-writable)", rel))
+		case mode.IsRegular() && mode&fs.ModeSetuid != 0:
+			found = append(found, fmt.Sprintf("%s (setuid)", rel))
+		case mode.IsRegular() && mode&fs.ModeSetgid != 0:
+			found = append(found, fmt.Sprintf("%s (setgid)", rel))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// AccountPaths lists the paths of the image filesystem which an account
+// owns, and which it can write to.
+type AccountPaths struct {
+	Owned    []string
+	Writable []string
+}
+
+// AccountAccess maps the names of the configured users and groups to the
+// paths they own or can write to.
+type AccountAccess struct {
+	Users  map[string]AccountPaths
+	Groups map[string]AccountPaths
+}
+
+// AccountAccess walks the image filesystem in the working directory, once
+// it is built, and reports the paths each configured user and group owns
+// or can write to, given the permissions of the paths and the group
+// memberships of the users. World-writable paths are writable by all.
+func (bc *Context) AccountAccess() (*AccountAccess, error) {
+	accounts := bc.ImageConfiguration.Accounts
+
+	// the groups of each user, by primary and supplementary membership
+	userGIDs := map[string]map[uint32]struct{}{}
+	for _, u := range accounts.Users {
+		userGIDs[u.UserName] = map[uint32]struct{}{u.GID: {}}
+	}
+	for _, g := range accounts.Groups {
+		for _, member := range g.Members {
+			if gids, ok := userGIDs[member]; ok {
+				gids[g.GID] = struct{}{}
+			}
+		}
+	}
+	for _, u := range accounts.Users {
+		for _, name := range u.Groups {
+			for _, g := range accounts.Groups {
+				if g.GroupName == name {
+					userGIDs[u.UserName][g.GID] = struct{}{}
+				}
+			}
+		}
+	}
+
+	access := &AccountAccess{
+		Users:  map[string]AccountPaths{},
+		Groups: map[string]AccountPaths{},
+	}
+	for _, u := range accounts.Users {
+		access.Users[u.UserName] = AccountPaths{Owned: []string{}, Writable: []string{}}
+	}
+	for _, g := range accounts.Groups {
+		access.Groups[g.GroupName] = AccountPaths{Owned: []string{}, Writable: []string{}}
+	}
+
+	err := walkImage(bc.Options.WorkDir, func(rel string, fi fs.FileInfo) error {
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("unable to read the owner of %s", rel)
+		}
+
+		perm := fi.Mode().Perm()
+		for _, u := range accounts.Users {
+			paths := access.Users[u.UserName]
+			_, inGroup := userGIDs[u.UserName][stat.Gid]
+			if stat.Uid == u.UID {
+				paths.Owned = append(paths.Owned, rel)
+			}
+			if (stat.Uid == u.UID && perm&0o200 != 0) || (inGroup && perm&0o020 != 0) || perm&0o002 != 0 {
+				paths.Writable = append(paths.Writable, rel)
+			}
+			access.Users[u.UserName] = paths
+		}
+
+		for _, g := range accounts.Groups {
+			paths := access.Groups[g.GroupName]
+			if stat.Gid == g.GID {
+				paths.Owned = append(paths.Owned, rel)
+			}
+			if (stat.Gid == g.GID && perm&0o020 != 0) || perm&0o002 != 0 {
+				paths.Writable = append(paths.Writable, rel)
+			}
+			access.Groups[g.GroupName] = paths
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return access, nil
+}
+
+func RequireNoInsecurePaths(optional bool) Assertion {
+	return func(bc *Context) error {
+		found, err := bc.InsecurePaths()
+		if err != nil {
+			return err
+		}
+
+		if len(found) == 0 {
+			return nil
+		}
+
+		if optional {
+			for _, p := range found {
+				bc.Logger().Warnf("insecure path found: %s", p)
+			}
+			return nil
+		}
+		return fmt.Errorf("insecure paths found: %s", strings.Join(found, ", "))
+	}
+}
@@ -52,3 +52,32 @@ func (e *Executor) Execute(name string, arg ...string) error {
 	cmd := exec.Command(name, arg...)
 	return e.impl.Run(cmd, logname, e.Log)
 }
+
+// ExecuteOutput executes the named program with the given arguments,
+// returning its standard output.
+func (e *Executor) ExecuteOutput(name string, arg ...string) ([]byte, error) {
+	logname := name
+
+	if e.UseProot {
+		arg = append([]string{"-0", name}, arg...)
+		name = "proot"
+	}
+
+	cmd := exec.Command(name, arg...)
+	return e.impl.Output(cmd, logname, e.Log)
+}
+
+// ExecuteInWorkDir executes the named program with the given arguments,
+// using the working directory as the current directory.
+func (e *Executor) ExecuteInWorkDir(name string, arg ...string) error {
+	logname := name
+
+	if e.UseProot {
+		arg = append([]string{"-0", name}, arg...)
+		name = "proot"
+	}
+
+	cmd := exec.Command(name, arg...)
+	cmd.Dir = e.WorkDir
+	return e.impl.Run(cmd, logname, e.Log)
+}
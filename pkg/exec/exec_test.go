@@ -94,3 +94,45 @@ func TestExecuteChroot(t *testing.T) {
 		}
 	}
 }
+
+func TestExecuteInWorkDir(t *testing.T) {
+	tErr := fmt.Errorf("synthetic error")
+	sut := &exec.Executor{
+		Log:     testLogger(),
+		WorkDir: "/mock",
+	}
+	for _, tc := range []struct {
+		prepare   func(*execfakes.FakeExecutorImplementation)
+		shouldErr bool
+	}{
+		{
+			func(fei *execfakes.FakeExecutorImplementation) {
+				fei.RunReturns(tErr)
+			},
+			true,
+		},
+		{
+			func(fei *execfakes.FakeExecutorImplementation) {
+				fei.RunReturns(nil)
+			},
+			false,
+		},
+	} {
+		impl := execfakes.FakeExecutorImplementation{}
+		tc.prepare(&impl)
+		sut.SetImplementation(&impl)
+
+		// Test with and without proot
+		for b := range map[bool]struct{}{false: {}, true: {}} {
+			sut.UseProot = b
+			err := sut.ExecuteInWorkDir("command")
+			if (err != nil) != tc.shouldErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			cmd, _, _ := impl.RunArgsForCall(impl.RunCallCount() - 1)
+			if cmd.Dir != "/mock" {
+				t.Fatalf("expected command to run in /mock, got %q", cmd.Dir)
+			}
+		}
+	}
+}
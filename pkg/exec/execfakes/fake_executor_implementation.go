@@ -1,3 +1,17 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
 // Code generated by counterfeiter. DO NOT EDIT.
 package execfakes
 
@@ -9,6 +23,21 @@ import (
 )
 
 type FakeExecutorImplementation struct {
+	OutputStub        func(*execa.Cmd, string, *logrus.Entry) ([]byte, error)
+	outputMutex       sync.RWMutex
+	outputArgsForCall []struct {
+		arg1 *execa.Cmd
+		arg2 string
+		arg3 *logrus.Entry
+	}
+	outputReturns struct {
+		result1 []byte
+		result2 error
+	}
+	outputReturnsOnCall map[int]struct {
+		result1 []byte
+		result2 error
+	}
 	RunStub        func(*execa.Cmd, string, *logrus.Entry) error
 	runMutex       sync.RWMutex
 	runArgsForCall []struct {
@@ -26,6 +55,72 @@ type FakeExecutorImplementation struct {
 	invocationsMutex sync.RWMutex
 }
 
+func (fake *FakeExecutorImplementation) Output(arg1 *execa.Cmd, arg2 string, arg3 *logrus.Entry) ([]byte, error) {
+	fake.outputMutex.Lock()
+	ret, specificReturn := fake.outputReturnsOnCall[len(fake.outputArgsForCall)]
+	fake.outputArgsForCall = append(fake.outputArgsForCall, struct {
+		arg1 *execa.Cmd
+		arg2 string
+		arg3 *logrus.Entry
+	}{arg1, arg2, arg3})
+	stub := fake.OutputStub
+	fakeReturns := fake.outputReturns
+	fake.recordInvocation("Output", []interface{}{arg1, arg2, arg3})
+	fake.outputMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeExecutorImplementation) OutputCallCount() int {
+	fake.outputMutex.RLock()
+	defer fake.outputMutex.RUnlock()
+	return len(fake.outputArgsForCall)
+}
+
+func (fake *FakeExecutorImplementation) OutputCalls(stub func(*execa.Cmd, string, *logrus.Entry) ([]byte, error)) {
+	fake.outputMutex.Lock()
+	defer fake.outputMutex.Unlock()
+	fake.OutputStub = stub
+}
+
+func (fake *FakeExecutorImplementation) OutputArgsForCall(i int) (*execa.Cmd, string, *logrus.Entry) {
+	fake.outputMutex.RLock()
+	defer fake.outputMutex.RUnlock()
+	argsForCall := fake.outputArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeExecutorImplementation) OutputReturns(result1 []byte, result2 error) {
+	fake.outputMutex.Lock()
+	defer fake.outputMutex.Unlock()
+	fake.OutputStub = nil
+	fake.outputReturns = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeExecutorImplementation) OutputReturnsOnCall(i int, result1 []byte, result2 error) {
+	fake.outputMutex.Lock()
+	defer fake.outputMutex.Unlock()
+	fake.OutputStub = nil
+	if fake.outputReturnsOnCall == nil {
+		fake.outputReturnsOnCall = make(map[int]struct {
+			result1 []byte
+			result2 error
+		})
+	}
+	fake.outputReturnsOnCall[i] = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeExecutorImplementation) Run(arg1 *execa.Cmd, arg2 string, arg3 *logrus.Entry) error {
 	fake.runMutex.Lock()
 	ret, specificReturn := fake.runReturnsOnCall[len(fake.runArgsForCall)]
@@ -92,6 +187,8 @@ func (fake *FakeExecutorImplementation) RunReturnsOnCall(i int, result1 error) {
 func (fake *FakeExecutorImplementation) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
+	fake.outputMutex.RLock()
+	defer fake.outputMutex.RUnlock()
 	fake.runMutex.RLock()
 	defer fake.runMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
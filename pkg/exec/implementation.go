@@ -16,6 +16,7 @@ package exec
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"os/exec"
 
@@ -25,6 +26,7 @@ import (
 //counterfeiter:generate . executorImplementation
 type executorImplementation interface {
 	Run(cmd *exec.Cmd, logname string, logger *logrus.Entry) error
+	Output(cmd *exec.Cmd, logname string, logger *logrus.Entry) ([]byte, error)
 }
 
 type defaultBuildImplementation struct{}
@@ -68,3 +70,32 @@ func (di *defaultBuildImplementation) Run(
 
 	return nil
 }
+
+// Output runs the command and returns its standard output, logging its
+// standard error.
+func (di *defaultBuildImplementation) Output(
+	cmd *exec.Cmd, logname string, baseLogger *logrus.Entry,
+) ([]byte, error) {
+	logger := baseLogger.WithFields(logrus.Fields{"cmd": logname})
+	logger.Infof("running: %s", cmd)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go monitorPipe(stderr, logger)
+
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
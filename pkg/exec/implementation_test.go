@@ -54,3 +54,19 @@ func TestRun(t *testing.T) {
 		}
 	}
 }
+
+func TestOutput(t *testing.T) {
+	if _, err := exec.LookPath("echo"); err != nil {
+		t.Skip("skipping Output test as echo executable not found")
+	}
+
+	impl := defaultBuildImplementation{}
+	l := logrus.NewEntry(&logrus.Logger{})
+
+	out, err := impl.Output(exec.Command("echo", "hello"), "test", l)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(out))
+
+	_, err = impl.Output(exec.Command("sldkfjlskdjflksjdf"), "test", l)
+	require.Error(t, err)
+}
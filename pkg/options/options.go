@@ -16,10 +16,13 @@ package options
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"time"
 
 	nested "github.com/antonfisher/nested-logrus-formatter"
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/sirupsen/logrus"
 
 	"chainguard.dev/apko/pkg/build/types"
@@ -30,28 +33,81 @@ type Options struct {
 	WantSBOM            bool
 	UseProot            bool
 	WithVCS             bool
+	StrictAnnotations   bool
+	StrictKeyring       bool
+	StrictBaseImage     bool
+	StrictEntrypoint    bool
 	WorkDir             string
+	PreserveWorkDir     bool
 	TarballPath         string
+	TarballOutputPath   string
+	OutputFormat        string
+	LayoutPath          string
 	Tags                []string
 	SourceDateEpoch     time.Time
+	KeepTimestamps      bool
+	SortTarEntries      bool
+	CompressionLevel    string
+	Compression         string
 	SBOMPath            string
+	SBOMWorkDir         string
 	SBOMFormats         []string
+	SBOMPredicates      bool
+	SBOMGzip            bool
+	SBOMSigningKey      string
+	RequireSBOM         bool
+	ReportPath          string
+	InstalledDBPath     string
+	ProvenancePath      string
+	MaxImageSize        int64
 	ExtraKeyFiles       []string
 	ExtraRepos          []string
+	AllowedOrigins      []string
 	Arch                types.Architecture
 	Log                 *logrus.Logger
 	TempDirPath         string
+
+	// CacheDir is a local apk cache, which takes precedence over the
+	// one of the image configuration.
+	CacheDir string
+
+	// Offline forbids apk from fetching anything from the network, so
+	// that any package missing from the cache fails the build.
+	Offline bool
+
+	// APKToolsVersion is the version of apk-tools the image was built
+	// with, detected when apk is initialized.
+	APKToolsVersion string
+
+	// LayerSize is the uncompressed size, in bytes, of the layer tarball,
+	// counted as it is written.
+	LayerSize int64
+
+	// BaseImageVerifier, when set, is called with the digest of the base
+	// image before it is used, e.g. to verify its signatures. An error
+	// fails the build.
+	BaseImageVerifier func(name.Digest) error
+
+	// PhaseHook, when set, is called as each phase of the build
+	// completes, with its name and the time it took.
+	PhaseHook func(name string, d time.Duration)
 }
 
 var Default = Options{
-	Log: &logrus.Logger{
-		Out: os.Stderr,
+	Log: NewLogger(os.Stderr),
+}
+
+// NewLogger returns a logger formatted like the default one, writing to w
+// at the info level.
+func NewLogger(w io.Writer) *logrus.Logger {
+	return &logrus.Logger{
+		Out: w,
 		Formatter: &nested.Formatter{
 			ShowFullLevel: true,
 		},
 		Hooks: make(logrus.LevelHooks),
 		Level: logrus.InfoLevel,
-	},
+	}
 }
 
 func (o *Options) Summarize(logger *logrus.Entry) {
@@ -59,11 +115,49 @@ func (o *Options) Summarize(logger *logrus.Entry) {
 	logger.Printf("  tarball path: %s", o.TarballPath)
 	logger.Printf("  use proot: %t", o.UseProot)
 	logger.Printf("  source date: %s", o.SourceDateEpoch)
+	if o.Compression != "" {
+		logger.Printf("  compression: %s", o.Compression)
+	}
+	if o.CompressionLevel != "" {
+		logger.Printf("  compression level: %s", o.CompressionLevel)
+	}
 	logger.Printf("  Docker mediatypes: %t", o.UseDockerMediaTypes)
 	logger.Printf("  SBOM output path: %s", o.SBOMPath)
+	if o.SBOMWorkDir != "" {
+		logger.Printf("  SBOM working directory: %s", o.SBOMWorkDir)
+	}
+	if o.MaxImageSize > 0 {
+		logger.Printf("  max image size: %d bytes", o.MaxImageSize)
+	}
+	if o.ReportPath != "" {
+		logger.Printf("  build report path: %s", o.ReportPath)
+	}
+	if o.InstalledDBPath != "" {
+		logger.Printf("  installed database path: %s", o.InstalledDBPath)
+	}
+	if o.ProvenancePath != "" {
+		logger.Printf("  provenance path: %s", o.ProvenancePath)
+	}
+	if o.CacheDir != "" {
+		logger.Printf("  apk cache directory: %s", o.CacheDir)
+	}
+	if o.Offline {
+		logger.Printf("  offline: %t", o.Offline)
+	}
+	if o.APKToolsVersion != "" {
+		logger.Printf("  apk-tools version: %s", o.APKToolsVersion)
+	}
 	logger.Printf("  arch: %v", o.Arch.ToAPK())
 }
 
+// ObservePhase reports the phase which started at start, and completed
+// now, to the phase hook, if any.
+func (o *Options) ObservePhase(name string, start time.Time) {
+	if o.PhaseHook != nil {
+		o.PhaseHook(name, time.Since(start))
+	}
+}
+
 func (o *Options) Logger() *logrus.Entry {
 	fields := logrus.Fields{}
 	emptyArch := types.Architecture{}
@@ -90,11 +184,25 @@ func (o *Options) TempDir() string {
 	return o.TempDirPath
 }
 
+// LayerTarballPath returns the path the layer tarball is written to: the
+// requested output path, or a file in the temporary directory.
+func (o *Options) LayerTarballPath() string {
+	if o.TarballOutputPath != "" {
+		return o.TarballOutputPath
+	}
+	return filepath.Join(o.TempDir(), o.TarballFileName())
+}
+
 // TarballFileName returns a deterministic filename for the layer taball
 func (o Options) TarballFileName() string {
-	tarName := "apko.tar.gz"
+	ext := "tar.gz"
+	if o.Compression == "zstd" {
+		ext = "tar.zst"
+	}
+
+	tarName := "apko." + ext
 	if o.Arch.String() != "" {
-		tarName = fmt.Sprintf("apko-%s.tar.gz", o.Arch.ToAPK())
+		tarName = fmt.Sprintf("apko-%s.%s", o.Arch.ToAPK(), ext)
 	}
 	return tarName
 }
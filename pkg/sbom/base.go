@@ -0,0 +1,151 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gitlab.alpinelinux.org/alpine/go/pkg/repository"
+)
+
+// The media types of the SBOMs attached to images by apko.
+const (
+	spdxMediaType        = "text/spdx+json"
+	cyclonedxMediaType   = "application/vnd.cyclonedx+json"
+	installedDBMediaType = "application/vnd.apko.installed-db"
+)
+
+// ReadBaseImageSBOM reads the packages listed in the SBOM of the base
+// image, which are merged with the packages found in the image.
+func (s *SBOM) ReadBaseImageSBOM(imageRef string, data []byte, mediaType string) error {
+	pkgs, err := parseSBOMPackages(data, mediaType)
+	if err != nil {
+		return fmt.Errorf("reading base image SBOM: %w", err)
+	}
+
+	s.Options.BaseImage = imageRef
+	s.Options.BasePackages = pkgs
+	return nil
+}
+
+// parseSBOMPackages returns the packages listed in an SBOM, leaving out
+// the entries describing images and layers.
+func parseSBOMPackages(data []byte, mediaType string) ([]*repository.Package, error) {
+	switch mediaType {
+	case spdxMediaType:
+		return parseSPDXPackages(data)
+	case cyclonedxMediaType:
+		return parseCycloneDXPackages(data)
+	case installedDBMediaType:
+		return repository.ParsePackageIndex(io.NopCloser(bytes.NewReader(data)))
+	default:
+		return nil, fmt.Errorf("unsupported SBOM media type %q", mediaType)
+	}
+}
+
+// isImagePurl reports whether a purl refers to an image or a layer.
+func isImagePurl(purl string) bool {
+	return strings.HasPrefix(purl, "pkg:oci/")
+}
+
+func parseSPDXPackages(data []byte) ([]*repository.Package, error) {
+	doc := struct {
+		Packages []struct {
+			Name             string `json:"name"`
+			Version          string `json:"versionInfo"`
+			LicenseConcluded string `json:"licenseConcluded"`
+			Description      string `json:"description"`
+			ExternalRefs     []struct {
+				Locator string `json:"referenceLocator"`
+				Type    string `json:"referenceType"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing SPDX document: %w", err)
+	}
+
+	pkgs := []*repository.Package{}
+	for _, p := range doc.Packages {
+		image := false
+		for _, ref := range p.ExternalRefs {
+			if ref.Type == "purl" && isImagePurl(ref.Locator) {
+				image = true
+			}
+		}
+		if image || p.Version == "" {
+			continue
+		}
+
+		pkgs = append(pkgs, &repository.Package{
+			Name:        p.Name,
+			Version:     p.Version,
+			License:     p.LicenseConcluded,
+			Description: p.Description,
+		})
+	}
+
+	return pkgs, nil
+}
+
+type cycloneDXComponent struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	PUrl        string `json:"purl"`
+	Licenses    []struct {
+		Expression string `json:"expression"`
+	} `json:"licenses"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+func parseCycloneDXPackages(data []byte) ([]*repository.Package, error) {
+	bom := struct {
+		Components []cycloneDXComponent `json:"components"`
+	}{}
+	if err := json.Unmarshal(data, &bom); err != nil {
+		return nil, fmt.Errorf("parsing CycloneDX document: %w", err)
+	}
+
+	pkgs := []*repository.Package{}
+	var walk func([]cycloneDXComponent)
+	walk = func(components []cycloneDXComponent) {
+		for _, c := range components {
+			// The image and layer components nest the packages
+			if isImagePurl(c.PUrl) {
+				walk(c.Components)
+				continue
+			}
+
+			pkg := &repository.Package{
+				Name:        c.Name,
+				Version:     c.Version,
+				Description: c.Description,
+			}
+			if len(c.Licenses) != 0 {
+				pkg.License = c.Licenses[0].Expression
+			}
+			pkgs = append(pkgs, pkg)
+			walk(c.Components)
+		}
+	}
+	walk(bom.Components)
+
+	return pkgs, nil
+}
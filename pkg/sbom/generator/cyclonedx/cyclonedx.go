@@ -21,6 +21,7 @@ import (
 	"strings"
 
 	purl "github.com/package-url/packageurl-go"
+	"sigs.k8s.io/release-utils/version"
 
 	"chainguard.dev/apko/pkg/sbom/options"
 )
@@ -46,7 +47,16 @@ func (cdx *CycloneDX) Generate(opts *options.Options, path string) error {
 
 	mm := map[string]string{"arch": opts.ImageInfo.Arch.ToAPK()}
 
-	for _, pkg := range opts.Packages {
+	for _, mp := range opts.MergedPackages() {
+		pkg := mp.Package
+		origin := "apko"
+		if mp.FromBaseImage {
+			origin = "base-image"
+		}
+		if mp.FromLocalFile {
+			origin = "local-file"
+		}
+
 		// add the component
 		c := Component{
 			BOMRef: purl.NewPackageURL(
@@ -65,6 +75,9 @@ func (cdx *CycloneDX) Generate(opts *options.Options, path string) error {
 				purl.QualifiersFromMap(mm), "").String(),
 			// TODO(kaniini): Talk with CycloneDX people about adding "package" type.
 			Type: "operating-system",
+			Properties: []Property{
+				{Name: "apko:origin", Value: origin},
+			},
 		}
 
 		pkgComponents = append(pkgComponents, c)
@@ -136,8 +149,9 @@ func (cdx *CycloneDX) Generate(opts *options.Options, path string) error {
 
 	if opts.ImageInfo.VCSUrl != "" {
 		vcsURLRef := ExternalReference{
-			URL:  opts.ImageInfo.VCSUrl,
-			Type: "vcs",
+			URL:     opts.ImageInfo.VCSUrl,
+			Type:    "vcs",
+			Comment: opts.VCSComment(),
 		}
 
 		if opts.ImageInfo.ImageDigest != "" {
@@ -151,6 +165,7 @@ func (cdx *CycloneDX) Generate(opts *options.Options, path string) error {
 		BOMFormat:    "CycloneDX",
 		SpecVersion:  "1.4",
 		Version:      1,
+		Metadata:     metadata(opts),
 		Dependencies: pkgDependencies,
 	}
 
@@ -167,11 +182,33 @@ func (cdx *CycloneDX) Generate(opts *options.Options, path string) error {
 	return nil
 }
 
+// Metadata describes how the sbom was created.
+type Metadata struct {
+	Tools []Tool `json:"tools,omitempty"`
+}
+
+type Tool struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// metadata lists the tools which created the sbom.
+func metadata(opts *options.Options) *Metadata {
+	m := &Metadata{
+		Tools: []Tool{{Name: "apko", Version: version.GetVersionInfo().GitVersion}},
+	}
+	if opts.APKToolsVersion != "" {
+		m.Tools = append(m.Tools, Tool{Name: "apk-tools", Version: opts.APKToolsVersion})
+	}
+	return m
+}
+
 // TODO(kaniini): Move most of this over to gitlab.alpinelinux.org/alpine/go.
 type Document struct {
 	BOMFormat    string       `json:"bomFormat"`
 	SpecVersion  string       `json:"specVersion"`
 	Version      int          `json:"version"`
+	Metadata     *Metadata    `json:"metadata,omitempty"`
 	Components   []Component  `json:"components,omitempty"`
 	Dependencies []Dependency `json:"dependencies,omitempty"`
 }
@@ -187,6 +224,12 @@ type Component struct {
 	ExternalReferences []ExternalReference `json:"externalReferences,omitempty"`
 	Licenses           []License           `json:"licenses,omitempty"`
 	Components         []Component         `json:"components,omitempty"`
+	Properties         []Property          `json:"properties,omitempty"`
+}
+
+type Property struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
 }
 
 type License struct {
@@ -194,8 +237,9 @@ type License struct {
 }
 
 type ExternalReference struct {
-	URL  string `json:"url"`
-	Type string `json:"type"`
+	URL     string `json:"url"`
+	Type    string `json:"type"`
+	Comment string `json:"comment,omitempty"`
 }
 
 type Dependency struct {
@@ -234,8 +278,9 @@ func (cdx *CycloneDX) GenerateIndex(opts *options.Options, path string) error {
 
 	if opts.ImageInfo.VCSUrl != "" {
 		indexComponent.ExternalReferences = append(indexComponent.ExternalReferences, ExternalReference{
-			URL:  opts.ImageInfo.VCSUrl,
-			Type: "vcs",
+			URL:     opts.ImageInfo.VCSUrl,
+			Type:    "vcs",
+			Comment: opts.VCSComment(),
 		})
 	}
 
@@ -250,6 +295,7 @@ func (cdx *CycloneDX) GenerateIndex(opts *options.Options, path string) error {
 		BOMFormat:   "CycloneDX",
 		SpecVersion: "1.4",
 		Version:     1,
+		Metadata:    metadata(opts),
 		Components: []Component{
 			indexComponent,
 		},
@@ -76,11 +76,8 @@ func (sx *SPDX) Generate(opts *options.Options, path string) error {
 		Name:    documentName,
 		Version: "SPDX-2.2",
 		CreationInfo: CreationInfo{
-			Created: opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
-			Creators: []string{
-				fmt.Sprintf("Tool: apko (%s)", version.GetVersionInfo().GitVersion),
-				"Organization: Chainguard, Inc",
-			},
+			Created:            opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
+			Creators:           creators(opts),
 			LicenseListVersion: "3.16",
 		},
 		DataLicense:   "CC0-1.0",
@@ -113,6 +110,7 @@ func (sx *SPDX) Generate(opts *options.Options, path string) error {
 			Category: "OTHER",
 			Locator:  opts.ImageInfo.VCSUrl,
 			Type:     "vcs",
+			Comment:  opts.VCSComment(),
 		}
 		if opts.ImageInfo.ImageDigest != "" {
 			imagePackage.ExternalRefs = append(imagePackage.ExternalRefs, vcsURLRef)
@@ -123,12 +121,19 @@ func (sx *SPDX) Generate(opts *options.Options, path string) error {
 
 	doc.Packages = append(doc.Packages, *layerPackage)
 
-	for _, pkg := range opts.Packages {
+	for _, mp := range opts.MergedPackages() {
+		pkg := mp.Package
 		// add the package
 		p, err := sx.apkPackage(opts, pkg)
 		if err != nil {
 			return fmt.Errorf("generating apk package: %w", err)
 		}
+		if mp.FromBaseImage {
+			p.SourceInfo = fmt.Sprintf("Package from base image %s", opts.BaseImage)
+		}
+		if mp.FromLocalFile {
+			p.SourceInfo = "Package installed from a local .apk file"
+		}
 		// Add the layer to the ID to avoid clashes
 		p.ID = stringToIdentifier(fmt.Sprintf(
 			"SPDXRef-Package-%s-%s-%s", layerPackage.ID, pkg.Name, pkg.Version,
@@ -216,12 +221,7 @@ func (sx *SPDX) apkPackage(opts *options.Options, pkg *repository.Package) (p Pa
 		Originator:       pkg.Maintainer,
 		SourceInfo:       "Package info from apk database",
 		CopyrightText:    NOASSERTION,
-		Checksums: []Checksum{
-			{
-				Algorithm: "SHA1",
-				Value:     fmt.Sprintf("%x", pkg.Checksum),
-			},
-		},
+		Checksums:        []Checksum{},
 		ExternalRefs: []ExternalRef{
 			{
 				Category: "PACKAGE_MANAGER",
@@ -234,6 +234,13 @@ func (sx *SPDX) apkPackage(opts *options.Options, pkg *repository.Package) (p Pa
 			},
 		},
 	}
+	// Packages of the base image read from its SBOM have no checksum
+	if len(pkg.Checksum) != 0 {
+		p.Checksums = append(p.Checksums, Checksum{
+			Algorithm: "SHA1",
+			Value:     fmt.Sprintf("%x", pkg.Checksum),
+		})
+	}
 	return p, nil
 }
 
@@ -268,6 +275,15 @@ func (sx *SPDX) layerPackage(opts *options.Options) (p *Package, err error) {
 	return &layerPackage, nil
 }
 
+// creators returns the tools and organization which created the sbom.
+func creators(opts *options.Options) []string {
+	c := []string{fmt.Sprintf("Tool: apko (%s)", version.GetVersionInfo().GitVersion)}
+	if opts.APKToolsVersion != "" {
+		c = append(c, fmt.Sprintf("Tool: apk-tools (%s)", opts.APKToolsVersion))
+	}
+	return append(c, "Organization: Chainguard, Inc")
+}
+
 type Document struct {
 	ID                   string                `json:"SPDXID"`
 	Name                 string                `json:"name"`
@@ -318,6 +334,7 @@ type ExternalRef struct {
 	Category string `json:"referenceCategory"`
 	Locator  string `json:"referenceLocator"`
 	Type     string `json:"referenceType"`
+	Comment  string `json:"comment,omitempty"`
 }
 
 type Relationship struct {
@@ -339,11 +356,8 @@ func (sx *SPDX) GenerateIndex(opts *options.Options, path string) error {
 		Name:    documentName,
 		Version: "SPDX-2.2",
 		CreationInfo: CreationInfo{
-			Created: opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
-			Creators: []string{
-				fmt.Sprintf("Tool: apko (%s)", version.GetVersionInfo().GitVersion),
-				"Organization: Chainguard, Inc",
-			},
+			Created:            opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
+			Creators:           creators(opts),
 			LicenseListVersion: "3.16",
 		},
 		DataLicense:   "CC0-1.0",
@@ -386,6 +400,7 @@ func (sx *SPDX) GenerateIndex(opts *options.Options, path string) error {
 			Category: "OTHER",
 			Locator:  opts.ImageInfo.VCSUrl,
 			Type:     "vcs",
+			Comment:  opts.VCSComment(),
 		})
 	}
 
@@ -66,6 +66,28 @@ func TestGenerate(t *testing.T) {
 	require.FileExists(t, path)
 }
 
+func TestCreators(t *testing.T) {
+	opts := *testOpts
+	require.Len(t, creators(&opts), 2)
+
+	opts.APKToolsVersion = "2.12.9"
+	require.Contains(t, creators(&opts), "Tool: apk-tools (2.12.9)")
+}
+
+func TestVCSSubPath(t *testing.T) {
+	opts := *testOpts
+	opts.ImageInfo.VCSUrl = "https://github.com/example/images"
+	opts.ImageInfo.VCSSubPath = "images/app"
+
+	sx := New()
+	path := filepath.Join(t.TempDir(), "sbom."+sx.Ext())
+	require.NoError(t, sx.Generate(&opts, path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"comment": "path: images/app"`)
+}
+
 func TestReproducible(t *testing.T) {
 	// Create two sboms based on the same input and ensure
 	// they are identical
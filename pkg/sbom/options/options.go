@@ -47,8 +47,84 @@ type Options struct {
 	// Formats dictates which SBOM formats we will output
 	Formats []string
 
+	// Gzip also writes a gzip compressed copy of each sbom, with the
+	// .gz extension appended
+	Gzip bool
+
+	// SigningKey is the path of the private key, or the KMS URI of the
+	// key, the sboms are signed with, when set. The signatures are written next to them with the
+	// .sig extension appended
+	SigningKey string
+
+	// APKToolsVersion is the version of apk-tools which installed the
+	// packages, recorded as one of the tools that created the sbom
+	APKToolsVersion string
+
 	// Packages is alist of packages which will be listed in the SBOM
 	Packages []*repository.Package
+
+	// LocalPackages are the names of the packages installed from local
+	// .apk files, which come from no repository
+	LocalPackages []string
+
+	// BaseImage is the reference of the base image of the image, if any
+	BaseImage string
+
+	// BasePackages is the list of packages read from the SBOM of the
+	// base image, which are merged with Packages
+	BasePackages []*repository.Package
+}
+
+// MergedPackage is a package listed in the SBOM, recording whether it
+// comes from the base image.
+type MergedPackage struct {
+	*repository.Package
+	FromBaseImage bool
+	// FromLocalFile is set for packages installed from a local .apk
+	// file rather than a repository.
+	FromLocalFile bool
+}
+
+// MergedPackages returns the packages to list in the SBOM: the packages
+// found in the image, followed by the packages of the base image which
+// were not found in it. Packages are deduplicated by name, so that a base
+// image package upgraded by apko is only listed at the installed version.
+// Those also listed at the same version in the base image SBOM are marked
+// as coming from the base image. Packages installed from local .apk files
+// are marked too.
+func (o *Options) MergedPackages() []MergedPackage {
+	base := map[string]string{}
+	for _, pkg := range o.BasePackages {
+		base[pkg.Name] = pkg.Version
+	}
+
+	local := map[string]struct{}{}
+	for _, name := range o.LocalPackages {
+		local[name] = struct{}{}
+	}
+
+	merged := make([]MergedPackage, 0, len(o.Packages)+len(o.BasePackages))
+	seen := map[string]struct{}{}
+	for _, pkg := range o.Packages {
+		if _, ok := seen[pkg.Name]; ok {
+			continue
+		}
+		version, ok := base[pkg.Name]
+		fromBase := ok && version == pkg.Version
+		_, fromLocal := local[pkg.Name]
+		merged = append(merged, MergedPackage{Package: pkg, FromBaseImage: fromBase, FromLocalFile: fromLocal})
+		seen[pkg.Name] = struct{}{}
+	}
+
+	for _, pkg := range o.BasePackages {
+		if _, ok := seen[pkg.Name]; ok {
+			continue
+		}
+		merged = append(merged, MergedPackage{Package: pkg, FromBaseImage: true})
+		seen[pkg.Name] = struct{}{}
+	}
+
+	return merged
 }
 
 type OSInfo struct {
@@ -65,6 +141,7 @@ type ImageInfo struct {
 	LayerDigest     string
 	ImageDigest     string
 	VCSUrl          string
+	VCSSubPath      string
 	IndexMediaType  ggcrtypes.MediaType
 	ImageMediaType  ggcrtypes.MediaType
 	IndexDigest     v1.Hash
@@ -79,6 +156,15 @@ type ArchImageInfo struct {
 	SBOMDigest string
 }
 
+// VCSComment returns the comment of the VCS reference of the image,
+// naming the directory of the image configuration in the repository.
+func (o *Options) VCSComment() string {
+	if o.ImageInfo.VCSSubPath == "" {
+		return ""
+	}
+	return "path: " + o.ImageInfo.VCSSubPath
+}
+
 // ImagePurlName returns a name to represent the image in a purl
 func (o *Options) ImagePurlName() string {
 	repoName := "image"
@@ -17,17 +17,20 @@ package sbom
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
 
 import (
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
 	osr "github.com/dominodatalab/os-release"
-	v1tar "github.com/google/go-containerregistry/pkg/v1/tarball"
 	"gitlab.alpinelinux.org/alpine/go/pkg/repository"
 
 	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/sbom/generator"
 	"chainguard.dev/apko/pkg/sbom/options"
+	"chainguard.dev/apko/pkg/tarball"
 )
 
 var (
@@ -48,6 +51,29 @@ var DefaultOptions = options.Options{
 	Formats:  []string{"spdx", "cyclonedx"},
 }
 
+// FileKind is the kind of a file written when generating an SBOM.
+type FileKind string
+
+const (
+	// FileKindSBOM is the SBOM document itself.
+	FileKindSBOM FileKind = "sbom"
+	// FileKindGzip is the gzip compressed copy of the SBOM.
+	FileKindGzip FileKind = "gzip"
+	// FileKindSignature is the signature of the SBOM.
+	FileKindSignature FileKind = "signature"
+)
+
+// File is a file written when generating the SBOMs.
+type File struct {
+	// Format is the SBOM format the file was written for.
+	Format string
+	// Path is the path of the file.
+	Path string
+	// Kind tells the SBOM document from its compressed copy and its
+	// signature.
+	Kind FileKind
+}
+
 type SBOM struct {
 	Generators map[string]generator.Generator
 	impl       sbomImplementation
@@ -97,7 +123,7 @@ func (s *SBOM) ReadPackageIndex() error {
 }
 
 // Generate creates the sboms according to the options set
-func (s *SBOM) Generate() ([]string, error) {
+func (s *SBOM) Generate() ([]File, error) {
 	// s.Options.Logger().Infof("generating SBOM")
 	if err := s.impl.CheckGenerators(
 		&s.Options, s.Generators,
@@ -112,7 +138,7 @@ func (s *SBOM) Generate() ([]string, error) {
 }
 
 // Generate creates the sboms according to the options set
-func (s *SBOM) GenerateIndex() ([]string, error) {
+func (s *SBOM) GenerateIndex() ([]File, error) {
 	if err := s.impl.CheckGenerators(
 		&s.Options, s.Generators,
 	); err != nil {
@@ -134,9 +160,9 @@ func (s *SBOM) ReadLayerTarball(path string) error {
 type sbomImplementation interface {
 	ReadReleaseData(*options.Options, string) error
 	ReadPackageIndex(*options.Options, string) ([]*repository.Package, error)
-	Generate(*options.Options, map[string]generator.Generator) ([]string, error)
+	Generate(*options.Options, map[string]generator.Generator) ([]File, error)
 	CheckGenerators(*options.Options, map[string]generator.Generator) error
-	GenerateIndex(*options.Options, map[string]generator.Generator) ([]string, error)
+	GenerateIndex(*options.Options, map[string]generator.Generator) ([]File, error)
 	ReadLayerTarball(*options.Options, string) error
 }
 
@@ -150,7 +176,6 @@ func (di *defaultSBOMImplementation) ReadReleaseData(opts *options.Options, path
 	}
 
 	info := osr.Parse(string(osReleaseData))
-	fmt.Printf("%+v", info)
 
 	opts.OS.Name = info.Name
 	opts.OS.ID = info.ID
@@ -179,20 +204,82 @@ func (di *defaultSBOMImplementation) ReadPackageIndex(
 // generate creates the documents according to the specified options
 func (di *defaultSBOMImplementation) Generate(
 	opts *options.Options, generators map[string]generator.Generator,
-) ([]string, error) {
-	files := []string{}
+) ([]File, error) {
+	return writeSBOMs(opts, generators, opts.FileName, generator.Generator.Generate)
+}
+
+// writeSBOMs writes the SBOM of each format with the given base name using
+// write, along with its compressed copy and its signature when requested,
+// returning the files written.
+func writeSBOMs(
+	opts *options.Options, generators map[string]generator.Generator, name string,
+	write func(generator.Generator, *options.Options, string) error,
+) ([]File, error) {
+	signer, err := signerFor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []File{}
 	for _, format := range opts.Formats {
 		path := filepath.Join(
-			opts.OutputDir, opts.FileName+"."+generators[format].Ext(),
+			opts.OutputDir, name+"."+generators[format].Ext(),
 		)
-		if err := generators[format].Generate(opts, path); err != nil {
+		if err := write(generators[format], opts, path); err != nil {
 			return nil, fmt.Errorf("generating %s sbom: %w", format, err)
 		}
-		files = append(files, path)
+		files = append(files, File{Format: format, Path: path, Kind: FileKindSBOM})
+
+		if opts.Gzip {
+			gzPath, err := gzipFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("compressing %s sbom: %w", format, err)
+			}
+			files = append(files, File{Format: format, Path: gzPath, Kind: FileKindGzip})
+		}
+
+		if signer != nil {
+			sigPath, err := signFile(signer, path)
+			if err != nil {
+				return nil, fmt.Errorf("signing %s sbom: %w", format, err)
+			}
+			files = append(files, File{Format: format, Path: sigPath, Kind: FileKindSignature})
+		} else if err := os.Remove(path + ".sig"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			// a signature left by a previous build would be attached
+			// along the unsigned sbom when publishing
+			return nil, fmt.Errorf("removing stale %s sbom signature: %w", format, err)
+		}
 	}
 	return files, nil
 }
 
+// gzipFile writes a gzip compressed copy of the file at path next to it,
+// returning the path of the copy.
+func gzipFile(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	gzPath := path + ".gz"
+	out, err := os.Create(gzPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	gzw := gzip.NewWriter(out)
+	if _, err := io.Copy(gzw, in); err != nil {
+		return "", err
+	}
+	if err := gzw.Close(); err != nil {
+		return "", err
+	}
+
+	return gzPath, out.Close()
+}
+
 // checkGenerators verifies we have generators available for the
 // formats specified in the options
 func (di *defaultSBOMImplementation) CheckGenerators(
@@ -215,23 +302,13 @@ func (di *defaultSBOMImplementation) CheckGenerators(
 }
 
 // GenerateIndex generates the index SBOM for a multi-arch image
-func (di *defaultSBOMImplementation) GenerateIndex(opts *options.Options, generators map[string]generator.Generator) ([]string, error) {
-	sboms := []string{}
-	for _, format := range opts.Formats {
-		path := filepath.Join(
-			opts.OutputDir, "sbom-index."+generators[format].Ext(),
-		)
-		if err := generators[format].GenerateIndex(opts, path); err != nil {
-			return nil, fmt.Errorf("generating %s sbom: %w", format, err)
-		}
-		sboms = append(sboms, path)
-	}
-	return sboms, nil
+func (di *defaultSBOMImplementation) GenerateIndex(opts *options.Options, generators map[string]generator.Generator) ([]File, error) {
+	return writeSBOMs(opts, generators, "sbom-index", generator.Generator.GenerateIndex)
 }
 
 // ReadLayerTarball reads an apko layer adding its digest to the sbom options
 func (di *defaultSBOMImplementation) ReadLayerTarball(opts *options.Options, tarballPath string) error {
-	v1Layer, err := v1tar.LayerFromFile(tarballPath)
+	v1Layer, err := tarball.LayerFromFile(tarballPath, "")
 	if err != nil {
 		return fmt.Errorf("failed to create OCI layer from tar.gz: %w", err)
 	}
@@ -30,14 +30,14 @@ var errFake = fmt.Errorf("synthetic error")
 func TestGenerate(t *testing.T) {
 	for _, tc := range []struct {
 		prepare func(*sbomfakes.FakeSbomImplementation)
-		assert  func([]string, error)
+		assert  func([]sbom.File, error)
 	}{
 		{
 			// CheckGenerators errors
 			prepare: func(fsi *sbomfakes.FakeSbomImplementation) {
 				fsi.CheckGeneratorsReturns(errFake)
 			},
-			assert: func(s []string, err error) {
+			assert: func(s []sbom.File, err error) {
 				require.Error(t, err)
 			},
 		},
@@ -47,16 +47,16 @@ func TestGenerate(t *testing.T) {
 				fsi.CheckGeneratorsReturns(nil)
 				fsi.GenerateReturns(nil, errFake)
 			},
-			assert: func(s []string, err error) {
+			assert: func(s []sbom.File, err error) {
 				require.Error(t, err)
 			},
 		},
 		{
 			// Success
 			prepare: func(fsi *sbomfakes.FakeSbomImplementation) {
-				fsi.GenerateReturns([]string{"/path/to/sbom.cdx"}, nil)
+				fsi.GenerateReturns([]sbom.File{{Format: "cyclonedx", Path: "/path/to/sbom.cdx", Kind: sbom.FileKindSBOM}}, nil)
 			},
-			assert: func(s []string, err error) {
+			assert: func(s []sbom.File, err error) {
 				require.GreaterOrEqual(t, len(s), 1)
 				require.NoError(t, err)
 			},
@@ -15,7 +15,16 @@
 package sbom
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -23,7 +32,11 @@ import (
 	"chainguard.dev/apko/pkg/sbom/generator"
 	"chainguard.dev/apko/pkg/sbom/generator/generatorfakes"
 
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/kms"
 	"github.com/stretchr/testify/require"
+	"gitlab.alpinelinux.org/alpine/go/pkg/repository"
 
 	"chainguard.dev/apko/pkg/sbom/options"
 )
@@ -168,7 +181,7 @@ func TestGenerate(t *testing.T) {
 	for _, tc := range []struct {
 		prepare func(*generatorfakes.FakeGenerator)
 		opts    options.Options
-		assert  func([]string, error)
+		assert  func([]File, error)
 	}{
 		{
 			// Success
@@ -176,7 +189,7 @@ func TestGenerate(t *testing.T) {
 				fg.GenerateReturns(nil)
 			},
 			opts: options.Options{OutputDir: outputDir, Formats: formats},
-			assert: func(sboms []string, err error) {
+			assert: func(sboms []File, err error) {
 				require.NoError(t, err)
 				require.GreaterOrEqual(t, len(sboms), 1)
 			},
@@ -187,7 +200,7 @@ func TestGenerate(t *testing.T) {
 				fg.GenerateReturns(errFake)
 			},
 			opts: options.Options{OutputDir: outputDir, Formats: formats},
-			assert: func(s []string, err error) {
+			assert: func(s []File, err error) {
 				require.Error(t, err)
 			},
 		},
@@ -200,3 +213,212 @@ func TestGenerate(t *testing.T) {
 		tc.assert(res, err)
 	}
 }
+
+func TestGenerateGzip(t *testing.T) {
+	di := defaultSBOMImplementation{}
+	outputDir := t.TempDir()
+
+	mock := &generatorfakes.FakeGenerator{}
+	mock.ExtReturns("spdx.json")
+	mock.GenerateStub = func(_ *options.Options, path string) error {
+		return os.WriteFile(path, []byte("sbom"), 0o644)
+	}
+
+	sboms, err := di.Generate(&options.Options{
+		OutputDir: outputDir, FileName: "sbom", Formats: []string{"fake"}, Gzip: true,
+	}, map[string]generator.Generator{"fake": mock})
+	require.NoError(t, err)
+
+	path := filepath.Join(outputDir, "sbom.spdx.json")
+	require.Equal(t, []File{
+		{Format: "fake", Path: path, Kind: FileKindSBOM},
+		{Format: "fake", Path: path + ".gz", Kind: FileKindGzip},
+	}, sboms)
+
+	f, err := os.Open(path + ".gz")
+	require.NoError(t, err)
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gzr)
+	require.NoError(t, err)
+	require.Equal(t, "sbom", string(data))
+}
+
+func TestGenerateSigned(t *testing.T) {
+	di := defaultSBOMImplementation{}
+	outputDir := t.TempDir()
+
+	t.Setenv("COSIGN_PASSWORD", "secret")
+	privPEM, pubPEM, err := cryptoutils.GeneratePEMEncodedECDSAKeyPair(elliptic.P256(), func(bool) ([]byte, error) {
+		return []byte("secret"), nil
+	})
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "cosign.key")
+	require.NoError(t, os.WriteFile(keyPath, privPEM, 0o600))
+
+	mock := &generatorfakes.FakeGenerator{}
+	mock.ExtReturns("spdx.json")
+	mock.GenerateStub = func(_ *options.Options, path string) error {
+		return os.WriteFile(path, []byte("sbom"), 0o644)
+	}
+
+	sboms, err := di.Generate(&options.Options{
+		OutputDir: outputDir, FileName: "sbom", Formats: []string{"fake"}, SigningKey: keyPath,
+	}, map[string]generator.Generator{"fake": mock})
+	require.NoError(t, err)
+
+	path := filepath.Join(outputDir, "sbom.spdx.json")
+	require.Equal(t, []File{
+		{Format: "fake", Path: path, Kind: FileKindSBOM},
+		{Format: "fake", Path: path + ".sig", Kind: FileKindSignature},
+	}, sboms)
+
+	data, err := os.ReadFile(path + ".sig")
+	require.NoError(t, err)
+	sig, err := base64.StdEncoding.DecodeString(string(data))
+	require.NoError(t, err)
+
+	pub, err := cryptoutils.UnmarshalPEMToPublicKey(pubPEM)
+	require.NoError(t, err)
+	verifier, err := signature.LoadVerifier(pub, crypto.SHA256)
+	require.NoError(t, err)
+	require.NoError(t, verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader([]byte("sbom"))))
+
+	// The password must match.
+	t.Setenv("COSIGN_PASSWORD", "wrong")
+	_, err = di.Generate(&options.Options{
+		OutputDir: outputDir, FileName: "sbom", Formats: []string{"fake"}, SigningKey: keyPath,
+	}, map[string]generator.Generator{"fake": mock})
+	require.ErrorContains(t, err, "parsing signing key")
+
+	// KMS keys need a registered provider.
+	_, err = di.Generate(&options.Options{
+		OutputDir: outputDir, FileName: "sbom", Formats: []string{"fake"}, SigningKey: "awskms:///alias/sbom",
+	}, map[string]generator.Generator{"fake": mock})
+	require.ErrorContains(t, err, "loading KMS signing key awskms:///alias/sbom")
+
+	// The signature of a previous build is removed when not signing.
+	require.FileExists(t, filepath.Join(outputDir, "sbom.spdx.json.sig"))
+	_, err = di.Generate(&options.Options{
+		OutputDir: outputDir, FileName: "sbom", Formats: []string{"fake"},
+	}, map[string]generator.Generator{"fake": mock})
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(outputDir, "sbom.spdx.json.sig"))
+}
+
+// fakeKMSSigner is a KMS signer backed by a local key.
+type fakeKMSSigner struct {
+	signature.SignerVerifier
+}
+
+func (fakeKMSSigner) CreateKey(context.Context, string) (crypto.PublicKey, error) {
+	return nil, errFake
+}
+
+func (fakeKMSSigner) CryptoSigner(context.Context, func(error)) (crypto.Signer, crypto.SignerOpts, error) {
+	return nil, nil, errFake
+}
+
+func (fakeKMSSigner) SupportedAlgorithms() []string { return nil }
+
+func (fakeKMSSigner) DefaultAlgorithm() string { return "" }
+
+func TestGenerateSignedKMS(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	sv, err := signature.LoadSignerVerifier(priv, crypto.SHA256)
+	require.NoError(t, err)
+
+	var keyRef string
+	kms.AddProvider("fakekms://", func(_ context.Context, ref string, _ crypto.Hash, _ ...signature.RPCOption) (kms.SignerVerifier, error) {
+		keyRef = ref
+		return fakeKMSSigner{sv}, nil
+	})
+
+	mock := &generatorfakes.FakeGenerator{}
+	mock.ExtReturns("spdx.json")
+	mock.GenerateStub = func(_ *options.Options, path string) error {
+		return os.WriteFile(path, []byte("sbom"), 0o644)
+	}
+
+	outputDir := t.TempDir()
+	di := defaultSBOMImplementation{}
+	_, err = di.Generate(&options.Options{
+		OutputDir: outputDir, FileName: "sbom", Formats: []string{"fake"}, SigningKey: "fakekms://sbom",
+	}, map[string]generator.Generator{"fake": mock})
+	require.NoError(t, err)
+	require.Equal(t, "fakekms://sbom", keyRef)
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "sbom.spdx.json.sig"))
+	require.NoError(t, err)
+	sig, err := base64.StdEncoding.DecodeString(string(data))
+	require.NoError(t, err)
+	require.NoError(t, sv.VerifySignature(bytes.NewReader(sig), bytes.NewReader([]byte("sbom"))))
+}
+
+func TestReadBaseImageSBOM(t *testing.T) {
+	spdxDoc := `{
+  "packages": [
+    {"name": "sha256:abc", "externalRefs": [{"referenceType": "purl", "referenceLocator": "pkg:oci/base@sha256:abc"}]},
+    {"name": "sha256:def", "versionInfo": "3.16", "externalRefs": [{"referenceType": "purl", "referenceLocator": "pkg:oci/base@sha256:def"}]},
+    {"name": "busybox", "versionInfo": "1.35.0-r17", "externalRefs": [{"referenceType": "purl", "referenceLocator": "pkg:apk/alpine/busybox@1.35.0-r17"}]},
+    {"name": "musl", "versionInfo": "1.2.3-r0"}
+  ]
+}`
+	cdxDoc := `{
+  "components": [
+    {"name": "base", "purl": "pkg:oci/base@sha256:abc", "components": [
+      {"name": "busybox", "version": "1.35.0-r17", "purl": "pkg:apk/alpine/busybox@1.35.0-r17"},
+      {"name": "musl", "version": "1.2.3-r0", "purl": "pkg:apk/alpine/musl@1.2.3-r0"}
+    ]}
+  ]
+}`
+
+	for mediaType, doc := range map[string]string{spdxMediaType: spdxDoc, cyclonedxMediaType: cdxDoc} {
+		s := New()
+		s.Options.Packages = []*repository.Package{
+			{Name: "musl", Version: "1.2.3-r0"},
+			{Name: "nginx", Version: "1.22.0-r1"},
+		}
+		require.NoError(t, s.ReadBaseImageSBOM("example.com/base", []byte(doc), mediaType), mediaType)
+		require.Equal(t, "example.com/base", s.Options.BaseImage)
+
+		merged := map[string]bool{}
+		for _, pkg := range s.Options.MergedPackages() {
+			merged[pkg.Name+"="+pkg.Version] = pkg.FromBaseImage
+		}
+		require.Equal(t, map[string]bool{
+			"musl=1.2.3-r0":      true,
+			"nginx=1.22.0-r1":    false,
+			"busybox=1.35.0-r17": true,
+		}, merged, mediaType)
+	}
+
+	require.Error(t, New().ReadBaseImageSBOM("example.com/base", []byte("{}"), "text/plain"))
+}
+
+func TestMergedPackagesUpgrade(t *testing.T) {
+	s := New()
+	s.Options.Packages = []*repository.Package{
+		{Name: "busybox", Version: "1.35.0-r18"},
+		{Name: "musl", Version: "1.2.3-r0"},
+	}
+	s.Options.BasePackages = []*repository.Package{
+		{Name: "busybox", Version: "1.35.0-r17"},
+		{Name: "musl", Version: "1.2.3-r0"},
+		{Name: "zlib", Version: "1.2.12-r3"},
+	}
+
+	// The base image package upgraded by apko is listed once, at the
+	// installed version, and not marked as coming from the base image.
+	merged := map[string]bool{}
+	for _, pkg := range s.Options.MergedPackages() {
+		merged[pkg.Name+"="+pkg.Version] = pkg.FromBaseImage
+	}
+	require.Equal(t, map[string]bool{
+		"busybox=1.35.0-r18": false,
+		"musl=1.2.3-r0":      true,
+		"zlib=1.2.12-r3":     true,
+	}, merged)
+}
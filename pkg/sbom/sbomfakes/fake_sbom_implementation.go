@@ -18,9 +18,10 @@ package sbomfakes
 import (
 	"sync"
 
+	"chainguard.dev/apko/pkg/sbom"
 	"chainguard.dev/apko/pkg/sbom/generator"
 	"chainguard.dev/apko/pkg/sbom/options"
-	"gitlab.alpinelinux.org/alpine/go/repository"
+	"gitlab.alpinelinux.org/alpine/go/pkg/repository"
 )
 
 type FakeSbomImplementation struct {
@@ -36,32 +37,32 @@ type FakeSbomImplementation struct {
 	checkGeneratorsReturnsOnCall map[int]struct {
 		result1 error
 	}
-	GenerateStub        func(*options.Options, map[string]generator.Generator) ([]string, error)
+	GenerateStub        func(*options.Options, map[string]generator.Generator) ([]sbom.File, error)
 	generateMutex       sync.RWMutex
 	generateArgsForCall []struct {
 		arg1 *options.Options
 		arg2 map[string]generator.Generator
 	}
 	generateReturns struct {
-		result1 []string
+		result1 []sbom.File
 		result2 error
 	}
 	generateReturnsOnCall map[int]struct {
-		result1 []string
+		result1 []sbom.File
 		result2 error
 	}
-	GenerateIndexStub        func(*options.Options, map[string]generator.Generator) ([]string, error)
+	GenerateIndexStub        func(*options.Options, map[string]generator.Generator) ([]sbom.File, error)
 	generateIndexMutex       sync.RWMutex
 	generateIndexArgsForCall []struct {
 		arg1 *options.Options
 		arg2 map[string]generator.Generator
 	}
 	generateIndexReturns struct {
-		result1 []string
+		result1 []sbom.File
 		result2 error
 	}
 	generateIndexReturnsOnCall map[int]struct {
-		result1 []string
+		result1 []sbom.File
 		result2 error
 	}
 	ReadLayerTarballStub        func(*options.Options, string) error
@@ -168,7 +169,7 @@ func (fake *FakeSbomImplementation) CheckGeneratorsReturnsOnCall(i int, result1
 	}{result1}
 }
 
-func (fake *FakeSbomImplementation) Generate(arg1 *options.Options, arg2 map[string]generator.Generator) ([]string, error) {
+func (fake *FakeSbomImplementation) Generate(arg1 *options.Options, arg2 map[string]generator.Generator) ([]sbom.File, error) {
 	fake.generateMutex.Lock()
 	ret, specificReturn := fake.generateReturnsOnCall[len(fake.generateArgsForCall)]
 	fake.generateArgsForCall = append(fake.generateArgsForCall, struct {
@@ -194,7 +195,7 @@ func (fake *FakeSbomImplementation) GenerateCallCount() int {
 	return len(fake.generateArgsForCall)
 }
 
-func (fake *FakeSbomImplementation) GenerateCalls(stub func(*options.Options, map[string]generator.Generator) ([]string, error)) {
+func (fake *FakeSbomImplementation) GenerateCalls(stub func(*options.Options, map[string]generator.Generator) ([]sbom.File, error)) {
 	fake.generateMutex.Lock()
 	defer fake.generateMutex.Unlock()
 	fake.GenerateStub = stub
@@ -207,33 +208,33 @@ func (fake *FakeSbomImplementation) GenerateArgsForCall(i int) (*options.Options
 	return argsForCall.arg1, argsForCall.arg2
 }
 
-func (fake *FakeSbomImplementation) GenerateReturns(result1 []string, result2 error) {
+func (fake *FakeSbomImplementation) GenerateReturns(result1 []sbom.File, result2 error) {
 	fake.generateMutex.Lock()
 	defer fake.generateMutex.Unlock()
 	fake.GenerateStub = nil
 	fake.generateReturns = struct {
-		result1 []string
+		result1 []sbom.File
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeSbomImplementation) GenerateReturnsOnCall(i int, result1 []string, result2 error) {
+func (fake *FakeSbomImplementation) GenerateReturnsOnCall(i int, result1 []sbom.File, result2 error) {
 	fake.generateMutex.Lock()
 	defer fake.generateMutex.Unlock()
 	fake.GenerateStub = nil
 	if fake.generateReturnsOnCall == nil {
 		fake.generateReturnsOnCall = make(map[int]struct {
-			result1 []string
+			result1 []sbom.File
 			result2 error
 		})
 	}
 	fake.generateReturnsOnCall[i] = struct {
-		result1 []string
+		result1 []sbom.File
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeSbomImplementation) GenerateIndex(arg1 *options.Options, arg2 map[string]generator.Generator) ([]string, error) {
+func (fake *FakeSbomImplementation) GenerateIndex(arg1 *options.Options, arg2 map[string]generator.Generator) ([]sbom.File, error) {
 	fake.generateIndexMutex.Lock()
 	ret, specificReturn := fake.generateIndexReturnsOnCall[len(fake.generateIndexArgsForCall)]
 	fake.generateIndexArgsForCall = append(fake.generateIndexArgsForCall, struct {
@@ -259,7 +260,7 @@ func (fake *FakeSbomImplementation) GenerateIndexCallCount() int {
 	return len(fake.generateIndexArgsForCall)
 }
 
-func (fake *FakeSbomImplementation) GenerateIndexCalls(stub func(*options.Options, map[string]generator.Generator) ([]string, error)) {
+func (fake *FakeSbomImplementation) GenerateIndexCalls(stub func(*options.Options, map[string]generator.Generator) ([]sbom.File, error)) {
 	fake.generateIndexMutex.Lock()
 	defer fake.generateIndexMutex.Unlock()
 	fake.GenerateIndexStub = stub
@@ -272,28 +273,28 @@ func (fake *FakeSbomImplementation) GenerateIndexArgsForCall(i int) (*options.Op
 	return argsForCall.arg1, argsForCall.arg2
 }
 
-func (fake *FakeSbomImplementation) GenerateIndexReturns(result1 []string, result2 error) {
+func (fake *FakeSbomImplementation) GenerateIndexReturns(result1 []sbom.File, result2 error) {
 	fake.generateIndexMutex.Lock()
 	defer fake.generateIndexMutex.Unlock()
 	fake.GenerateIndexStub = nil
 	fake.generateIndexReturns = struct {
-		result1 []string
+		result1 []sbom.File
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeSbomImplementation) GenerateIndexReturnsOnCall(i int, result1 []string, result2 error) {
+func (fake *FakeSbomImplementation) GenerateIndexReturnsOnCall(i int, result1 []sbom.File, result2 error) {
 	fake.generateIndexMutex.Lock()
 	defer fake.generateIndexMutex.Unlock()
 	fake.GenerateIndexStub = nil
 	if fake.generateIndexReturnsOnCall == nil {
 		fake.generateIndexReturnsOnCall = make(map[int]struct {
-			result1 []string
+			result1 []sbom.File
 			result2 error
 		})
 	}
 	fake.generateIndexReturnsOnCall[i] = struct {
-		result1 []string
+		result1 []sbom.File
 		result2 error
 	}{result1, result2}
 }
@@ -0,0 +1,104 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/kms"
+
+	"chainguard.dev/apko/pkg/sbom/options"
+)
+
+// signingPasswordEnv holds the password of encrypted signing keys, as
+// for cosign.
+const signingPasswordEnv = "COSIGN_PASSWORD"
+
+// signerFor returns the signer of the sboms, or nil when they are not
+// signed.
+func signerFor(opts *options.Options) (signature.Signer, error) {
+	if opts.SigningKey == "" {
+		return nil, nil
+	}
+	return loadSigner(opts.SigningKey)
+}
+
+// loadSigner loads the signer of the PEM encoded private key at keyRef,
+// which may be encrypted as cosign does, or, when keyRef is a KMS URI,
+// e.g. awskms:///alias/sbom, the signer of the KMS provider registered
+// for it.
+func loadSigner(keyRef string) (signature.Signer, error) {
+	if strings.Contains(keyRef, "://") {
+		signer, err := kms.Get(context.Background(), keyRef, crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("loading KMS signing key %s: %w", keyRef, err)
+		}
+		return signer, nil
+	}
+
+	pass := func(bool) ([]byte, error) {
+		if password, ok := os.LookupEnv(signingPasswordEnv); ok {
+			return []byte(password), nil
+		}
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(keyRef)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+
+	key, err := cryptoutils.UnmarshalPEMToPrivateKey(data, pass)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing key %s: %w", keyRef, err)
+	}
+
+	signer, err := signature.LoadSigner(key, crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("loading signing key %s: %w", keyRef, err)
+	}
+	return signer, nil
+}
+
+// signFile signs the file at path, writing the base64 encoded signature
+// next to it as <path>.sig, as cosign sign-blob does, and returns the
+// path of the signature.
+func signFile(signer signature.Signer, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := signer.SignMessage(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	sigPath := path + ".sig"
+	// #nosec G306 -- signatures are public
+	if err := os.WriteFile(sigPath, []byte(base64.StdEncoding.EncodeToString(sig)), 0o644); err != nil {
+		return "", err
+	}
+
+	return sigPath, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarball
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	v1tar "github.com/google/go-containerregistry/pkg/v1/tarball"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdLayerMediaType is the media type of zstd compressed OCI layers.
+const ZstdLayerMediaType ggcrtypes.MediaType = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// IsZstd reports whether the archive at path is zstd compressed.
+func IsZstd(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(zstdMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return bytes.Equal(magic, zstdMagic), nil
+}
+
+// LayerFromFile returns the image layer of the archive at path, which
+// may be compressed with gzip or zstd. Gzip layers get the given media
+// type, when set; zstd layers always get ZstdLayerMediaType, which only
+// exists for OCI images.
+func LayerFromFile(path string, mediaType ggcrtypes.MediaType) (v1.Layer, error) {
+	isZstd, err := IsZstd(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isZstd {
+		opts := []v1tar.LayerOption{}
+		if mediaType != "" {
+			opts = append(opts, v1tar.WithMediaType(mediaType))
+		}
+		return v1tar.LayerFromFile(path, opts...)
+	}
+
+	if mediaType == ggcrtypes.DockerLayer {
+		return nil, fmt.Errorf("zstd compressed layers cannot use Docker media types")
+	}
+
+	return newZstdLayer(path)
+}
+
+// zstdLayer is a zstd compressed layer read from a file.
+type zstdLayer struct {
+	path   string
+	digest v1.Hash
+	diffID v1.Hash
+	size   int64
+}
+
+func newZstdLayer(path string) (*zstdLayer, error) {
+	l := &zstdLayer{path: path}
+
+	rc, err := l.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	if l.digest, l.size, err = v1.SHA256(rc); err != nil {
+		return nil, fmt.Errorf("computing layer digest: %w", err)
+	}
+
+	urc, err := l.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer urc.Close()
+
+	if l.diffID, _, err = v1.SHA256(urc); err != nil {
+		return nil, fmt.Errorf("computing layer diff id: %w", err)
+	}
+
+	return l, nil
+}
+
+func (l *zstdLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+func (l *zstdLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+func (l *zstdLayer) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+func (l *zstdLayer) Uncompressed() (io.ReadCloser, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading zstd layer: %w", err)
+	}
+
+	return &zstdReadCloser{Decoder: zr, f: f}, nil
+}
+
+func (l *zstdLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+func (l *zstdLayer) MediaType() (ggcrtypes.MediaType, error) {
+	return ZstdLayerMediaType, nil
+}
+
+// zstdReadCloser closes both the decoder and the file it reads.
+type zstdReadCloser struct {
+	*zstd.Decoder
+	f *os.File
+}
+
+func (r *zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return r.f.Close()
+}
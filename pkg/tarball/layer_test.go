@@ -0,0 +1,84 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarball_test
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	apkofs "chainguard.dev/apko/pkg/fs"
+	"chainguard.dev/apko/pkg/tarball"
+)
+
+func TestLayerFromFileZstd(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0o644))
+
+	write := func(opts ...tarball.Option) string {
+		ctx, err := tarball.NewContext(opts...)
+		require.NoError(t, err)
+
+		f, err := os.CreateTemp(t.TempDir(), "layer")
+		require.NoError(t, err)
+		defer f.Close()
+		require.NoError(t, ctx.WriteArchive(f, apkofs.DirFS(dir)))
+		return f.Name()
+	}
+
+	zstdPath := write(tarball.WithCompression(tarball.CompressionZstd))
+	isZstd, err := tarball.IsZstd(zstdPath)
+	require.NoError(t, err)
+	require.True(t, isZstd)
+
+	layer, err := tarball.LayerFromFile(zstdPath, ggcrtypes.OCILayer)
+	require.NoError(t, err)
+	mt, err := layer.MediaType()
+	require.NoError(t, err)
+	require.Equal(t, tarball.ZstdLayerMediaType, mt)
+
+	// The diff id is the digest of the uncompressed archive, and
+	// matches the one of the same archive compressed with gzip.
+	rc, err := layer.Uncompressed()
+	require.NoError(t, err)
+	hdr, err := tar.NewReader(rc).Next()
+	require.NoError(t, err)
+	require.Equal(t, "file", hdr.Name)
+	require.NoError(t, rc.Close())
+
+	gzipLayer, err := tarball.LayerFromFile(write(), ggcrtypes.OCILayer)
+	require.NoError(t, err)
+	mt, err = gzipLayer.MediaType()
+	require.NoError(t, err)
+	require.Equal(t, ggcrtypes.OCILayer, mt)
+
+	diffID := func(l v1.Layer) v1.Hash {
+		h, err := l.DiffID()
+		require.NoError(t, err)
+		return h
+	}
+	require.Equal(t, diffID(gzipLayer), diffID(layer))
+
+	_, err = tarball.LayerFromFile(zstdPath, ggcrtypes.DockerLayer)
+	require.Error(t, err)
+
+	_, err = tarball.NewContext(tarball.WithCompression("xz"))
+	require.Error(t, err)
+}
@@ -15,6 +15,9 @@
 package tarball
 
 import (
+	"compress/gzip"
+	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -27,13 +30,28 @@ type Context struct {
 	OverrideGname   string
 	SkipClose       bool
 	UseChecksums    bool
+	KeepTimestamps  bool
+	SortEntries     bool
+
+	// CompressionLevel is the gzip level of the archive, or
+	// DefaultCompressionLevel to compress it in parallel at the
+	// default level.
+	CompressionLevel int
+
+	// Compression is the compression of the archive, CompressionGzip
+	// unless set.
+	Compression string
+
+	// uncompressedSize is the size of the last archive written, before
+	// it was compressed.
+	uncompressedSize int64
 }
 
 type Option func(*Context) error
 
 // Generates a new context from a set of options.
 func NewContext(opts ...Option) (*Context, error) {
-	ctx := Context{}
+	ctx := Context{CompressionLevel: DefaultCompressionLevel}
 
 	for _, opt := range opts {
 		if err := opt(&ctx); err != nil {
@@ -96,3 +114,75 @@ func WithUseChecksums(useChecksums bool) Option {
 		return nil
 	}
 }
+
+// WithKeepTimestamps is used to determine whether the timestamps of
+// files are kept, instead of being clamped to SourceDateEpoch. Keeping
+// them makes the tarball non-reproducible.
+func WithKeepTimestamps(keepTimestamps bool) Option {
+	return func(ctx *Context) error {
+		ctx.KeepTimestamps = keepTimestamps
+		return nil
+	}
+}
+
+// WithSortEntries writes the archive entries ordered lexicographically by
+// their full path, rather than in the order the directories are walked,
+// which is depth first and lexical within each directory.
+func WithSortEntries(sortEntries bool) Option {
+	return func(ctx *Context) error {
+		ctx.SortEntries = sortEntries
+		return nil
+	}
+}
+
+// DefaultCompressionLevel compresses the archive at the default gzip level.
+const DefaultCompressionLevel = gzip.DefaultCompression
+
+// ParseCompressionLevel parses a gzip level from 0 to 9, or "none", which
+// is the same as 0. An empty level is DefaultCompressionLevel.
+func ParseCompressionLevel(level string) (int, error) {
+	switch level {
+	case "":
+		return DefaultCompressionLevel, nil
+	case "none":
+		return gzip.NoCompression, nil
+	}
+
+	l, err := strconv.Atoi(level)
+	if err != nil || l < gzip.NoCompression || l > gzip.BestCompression {
+		return 0, fmt.Errorf("invalid compression level %q, expected 0-9 or none", level)
+	}
+	return l, nil
+}
+
+const (
+	// CompressionGzip compresses archives with gzip.
+	CompressionGzip = "gzip"
+	// CompressionZstd compresses archives with zstd.
+	CompressionZstd = "zstd"
+)
+
+// WithCompression sets the compression of the archive, CompressionGzip
+// or CompressionZstd.
+func WithCompression(compression string) Option {
+	return func(ctx *Context) error {
+		switch compression {
+		case "", CompressionGzip, CompressionZstd:
+			ctx.Compression = compression
+			return nil
+		default:
+			return fmt.Errorf("unsupported compression %q, expected %q or %q", compression, CompressionGzip, CompressionZstd)
+		}
+	}
+}
+
+// WithCompressionLevel sets the gzip level of the archive.
+func WithCompressionLevel(level int) Option {
+	return func(ctx *Context) error {
+		if level != DefaultCompressionLevel && (level < gzip.NoCompression || level > gzip.BestCompression) {
+			return fmt.Errorf("invalid compression level %d", level)
+		}
+		ctx.CompressionLevel = level
+		return nil
+	}
+}
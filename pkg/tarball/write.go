@@ -16,24 +16,42 @@ package tarball
 
 import (
 	"archive/tar"
+	"compress/gzip"
 	"crypto/sha1" // nolint:gosec
 	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"sort"
 	"syscall"
 
-	gzip "golang.org/x/build/pargzip"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/build/pargzip"
 
 	apkofs "chainguard.dev/apko/pkg/fs"
 )
 
 func (ctx *Context) writeArchiveFromFS(dst io.Writer, fsys fs.FS) error {
-	gzw := gzip.NewWriter(dst)
+	var gzw io.WriteCloser
+	if ctx.Compression == CompressionZstd {
+		zw, err := zstd.NewWriter(dst)
+		if err != nil {
+			return err
+		}
+		gzw = zw
+	} else if ctx.CompressionLevel == DefaultCompressionLevel {
+		gzw = pargzip.NewWriter(dst)
+	} else {
+		var err error
+		if gzw, err = gzip.NewWriterLevel(dst, ctx.CompressionLevel); err != nil {
+			return err
+		}
+	}
 	defer gzw.Close()
 
-	tw := tar.NewWriter(gzw)
+	ctx.uncompressedSize = 0
+	tw := tar.NewWriter(&countingWriter{w: gzw, n: &ctx.uncompressedSize})
 	if !ctx.SkipClose {
 		defer tw.Close()
 	} else {
@@ -43,6 +61,18 @@ func (ctx *Context) writeArchiveFromFS(dst io.Writer, fsys fs.FS) error {
 	return ctx.writeTar(tw, fsys)
 }
 
+// countingWriter counts the bytes written through it in n.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	*cw.n += int64(n)
+	return n, err
+}
+
 func hasHardlinks(fi fs.FileInfo) bool {
 	if stat := fi.Sys(); stat != nil {
 		si := stat.(*syscall.Stat_t)
@@ -75,8 +105,15 @@ func getInodeFromFileInfo(fi fs.FileInfo) (uint64, error) {
 	return 0, fmt.Errorf("unable to stat underlying file")
 }
 
+// entry is a file of the filesystem written to the archive.
+type entry struct {
+	path string
+	d    fs.DirEntry
+}
+
 func (ctx *Context) writeTar(tw *tar.Writer, fsys fs.FS) error {
 	seenFiles := map[uint64]string{}
+	entries := []entry{}
 
 	if err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		// skip the root path, superfluous
@@ -88,109 +125,132 @@ func (ctx *Context) writeTar(tw *tar.Writer, fsys fs.FS) error {
 			return err
 		}
 
-		info, err := d.Info()
-		if err != nil {
+		if ctx.SortEntries {
+			entries = append(entries, entry{path: path, d: d})
+			return nil
+		}
+
+		return ctx.writeEntry(tw, fsys, seenFiles, path, d)
+	}); err != nil {
+		return err
+	}
+
+	// write the entries ordered by their full path, rather than in the
+	// order the directories were walked
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+
+	for _, e := range entries {
+		if err := ctx.writeEntry(tw, fsys, seenFiles, e.path, e.d); err != nil {
 			return err
 		}
+	}
 
-		var link string
-		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
-			rlfs, ok := fsys.(apkofs.ReadLinkFS)
-			if !ok {
-				return fmt.Errorf("readlink not supported by this fs: path (%s)", path)
-			}
+	return nil
+}
 
-			if link, err = rlfs.Readlink(path); err != nil {
-				return err
-			}
+func (ctx *Context) writeEntry(tw *tar.Writer, fsys fs.FS, seenFiles map[uint64]string, path string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+		rlfs, ok := fsys.(apkofs.ReadLinkFS)
+		if !ok {
+			return fmt.Errorf("readlink not supported by this fs: path (%s)", path)
 		}
 
-		header, err := tar.FileInfoHeader(info, link)
-		if err != nil {
+		if link, err = rlfs.Readlink(path); err != nil {
 			return err
 		}
-		// work around some weirdness, without this we wind up with just the basename
-		header.Name = path
+	}
 
-		// zero out timestamps for reproducibility
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	// work around some weirdness, without this we wind up with just the basename
+	header.Name = path
+
+	// zero out timestamps for reproducibility
+	if !ctx.KeepTimestamps {
 		header.AccessTime = ctx.SourceDateEpoch
 		header.ModTime = ctx.SourceDateEpoch
 		header.ChangeTime = ctx.SourceDateEpoch
+	}
 
-		if ctx.OverrideUIDGID {
-			header.Uid = ctx.UID
-			header.Gid = ctx.GID
-		}
-
-		if ctx.OverrideUname != "" {
-			header.Uname = ctx.OverrideUname
-		}
+	if ctx.OverrideUIDGID {
+		header.Uid = ctx.UID
+		header.Gid = ctx.GID
+	}
 
-		if ctx.OverrideGname != "" {
-			header.Gname = ctx.OverrideGname
-		}
+	if ctx.OverrideUname != "" {
+		header.Uname = ctx.OverrideUname
+	}
 
-		if !info.IsDir() && hasHardlinks(info) {
-			inode, err := getInodeFromFileInfo(info)
-			if err != nil {
-				return err
-			}
+	if ctx.OverrideGname != "" {
+		header.Gname = ctx.OverrideGname
+	}
 
-			if oldpath, ok := seenFiles[inode]; ok {
-				header.Typeflag = tar.TypeLink
-				header.Linkname = oldpath
-				header.Size = 0
-			} else {
-				seenFiles[inode] = header.Name
-			}
+	if !info.IsDir() && hasHardlinks(info) {
+		inode, err := getInodeFromFileInfo(info)
+		if err != nil {
+			return err
 		}
 
-		if ctx.UseChecksums {
-			header.PAXRecords = map[string]string{}
-
-			if link != "" {
-				linkDigest := sha1.Sum([]byte(link)) // nolint:gosec
-				linkChecksum := hex.EncodeToString(linkDigest[:])
-				header.PAXRecords["APK-TOOLS.checksum.SHA1"] = linkChecksum
-			} else if info.Mode().IsRegular() {
-				data, err := fsys.Open(path)
-				if err != nil {
-					return err
-				}
-				defer data.Close()
-
-				fileDigest := sha1.New() // nolint:gosec
-				if _, err := io.Copy(fileDigest, data); err != nil {
-					return err
-				}
-
-				fileChecksum := hex.EncodeToString(fileDigest.Sum(nil))
-				header.PAXRecords["APK-TOOLS.checksum.SHA1"] = fileChecksum
-			}
+		if oldpath, ok := seenFiles[inode]; ok {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = oldpath
+			header.Size = 0
+		} else {
+			seenFiles[inode] = header.Name
 		}
+	}
 
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
+	if ctx.UseChecksums {
+		header.PAXRecords = map[string]string{}
 
-		if info.Mode().IsRegular() && header.Size > 0 {
+		if link != "" {
+			linkDigest := sha1.Sum([]byte(link)) // nolint:gosec
+			linkChecksum := hex.EncodeToString(linkDigest[:])
+			header.PAXRecords["APK-TOOLS.checksum.SHA1"] = linkChecksum
+		} else if info.Mode().IsRegular() {
 			data, err := fsys.Open(path)
 			if err != nil {
 				return err
 			}
-
 			defer data.Close()
 
-			if _, err := io.Copy(tw, data); err != nil {
+			fileDigest := sha1.New() // nolint:gosec
+			if _, err := io.Copy(fileDigest, data); err != nil {
 				return err
 			}
+
+			fileChecksum := hex.EncodeToString(fileDigest.Sum(nil))
+			header.PAXRecords["APK-TOOLS.checksum.SHA1"] = fileChecksum
 		}
+	}
 
-		return nil
-	}); err != nil {
+	if err := tw.WriteHeader(header); err != nil {
 		return err
 	}
 
+	if info.Mode().IsRegular() && header.Size > 0 {
+		data, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer data.Close()
+
+		if _, err := io.Copy(tw, data); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -202,3 +262,9 @@ func (ctx *Context) WriteArchive(dst io.Writer, src fs.FS) error {
 
 	return nil
 }
+
+// UncompressedSize returns the size, in bytes, of the archive last
+// written by WriteArchive before it was compressed.
+func (ctx *Context) UncompressedSize() int64 {
+	return ctx.uncompressedSize
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarball_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	apkofs "chainguard.dev/apko/pkg/fs"
+	"chainguard.dev/apko/pkg/tarball"
+)
+
+func TestWriteArchiveTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o644))
+
+	mtime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+
+	epoch := time.Unix(0, 0).UTC()
+
+	modTimes := func(opts ...tarball.Option) []time.Time {
+		ctx, err := tarball.NewContext(opts...)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, ctx.WriteArchive(&buf, apkofs.DirFS(dir)))
+
+		gzr, err := gzip.NewReader(&buf)
+		require.NoError(t, err)
+		tr := tar.NewReader(gzr)
+
+		times := []time.Time{}
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			times = append(times, hdr.ModTime.UTC())
+		}
+		return times
+	}
+
+	require.Equal(t, []time.Time{epoch}, modTimes(tarball.WithSourceDateEpoch(epoch)))
+	require.Equal(t, []time.Time{mtime}, modTimes(tarball.WithSourceDateEpoch(epoch), tarball.WithKeepTimestamps(true)))
+}
+
+func TestWriteArchiveSortEntries(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a/b", "a-b", "a.b/c"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte("data"), 0o644))
+	}
+
+	names := func(opts ...tarball.Option) []string {
+		ctx, err := tarball.NewContext(opts...)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, ctx.WriteArchive(&buf, apkofs.DirFS(dir)))
+
+		gzr, err := gzip.NewReader(&buf)
+		require.NoError(t, err)
+		tr := tar.NewReader(gzr)
+
+		names := []string{}
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			names = append(names, hdr.Name)
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"a", "a/b", "a-b", "a.b", "a.b/c"}, names())
+	require.Equal(t, []string{"a", "a-b", "a.b", "a.b/c", "a/b"}, names(tarball.WithSortEntries(true)))
+}
+
+func TestWriteArchiveCompressionLevel(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), bytes.Repeat([]byte("data"), 1024), 0o644))
+
+	size := func(level string) int {
+		l, err := tarball.ParseCompressionLevel(level)
+		require.NoError(t, err)
+		ctx, err := tarball.NewContext(tarball.WithCompressionLevel(l))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, ctx.WriteArchive(&buf, apkofs.DirFS(dir)))
+		n := buf.Len()
+
+		gzr, err := gzip.NewReader(&buf)
+		require.NoError(t, err)
+		tr := tar.NewReader(gzr)
+		hdr, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, "file", hdr.Name)
+		return n
+	}
+
+	require.Less(t, size("9"), size("none"))
+	require.Less(t, size(""), size("0"))
+
+	for _, level := range []string{"10", "-1", "fast"} {
+		_, err := tarball.ParseCompressionLevel(level)
+		require.Error(t, err, level)
+	}
+}
+
+func TestWriteArchiveUncompressedSize(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), bytes.Repeat([]byte("data"), 1024), 0o644))
+
+	ctx, err := tarball.NewContext()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, ctx.WriteArchive(&buf, apkofs.DirFS(dir)))
+
+	gzr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	n, err := io.Copy(io.Discard, gzr)
+	require.NoError(t, err)
+	require.Equal(t, n, ctx.UncompressedSize())
+}
@@ -26,31 +26,31 @@ import (
 
 // Given a starting directory and toplevel directory, work backwards
 // to the toplevel directory and probe for a Git repository, returning
-// the origin URI if known.
-func ProbeDirForVCSUrl(startDir, toplevelDir string) (string, error) {
+// the repository and the directory it is in.
+func findRepository(startDir, toplevelDir string) (*git.Repository, string, error) {
 	fi, err := os.Stat(startDir)
 	if err != nil {
-		return "", fmt.Errorf("cannot check start directory: %w", err)
+		return nil, "", fmt.Errorf("cannot check start directory: %w", err)
 	}
 
 	if !fi.IsDir() {
-		return "", fmt.Errorf("starting path %s is not directory", startDir)
+		return nil, "", fmt.Errorf("starting path %s is not directory", startDir)
 	}
 
 	fi, err = os.Stat(toplevelDir)
 	if err != nil {
-		return "", fmt.Errorf("cannot check start directory: %w", err)
+		return nil, "", fmt.Errorf("cannot check start directory: %w", err)
 	}
 
 	if !fi.IsDir() {
-		return "", fmt.Errorf("top-level path %s is not directory", toplevelDir)
+		return nil, "", fmt.Errorf("top-level path %s is not directory", toplevelDir)
 	}
 
 	searchPath := startDir
 
 	for {
 		if !strings.HasPrefix(searchPath, toplevelDir) {
-			return "", fmt.Errorf("path %s is not contained by %s", searchPath, toplevelDir)
+			return nil, "", fmt.Errorf("path %s is not contained by %s", searchPath, toplevelDir)
 		}
 
 		repo, err := git.PlainOpen(searchPath)
@@ -59,53 +59,124 @@ func ProbeDirForVCSUrl(startDir, toplevelDir string) (string, error) {
 			continue
 		}
 
-		remote, err := repo.Remote("origin")
-		if err != nil {
-			return "", fmt.Errorf("unable to determine upstream git vcs url: %w", err)
-		}
+		return repo, searchPath, nil
+	}
+}
 
-		remoteConfig := remote.Config()
-		remoteURL := remoteConfig.URLs[0]
+// Given a starting directory and toplevel directory, work backwards
+// to the toplevel directory and probe for a Git repository, returning
+// the origin URI if known.
+func ProbeDirForVCSUrl(startDir, toplevelDir string) (string, error) {
+	repo, _, err := findRepository(startDir, toplevelDir)
+	if err != nil {
+		return "", err
+	}
 
-		normalizedURL, err := url.Parse(remoteURL)
-		if err != nil {
-			// URL is most likely a git+ssh:// type URL, represented
-			// in the way git itself does so.
+	return originURL(repo)
+}
 
-			// Take the user@host:repo and turn it into user@host/repo.
-			remoteURL = strings.Replace(remoteURL, ":", "/", 1)
-			remoteURL = fmt.Sprintf("git+ssh://%s", remoteURL)
+// Given a starting directory and toplevel directory, work backwards
+// to the toplevel directory and probe for a Git repository, returning
+// the origin URI if known and the path of the starting directory
+// relative to the root of the repository, with forward slashes, which is
+// empty at the root. The origin URI is returned even when the path
+// cannot be determined.
+func ProbeDirForVCS(startDir, toplevelDir string) (string, string, error) {
+	repo, root, err := findRepository(startDir, toplevelDir)
+	if err != nil {
+		return "", "", err
+	}
 
-			normalizedURL, err = url.Parse(remoteURL)
-			if err != nil {
-				return "", fmt.Errorf("unable to parse %s as a git vcs url: %w", remoteURL, err)
-			}
-		}
+	url, err := originURL(repo)
+	if err != nil {
+		return "", "", err
+	}
 
-		// sanitize any user authentication data from the VCS URL
-		normalizedURL.User = nil
+	rel, err := filepath.Rel(root, startDir)
+	if err != nil {
+		return url, "", fmt.Errorf("cannot find %s in repository %s: %w", startDir, root, err)
+	}
 
-		return normalizedURL.String(), nil
+	if rel == "." {
+		return url, "", nil
 	}
+	return url, filepath.ToSlash(rel), nil
+}
+
+// originURL returns the URI of the origin remote of the repository,
+// without any user authentication data.
+func originURL(repo *git.Repository) (string, error) {
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return "", fmt.Errorf("unable to determine upstream git vcs url: %w", err)
+	}
+
+	remoteConfig := remote.Config()
+	remoteURL := remoteConfig.URLs[0]
+
+	normalizedURL, err := url.Parse(remoteURL)
+	if err != nil {
+		// URL is most likely a git+ssh:// type URL, represented
+		// in the way git itself does so.
+
+		// Take the user@host:repo and turn it into user@host/repo.
+		remoteURL = strings.Replace(remoteURL, ":", "/", 1)
+		remoteURL = fmt.Sprintf("git+ssh://%s", remoteURL)
+
+		normalizedURL, err = url.Parse(remoteURL)
+		if err != nil {
+			return "", fmt.Errorf("unable to parse %s as a git vcs url: %w", remoteURL, err)
+		}
+	}
+
+	// sanitize any user authentication data from the VCS URL
+	normalizedURL.User = nil
+
+	return normalizedURL.String(), nil
 }
 
 // Given a starting directory, work backwards to the current working
 // directory and probe for a Git repository, returning the origin URI
 // if known.
 func ProbeDirFromPath(startingPath string) (string, error) {
+	startDir, toplevelDir, err := searchDirs(startingPath)
+	if err != nil {
+		return "", err
+	}
+
+	return ProbeDirForVCSUrl(startDir, toplevelDir)
+}
+
+// Given a starting path, work backwards to the current working directory
+// and probe for a Git repository, returning the origin URI if known and
+// the path of the directory of the starting path relative to the root of
+// the repository, as ProbeDirForVCS does.
+func ProbeFromPath(startingPath string) (string, string, error) {
+	startDir, toplevelDir, err := searchDirs(startingPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	return ProbeDirForVCS(startDir, toplevelDir)
+}
+
+// searchDirs returns the absolute directory of the starting path, which
+// may be a file such as "foo/apko.yaml", and the current working
+// directory the search for a Git repository stops at.
+func searchDirs(startingPath string) (string, string, error) {
 	toplevelDir, err := os.Getwd()
 	if err != nil {
-		return "", fmt.Errorf("cannot find working directory: %w", err)
+		return "", "", fmt.Errorf("cannot find working directory: %w", err)
 	}
 
 	startingPath, err = filepath.Abs(startingPath)
 	if err != nil {
-		return "", fmt.Errorf("cannot dereference relative path %s: %w", startingPath, err)
+		return "", "", fmt.Errorf("cannot dereference relative path %s: %w", startingPath, err)
 	}
 
 	fi, err := os.Stat(startingPath)
 	if err != nil {
-		return "", fmt.Errorf("cannot check start directory: %w", err)
+		return "", "", fmt.Errorf("cannot check start directory: %w", err)
 	}
 
 	// If starting path is not a directory, get the parent directory.
@@ -115,5 +186,5 @@ func ProbeDirFromPath(startingPath string) (string, error) {
 		startingPath = filepath.Dir(startingPath)
 	}
 
-	return ProbeDirForVCSUrl(startingPath, toplevelDir)
+	return startingPath, toplevelDir, nil
 }